@@ -0,0 +1,81 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"shopping-list/db"
+	"shopping-list/handlers"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// currentUserID returns the ID of the user the request is made as
+func currentUserID(c *fiber.Ctx) int64 {
+	return handlers.CurrentUser(c).ID
+}
+
+// tokenListID returns the list the request's token is limited to, 0 if it isn't
+func tokenListID(c *fiber.Ctx) int64 {
+	if token := currentToken(c); token != nil {
+		return token.ListID
+	}
+	return 0
+}
+
+// checkListAccess is db.CheckListAccess for the request's user.
+// Lists outside a list-scoped token are reported as not found.
+func checkListAccess(c *fiber.Ctx, listID int64, need db.Access) error {
+	if err := db.CheckListAccess(currentUserID(c), listID, need); err != nil {
+		return err
+	}
+	if scoped := tokenListID(c); scoped != 0 && scoped != listID {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// checkSectionAccess is db.CheckSectionAccess with the token's list scope applied
+func checkSectionAccess(c *fiber.Ctx, sectionID int64, need db.Access) (int64, error) {
+	listID, err := db.CheckSectionAccess(currentUserID(c), sectionID, need)
+	if err != nil {
+		return 0, err
+	}
+	if scoped := tokenListID(c); scoped != 0 && scoped != listID {
+		return 0, sql.ErrNoRows
+	}
+	return listID, nil
+}
+
+// checkItemAccess is db.CheckItemAccess with the token's list scope applied
+func checkItemAccess(c *fiber.Ctx, itemID int64, need db.Access) (int64, error) {
+	listID, err := db.CheckItemAccess(currentUserID(c), itemID, need)
+	if err != nil {
+		return 0, err
+	}
+	if scoped := tokenListID(c); scoped != 0 && scoped != listID {
+		return 0, sql.ErrNoRows
+	}
+	return listID, nil
+}
+
+// accessError responds to a failed list access check.
+// what names the thing that was looked up, e.g. "List" or "Item".
+func accessError(c *fiber.Ctx, err error, what string) error {
+	switch {
+	case err == sql.ErrNoRows:
+		return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+			Error:   "not_found",
+			Message: what + " not found",
+		})
+	case errors.Is(err, db.ErrForbidden):
+		return c.Status(fiber.StatusForbidden).JSON(ErrorResponse{
+			Error:   "forbidden",
+			Message: "You don't have permission to change this list",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+		Error:   "db_error",
+		Message: "Failed to fetch " + strings.ToLower(what),
+	})
+}
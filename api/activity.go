@@ -0,0 +1,57 @@
+package api
+
+import (
+	"shopping-list/db"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	DefaultActivityLimit = 50
+	MaxActivityLimit     = 200
+)
+
+// ActivityResponse wraps a page of a list's audit trail, newest first.
+// NextBefore is passed as ?before= to fetch the next page; it is omitted on the last page.
+type ActivityResponse struct {
+	Events     []db.Event `json:"events"`
+	NextBefore int64      `json:"next_before,omitempty"`
+}
+
+// GetListActivity returns who changed what on a list
+func GetListActivity(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid list ID",
+		})
+	}
+
+	if err := checkListAccess(c, int64(id), db.AccessRead); err != nil {
+		return accessError(c, err, "List")
+	}
+
+	limit := c.QueryInt("limit", DefaultActivityLimit)
+	if limit <= 0 || limit > MaxActivityLimit {
+		limit = DefaultActivityLimit
+	}
+	before := int64(c.QueryInt("before", 0))
+
+	events, err := db.GetListEvents(int64(id), before, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   "db_error",
+			Message: "Failed to fetch activity",
+		})
+	}
+
+	resp := ActivityResponse{Events: events}
+	if events == nil {
+		resp.Events = []db.Event{}
+	}
+	if len(events) == limit {
+		resp.NextBefore = events[len(events)-1].ID
+	}
+	return c.JSON(resp)
+}
@@ -6,34 +6,27 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
-// Register conditionally registers the API routes if API_TOKEN is set
+// Register registers the API routes. Requests need an API token created by a user
+// (or the legacy API_TOKEN env var).
 func Register(app *fiber.App) {
-	if !IsAPIEnabled() {
-		log.Println("REST API is disabled (API_TOKEN not set)")
-		// Register catch-all handler that returns 503 for all API requests
-		app.All("/api/v1/*", func(c *fiber.Ctx) error {
-			return c.Status(fiber.StatusServiceUnavailable).JSON(ErrorResponse{
-				Error:   "api_disabled",
-				Message: "API is not enabled on this server",
-			})
-		})
-		return
+	if GetAPIToken() != "" {
+		log.Println("REST API: API_TOKEN is set and acts as the first admin")
 	}
 
-	log.Println("REST API is enabled")
-
 	// Create API group with version prefix and token auth middleware
 	v1 := app.Group("/api/v1", TokenAuthMiddleware)
 
 	// Lists endpoints
 	v1.Get("/lists", GetLists)
 	v1.Get("/lists/:id", GetList)
-	v1.Post("/lists", CreateList)
+	v1.Post("/lists", allListsOnly, CreateList)
 	v1.Put("/lists/:id", UpdateList)
 	v1.Delete("/lists/:id", DeleteList)
 	v1.Get("/lists/:id/sections", GetListSections)
 	v1.Post("/lists/:id/move-up", MoveListUp)
 	v1.Post("/lists/:id/move-down", MoveListDown)
+	v1.Get("/lists/:id/activity", GetListActivity)
+	v1.Get("/lists/:id/presence", GetListPresence)
 
 	// Sections endpoints
 	v1.Get("/sections/:id", GetSection)
@@ -51,6 +44,8 @@ func Register(app *fiber.App) {
 	v1.Delete("/items/:id", DeleteItem)
 	v1.Post("/items/:id/toggle", ToggleItemCompleted)
 	v1.Post("/items/:id/uncertain", ToggleItemUncertain)
+	v1.Post("/items/:id/increment", IncrementItem)
+	v1.Post("/items/:id/decrement", DecrementItem)
 	v1.Post("/items/:id/move", MoveItem)
 	v1.Post("/items/:id/move-up", MoveItemUp)
 	v1.Post("/items/:id/move-down", MoveItemDown)
@@ -58,9 +53,38 @@ func Register(app *fiber.App) {
 	// Batch endpoint
 	v1.Post("/batch", BatchCreate)
 
-	// History endpoints (suggestions)
-	v1.Get("/history", GetHistory)
-	v1.Post("/history", CreateHistory)
-	v1.Delete("/history/:id", DeleteHistory)
-	v1.Post("/history/batch-delete", BatchDeleteHistory)
+	// History endpoints (suggestions), shared by all lists
+	history := v1.Group("/history", allListsOnly)
+	history.Get("", GetHistory)
+	history.Post("", CreateHistory)
+	history.Delete("/:id", DeleteHistory)
+	history.Post("/batch-delete", BatchDeleteHistory)
+	history.Get("/duplicates", GetHistoryDuplicates)
+	history.Post("/:id/merge", MergeHistory)
+	history.Post("/:id/aliases", AddHistoryAlias)
+	history.Delete("/:id/aliases/:aliasId", DeleteHistoryAlias)
+
+	// Price history endpoint
+	v1.Get("/prices", allListsOnly, GetPriceHistory)
+
+	// Recurring items endpoints
+	v1.Get("/recurrences", GetRecurrences)
+	v1.Get("/recurrences/:id", GetRecurrence)
+	v1.Post("/recurrences", CreateRecurrence)
+	v1.Put("/recurrences/:id", UpdateRecurrence)
+	v1.Delete("/recurrences/:id", DeleteRecurrence)
+
+	// Session endpoints (devices the token's user is logged in on)
+	sessions := v1.Group("/sessions", allListsOnly)
+	sessions.Get("", GetSessions)
+	sessions.Delete("", DeleteSessions)
+	sessions.Delete("/:id", DeleteSession)
+
+	// Live updates as Server-Sent Events
+	v1.Get("/events", GetEvents)
+
+	// Purchase stats endpoints
+	v1.Get("/stats/purchases/top", GetTopPurchases)
+	v1.Get("/stats/purchases/frequency", GetPurchaseFrequency)
+	v1.Get("/stats/purchases/weekly", GetWeeklyPurchases)
 }
@@ -1,7 +1,6 @@
 package api
 
 import (
-	"database/sql"
 	"shopping-list/db"
 	"shopping-list/handlers"
 
@@ -35,6 +34,13 @@ func BatchCreate(c *fiber.Ctx) error {
 
 // batchCreateNewList creates a new list with sections and items
 func batchCreateNewList(c *fiber.Ctx, req BatchCreateRequest) error {
+	if tokenListID(c) != 0 {
+		return c.Status(fiber.StatusForbidden).JSON(ErrorResponse{
+			Error:   "forbidden",
+			Message: "This token is limited to one list",
+		})
+	}
+
 	if req.List.Name == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
 			Error:   "validation_error",
@@ -63,6 +69,7 @@ func batchCreateNewList(c *fiber.Ctx, req BatchCreateRequest) error {
 				Message: "Section name exceeds maximum length of 100 characters",
 			})
 		}
+		quickAddItems(s.Items)
 		for _, item := range s.Items {
 			if item.Name == "" {
 				return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
@@ -82,6 +89,9 @@ func batchCreateNewList(c *fiber.Ctx, req BatchCreateRequest) error {
 					Message: "Item description exceeds maximum length of 500 characters",
 				})
 			}
+			if errResp := validateQuantity(item.Quantity, item.Unit); errResp != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(errResp)
+			}
 		}
 	}
 
@@ -97,7 +107,7 @@ func batchCreateNewList(c *fiber.Ctx, req BatchCreateRequest) error {
 
 	// Create list
 	icon := NormalizeIcon(req.List.Icon)
-	list, err := db.CreateListTx(tx, req.List.Name, icon)
+	list, err := db.CreateListTx(tx, currentUserID(c), req.List.Name, icon)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 			Error:   "create_failed",
@@ -120,18 +130,18 @@ func batchCreateNewList(c *fiber.Ctx, req BatchCreateRequest) error {
 
 		var sectionItems []db.Item
 		for itemOrder, itemInput := range sectionInput.Items {
-			item, err := db.CreateItemTx(tx, section.ID, itemInput.Name, itemInput.Description, itemOrder)
+			item, _, err := db.CreateItemTx(tx, currentUserID(c), section.ID, itemInput.Name, itemInput.Description, itemInput.Quantity, itemInput.Unit, itemOrder)
 			if err != nil {
 				return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 					Error:   "create_failed",
 					Message: "Failed to create item: " + itemInput.Name,
 				})
 			}
-			sectionItems = append(sectionItems, *item)
-			items = append(items, *item)
+			sectionItems = upsertItem(sectionItems, *item)
+			items = upsertItem(items, *item)
 
 			// Save to item history
-			db.SaveItemHistoryTx(tx, itemInput.Name, section.ID)
+			db.SaveItemHistoryTx(tx, currentUserID(c), itemInput.Name, section.ID)
 		}
 
 		section.Items = sectionItems
@@ -145,13 +155,20 @@ func batchCreateNewList(c *fiber.Ctx, req BatchCreateRequest) error {
 			Message: "Failed to commit transaction",
 		})
 	}
+	db.InvalidateSuggestionIndex()
+
+	handlers.RecordEvent(c, list.ID, db.EventCreated, db.EntityList, list.ID, nil, list)
+	for _, item := range items {
+		handlers.RecordEvent(c, list.ID, db.EventCreated, db.EntityItem, item.ID, nil, item)
+	}
 
 	// Get list with stats
 	list.Stats = db.GetListStats(list.ID)
 
-	// Broadcast WebSocket update
-	handlers.BroadcastUpdate("batch_created", map[string]interface{}{
-		"list_id": list.ID,
+	// Broadcast WebSocket update; nobody is subscribed to the new list yet
+	handlers.BroadcastToUsers([]int64{currentUserID(c)}, list.ID, "batch_created", BatchCreateResponse{
+		List:     list,
+		Sections: sections,
 	})
 
 	return c.Status(fiber.StatusCreated).JSON(BatchCreateResponse{
@@ -163,19 +180,9 @@ func batchCreateNewList(c *fiber.Ctx, req BatchCreateRequest) error {
 
 // batchAddToList adds sections and items to an existing list
 func batchAddToList(c *fiber.Ctx, req BatchCreateRequest) error {
-	// Check if list exists
-	_, err := db.GetListByID(req.ListID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
-				Error:   "not_found",
-				Message: "List not found",
-			})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-			Error:   "db_error",
-			Message: "Failed to fetch list",
-		})
+	// Check if list exists and the user has access to it
+	if err := checkListAccess(c, req.ListID, db.AccessWrite); err != nil {
+		return accessError(c, err, "List")
 	}
 
 	// Validate sections and items
@@ -192,6 +199,7 @@ func batchAddToList(c *fiber.Ctx, req BatchCreateRequest) error {
 				Message: "Section name exceeds maximum length of 100 characters",
 			})
 		}
+		quickAddItems(s.Items)
 		for _, item := range s.Items {
 			if item.Name == "" {
 				return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
@@ -205,6 +213,9 @@ func batchAddToList(c *fiber.Ctx, req BatchCreateRequest) error {
 					Message: "Item name exceeds maximum length of 200 characters",
 				})
 			}
+			if errResp := validateQuantity(item.Quantity, item.Unit); errResp != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(errResp)
+			}
 		}
 	}
 
@@ -236,17 +247,17 @@ func batchAddToList(c *fiber.Ctx, req BatchCreateRequest) error {
 
 		var sectionItems []db.Item
 		for itemOrder, itemInput := range sectionInput.Items {
-			item, err := db.CreateItemTx(tx, section.ID, itemInput.Name, itemInput.Description, itemOrder)
+			item, _, err := db.CreateItemTx(tx, currentUserID(c), section.ID, itemInput.Name, itemInput.Description, itemInput.Quantity, itemInput.Unit, itemOrder)
 			if err != nil {
 				return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 					Error:   "create_failed",
 					Message: "Failed to create item: " + itemInput.Name,
 				})
 			}
-			sectionItems = append(sectionItems, *item)
-			items = append(items, *item)
+			sectionItems = upsertItem(sectionItems, *item)
+			items = upsertItem(items, *item)
 
-			db.SaveItemHistoryTx(tx, itemInput.Name, section.ID)
+			db.SaveItemHistoryTx(tx, currentUserID(c), itemInput.Name, section.ID)
 		}
 
 		section.Items = sectionItems
@@ -260,10 +271,19 @@ func batchAddToList(c *fiber.Ctx, req BatchCreateRequest) error {
 			Message: "Failed to commit transaction",
 		})
 	}
+	db.InvalidateSuggestionIndex()
+
+	for _, section := range sections {
+		handlers.RecordEvent(c, req.ListID, db.EventCreated, db.EntitySection, section.ID, nil, section)
+	}
+	for _, item := range items {
+		handlers.RecordEvent(c, req.ListID, db.EventCreated, db.EntityItem, item.ID, nil, item)
+	}
 
 	// Broadcast WebSocket update
-	handlers.BroadcastUpdate("batch_created", map[string]interface{}{
-		"list_id": req.ListID,
+	handlers.BroadcastListUpdate(req.ListID, "batch_created", BatchCreateResponse{
+		Sections: sections,
+		Items:    items,
 	})
 
 	return c.Status(fiber.StatusCreated).JSON(BatchCreateResponse{
@@ -274,22 +294,14 @@ func batchAddToList(c *fiber.Ctx, req BatchCreateRequest) error {
 
 // batchAddToSection adds items to an existing section
 func batchAddToSection(c *fiber.Ctx, req BatchCreateRequest) error {
-	// Check if section exists
-	_, err := db.GetSectionByID(req.SectionID)
+	// Check if section exists and the user has access to its list
+	listID, err := checkSectionAccess(c, req.SectionID, db.AccessWrite)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
-				Error:   "not_found",
-				Message: "Section not found",
-			})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-			Error:   "db_error",
-			Message: "Failed to fetch section",
-		})
+		return accessError(c, err, "Section")
 	}
 
 	// Validate items
+	quickAddItems(req.Items)
 	for _, item := range req.Items {
 		if item.Name == "" {
 			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
@@ -303,6 +315,9 @@ func batchAddToSection(c *fiber.Ctx, req BatchCreateRequest) error {
 				Message: "Item name exceeds maximum length of 200 characters",
 			})
 		}
+		if errResp := validateQuantity(item.Quantity, item.Unit); errResp != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(errResp)
+		}
 	}
 
 	// Start transaction
@@ -322,16 +337,16 @@ func batchAddToSection(c *fiber.Ctx, req BatchCreateRequest) error {
 
 	// Create items
 	for i, itemInput := range req.Items {
-		item, err := db.CreateItemTx(tx, req.SectionID, itemInput.Name, itemInput.Description, baseItemOrder+i)
+		item, _, err := db.CreateItemTx(tx, currentUserID(c), req.SectionID, itemInput.Name, itemInput.Description, itemInput.Quantity, itemInput.Unit, baseItemOrder+i)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 				Error:   "create_failed",
 				Message: "Failed to create item: " + itemInput.Name,
 			})
 		}
-		items = append(items, *item)
+		items = upsertItem(items, *item)
 
-		db.SaveItemHistoryTx(tx, itemInput.Name, req.SectionID)
+		db.SaveItemHistoryTx(tx, currentUserID(c), itemInput.Name, req.SectionID)
 	}
 
 	// Commit transaction
@@ -341,13 +356,42 @@ func batchAddToSection(c *fiber.Ctx, req BatchCreateRequest) error {
 			Message: "Failed to commit transaction",
 		})
 	}
+	db.InvalidateSuggestionIndex()
+
+	for _, item := range items {
+		handlers.RecordEvent(c, listID, db.EventCreated, db.EntityItem, item.ID, nil, item)
+	}
 
 	// Broadcast WebSocket update
-	handlers.BroadcastUpdate("batch_created", map[string]interface{}{
-		"section_id": req.SectionID,
+	handlers.BroadcastListUpdate(listID, "batch_created", BatchCreateResponse{
+		Items: items,
 	})
 
 	return c.Status(fiber.StatusCreated).JSON(BatchCreateResponse{
 		Items: items,
 	})
 }
+
+// upsertItem appends item to items, or replaces the existing entry when the
+// item was merged into one created earlier in the same batch
+func upsertItem(items []db.Item, item db.Item) []db.Item {
+	for i := range items {
+		if items[i].ID == item.ID {
+			items[i] = item
+			return items
+		}
+	}
+	return append(items, item)
+}
+
+// quickAddItems parses quantity, unit and note out of the names of items sent
+// with parse that have none set
+func quickAddItems(items []BatchItemInput) {
+	for i := range items {
+		item := &items[i]
+		if !item.Parse || item.Quantity != 0 || item.Unit != "" {
+			continue
+		}
+		item.Name, item.Description, item.Quantity, item.Unit = handlers.QuickAdd(item.Name, item.Description)
+	}
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"shopping-list/db"
+	"shopping-list/handlers"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetEvents streams the changes to the token user's lists as Server-Sent Events.
+// ?list_ids=1,2 limits the stream to some lists; tokens limited to one list only get
+// that list's changes. Last-Event-ID (or ?last_event_id=) resumes after a disconnect.
+func GetEvents(c *fiber.Ctx) error {
+	var listIDs []int64
+	for _, field := range strings.Split(c.Query("list_ids"), ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Error:   "invalid_id",
+				Message: "Invalid list ID",
+			})
+		}
+		if err := checkListAccess(c, id, db.AccessRead); err != nil {
+			return accessError(c, err, "List")
+		}
+		listIDs = append(listIDs, id)
+	}
+	if len(listIDs) == 0 && tokenListID(c) != 0 {
+		listIDs = []int64{tokenListID(c)}
+	}
+
+	lastEventID := c.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+
+	return handlers.StreamEvents(c, currentToken(c), listIDs, lastEventID)
+}
@@ -1,7 +1,10 @@
 package api
 
 import (
+	"database/sql"
 	"shopping-list/db"
+	"shopping-list/handlers"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -22,9 +25,24 @@ type BatchDeleteHistoryRequest struct {
 	IDs []int64 `json:"ids"`
 }
 
+// MergeHistoryRequest for folding history entries into another one
+type MergeHistoryRequest struct {
+	IDs []int64 `json:"ids"`
+}
+
+// AddAliasRequest for adding another spelling to a history entry
+type AddAliasRequest struct {
+	Alias string `json:"alias"`
+}
+
+// DuplicatesResponse wraps proposed history merges
+type DuplicatesResponse struct {
+	Groups []db.DuplicateGroup `json:"groups"`
+}
+
 // GetHistory returns all history items
 func GetHistory(c *fiber.Ctx) error {
-	items, err := db.GetItemHistoryList()
+	items, err := db.GetItemHistoryList(currentUserID(c))
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 			Error:   "db_error",
@@ -63,18 +81,14 @@ func CreateHistory(c *fiber.Ctx) error {
 		})
 	}
 
-	// If section_id provided, verify it exists
+	// If section_id provided, verify it exists and the user can change its list
 	if req.SectionID != 0 {
-		_, err := db.GetSectionByID(req.SectionID)
-		if err != nil {
-			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
-				Error:   "not_found",
-				Message: "Section not found",
-			})
+		if _, err := checkSectionAccess(c, req.SectionID, db.AccessWrite); err != nil {
+			return accessError(c, err, "Section")
 		}
 	}
 
-	if err := db.SaveItemHistory(req.Name, req.SectionID); err != nil {
+	if err := db.SaveItemHistory(currentUserID(c), req.Name, req.SectionID); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 			Error:   "create_failed",
 			Message: "Failed to save history",
@@ -97,13 +111,16 @@ func DeleteHistory(c *fiber.Ctx) error {
 		})
 	}
 
-	if err := db.DeleteItemHistory(int64(id)); err != nil {
+	userID := currentUserID(c)
+	before, _ := db.GetHistoryItemByID(userID, int64(id))
+	if err := db.DeleteItemHistory(userID, int64(id)); err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
 			Error:   "not_found",
 			Message: "History entry not found",
 		})
 	}
 
+	handlers.RecordEvent(c, 0, db.EventDeleted, db.EntityHistory, int64(id), before, nil)
 	return c.SendStatus(fiber.StatusNoContent)
 }
 
@@ -124,7 +141,7 @@ func BatchDeleteHistory(c *fiber.Ctx) error {
 		})
 	}
 
-	deleted, err := db.DeleteItemHistoryBatch(req.IDs)
+	deleted, err := db.DeleteItemHistoryBatch(currentUserID(c), req.IDs)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 			Error:   "delete_failed",
@@ -132,7 +149,184 @@ func BatchDeleteHistory(c *fiber.Ctx) error {
 		})
 	}
 
+	for _, id := range req.IDs {
+		handlers.RecordEvent(c, 0, db.EventDeleted, db.EntityHistory, id, nil, nil)
+	}
+
 	return c.JSON(fiber.Map{
 		"deleted": deleted,
 	})
 }
+
+// MergeHistory folds the given history entries into the entry in the URL.
+// Usage counts are added up and the merged names become aliases.
+func MergeHistory(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid history ID",
+		})
+	}
+
+	var req MergeHistoryRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "invalid_json",
+			Message: "Failed to parse request body",
+		})
+	}
+
+	if len(req.IDs) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "validation_error",
+			Message: "IDs array is required",
+		})
+	}
+
+	if len(req.IDs) > 100 {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "validation_error",
+			Message: "Too many IDs (max 100)",
+		})
+	}
+
+	item, err := db.MergeItemHistory(currentUserID(c), int64(id), req.IDs)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+				Error:   "not_found",
+				Message: "History entry not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   "merge_failed",
+			Message: "Failed to merge history entries",
+		})
+	}
+
+	handlers.RecordEvent(c, 0, db.EventMerged, db.EntityHistory, int64(id), fiber.Map{"merged_ids": req.IDs}, item)
+
+	return c.JSON(item)
+}
+
+// GetHistoryDuplicates returns proposed merges of near-duplicate history entries
+func GetHistoryDuplicates(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	groups, err := db.FindHistoryDuplicates(currentUserID(c), limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   "db_error",
+			Message: "Failed to find duplicates",
+		})
+	}
+
+	if groups == nil {
+		groups = []db.DuplicateGroup{}
+	}
+
+	return c.JSON(DuplicatesResponse{Groups: groups})
+}
+
+// AddHistoryAlias adds another spelling to a history entry
+func AddHistoryAlias(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid history ID",
+		})
+	}
+
+	var req AddAliasRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "invalid_json",
+			Message: "Failed to parse request body",
+		})
+	}
+
+	req.Alias = strings.TrimSpace(req.Alias)
+	if req.Alias == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "validation_error",
+			Message: "Alias is required",
+		})
+	}
+
+	if len(req.Alias) > MaxItemNameLength {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "validation_error",
+			Message: "Alias exceeds maximum length of 200 characters",
+		})
+	}
+
+	userID := currentUserID(c)
+	before, _ := db.GetHistoryItemByID(userID, int64(id))
+	item, err := db.AddHistoryAlias(userID, int64(id), req.Alias)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+				Error:   "not_found",
+				Message: "History entry not found",
+			})
+		}
+		if err == db.ErrAliasConflict {
+			return c.Status(fiber.StatusConflict).JSON(ErrorResponse{
+				Error:   "conflict",
+				Message: "Alias is already in use, merge the entries instead",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   "create_failed",
+			Message: "Failed to add alias",
+		})
+	}
+
+	handlers.RecordEvent(c, 0, db.EventUpdated, db.EntityHistory, int64(id), before, item)
+
+	return c.Status(fiber.StatusCreated).JSON(item)
+}
+
+// DeleteHistoryAlias removes a spelling from a history entry
+func DeleteHistoryAlias(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid history ID",
+		})
+	}
+
+	aliasID, err := c.ParamsInt("aliasId")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid alias ID",
+		})
+	}
+
+	userID := currentUserID(c)
+	before, _ := db.GetHistoryItemByID(userID, int64(id))
+	if err := db.DeleteHistoryAlias(userID, int64(id), int64(aliasID)); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+				Error:   "not_found",
+				Message: "Alias not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   "delete_failed",
+			Message: "Failed to delete alias",
+		})
+	}
+
+	after, _ := db.GetHistoryItemByID(userID, int64(id))
+	handlers.RecordEvent(c, 0, db.EventUpdated, db.EntityHistory, int64(id), before, after)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
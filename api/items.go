@@ -11,8 +11,40 @@ import (
 const (
 	MaxItemNameLength    = 200
 	MaxDescriptionLength = 500
+	MaxUnitLength        = 20
+	MaxQuantity          = db.MaxItemQuantity
+	MaxPrice             = 1000000
 )
 
+// validateQuantity checks an item's quantity and unit, returning nil if they are valid.
+// A zero quantity is accepted and means "not specified".
+func validateQuantity(quantity float64, unit string) *ErrorResponse {
+	if quantity < 0 || quantity > MaxQuantity {
+		return &ErrorResponse{
+			Error:   "validation_error",
+			Message: "Quantity must be between 0 and 100000",
+		}
+	}
+	if len(unit) > MaxUnitLength {
+		return &ErrorResponse{
+			Error:   "validation_error",
+			Message: "Unit exceeds maximum length of 20 characters",
+		}
+	}
+	return nil
+}
+
+// validatePrice checks a price or budget amount, returning nil if it is valid
+func validatePrice(price float64) *ErrorResponse {
+	if price < 0 || price > MaxPrice {
+		return &ErrorResponse{
+			Error:   "validation_error",
+			Message: "Price must be between 0 and 1000000",
+		}
+	}
+	return nil
+}
+
 // GetItem returns a single item by ID
 func GetItem(c *fiber.Ctx) error {
 	id, err := c.ParamsInt("id")
@@ -23,6 +55,10 @@ func GetItem(c *fiber.Ctx) error {
 		})
 	}
 
+	if _, err := checkItemAccess(c, int64(id), db.AccessRead); err != nil {
+		return accessError(c, err, "Item")
+	}
+
 	item, err := db.GetItemByID(int64(id))
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -50,17 +86,15 @@ func CreateItem(c *fiber.Ctx) error {
 		})
 	}
 
-	if req.Name == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
-			Error:   "validation_error",
-			Message: "Name is required",
-		})
+	// With parse, read missing quantity fields from the name ("500 g mince")
+	if req.Parse && req.Quantity == 0 && req.Unit == "" {
+		req.Name, req.Description, req.Quantity, req.Unit = handlers.QuickAdd(req.Name, req.Description)
 	}
 
-	if req.SectionID == 0 {
+	if req.Name == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
 			Error:   "validation_error",
-			Message: "section_id is required",
+			Message: "Name is required",
 		})
 	}
 
@@ -78,22 +112,62 @@ func CreateItem(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if section exists
-	_, err := db.GetSectionByID(req.SectionID)
-	if err != nil {
-		if err == sql.ErrNoRows {
+	if errResp := validateQuantity(req.Quantity, req.Unit); errResp != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errResp)
+	}
+
+	userID := currentUserID(c)
+	var listID int64
+	if req.SectionID == 0 {
+		// Route the item to the section it was last put in on the target list
+		var list *db.List
+		var err error
+		if req.ListID == 0 {
+			req.ListID = tokenListID(c)
+		}
+		if req.ListID != 0 {
+			list, err = db.GetListByID(userID, req.ListID)
+		} else {
+			list, err = db.GetActiveList(userID)
+		}
+		if err != nil {
 			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
 				Error:   "not_found",
-				Message: "Section not found",
+				Message: "List not found",
 			})
 		}
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-			Error:   "db_error",
-			Message: "Failed to fetch section",
-		})
+		if err := checkListAccess(c, list.ID, db.AccessWrite); err != nil {
+			return accessError(c, err, "List")
+		}
+		listID = list.ID
+
+		section, created, err := db.ResolveItemSection(userID, list.ID, req.Name)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+					Error:   "validation_error",
+					Message: "section_id is required: list has no sections",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+				Error:   "db_error",
+				Message: "Failed to find section",
+			})
+		}
+		if created {
+			handlers.BroadcastListUpdate(listID, "section_created", section)
+		}
+		req.SectionID = section.ID
+	} else {
+		// Check if section exists and the user has access to its list
+		var err error
+		listID, err = checkSectionAccess(c, req.SectionID, db.AccessWrite)
+		if err != nil {
+			return accessError(c, err, "Section")
+		}
 	}
 
-	item, err := db.CreateItem(req.SectionID, req.Name, req.Description)
+	item, merged, err := db.CreateItem(userID, req.SectionID, req.Name, req.Description, req.Quantity, req.Unit)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 			Error:   "create_failed",
@@ -102,9 +176,17 @@ func CreateItem(c *fiber.Ctx) error {
 	}
 
 	// Save to item history for suggestions
-	db.SaveItemHistory(req.Name, req.SectionID)
+	db.SaveItemHistory(userID, req.Name, req.SectionID)
+
+	// Adding an item that is already on the list sums the quantities
+	if merged {
+		handlers.RecordEvent(c, listID, db.EventUpdated, db.EntityItem, item.ID, nil, item)
+		handlers.BroadcastListUpdate(listID, "item_updated", item)
+		return c.JSON(item)
+	}
 
-	handlers.BroadcastUpdate("item_created", item)
+	handlers.RecordEvent(c, listID, db.EventCreated, db.EntityItem, item.ID, nil, item)
+	handlers.BroadcastListUpdate(listID, "item_created", item)
 	return c.Status(fiber.StatusCreated).JSON(item)
 }
 
@@ -126,6 +208,11 @@ func UpdateItem(c *fiber.Ctx) error {
 		})
 	}
 
+	listID, err := checkItemAccess(c, int64(id), db.AccessWrite)
+	if err != nil {
+		return accessError(c, err, "Item")
+	}
+
 	// Get existing item
 	existing, err := db.GetItemByID(int64(id))
 	if err != nil {
@@ -149,6 +236,22 @@ func UpdateItem(c *fiber.Ctx) error {
 	if description == "" && req.Name != "" {
 		description = existing.Description
 	}
+	quantity := existing.Quantity
+	if req.Quantity != nil {
+		quantity = *req.Quantity
+	}
+	unit := existing.Unit
+	if req.Unit != nil {
+		unit = *req.Unit
+	}
+	price := existing.Price
+	if req.Price != nil {
+		price = *req.Price
+	}
+	paidPrice := existing.PaidPrice
+	if req.PaidPrice != nil {
+		paidPrice = *req.PaidPrice
+	}
 
 	if len(name) > MaxItemNameLength {
 		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
@@ -164,7 +267,20 @@ func UpdateItem(c *fiber.Ctx) error {
 		})
 	}
 
-	item, err := db.UpdateItem(int64(id), name, description)
+	if errResp := validateQuantity(quantity, unit); errResp != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errResp)
+	}
+	if quantity == 0 {
+		quantity = 1
+	}
+
+	for _, p := range []float64{price, paidPrice} {
+		if errResp := validatePrice(p); errResp != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(errResp)
+		}
+	}
+
+	item, err := db.UpdateItem(int64(id), name, description, quantity, unit, price, paidPrice)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 			Error:   "update_failed",
@@ -172,7 +288,8 @@ func UpdateItem(c *fiber.Ctx) error {
 		})
 	}
 
-	handlers.BroadcastUpdate("item_updated", item)
+	handlers.RecordEvent(c, listID, db.EventUpdated, db.EntityItem, item.ID, existing, item)
+	handlers.BroadcastListUpdate(listID, "item_updated", item)
 	return c.JSON(item)
 }
 
@@ -186,21 +303,13 @@ func DeleteItem(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if item exists
-	_, err = db.GetItemByID(int64(id))
+	// Check if item exists and the user has access to its list
+	listID, err := checkItemAccess(c, int64(id), db.AccessWrite)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
-				Error:   "not_found",
-				Message: "Item not found",
-			})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-			Error:   "db_error",
-			Message: "Failed to fetch item",
-		})
+		return accessError(c, err, "Item")
 	}
 
+	before, _ := db.GetItemByID(int64(id))
 	if err := db.DeleteItem(int64(id)); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 			Error:   "delete_failed",
@@ -208,7 +317,8 @@ func DeleteItem(c *fiber.Ctx) error {
 		})
 	}
 
-	handlers.BroadcastUpdate("item_deleted", map[string]int64{"id": int64(id)})
+	handlers.RecordEvent(c, listID, db.EventDeleted, db.EntityItem, int64(id), before, nil)
+	handlers.BroadcastListUpdate(listID, "item_deleted", before)
 	return c.SendStatus(fiber.StatusNoContent)
 }
 
@@ -222,22 +332,14 @@ func ToggleItemCompleted(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if item exists
-	_, err = db.GetItemByID(int64(id))
+	// Check if item exists and the user has access to its list
+	listID, err := checkItemAccess(c, int64(id), db.AccessWrite)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
-				Error:   "not_found",
-				Message: "Item not found",
-			})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-			Error:   "db_error",
-			Message: "Failed to fetch item",
-		})
+		return accessError(c, err, "Item")
 	}
 
-	item, err := db.ToggleItemCompleted(int64(id))
+	before, _ := db.GetItemByID(int64(id))
+	item, err := db.ToggleItemCompleted(currentUserID(c), int64(id))
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 			Error:   "toggle_failed",
@@ -245,12 +347,22 @@ func ToggleItemCompleted(c *fiber.Ctx) error {
 		})
 	}
 
-	handlers.BroadcastUpdate("item_toggled", item)
+	handlers.RecordEvent(c, listID, handlers.CompletionAction(item), db.EntityItem, item.ID, before, item)
+	handlers.BroadcastListUpdate(listID, "item_toggled", item)
 	return c.JSON(item)
 }
 
-// ToggleItemUncertain toggles the uncertain status
-func ToggleItemUncertain(c *fiber.Ctx) error {
+// IncrementItem increases an item's quantity by step (default 1)
+func IncrementItem(c *fiber.Ctx) error {
+	return changeItemQuantity(c, 1)
+}
+
+// DecrementItem decreases an item's quantity by step (default 1)
+func DecrementItem(c *fiber.Ctx) error {
+	return changeItemQuantity(c, -1)
+}
+
+func changeItemQuantity(c *fiber.Ctx, sign float64) error {
 	id, err := c.ParamsInt("id")
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
@@ -259,8 +371,30 @@ func ToggleItemUncertain(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if item exists
-	_, err = db.GetItemByID(int64(id))
+	// Body is optional
+	var req ChangeQuantityRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Error:   "invalid_json",
+				Message: "Failed to parse request body",
+			})
+		}
+	}
+	if errResp := validateQuantity(req.Step, ""); errResp != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errResp)
+	}
+	if req.Step == 0 {
+		req.Step = 1
+	}
+
+	listID, err := checkItemAccess(c, int64(id), db.AccessWrite)
+	if err != nil {
+		return accessError(c, err, "Item")
+	}
+
+	before, _ := db.GetItemByID(int64(id))
+	item, err := db.ChangeItemQuantity(int64(id), sign*req.Step)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
@@ -268,12 +402,46 @@ func ToggleItemUncertain(c *fiber.Ctx) error {
 				Message: "Item not found",
 			})
 		}
+		if err == db.ErrQuantityTooLow {
+			return c.Status(fiber.StatusConflict).JSON(ErrorResponse{
+				Error:   "quantity_too_low",
+				Message: "Quantity must stay above zero",
+			})
+		}
+		if err == db.ErrQuantityTooHigh {
+			return c.Status(fiber.StatusConflict).JSON(ErrorResponse{
+				Error:   "quantity_too_high",
+				Message: "Quantity can't exceed 100000",
+			})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-			Error:   "db_error",
-			Message: "Failed to fetch item",
+			Error:   "update_failed",
+			Message: "Failed to change quantity",
 		})
 	}
 
+	handlers.RecordEvent(c, listID, db.EventUpdated, db.EntityItem, item.ID, before, item)
+	handlers.BroadcastListUpdate(listID, "item_updated", item)
+	return c.JSON(item)
+}
+
+// ToggleItemUncertain toggles the uncertain status
+func ToggleItemUncertain(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid item ID",
+		})
+	}
+
+	// Check if item exists and the user has access to its list
+	listID, err := checkItemAccess(c, int64(id), db.AccessWrite)
+	if err != nil {
+		return accessError(c, err, "Item")
+	}
+
+	before, _ := db.GetItemByID(int64(id))
 	item, err := db.ToggleItemUncertain(int64(id))
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
@@ -282,7 +450,8 @@ func ToggleItemUncertain(c *fiber.Ctx) error {
 		})
 	}
 
-	handlers.BroadcastUpdate("item_updated", item)
+	handlers.RecordEvent(c, listID, db.EventUpdated, db.EntityItem, item.ID, before, item)
+	handlers.BroadcastListUpdate(listID, "item_updated", item)
 	return c.JSON(item)
 }
 
@@ -304,43 +473,25 @@ func MoveItem(c *fiber.Ctx) error {
 		})
 	}
 
-	if req.SectionID == 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
-			Error:   "validation_error",
-			Message: "section_id is required",
-		})
-	}
-
-	// Check if item exists
-	_, err = db.GetItemByID(int64(id))
+	// Check if item exists and the user has access to its list
+	listID, err := checkItemAccess(c, int64(id), db.AccessWrite)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
-				Error:   "not_found",
-				Message: "Item not found",
-			})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-			Error:   "db_error",
-			Message: "Failed to fetch item",
-		})
+		return accessError(c, err, "Item")
 	}
 
-	// Check if target section exists
-	_, err = db.GetSectionByID(req.SectionID)
+	// Check if target section exists and the user has access to its list
+	targetListID, err := checkSectionAccess(c, req.SectionID, db.AccessWrite)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
-				Error:   "not_found",
-				Message: "Target section not found",
-			})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-			Error:   "db_error",
-			Message: "Failed to fetch section",
+		return accessError(c, err, "Section")
+	}
+	if targetListID != listID {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "validation_error",
+			Message: "Items can only be moved within their list",
 		})
 	}
 
+	before, _ := db.GetItemByID(int64(id))
 	item, err := db.MoveItemToSection(int64(id), req.SectionID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
@@ -349,7 +500,8 @@ func MoveItem(c *fiber.Ctx) error {
 		})
 	}
 
-	handlers.BroadcastUpdate("item_moved", item)
+	handlers.RecordEvent(c, listID, db.EventMoved, db.EntityItem, item.ID, before, item)
+	handlers.BroadcastListUpdate(listID, "item_moved", item)
 	return c.JSON(item)
 }
 
@@ -363,15 +515,14 @@ func MoveItemUp(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if item exists
+	// Check if item exists and the user has access to its list
+	listID, err := checkItemAccess(c, int64(id), db.AccessWrite)
+	if err != nil {
+		return accessError(c, err, "Item")
+	}
+
 	item, err := db.GetItemByID(int64(id))
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
-				Error:   "not_found",
-				Message: "Item not found",
-			})
-		}
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 			Error:   "db_error",
 			Message: "Failed to fetch item",
@@ -385,7 +536,8 @@ func MoveItemUp(c *fiber.Ctx) error {
 		})
 	}
 
-	handlers.BroadcastUpdate("items_reordered", map[string]int64{"section_id": item.SectionID})
+	handlers.RecordEvent(c, listID, db.EventReordered, db.EntityItem, int64(id), nil, nil)
+	handlers.BroadcastListUpdate(listID, "items_reordered", map[string]int64{"section_id": item.SectionID})
 
 	updatedItem, _ := db.GetItemByID(int64(id))
 	return c.JSON(updatedItem)
@@ -401,15 +553,14 @@ func MoveItemDown(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if item exists
+	// Check if item exists and the user has access to its list
+	listID, err := checkItemAccess(c, int64(id), db.AccessWrite)
+	if err != nil {
+		return accessError(c, err, "Item")
+	}
+
 	item, err := db.GetItemByID(int64(id))
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
-				Error:   "not_found",
-				Message: "Item not found",
-			})
-		}
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 			Error:   "db_error",
 			Message: "Failed to fetch item",
@@ -423,7 +574,8 @@ func MoveItemDown(c *fiber.Ctx) error {
 		})
 	}
 
-	handlers.BroadcastUpdate("items_reordered", map[string]int64{"section_id": item.SectionID})
+	handlers.RecordEvent(c, listID, db.EventReordered, db.EntityItem, int64(id), nil, nil)
+	handlers.BroadcastListUpdate(listID, "items_reordered", map[string]int64{"section_id": item.SectionID})
 
 	updatedItem, _ := db.GetItemByID(int64(id))
 	return c.JSON(updatedItem)
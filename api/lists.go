@@ -15,13 +15,24 @@ const (
 
 // GetLists returns all lists
 func GetLists(c *fiber.Ctx) error {
-	lists, err := db.GetAllLists()
+	lists, err := db.GetAllLists(currentUserID(c))
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 			Error:   "db_error",
 			Message: "Failed to fetch lists",
 		})
 	}
+
+	// A list-scoped token only sees its list
+	if scoped := tokenListID(c); scoped != 0 {
+		var visible []db.List
+		for _, list := range lists {
+			if list.ID == scoped {
+				visible = append(visible, list)
+			}
+		}
+		lists = visible
+	}
 	return c.JSON(ListsResponse{Lists: lists})
 }
 
@@ -35,7 +46,11 @@ func GetList(c *fiber.Ctx) error {
 		})
 	}
 
-	list, err := db.GetListByID(int64(id))
+	if err := checkListAccess(c, int64(id), db.AccessRead); err != nil {
+		return accessError(c, err, "List")
+	}
+
+	list, err := db.GetListByID(currentUserID(c), int64(id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
@@ -84,7 +99,8 @@ func CreateList(c *fiber.Ctx) error {
 	}
 
 	icon := NormalizeIcon(req.Icon)
-	list, err := db.CreateList(req.Name, icon)
+	userID := currentUserID(c)
+	list, err := db.CreateList(userID, req.Name, icon)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 			Error:   "create_failed",
@@ -92,7 +108,8 @@ func CreateList(c *fiber.Ctx) error {
 		})
 	}
 
-	handlers.BroadcastUpdate("list_created", list)
+	handlers.RecordEvent(c, list.ID, db.EventCreated, db.EntityList, list.ID, nil, list)
+	handlers.BroadcastToUsers([]int64{userID}, list.ID, "list_created", list)
 	return c.Status(fiber.StatusCreated).JSON(list)
 }
 
@@ -114,8 +131,13 @@ func UpdateList(c *fiber.Ctx) error {
 		})
 	}
 
-	// Get existing list to check if it exists and for default values
-	existing, err := db.GetListByID(int64(id))
+	userID := currentUserID(c)
+	if err := checkListAccess(c, int64(id), db.AccessWrite); err != nil {
+		return accessError(c, err, "List")
+	}
+
+	// Get existing list for default values
+	existing, err := db.GetListByID(userID, int64(id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
@@ -147,7 +169,15 @@ func UpdateList(c *fiber.Ctx) error {
 		})
 	}
 
-	list, err := db.UpdateList(int64(id), name, icon)
+	budget := existing.Budget
+	if req.Budget != nil {
+		budget = *req.Budget
+	}
+	if errResp := validatePrice(budget); errResp != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errResp)
+	}
+
+	list, err := db.UpdateList(userID, int64(id), name, icon, budget)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 			Error:   "update_failed",
@@ -155,7 +185,8 @@ func UpdateList(c *fiber.Ctx) error {
 		})
 	}
 
-	handlers.BroadcastUpdate("list_updated", list)
+	handlers.RecordEvent(c, int64(id), db.EventUpdated, db.EntityList, int64(id), existing, list)
+	handlers.BroadcastListUpdate(int64(id), "list_updated", list)
 	return c.JSON(list)
 }
 
@@ -169,21 +200,15 @@ func DeleteList(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if list exists
-	_, err = db.GetListByID(int64(id))
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
-				Error:   "not_found",
-				Message: "List not found",
-			})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-			Error:   "db_error",
-			Message: "Failed to fetch list",
-		})
+	// Check if list exists and the user has access to it
+	if err := checkListAccess(c, int64(id), db.AccessOwner); err != nil {
+		return accessError(c, err, "List")
 	}
 
+	// Members have to be looked up before the list is gone
+	userIDs, _ := db.GetListUserIDs(int64(id))
+	before, _ := db.GetListByID(currentUserID(c), int64(id))
+
 	if err := db.DeleteList(int64(id)); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 			Error:   "delete_failed",
@@ -191,7 +216,9 @@ func DeleteList(c *fiber.Ctx) error {
 		})
 	}
 
-	handlers.BroadcastUpdate("list_deleted", map[string]int64{"id": int64(id)})
+	// The list's own events are deleted with it, so this one is kept outside the list
+	handlers.RecordEvent(c, 0, db.EventDeleted, db.EntityList, int64(id), before, nil)
+	handlers.BroadcastToUsers(userIDs, int64(id), "list_deleted", before)
 	return c.SendStatus(fiber.StatusNoContent)
 }
 
@@ -205,19 +232,9 @@ func GetListSections(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if list exists
-	_, err = db.GetListByID(int64(id))
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
-				Error:   "not_found",
-				Message: "List not found",
-			})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-			Error:   "db_error",
-			Message: "Failed to fetch list",
-		})
+	// Check if list exists and the user has access to it
+	if err := checkListAccess(c, int64(id), db.AccessRead); err != nil {
+		return accessError(c, err, "List")
 	}
 
 	sections, err := db.GetSectionsByList(int64(id))
@@ -231,8 +248,8 @@ func GetListSections(c *fiber.Ctx) error {
 	return c.JSON(SectionsResponse{Sections: sections})
 }
 
-// MoveListUp moves a list up in sort order
-func MoveListUp(c *fiber.Ctx) error {
+// GetListPresence returns who has a list open in the app, and what they last did on it
+func GetListPresence(c *fiber.Ctx) error {
 	id, err := c.ParamsInt("id")
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
@@ -241,31 +258,39 @@ func MoveListUp(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if list exists
-	_, err = db.GetListByID(int64(id))
+	if err := checkListAccess(c, int64(id), db.AccessRead); err != nil {
+		return accessError(c, err, "List")
+	}
+
+	return c.JSON(PresenceResponse{Presence: handlers.ListPresence(int64(id))})
+}
+
+// MoveListUp moves a list up in sort order
+func MoveListUp(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
-				Error:   "not_found",
-				Message: "List not found",
-			})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-			Error:   "db_error",
-			Message: "Failed to fetch list",
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid list ID",
 		})
 	}
 
-	if err := db.MoveListUp(int64(id)); err != nil {
+	// Check if list exists and the user has access to it
+	if err := checkListAccess(c, int64(id), db.AccessRead); err != nil {
+		return accessError(c, err, "List")
+	}
+
+	if err := db.MoveListUp(currentUserID(c), int64(id)); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 			Error:   "move_failed",
 			Message: "Failed to move list",
 		})
 	}
 
-	handlers.BroadcastUpdate("lists_reordered", nil)
+	handlers.RecordEvent(c, int64(id), db.EventReordered, db.EntityList, int64(id), nil, nil)
+	handlers.BroadcastListsReordered(currentUserID(c))
 
-	list, _ := db.GetListByID(int64(id))
+	list, _ := db.GetListByID(currentUserID(c), int64(id))
 	return c.JSON(list)
 }
 
@@ -279,30 +304,21 @@ func MoveListDown(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if list exists
-	_, err = db.GetListByID(int64(id))
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
-				Error:   "not_found",
-				Message: "List not found",
-			})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-			Error:   "db_error",
-			Message: "Failed to fetch list",
-		})
+	// Check if list exists and the user has access to it
+	if err := checkListAccess(c, int64(id), db.AccessRead); err != nil {
+		return accessError(c, err, "List")
 	}
 
-	if err := db.MoveListDown(int64(id)); err != nil {
+	if err := db.MoveListDown(currentUserID(c), int64(id)); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 			Error:   "move_failed",
 			Message: "Failed to move list",
 		})
 	}
 
-	handlers.BroadcastUpdate("lists_reordered", nil)
+	handlers.RecordEvent(c, int64(id), db.EventReordered, db.EntityList, int64(id), nil, nil)
+	handlers.BroadcastListsReordered(currentUserID(c))
 
-	list, _ := db.GetListByID(int64(id))
+	list, _ := db.GetListByID(currentUserID(c), int64(id))
 	return c.JSON(list)
 }
@@ -1,32 +1,51 @@
 package api
 
 import (
+	"crypto/subtle"
+	"database/sql"
+	"log"
 	"os"
+	"shopping-list/db"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-// GetAPIToken returns the API token from environment, empty if not set
+// GetAPIToken returns the legacy API token from environment, empty if not set
 func GetAPIToken() string {
 	return os.Getenv("API_TOKEN")
 }
 
-// IsAPIEnabled returns true if API_TOKEN is set
-func IsAPIEnabled() bool {
-	return GetAPIToken() != ""
+// currentToken returns the token the request was authenticated with
+func currentToken(c *fiber.Ctx) *db.APIToken {
+	token, _ := c.Locals("api_token").(*db.APIToken)
+	return token
 }
 
-// TokenAuthMiddleware validates Bearer token in Authorization header
-func TokenAuthMiddleware(c *fiber.Ctx) error {
-	expectedToken := GetAPIToken()
-	if expectedToken == "" {
-		return c.Status(fiber.StatusServiceUnavailable).JSON(ErrorResponse{
-			Error:   "api_disabled",
-			Message: "API is not enabled on this server",
-		})
+// authenticate returns the token and the user it acts as
+func authenticate(token string) (*db.APIToken, *db.User, error) {
+	// The API_TOKEN env var is a full access token of the first admin
+	if expected := GetAPIToken(); expected != "" && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+		admin, err := db.GetFirstAdmin()
+		if err != nil {
+			return nil, nil, err
+		}
+		return &db.APIToken{Name: "API_TOKEN", UserID: admin.ID, Scope: db.TokenScopeFull}, admin, nil
+	}
+
+	t, err := db.AuthenticateAPIToken(token)
+	if err != nil {
+		return nil, nil, err
 	}
+	user, err := db.GetUserByID(t.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return t, user, nil
+}
 
+// TokenAuthMiddleware validates Bearer token in Authorization header and enforces its scope
+func TokenAuthMiddleware(c *fiber.Ctx) error {
 	authHeader := c.Get("Authorization")
 	if authHeader == "" {
 		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
@@ -44,12 +63,56 @@ func TokenAuthMiddleware(c *fiber.Ctx) error {
 		})
 	}
 
-	if parts[1] != expectedToken {
+	token, user, err := authenticate(parts[1])
+	switch {
+	case err == sql.ErrNoRows:
 		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
 			Error:   "invalid_token",
 			Message: "Invalid API token",
 		})
+	case err == db.ErrTokenExpired:
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   "token_expired",
+			Message: "API token has expired",
+		})
+	case err != nil:
+		log.Printf("[API] Token authentication failed: %v", err)
+		return c.Status(fiber.StatusServiceUnavailable).JSON(ErrorResponse{
+			Error:   "db_error",
+			Message: "Failed to load API user",
+		})
 	}
 
+	switch token.Scope {
+	case db.TokenScopeRead:
+		if c.Method() != fiber.MethodGet && c.Method() != fiber.MethodHead {
+			return c.Status(fiber.StatusForbidden).JSON(ErrorResponse{
+				Error:   "forbidden",
+				Message: "This token is read-only",
+			})
+		}
+	case db.TokenScopeHistory:
+		if path := c.Path(); path != "/api/v1/history" && !strings.HasPrefix(path, "/api/v1/history/") {
+			return c.Status(fiber.StatusForbidden).JSON(ErrorResponse{
+				Error:   "forbidden",
+				Message: "This token can only access the item history",
+			})
+		}
+	}
+
+	c.Locals("user", user)
+	c.Locals("api_token", token)
+
+	return c.Next()
+}
+
+// allListsOnly rejects tokens limited to one list on endpoints that are not about a single list
+func allListsOnly(c *fiber.Ctx) error {
+	if tokenListID(c) != 0 {
+		return c.Status(fiber.StatusForbidden).JSON(ErrorResponse{
+			Error:   "forbidden",
+			Message: "This token is limited to one list",
+		})
+	}
 	return c.Next()
 }
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"shopping-list/db"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	DefaultPriceHistoryLimit = 50
+	MaxPriceHistoryLimit     = 500
+)
+
+// PriceHistoryResponse wraps recorded prices for an item name
+type PriceHistoryResponse struct {
+	Name   string          `json:"name"`
+	Prices []db.PricePoint `json:"prices"`
+}
+
+// GetPriceHistory returns recorded paid prices for an item name, newest first
+func GetPriceHistory(c *fiber.Ctx) error {
+	name := c.Query("name")
+	if name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "validation_error",
+			Message: "name query parameter is required",
+		})
+	}
+
+	limit := c.QueryInt("limit", DefaultPriceHistoryLimit)
+	if limit <= 0 || limit > MaxPriceHistoryLimit {
+		limit = DefaultPriceHistoryLimit
+	}
+
+	prices, err := db.GetPriceHistory(currentUserID(c), name, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   "db_error",
+			Message: "Failed to fetch price history",
+		})
+	}
+
+	if prices == nil {
+		prices = []db.PricePoint{}
+	}
+
+	return c.JSON(PriceHistoryResponse{Name: name, Prices: prices})
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"database/sql"
+	"shopping-list/db"
+	"shopping-list/handlers"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RecurrencesResponse wraps multiple recurrences
+type RecurrencesResponse struct {
+	Recurrences []db.Recurrence `json:"recurrences"`
+}
+
+// CreateRecurrenceRequest makes a list item, or a template item on a list, recurring.
+// The rule is one of: {"kind":"interval","interval_days":7}, {"kind":"weekday","weekday":1}
+// or {"kind":"month_day","month_day":15}.
+type CreateRecurrenceRequest struct {
+	ItemID         int64 `json:"item_id,omitempty"`
+	TemplateItemID int64 `json:"template_item_id,omitempty"`
+	ListID         int64 `json:"list_id,omitempty"` // list a template item is added to
+	db.RecurrenceRule
+}
+
+// GetRecurrences returns recurring items, optionally for one list (?list_id=)
+func GetRecurrences(c *fiber.Ctx) error {
+	listID := int64(c.QueryInt("list_id", 0))
+	if scoped := tokenListID(c); scoped != 0 {
+		if listID != 0 && listID != scoped {
+			return c.Status(fiber.StatusForbidden).JSON(ErrorResponse{
+				Error:   "forbidden",
+				Message: "This token is limited to one list",
+			})
+		}
+		listID = scoped
+	}
+
+	recurrences, err := db.GetRecurrences(currentUserID(c), listID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   "db_error",
+			Message: "Failed to fetch recurrences",
+		})
+	}
+
+	if recurrences == nil {
+		recurrences = []db.Recurrence{}
+	}
+
+	return c.JSON(RecurrencesResponse{Recurrences: recurrences})
+}
+
+// GetRecurrence returns a single recurrence
+func GetRecurrence(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid recurrence ID",
+		})
+	}
+
+	recurrence, err := recurrenceWithAccess(c, int64(id), db.AccessRead)
+	if err != nil {
+		return accessError(c, err, "Recurrence")
+	}
+
+	return c.JSON(recurrence)
+}
+
+// recurrenceWithAccess returns a recurrence if the user has the access needed to its list
+func recurrenceWithAccess(c *fiber.Ctx, id int64, need db.Access) (*db.Recurrence, error) {
+	recurrence, err := db.GetRecurrenceByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkListAccess(c, recurrence.ListID, need); err != nil {
+		return nil, err
+	}
+	return recurrence, nil
+}
+
+// CreateRecurrence sets the recurrence rule of a list item or template item,
+// replacing any rule it already had
+func CreateRecurrence(c *fiber.Ctx) error {
+	var req CreateRecurrenceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "invalid_json",
+			Message: "Failed to parse request body",
+		})
+	}
+
+	if (req.ItemID == 0) == (req.TemplateItemID == 0) {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "validation_error",
+			Message: "Exactly one of item_id or template_item_id is required",
+		})
+	}
+
+	if req.TemplateItemID != 0 && req.ListID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "validation_error",
+			Message: "list_id is required for template items",
+		})
+	}
+
+	if err := req.RecurrenceRule.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "validation_error",
+			Message: err.Error(),
+		})
+	}
+
+	// The list the item is on, or the template item is added to, has to be writable,
+	// and template items have to be on one of the user's own templates
+	var err error
+	if req.ItemID != 0 {
+		_, err = checkItemAccess(c, req.ItemID, db.AccessWrite)
+	} else {
+		err = checkListAccess(c, req.ListID, db.AccessWrite)
+		if err == nil {
+			_, err = db.CheckTemplateItemAccess(currentUserID(c), req.TemplateItemID)
+		}
+	}
+	if err != nil {
+		return accessError(c, err, "Item or list")
+	}
+
+	var recurrence *db.Recurrence
+	if req.ItemID != 0 {
+		recurrence, err = db.SetItemRecurrence(req.ItemID, req.RecurrenceRule)
+	} else {
+		recurrence, err = db.SetTemplateItemRecurrence(req.TemplateItemID, req.ListID, req.RecurrenceRule)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+				Error:   "not_found",
+				Message: "Item, template item or list not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   "create_failed",
+			Message: "Failed to create recurrence",
+		})
+	}
+
+	handlers.RecordEvent(c, recurrence.ListID, db.EventCreated, db.EntityRecurrence, recurrence.ID, nil, recurrence)
+	return c.Status(fiber.StatusCreated).JSON(recurrence)
+}
+
+// UpdateRecurrence changes a recurrence rule; the next due date counts from now
+func UpdateRecurrence(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid recurrence ID",
+		})
+	}
+
+	var rule db.RecurrenceRule
+	if err := c.BodyParser(&rule); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "invalid_json",
+			Message: "Failed to parse request body",
+		})
+	}
+
+	if err := rule.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "validation_error",
+			Message: err.Error(),
+		})
+	}
+
+	before, err := recurrenceWithAccess(c, int64(id), db.AccessWrite)
+	if err != nil {
+		return accessError(c, err, "Recurrence")
+	}
+
+	recurrence, err := db.UpdateRecurrenceRule(int64(id), rule)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+				Error:   "not_found",
+				Message: "Recurrence not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   "update_failed",
+			Message: "Failed to update recurrence",
+		})
+	}
+
+	handlers.RecordEvent(c, recurrence.ListID, db.EventUpdated, db.EntityRecurrence, recurrence.ID, before, recurrence)
+	return c.JSON(recurrence)
+}
+
+// DeleteRecurrence stops an item from recurring; the item itself is kept
+func DeleteRecurrence(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid recurrence ID",
+		})
+	}
+
+	before, err := recurrenceWithAccess(c, int64(id), db.AccessWrite)
+	if err != nil {
+		return accessError(c, err, "Recurrence")
+	}
+
+	if err := db.DeleteRecurrence(int64(id)); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+				Error:   "not_found",
+				Message: "Recurrence not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   "delete_failed",
+			Message: "Failed to delete recurrence",
+		})
+	}
+
+	handlers.RecordEvent(c, before.ListID, db.EventDeleted, db.EntityRecurrence, before.ID, before, nil)
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"shopping-list/db"
+	"shopping-list/handlers"
 	"unicode"
 )
 
@@ -21,6 +22,11 @@ type SectionsResponse struct {
 	Sections []db.Section `json:"sections"`
 }
 
+// PresenceResponse wraps the users who have a list open
+type PresenceResponse struct {
+	Presence []handlers.Presence `json:"presence"`
+}
+
 // ItemsResponse wraps multiple items
 type ItemsResponse struct {
 	Items []db.Item `json:"items"`
@@ -55,8 +61,11 @@ type BatchSectionInput struct {
 
 // BatchItemInput represents an item for creation
 type BatchItemInput struct {
-	Name        string `json:"name"`
-	Description string `json:"description,omitempty"`
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Quantity    float64 `json:"quantity,omitempty"`
+	Unit        string  `json:"unit,omitempty"`
+	Parse       bool    `json:"parse,omitempty"` // read quantity, unit and note from the name ("500 g mince")
 }
 
 // BatchCreateResponse represents the response from batch creation
@@ -74,8 +83,9 @@ type CreateListRequest struct {
 
 // UpdateListRequest for updating a list
 type UpdateListRequest struct {
-	Name string `json:"name,omitempty"`
-	Icon string `json:"icon,omitempty"`
+	Name   string   `json:"name,omitempty"`
+	Icon   string   `json:"icon,omitempty"`
+	Budget *float64 `json:"budget,omitempty"`
 }
 
 // CreateSectionRequest for creating a new section
@@ -91,17 +101,30 @@ type UpdateSectionRequest struct {
 
 // CreateItemRequest for creating a new item
 type CreateItemRequest struct {
-	SectionID   int64  `json:"section_id"`
-	Name        string `json:"name"`
-	Description string `json:"description,omitempty"`
+	SectionID   int64   `json:"section_id,omitempty"` // omitted: routed to the remembered section
+	ListID      int64   `json:"list_id,omitempty"`    // list to route into without section_id, default active list
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Quantity    float64 `json:"quantity,omitempty"`
+	Unit        string  `json:"unit,omitempty"`
+	Parse       bool    `json:"parse,omitempty"` // read quantity, unit and note from the name ("500 g mince")
 }
 
 // UpdateItemRequest for updating an item
 type UpdateItemRequest struct {
-	Name        string `json:"name,omitempty"`
-	Description string `json:"description,omitempty"`
-	Completed   *bool  `json:"completed,omitempty"`
-	Uncertain   *bool  `json:"uncertain,omitempty"`
+	Name        string   `json:"name,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Quantity    *float64 `json:"quantity,omitempty"`
+	Unit        *string  `json:"unit,omitempty"`
+	Price       *float64 `json:"price,omitempty"`
+	PaidPrice   *float64 `json:"paid_price,omitempty"`
+	Completed   *bool    `json:"completed,omitempty"`
+	Uncertain   *bool    `json:"uncertain,omitempty"`
+}
+
+// ChangeQuantityRequest for incrementing or decrementing an item's quantity
+type ChangeQuantityRequest struct {
+	Step float64 `json:"step,omitempty"`
 }
 
 // MoveItemRequest for moving item to another section
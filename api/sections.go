@@ -22,6 +22,10 @@ func GetSection(c *fiber.Ctx) error {
 		})
 	}
 
+	if _, err := checkSectionAccess(c, int64(id), db.AccessRead); err != nil {
+		return accessError(c, err, "Section")
+	}
+
 	section, err := db.GetSectionByID(int64(id))
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -70,19 +74,9 @@ func CreateSection(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if list exists
-	_, err := db.GetListByID(req.ListID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
-				Error:   "not_found",
-				Message: "List not found",
-			})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-			Error:   "db_error",
-			Message: "Failed to fetch list",
-		})
+	// Check if list exists and the user has access to it
+	if err := checkListAccess(c, req.ListID, db.AccessWrite); err != nil {
+		return accessError(c, err, "List")
 	}
 
 	section, err := db.CreateSectionForList(req.ListID, req.Name)
@@ -93,7 +87,8 @@ func CreateSection(c *fiber.Ctx) error {
 		})
 	}
 
-	handlers.BroadcastUpdate("section_created", section)
+	handlers.RecordEvent(c, req.ListID, db.EventCreated, db.EntitySection, section.ID, nil, section)
+	handlers.BroadcastListUpdate(req.ListID, "section_created", section)
 	return c.Status(fiber.StatusCreated).JSON(section)
 }
 
@@ -129,21 +124,13 @@ func UpdateSection(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if section exists
-	_, err = db.GetSectionByID(int64(id))
+	// Check if section exists and the user has access to its list
+	listID, err := checkSectionAccess(c, int64(id), db.AccessWrite)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
-				Error:   "not_found",
-				Message: "Section not found",
-			})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-			Error:   "db_error",
-			Message: "Failed to fetch section",
-		})
+		return accessError(c, err, "Section")
 	}
 
+	before, _ := db.GetSectionByID(int64(id))
 	section, err := db.UpdateSection(int64(id), req.Name)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
@@ -152,7 +139,8 @@ func UpdateSection(c *fiber.Ctx) error {
 		})
 	}
 
-	handlers.BroadcastUpdate("section_updated", section)
+	handlers.RecordEvent(c, listID, db.EventUpdated, db.EntitySection, section.ID, before, section)
+	handlers.BroadcastListUpdate(listID, "section_updated", section)
 	return c.JSON(section)
 }
 
@@ -166,21 +154,13 @@ func DeleteSection(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if section exists
-	_, err = db.GetSectionByID(int64(id))
+	// Check if section exists and the user has access to its list
+	listID, err := checkSectionAccess(c, int64(id), db.AccessWrite)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
-				Error:   "not_found",
-				Message: "Section not found",
-			})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-			Error:   "db_error",
-			Message: "Failed to fetch section",
-		})
+		return accessError(c, err, "Section")
 	}
 
+	before, _ := db.GetSectionByID(int64(id))
 	if err := db.DeleteSection(int64(id)); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 			Error:   "delete_failed",
@@ -188,7 +168,8 @@ func DeleteSection(c *fiber.Ctx) error {
 		})
 	}
 
-	handlers.BroadcastUpdate("section_deleted", map[string]int64{"id": int64(id)})
+	handlers.RecordEvent(c, listID, db.EventDeleted, db.EntitySection, int64(id), before, nil)
+	handlers.BroadcastListUpdate(listID, "section_deleted", before)
 	return c.SendStatus(fiber.StatusNoContent)
 }
 
@@ -202,19 +183,9 @@ func GetSectionItems(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if section exists
-	_, err = db.GetSectionByID(int64(id))
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
-				Error:   "not_found",
-				Message: "Section not found",
-			})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-			Error:   "db_error",
-			Message: "Failed to fetch section",
-		})
+	// Check if section exists and the user has access to its list
+	if _, err := checkSectionAccess(c, int64(id), db.AccessRead); err != nil {
+		return accessError(c, err, "Section")
 	}
 
 	items, err := db.GetItemsBySection(int64(id))
@@ -238,19 +209,10 @@ func MoveSectionUp(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if section exists
-	_, err = db.GetSectionByID(int64(id))
+	// Check if section exists and the user has access to its list
+	listID, err := checkSectionAccess(c, int64(id), db.AccessWrite)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
-				Error:   "not_found",
-				Message: "Section not found",
-			})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-			Error:   "db_error",
-			Message: "Failed to fetch section",
-		})
+		return accessError(c, err, "Section")
 	}
 
 	if err := db.MoveSectionUp(int64(id)); err != nil {
@@ -260,7 +222,8 @@ func MoveSectionUp(c *fiber.Ctx) error {
 		})
 	}
 
-	handlers.BroadcastUpdate("sections_reordered", nil)
+	handlers.RecordEvent(c, listID, db.EventReordered, db.EntitySection, int64(id), nil, nil)
+	handlers.BroadcastSectionsReordered(listID)
 
 	section, _ := db.GetSectionByID(int64(id))
 	return c.JSON(section)
@@ -276,19 +239,10 @@ func MoveSectionDown(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if section exists
-	_, err = db.GetSectionByID(int64(id))
+	// Check if section exists and the user has access to its list
+	listID, err := checkSectionAccess(c, int64(id), db.AccessWrite)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
-				Error:   "not_found",
-				Message: "Section not found",
-			})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-			Error:   "db_error",
-			Message: "Failed to fetch section",
-		})
+		return accessError(c, err, "Section")
 	}
 
 	if err := db.MoveSectionDown(int64(id)); err != nil {
@@ -298,7 +252,8 @@ func MoveSectionDown(c *fiber.Ctx) error {
 		})
 	}
 
-	handlers.BroadcastUpdate("sections_reordered", nil)
+	handlers.RecordEvent(c, listID, db.EventReordered, db.EntitySection, int64(id), nil, nil)
+	handlers.BroadcastSectionsReordered(listID)
 
 	section, _ := db.GetSectionByID(int64(id))
 	return c.JSON(section)
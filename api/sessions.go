@@ -0,0 +1,73 @@
+package api
+
+import (
+	"database/sql"
+	"shopping-list/db"
+	"shopping-list/handlers"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SessionsResponse wraps the devices a user is logged in on
+type SessionsResponse struct {
+	Sessions []db.Session `json:"sessions"`
+}
+
+// RevokeSessionsResponse reports how many sessions were ended
+type RevokeSessionsResponse struct {
+	Revoked int `json:"revoked"`
+}
+
+// GetSessions returns the devices the token's user is logged in on
+func GetSessions(c *fiber.Ctx) error {
+	sessions, err := db.GetUserSessions(currentUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   "db_error",
+			Message: "Failed to fetch sessions",
+		})
+	}
+	if sessions == nil {
+		sessions = []db.Session{}
+	}
+	return c.JSON(SessionsResponse{Sessions: sessions})
+}
+
+// DeleteSession logs one device out and closes its WebSockets
+func DeleteSession(c *fiber.Ctx) error {
+	session, err := db.GetUserSessionByKey(currentUserID(c), c.Params("id"))
+	if err == nil {
+		err = db.DeleteSession(session.ID)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+				Error:   "not_found",
+				Message: "Session not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   "db_error",
+			Message: "Failed to revoke session",
+		})
+	}
+	handlers.CloseSessionConnections([]string{session.ID})
+	handlers.RecordEvent(c, 0, db.EventDeleted, db.EntitySession, 0, session, nil)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// DeleteSessions logs the user out on every device. API tokens are not sessions and keep working.
+func DeleteSessions(c *fiber.Ctx) error {
+	count, err := handlers.RevokeOtherSessions(currentUserID(c), "")
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   "db_error",
+			Message: "Failed to revoke sessions",
+		})
+	}
+	if count > 0 {
+		handlers.RecordEvent(c, 0, db.EventDeleted, db.EntitySession, 0, fiber.Map{"sessions": count}, nil)
+	}
+	return c.JSON(RevokeSessionsResponse{Revoked: count})
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"shopping-list/db"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	DefaultStatsLimit = 20
+	MaxStatsLimit     = 500
+	DefaultStatsWeeks = 12
+	MaxStatsWeeks     = 104
+)
+
+// TopPurchasesResponse wraps the most often bought items
+type TopPurchasesResponse struct {
+	Items []db.TopPurchase `json:"items"`
+}
+
+// PurchaseFrequencyResponse wraps purchase frequency per item
+type PurchaseFrequencyResponse struct {
+	Items []db.PurchaseFrequency `json:"items"`
+}
+
+// WeeklyPurchasesResponse wraps purchase counts per week
+type WeeklyPurchasesResponse struct {
+	Weeks []db.WeeklyPurchases `json:"weeks"`
+}
+
+// purchaseFilter reads the list_id and days query parameters shared by the purchase stats endpoints
+func purchaseFilter(c *fiber.Ctx) (db.PurchaseFilter, *ErrorResponse) {
+	filter := db.PurchaseFilter{UserID: currentUserID(c)}
+
+	listID := c.QueryInt("list_id", 0)
+	if listID < 0 {
+		return filter, &ErrorResponse{
+			Error:   "validation_error",
+			Message: "Invalid list_id",
+		}
+	}
+	filter.ListID = int64(listID)
+
+	// A list-scoped token only sees purchases from its list
+	if scoped := tokenListID(c); scoped != 0 {
+		if filter.ListID != 0 && filter.ListID != scoped {
+			return filter, &ErrorResponse{
+				Error:   "validation_error",
+				Message: "This token is limited to one list",
+			}
+		}
+		filter.ListID = scoped
+	}
+
+	days := c.QueryInt("days", 0)
+	if days < 0 {
+		return filter, &ErrorResponse{
+			Error:   "validation_error",
+			Message: "days must not be negative",
+		}
+	}
+	if days > 0 {
+		filter.Since = time.Now().AddDate(0, 0, -days).Unix()
+	}
+
+	return filter, nil
+}
+
+func statsLimit(c *fiber.Ctx) int {
+	limit := c.QueryInt("limit", DefaultStatsLimit)
+	if limit <= 0 || limit > MaxStatsLimit {
+		return DefaultStatsLimit
+	}
+	return limit
+}
+
+// GetTopPurchases returns the most often bought items
+func GetTopPurchases(c *fiber.Ctx) error {
+	filter, errResp := purchaseFilter(c)
+	if errResp != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errResp)
+	}
+
+	items, err := db.GetTopPurchases(filter, statsLimit(c))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   "db_error",
+			Message: "Failed to fetch purchase stats",
+		})
+	}
+
+	if items == nil {
+		items = []db.TopPurchase{}
+	}
+
+	return c.JSON(TopPurchasesResponse{Items: items})
+}
+
+// GetPurchaseFrequency returns how often each item is bought, optionally for a single name
+func GetPurchaseFrequency(c *fiber.Ctx) error {
+	filter, errResp := purchaseFilter(c)
+	if errResp != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errResp)
+	}
+
+	items, err := db.GetPurchaseFrequency(filter, c.Query("name"), statsLimit(c))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   "db_error",
+			Message: "Failed to fetch purchase stats",
+		})
+	}
+
+	if items == nil {
+		items = []db.PurchaseFrequency{}
+	}
+
+	return c.JSON(PurchaseFrequencyResponse{Items: items})
+}
+
+// GetWeeklyPurchases returns purchase counts per week
+func GetWeeklyPurchases(c *fiber.Ctx) error {
+	filter, errResp := purchaseFilter(c)
+	if errResp != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errResp)
+	}
+
+	weeks := c.QueryInt("weeks", DefaultStatsWeeks)
+	if weeks <= 0 || weeks > MaxStatsWeeks {
+		weeks = DefaultStatsWeeks
+	}
+
+	result, err := db.GetWeeklyPurchases(filter, weeks)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   "db_error",
+			Message: "Failed to fetch purchase stats",
+		})
+	}
+
+	return c.JSON(WeeklyPurchasesResponse{Weeks: result})
+}
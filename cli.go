@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"shopping-list/db"
+	"shopping-list/handlers"
+	"strconv"
+	"time"
+)
+
+const commandUsage = `Usage:
+  shopping-list token create -user <username> -name <name> [-scope full|read|history] [-list <id>] [-days <n>]
+  shopping-list token list -user <username>
+  shopping-list token revoke <id>`
+
+// runCommand runs a command line command and exits with 1 if it fails
+func runCommand(args []string) {
+	if err := runTokenCommand(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		db.Close()
+		os.Exit(1)
+	}
+}
+
+func runTokenCommand(args []string) error {
+	if len(args) < 2 || args[0] != "token" {
+		return fmt.Errorf(commandUsage)
+	}
+
+	switch args[1] {
+	case "create":
+		flags := flag.NewFlagSet("token create", flag.ContinueOnError)
+		username := flags.String("user", "", "user the token acts as")
+		name := flags.String("name", "", "name to recognise the token by")
+		scope := flags.String("scope", db.TokenScopeFull, "full, read or history")
+		listID := flags.Int64("list", 0, "limit the token to this list")
+		days := flags.Int("days", 0, "expire after this many days (0 = never)")
+		if err := flags.Parse(args[2:]); err != nil {
+			return fmt.Errorf(commandUsage)
+		}
+
+		user, err := db.GetUserByUsername(*username)
+		if err != nil {
+			return fmt.Errorf("user %q not found", *username)
+		}
+		if msg := handlers.ValidateAPIToken(user.ID, *name, *scope, *listID); msg != "" {
+			return fmt.Errorf("%s", msg)
+		}
+
+		var expiresAt int64
+		if *days > 0 {
+			expiresAt = time.Now().AddDate(0, 0, *days).Unix()
+		}
+		token, secret, err := db.CreateAPIToken(user.ID, *name, *scope, *listID, expiresAt)
+		if err != nil {
+			return fmt.Errorf("failed to create token: %v", err)
+		}
+		db.RecordEvent(user.ID, 0, db.EventCreated, db.EntityAPIToken, token.ID, nil, token)
+
+		fmt.Printf("Created token %d (%s) for %s. It is only shown once:\n%s\n", token.ID, token.Name, user.Username, secret)
+		return nil
+
+	case "list":
+		flags := flag.NewFlagSet("token list", flag.ContinueOnError)
+		username := flags.String("user", "", "user whose tokens are listed")
+		if err := flags.Parse(args[2:]); err != nil {
+			return fmt.Errorf(commandUsage)
+		}
+
+		user, err := db.GetUserByUsername(*username)
+		if err != nil {
+			return fmt.Errorf("user %q not found", *username)
+		}
+		tokens, err := db.GetAPITokens(user.ID)
+		if err != nil {
+			return fmt.Errorf("failed to fetch tokens: %v", err)
+		}
+		for _, t := range tokens {
+			lastUsed := "never used"
+			if t.LastUsedAt != 0 {
+				lastUsed = "last used " + time.Unix(t.LastUsedAt, 0).Format(time.RFC3339)
+			}
+			fmt.Printf("%d\t%s…\t%s\t%s\t%s\n", t.ID, t.Prefix, t.Scope, t.Name, lastUsed)
+		}
+		return nil
+
+	case "revoke":
+		if len(args) != 3 {
+			return fmt.Errorf(commandUsage)
+		}
+		id, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid token ID %q", args[2])
+		}
+		token, err := db.GetAPITokenByID(id)
+		if err == nil {
+			err = db.DeleteAPIToken(id)
+		}
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("token %d not found", id)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to revoke token: %v", err)
+		}
+		db.RecordEvent(token.UserID, 0, db.EventDeleted, db.EntityAPIToken, id, token, nil)
+
+		fmt.Printf("Revoked token %d (%s)\n", id, token.Name)
+		return nil
+	}
+
+	return fmt.Errorf(commandUsage)
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"os"
@@ -153,6 +154,57 @@ func runMigrations() {
 
 	// Migration: Add icon to lists
 	migrateListIcons()
+
+	// Migration: Quantities and units on items
+	migrateItemQuantities()
+
+	// Migration: Prices, list budgets and price history
+	migratePrices()
+
+	// Migration: Purchase log
+	migratePurchases()
+
+	// Migration: Per-list section memory
+	migrateSectionMemory()
+
+	// Migration: Item name aliases
+	migrateItemAliases()
+
+	// Migration: Recurring items
+	migrateRecurrences()
+
+	// Migration: User accounts
+	migrateUsers()
+
+	// Migration: List owners and sharing
+	migrateListSharing()
+
+	// Migration: Audit trail
+	migrateAuditTrail()
+
+	// Migration: Named API tokens
+	migrateAPITokens()
+
+	// Migration: Two-factor authentication
+	migrateTwoFactor()
+
+	// Migration: Session devices
+	migrateSessionDevices()
+
+	// Migration: OpenID Connect logins
+	migrateUserIdentities()
+
+	// Migration: Public share links for lists
+	migrateShareLinks()
+
+	// Migration: Log of recent changes, replayed to reconnecting clients
+	migrateChangeLog()
+
+	// Migration: Template owners
+	migrateTemplateOwners()
+
+	// Migration: Item history per user
+	migrateHistoryOwners()
 }
 
 func migrateToMultipleLists() {
@@ -297,6 +349,813 @@ func migrateListIcons() {
 	log.Println("Migration completed: List icons added")
 }
 
+func migrateItemQuantities() {
+	// Check if quantity column exists in items
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM pragma_table_info('items') WHERE name='quantity'").Scan(&count)
+	if err != nil {
+		log.Println("Migration check failed:", err)
+		return
+	}
+
+	if count > 0 {
+		return // Already migrated
+	}
+
+	log.Println("Running migration: Adding quantity and unit to items...")
+
+	_, err = DB.Exec(`
+		ALTER TABLE items ADD COLUMN quantity REAL NOT NULL DEFAULT 1;
+		ALTER TABLE items ADD COLUMN unit TEXT NOT NULL DEFAULT '';
+		ALTER TABLE template_items ADD COLUMN quantity REAL NOT NULL DEFAULT 1;
+		ALTER TABLE template_items ADD COLUMN unit TEXT NOT NULL DEFAULT '';
+	`)
+	if err != nil {
+		log.Println("Migration failed - adding quantity and unit:", err)
+		return
+	}
+
+	log.Println("Migration completed: Item quantities and units added")
+}
+
+func migratePrices() {
+	// Check the price columns and the price_history table separately,
+	// since an earlier run may have stopped in between
+	var columns, tables int
+	err := DB.QueryRow("SELECT COUNT(*) FROM pragma_table_info('items') WHERE name='price'").Scan(&columns)
+	if err == nil {
+		err = DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='price_history'").Scan(&tables)
+	}
+	if err != nil {
+		log.Println("Migration check failed:", err)
+		return
+	}
+
+	if columns > 0 && tables > 0 {
+		return // Already migrated
+	}
+
+	log.Println("Running migration: Adding prices and budgets...")
+
+	// One transaction, so a failed step leaves nothing half added for the next start
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Migration failed - starting transaction:", err)
+		return
+	}
+	defer tx.Rollback()
+
+	// price is the expected price per unit, paid_price the amount actually paid for the item
+	if columns == 0 {
+		_, err = tx.Exec(`
+			ALTER TABLE items ADD COLUMN price REAL NOT NULL DEFAULT 0;
+			ALTER TABLE items ADD COLUMN paid_price REAL NOT NULL DEFAULT 0;
+			ALTER TABLE lists ADD COLUMN budget REAL NOT NULL DEFAULT 0;
+		`)
+		if err != nil {
+			log.Println("Migration failed - adding price columns:", err)
+			return
+		}
+	}
+
+	// Kept independently of lists, so history survives deleting a list
+	_, err = tx.Exec(`
+		CREATE TABLE IF NOT EXISTS price_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL COLLATE NOCASE,
+			quantity REAL NOT NULL,
+			unit TEXT NOT NULL DEFAULT '',
+			paid_price REAL NOT NULL,
+			list_id INTEGER,
+			recorded_at INTEGER DEFAULT (strftime('%s', 'now'))
+		);
+		CREATE INDEX IF NOT EXISTS idx_price_history_name ON price_history(name, recorded_at);
+	`)
+	if err != nil {
+		log.Println("Migration failed - creating price_history table:", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("Migration failed - committing prices:", err)
+		return
+	}
+
+	log.Println("Migration completed: Prices and budgets added")
+}
+
+func migratePurchases() {
+	// Check if purchases table exists
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='purchases'").Scan(&count)
+	if err != nil {
+		log.Println("Migration check failed:", err)
+		return
+	}
+
+	if count > 0 {
+		return // Already migrated
+	}
+
+	log.Println("Running migration: Adding purchases table...")
+
+	// No foreign keys: purchases outlive the items, sections and lists they came from
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS purchases (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			item_id INTEGER,
+			name TEXT NOT NULL COLLATE NOCASE,
+			quantity REAL NOT NULL DEFAULT 1,
+			unit TEXT NOT NULL DEFAULT '',
+			list_id INTEGER,
+			section_id INTEGER,
+			section_name TEXT NOT NULL DEFAULT '',
+			purchased_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now'))
+		);
+		CREATE INDEX IF NOT EXISTS idx_purchases_time ON purchases(purchased_at);
+		CREATE INDEX IF NOT EXISTS idx_purchases_name ON purchases(name);
+		CREATE INDEX IF NOT EXISTS idx_purchases_item ON purchases(item_id);
+	`)
+	if err != nil {
+		log.Println("Migration failed - creating purchases table:", err)
+		return
+	}
+
+	log.Println("Migration completed: Purchases table added")
+}
+
+func migrateSectionMemory() {
+	// Check if item_sections table exists
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='item_sections'").Scan(&count)
+	if err != nil {
+		log.Println("Migration check failed:", err)
+		return
+	}
+
+	if count > 0 {
+		return // Already migrated
+	}
+
+	log.Println("Running migration: Adding per-list section memory...")
+
+	// section_name is kept so the memory survives the section being deleted and
+	// can be matched against same-named sections in other lists
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS item_sections (
+			name TEXT NOT NULL COLLATE NOCASE,
+			list_id INTEGER NOT NULL,
+			section_id INTEGER,
+			section_name TEXT NOT NULL COLLATE NOCASE,
+			usage_count INTEGER NOT NULL DEFAULT 1,
+			last_used_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+			PRIMARY KEY (name, list_id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_item_sections_list ON item_sections(list_id);
+	`)
+	if err != nil {
+		log.Println("Migration failed - creating item_sections table:", err)
+		return
+	}
+
+	// Seed from the global history and from the items currently on the lists
+	_, err = DB.Exec(`
+		INSERT OR IGNORE INTO item_sections (name, list_id, section_id, section_name, usage_count, last_used_at)
+		SELECT h.name, s.list_id, s.id, s.name, COALESCE(h.usage_count, 1), COALESCE(h.last_used_at, strftime('%s', 'now'))
+		FROM item_history h
+		JOIN sections s ON s.id = h.last_section_id
+		WHERE s.list_id IS NOT NULL
+	`)
+	if err != nil {
+		log.Println("Migration failed - seeding from item_history:", err)
+	}
+	_, err = DB.Exec(`
+		INSERT OR IGNORE INTO item_sections (name, list_id, section_id, section_name, usage_count, last_used_at)
+		SELECT i.name, s.list_id, s.id, s.name, 1, COALESCE(i.updated_at, strftime('%s', 'now'))
+		FROM items i
+		JOIN sections s ON s.id = i.section_id
+		WHERE s.list_id IS NOT NULL
+	`)
+	if err != nil {
+		log.Println("Migration failed - seeding from items:", err)
+	}
+
+	log.Println("Migration completed: Per-list section memory added")
+}
+
+func migrateItemAliases() {
+	// Check if item_aliases table exists
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='item_aliases'").Scan(&count)
+	if err != nil {
+		log.Println("Migration check failed:", err)
+		return
+	}
+
+	if count > 0 {
+		return // Already migrated
+	}
+
+	log.Println("Running migration: Adding item aliases table...")
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS item_aliases (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			alias TEXT NOT NULL COLLATE NOCASE,
+			history_id INTEGER NOT NULL,
+			created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+			UNIQUE(alias COLLATE NOCASE),
+			FOREIGN KEY (history_id) REFERENCES item_history(id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_item_aliases_history ON item_aliases(history_id);
+	`)
+	if err != nil {
+		log.Println("Migration failed - creating item_aliases table:", err)
+		return
+	}
+
+	log.Println("Migration completed: Item aliases table added")
+}
+
+func migrateRecurrences() {
+	// Check if recurrences table exists
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='recurrences'").Scan(&count)
+	if err != nil {
+		log.Println("Migration check failed:", err)
+		return
+	}
+
+	if count > 0 {
+		return // Already migrated
+	}
+
+	log.Println("Running migration: Adding recurrences table...")
+
+	// item_id has no foreign key: the rule outlives the item (DeleteCompletedItems)
+	// and re-adds it from the stored name, quantity and section name when due
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS recurrences (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			list_id INTEGER NOT NULL,
+			item_id INTEGER,
+			template_item_id INTEGER,
+			name TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			quantity REAL NOT NULL DEFAULT 1,
+			unit TEXT NOT NULL DEFAULT '',
+			section_name TEXT NOT NULL DEFAULT '',
+			kind TEXT NOT NULL,
+			interval_days INTEGER NOT NULL DEFAULT 0,
+			weekday INTEGER NOT NULL DEFAULT 0,
+			month_day INTEGER NOT NULL DEFAULT 0,
+			next_due_at INTEGER NOT NULL,
+			last_run_at INTEGER NOT NULL DEFAULT 0,
+			created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+			FOREIGN KEY (list_id) REFERENCES lists(id) ON DELETE CASCADE,
+			FOREIGN KEY (template_item_id) REFERENCES template_items(id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_recurrences_due ON recurrences(next_due_at);
+		CREATE INDEX IF NOT EXISTS idx_recurrences_item ON recurrences(item_id);
+	`)
+	if err != nil {
+		log.Println("Migration failed - creating recurrences table:", err)
+		return
+	}
+
+	log.Println("Migration completed: Recurrences table added")
+}
+
+func migrateUsers() {
+	// Check if users table exists
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='users'").Scan(&count)
+	if err != nil {
+		log.Println("Migration check failed:", err)
+		return
+	}
+
+	if count > 0 {
+		return // Already migrated
+	}
+
+	log.Println("Running migration: Adding user accounts...")
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL COLLATE NOCASE,
+			password_hash TEXT NOT NULL,
+			is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+			last_login_at INTEGER NOT NULL DEFAULT 0,
+			UNIQUE(username COLLATE NOCASE)
+		);
+
+		CREATE TABLE IF NOT EXISTS invites (
+			code TEXT PRIMARY KEY,
+			created_by INTEGER,
+			is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+			expires_at INTEGER NOT NULL,
+			used_by INTEGER,
+			used_at INTEGER NOT NULL DEFAULT 0,
+			created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+			FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL,
+			FOREIGN KEY (used_by) REFERENCES users(id) ON DELETE SET NULL
+		);
+	`)
+	if err != nil {
+		log.Println("Migration failed - creating users tables:", err)
+		return
+	}
+
+	// Sessions from the shared password are given to the first admin when it is created
+	_, err = DB.Exec(`ALTER TABLE sessions ADD COLUMN user_id INTEGER REFERENCES users(id) ON DELETE CASCADE`)
+	if err != nil {
+		log.Println("Migration failed - adding sessions.user_id:", err)
+		return
+	}
+
+	log.Println("Migration completed: User accounts added")
+}
+
+func migrateListSharing() {
+	// Check if list_members table exists
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='list_members'").Scan(&count)
+	if err != nil {
+		log.Println("Migration check failed:", err)
+		return
+	}
+
+	if count > 0 {
+		return // Already migrated
+	}
+
+	log.Println("Running migration: Adding list owners and sharing...")
+
+	// Existing lists have no owner yet; they are given to the first admin on startup
+	_, err = DB.Exec(`ALTER TABLE lists ADD COLUMN owner_id INTEGER REFERENCES users(id) ON DELETE CASCADE`)
+	if err != nil {
+		log.Println("Migration failed - adding lists.owner_id:", err)
+		return
+	}
+
+	// The active list is now chosen per user instead of lists.is_active
+	_, err = DB.Exec(`ALTER TABLE users ADD COLUMN active_list_id INTEGER REFERENCES lists(id) ON DELETE SET NULL`)
+	if err != nil {
+		log.Println("Migration failed - adding users.active_list_id:", err)
+		return
+	}
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS list_members (
+			list_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			role TEXT NOT NULL DEFAULT 'editor',
+			created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+			PRIMARY KEY (list_id, user_id),
+			FOREIGN KEY (list_id) REFERENCES lists(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_list_members_user ON list_members(user_id);
+		CREATE INDEX IF NOT EXISTS idx_lists_owner ON lists(owner_id);
+	`)
+	if err != nil {
+		log.Println("Migration failed - creating list_members table:", err)
+		return
+	}
+
+	log.Println("Migration completed: List owners and sharing added")
+}
+
+func migrateAuditTrail() {
+	// Check if events table exists
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='events'").Scan(&count)
+	if err != nil {
+		log.Println("Migration check failed:", err)
+		return
+	}
+
+	if count > 0 {
+		return // Already migrated
+	}
+
+	log.Println("Running migration: Adding audit trail...")
+
+	for _, column := range []string{
+		"added_by INTEGER REFERENCES users(id) ON DELETE SET NULL",
+		"completed_by INTEGER REFERENCES users(id) ON DELETE SET NULL",
+		"completed_at INTEGER",
+	} {
+		if _, err := DB.Exec("ALTER TABLE items ADD COLUMN " + column); err != nil {
+			log.Println("Migration failed - adding items column:", err)
+			return
+		}
+	}
+
+	// before_json and after_json hold the entity as it was before and after the change
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			list_id INTEGER,
+			user_id INTEGER,
+			action TEXT NOT NULL,
+			entity_type TEXT NOT NULL,
+			entity_id INTEGER NOT NULL DEFAULT 0,
+			before_json TEXT,
+			after_json TEXT,
+			created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+			FOREIGN KEY (list_id) REFERENCES lists(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_events_list ON events(list_id, id);
+	`)
+	if err != nil {
+		log.Println("Migration failed - creating events table:", err)
+		return
+	}
+
+	log.Println("Migration completed: Audit trail added")
+}
+
+func migrateAPITokens() {
+	// Check if api_tokens table exists
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='api_tokens'").Scan(&count)
+	if err != nil {
+		log.Println("Migration check failed:", err)
+		return
+	}
+
+	if count > 0 {
+		return // Already migrated
+	}
+
+	log.Println("Running migration: Adding API tokens...")
+
+	// Only a SHA-256 hash of each token is kept; prefix finds the candidates to compare
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			prefix TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			scope TEXT NOT NULL DEFAULT 'full',
+			list_id INTEGER,
+			created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+			last_used_at INTEGER NOT NULL DEFAULT 0,
+			expires_at INTEGER NOT NULL DEFAULT 0,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (list_id) REFERENCES lists(id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_api_tokens_prefix ON api_tokens(prefix);
+		CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens(user_id);
+	`)
+	if err != nil {
+		log.Println("Migration failed - creating api_tokens table:", err)
+		return
+	}
+
+	log.Println("Migration completed: API tokens added")
+}
+
+func migrateTwoFactor() {
+	// Check if trusted_devices table exists
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='trusted_devices'").Scan(&count)
+	if err != nil {
+		log.Println("Migration check failed:", err)
+		return
+	}
+
+	if count > 0 {
+		return // Already migrated
+	}
+
+	log.Println("Running migration: Adding two-factor authentication...")
+
+	// totp_pending_secret is set during enrollment until the first code is confirmed.
+	// totp_last_step is the last accepted code's time step, so a code cannot be used twice.
+	for _, column := range []string{
+		"totp_secret TEXT NOT NULL DEFAULT ''",
+		"totp_pending_secret TEXT NOT NULL DEFAULT ''",
+		"totp_enabled BOOLEAN NOT NULL DEFAULT FALSE",
+		"totp_last_step INTEGER NOT NULL DEFAULT 0",
+	} {
+		if _, err := DB.Exec("ALTER TABLE users ADD COLUMN " + column); err != nil {
+			log.Println("Migration failed - adding users column:", err)
+			return
+		}
+	}
+
+	// A pending session has passed the password check and waits for the second step
+	_, err = DB.Exec(`ALTER TABLE sessions ADD COLUMN pending_2fa BOOLEAN NOT NULL DEFAULT FALSE`)
+	if err != nil {
+		log.Println("Migration failed - adding sessions.pending_2fa:", err)
+		return
+	}
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS recovery_codes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			code_hash TEXT NOT NULL,
+			used_at INTEGER NOT NULL DEFAULT 0,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_recovery_codes_user ON recovery_codes(user_id);
+
+		CREATE TABLE IF NOT EXISTS trusted_devices (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			name TEXT NOT NULL DEFAULT '',
+			created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+			last_used_at INTEGER NOT NULL DEFAULT 0,
+			expires_at INTEGER NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_trusted_devices_user ON trusted_devices(user_id);
+	`)
+	if err != nil {
+		log.Println("Migration failed - creating two-factor tables:", err)
+		return
+	}
+
+	log.Println("Migration completed: Two-factor authentication added")
+}
+
+func migrateSessionDevices() {
+	// Check if last_seen_at column exists in sessions
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM pragma_table_info('sessions') WHERE name='last_seen_at'").Scan(&count)
+	if err != nil {
+		log.Println("Migration check failed:", err)
+		return
+	}
+
+	if count > 0 {
+		return // Already migrated
+	}
+
+	log.Println("Running migration: Adding device details to sessions...")
+
+	// name is a device name the user can change; it defaults to one derived from the user agent
+	for _, column := range []string{
+		"user_agent TEXT NOT NULL DEFAULT ''",
+		"ip TEXT NOT NULL DEFAULT ''",
+		"name TEXT NOT NULL DEFAULT ''",
+		"created_at INTEGER NOT NULL DEFAULT 0",
+		"last_seen_at INTEGER NOT NULL DEFAULT 0",
+	} {
+		if _, err := DB.Exec("ALTER TABLE sessions ADD COLUMN " + column); err != nil {
+			log.Println("Migration failed - adding sessions column:", err)
+			return
+		}
+	}
+
+	// Existing sessions were created at an unknown time; use the migration time
+	_, err = DB.Exec("UPDATE sessions SET created_at = strftime('%s', 'now'), last_seen_at = strftime('%s', 'now')")
+	if err != nil {
+		log.Printf("WARNING: Migration UPDATE failed for sessions: %v", err)
+	}
+
+	_, err = DB.Exec("CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id)")
+	if err != nil {
+		log.Println("Migration failed - creating sessions index:", err)
+		return
+	}
+
+	log.Println("Migration completed: Session devices added")
+}
+
+func migrateUserIdentities() {
+	// Check if user_identities table exists
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='user_identities'").Scan(&count)
+	if err != nil {
+		log.Println("Migration check failed:", err)
+		return
+	}
+
+	if count > 0 {
+		return // Already migrated
+	}
+
+	log.Println("Running migration: Adding user identities...")
+
+	// An identity links a user to an account at an OpenID Connect provider, by the provider's subject ID
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS user_identities (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			issuer TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			UNIQUE (issuer, subject)
+		);
+		CREATE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities(user_id);
+	`)
+	if err != nil {
+		log.Println("Migration failed - creating user_identities table:", err)
+		return
+	}
+
+	log.Println("Migration completed: User identities added")
+}
+
+func migrateShareLinks() {
+	// Check if share_links table exists
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='share_links'").Scan(&count)
+	if err != nil {
+		log.Println("Migration check failed:", err)
+		return
+	}
+
+	if count > 0 {
+		return // Already migrated
+	}
+
+	log.Println("Running migration: Adding share links...")
+
+	// Like API tokens, only a SHA-256 hash of each link's token is kept
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS share_links (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			list_id INTEGER NOT NULL,
+			name TEXT NOT NULL DEFAULT '',
+			prefix TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			can_check BOOLEAN NOT NULL DEFAULT FALSE,
+			created_by INTEGER NOT NULL,
+			created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+			expires_at INTEGER NOT NULL,
+			FOREIGN KEY (list_id) REFERENCES lists(id) ON DELETE CASCADE,
+			FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_share_links_list ON share_links(list_id);
+	`)
+	if err != nil {
+		log.Println("Migration failed - creating share_links table:", err)
+		return
+	}
+
+	log.Println("Migration completed: Share links added")
+}
+
+func migrateChangeLog() {
+	// Check if change_log table exists
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='change_log'").Scan(&count)
+	if err != nil {
+		log.Println("Migration check failed:", err)
+		return
+	}
+
+	if count > 0 {
+		return // Already migrated
+	}
+
+	log.Println("Running migration: Adding change log...")
+
+	// AUTOINCREMENT keeps sequence numbers from being reused after old rows are trimmed.
+	// No foreign keys: entries of deleted lists are still replayed to say they were deleted.
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS change_log (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			scope TEXT NOT NULL,
+			list_id INTEGER NOT NULL DEFAULT 0,
+			user_ids TEXT NOT NULL DEFAULT '',
+			type TEXT NOT NULL,
+			entity TEXT NOT NULL DEFAULT '',
+			data TEXT NOT NULL DEFAULT 'null',
+			created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now'))
+		)
+	`)
+	if err != nil {
+		log.Println("Migration failed - creating change_log table:", err)
+		return
+	}
+
+	log.Println("Migration completed: Change log added")
+}
+
+func migrateTemplateOwners() {
+	// Check if templates.owner_id column exists
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM pragma_table_info('templates') WHERE name='owner_id'").Scan(&count)
+	if err != nil {
+		log.Println("Migration check failed:", err)
+		return
+	}
+
+	if count > 0 {
+		return // Already migrated
+	}
+
+	log.Println("Running migration: Adding template owners...")
+
+	// Existing templates have no owner yet; they are given to the first admin on startup
+	_, err = DB.Exec(`
+		ALTER TABLE templates ADD COLUMN owner_id INTEGER REFERENCES users(id) ON DELETE CASCADE;
+		CREATE INDEX IF NOT EXISTS idx_templates_owner ON templates(owner_id, sort_order);
+	`)
+	if err != nil {
+		log.Println("Migration failed - adding templates.owner_id:", err)
+		return
+	}
+
+	log.Println("Migration completed: Template owners added")
+}
+
+func migrateHistoryOwners() {
+	// Check if item_history.user_id column exists
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM pragma_table_info('item_history') WHERE name='user_id'").Scan(&count)
+	if err != nil {
+		log.Println("Migration check failed:", err)
+		return
+	}
+
+	if count > 0 {
+		return // Already migrated
+	}
+
+	log.Println("Running migration: Adding item history owners...")
+
+	// Names and aliases become unique per user, which needs both tables rebuilt.
+	// Foreign keys are off meanwhile, so dropping item_history keeps the aliases;
+	// the pragma is per connection and has no effect inside a transaction.
+	ctx := context.Background()
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		log.Println("Migration failed - getting a connection:", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		log.Println("Migration failed - disabling foreign keys:", err)
+		return
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Migration failed - starting transaction:", err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Existing history has no owner yet; it is given to the first admin on startup
+	_, err = tx.Exec(`
+		CREATE TABLE item_history_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			name TEXT NOT NULL COLLATE NOCASE,
+			last_section_id INTEGER,
+			usage_count INTEGER DEFAULT 1,
+			last_used_at INTEGER DEFAULT (strftime('%s', 'now')),
+			UNIQUE(user_id, name COLLATE NOCASE)
+		);
+		INSERT INTO item_history_new (id, name, last_section_id, usage_count, last_used_at)
+		SELECT id, name, last_section_id, usage_count, last_used_at FROM item_history;
+		DROP TABLE item_history;
+		ALTER TABLE item_history_new RENAME TO item_history;
+		CREATE INDEX IF NOT EXISTS idx_item_history_name ON item_history(name COLLATE NOCASE);
+
+		CREATE TABLE item_aliases_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			alias TEXT NOT NULL COLLATE NOCASE,
+			history_id INTEGER NOT NULL,
+			created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+			UNIQUE(history_id, alias COLLATE NOCASE),
+			FOREIGN KEY (history_id) REFERENCES item_history(id) ON DELETE CASCADE
+		);
+		INSERT INTO item_aliases_new (id, alias, history_id, created_at)
+		SELECT id, alias, history_id, created_at FROM item_aliases;
+		DROP TABLE item_aliases;
+		ALTER TABLE item_aliases_new RENAME TO item_aliases;
+		CREATE INDEX IF NOT EXISTS idx_item_aliases_history ON item_aliases(history_id);
+		CREATE INDEX IF NOT EXISTS idx_item_aliases_alias ON item_aliases(alias COLLATE NOCASE);
+	`)
+	if err != nil {
+		log.Println("Migration failed - rebuilding item history:", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("Migration failed - committing item history owners:", err)
+		return
+	}
+
+	log.Println("Migration completed: Item history owners added")
+}
+
 func Close() {
 	if DB != nil {
 		DB.Close()
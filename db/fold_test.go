@@ -0,0 +1,25 @@
+package db
+
+import "testing"
+
+func TestFoldName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Żółty ser", "zolty ser"},
+		{"Grüße", "grusse"},
+		{"Pâté", "pate"},
+		{"Œuf", "oeuf"},
+		{"Молоко", "moloko"},
+		{"хліб", "khlib"},
+		{"м'ясо", "miaso"},
+		{"Ґава", "gava"},
+		{"milk 2%", "milk 2%"},
+	}
+	for _, tt := range tests {
+		if got := foldName(tt.name); got != tt.want {
+			t.Errorf("foldName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,11 +1,21 @@
 package db
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"math"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // Section represents a shopping list section
@@ -25,17 +35,33 @@ type Item struct {
 	SectionID   int64     `json:"section_id"`
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
+	Quantity    float64   `json:"quantity"`
+	Unit        string    `json:"unit"`
+	Price       float64   `json:"price"`      // expected price per unit, 0 if unknown
+	PaidPrice   float64   `json:"paid_price"` // amount actually paid for the item, 0 if not yet paid
 	Completed   bool      `json:"completed"`
 	Uncertain   bool      `json:"uncertain"`
 	SortOrder   int       `json:"sort_order"`
+	AddedBy     int64     `json:"added_by"`     // user ID, 0 if unknown or added by a recurrence
+	CompletedBy int64     `json:"completed_by"` // user ID, 0 if not completed or unknown
+	CompletedAt int64     `json:"completed_at"` // 0 if not completed
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   int64     `json:"updated_at"`
 }
 
-// Session represents a user session
+// Session represents a user session, one per logged in device
 type Session struct {
-	ID        string
-	ExpiresAt int64
+	ID         string `json:"-"`  // the session cookie, never shown
+	Key        string `json:"id"` // identifies the session without revealing the cookie
+	UserID     int64  `json:"-"`
+	ExpiresAt  int64  `json:"expires_at"`
+	Pending2FA bool   `json:"-"` // password checked, waiting for the two-factor code
+	UserAgent  string `json:"user_agent"`
+	IP         string `json:"ip"`
+	Name       string `json:"name"`
+	CreatedAt  int64  `json:"created_at"`
+	LastSeenAt int64  `json:"last_seen_at"`
+	Current    bool   `json:"current"` // whether this is the session making the request
 }
 
 // List represents a shopping list
@@ -45,6 +71,9 @@ type List struct {
 	Icon      string    `json:"icon"`
 	SortOrder int       `json:"sort_order"`
 	IsActive  bool      `json:"is_active"`
+	Budget    float64   `json:"budget"` // 0 means no budget
+	OwnerID   int64     `json:"owner_id"`
+	Role      string    `json:"role"` // the current user's role: owner, editor or viewer
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt int64     `json:"updated_at"`
 	Stats     Stats     `json:"stats,omitempty"`
@@ -53,6 +82,7 @@ type List struct {
 // Template represents a reusable template
 type Template struct {
 	ID          int64          `json:"id"`
+	OwnerID     int64          `json:"owner_id"`
 	Name        string         `json:"name"`
 	Description string         `json:"description"`
 	SortOrder   int            `json:"sort_order"`
@@ -68,19 +98,39 @@ type TemplateItem struct {
 	SectionName string    `json:"section_name"`
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
+	Quantity    float64   `json:"quantity"`
+	Unit        string    `json:"unit"`
 	SortOrder   int       `json:"sort_order"`
 	CreatedAt   time.Time `json:"created_at"`
 }
 
 // ==================== LISTS ====================
 
-// GetAllLists returns all shopping lists with their stats
-func GetAllLists() ([]List, error) {
-	rows, err := DB.Query(`
-		SELECT id, name, COALESCE(icon, '🛒'), sort_order, is_active, created_at, COALESCE(updated_at, 0)
-		FROM lists
-		ORDER BY sort_order ASC
-	`)
+// listQuery selects the lists a user owns or is a member of, with their role and
+// whether the list is the one they have open. It takes the user ID four times.
+const listQuery = `
+	SELECT l.id, l.name, COALESCE(l.icon, '🛒'), l.sort_order, l.id = COALESCE(u.active_list_id, 0),
+		l.budget, l.created_at, COALESCE(l.updated_at, 0), COALESCE(l.owner_id, 0),
+		CASE WHEN l.owner_id = ? THEN 'owner' ELSE m.role END
+	FROM lists l
+	LEFT JOIN list_members m ON m.list_id = l.id AND m.user_id = ?
+	LEFT JOIN users u ON u.id = ?
+	WHERE (l.owner_id = ? OR m.user_id IS NOT NULL)`
+
+func scanList(row interface{ Scan(...interface{}) error }) (*List, error) {
+	var l List
+	err := row.Scan(&l.ID, &l.Name, &l.Icon, &l.SortOrder, &l.IsActive, &l.Budget, &l.CreatedAt, &l.UpdatedAt, &l.OwnerID, &l.Role)
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// GetAllLists returns the lists a user can see with their stats
+func GetAllLists(userID int64) ([]List, error) {
+	rows, err := DB.Query(listQuery+`
+		ORDER BY l.sort_order ASC
+	`, userID, userID, userID, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -88,48 +138,42 @@ func GetAllLists() ([]List, error) {
 
 	var lists []List
 	for rows.Next() {
-		var l List
-		err := rows.Scan(&l.ID, &l.Name, &l.Icon, &l.SortOrder, &l.IsActive, &l.CreatedAt, &l.UpdatedAt)
+		l, err := scanList(rows)
 		if err != nil {
 			return nil, err
 		}
 		l.Stats = GetListStats(l.ID)
-		lists = append(lists, l)
+		lists = append(lists, *l)
 	}
 	return lists, nil
 }
 
-// GetListByID returns a single list by ID
-func GetListByID(id int64) (*List, error) {
-	var l List
-	err := DB.QueryRow(`
-		SELECT id, name, COALESCE(icon, '🛒'), sort_order, is_active, created_at, COALESCE(updated_at, 0)
-		FROM lists WHERE id = ?
-	`, id).Scan(&l.ID, &l.Name, &l.Icon, &l.SortOrder, &l.IsActive, &l.CreatedAt, &l.UpdatedAt)
+// GetListByID returns a single list by ID. Lists the user cannot see are sql.ErrNoRows.
+func GetListByID(userID, id int64) (*List, error) {
+	l, err := scanList(DB.QueryRow(listQuery+` AND l.id = ?`, userID, userID, userID, userID, id))
 	if err != nil {
 		return nil, err
 	}
 	l.Stats = GetListStats(l.ID)
-	return &l, nil
+	return l, nil
 }
 
-// GetActiveList returns the currently active list
-func GetActiveList() (*List, error) {
-	var l List
-	err := DB.QueryRow(`
-		SELECT id, name, COALESCE(icon, '🛒'), sort_order, is_active, created_at, COALESCE(updated_at, 0)
-		FROM lists WHERE is_active = TRUE
+// GetActiveList returns the list a user has open, or their first list if they have not
+// opened one or it is no longer shared with them
+func GetActiveList(userID int64) (*List, error) {
+	l, err := scanList(DB.QueryRow(listQuery+`
+		ORDER BY l.id = COALESCE(u.active_list_id, 0) DESC, l.sort_order ASC
 		LIMIT 1
-	`).Scan(&l.ID, &l.Name, &l.Icon, &l.SortOrder, &l.IsActive, &l.CreatedAt, &l.UpdatedAt)
+	`, userID, userID, userID, userID))
 	if err != nil {
 		return nil, err
 	}
 	l.Stats = GetListStats(l.ID)
-	return &l, nil
+	return l, nil
 }
 
-// CreateList creates a new shopping list
-func CreateList(name, icon string) (*List, error) {
+// CreateList creates a new shopping list owned by a user
+func CreateList(ownerID int64, name, icon string) (*List, error) {
 	var maxOrder int
 	DB.QueryRow("SELECT COALESCE(MAX(sort_order), -1) FROM lists").Scan(&maxOrder)
 
@@ -138,30 +182,30 @@ func CreateList(name, icon string) (*List, error) {
 	}
 
 	result, err := DB.Exec(`
-		INSERT INTO lists (name, icon, sort_order, is_active) VALUES (?, ?, ?, FALSE)
-	`, name, icon, maxOrder+1)
+		INSERT INTO lists (name, icon, sort_order, is_active, owner_id) VALUES (?, ?, ?, FALSE, ?)
+	`, name, icon, maxOrder+1, ownerID)
 	if err != nil {
 		return nil, err
 	}
 
 	id, _ := result.LastInsertId()
-	return GetListByID(id)
+	return GetListByID(ownerID, id)
 }
 
-// UpdateList updates a list's name and icon
-func UpdateList(id int64, name, icon string) (*List, error) {
+// UpdateList updates a list's name, icon and budget, returning it as the user sees it
+func UpdateList(userID, id int64, name, icon string, budget float64) (*List, error) {
 	if icon == "" {
-		_, err := DB.Exec(`UPDATE lists SET name = ?, updated_at = strftime('%s', 'now') WHERE id = ?`, name, id)
+		_, err := DB.Exec(`UPDATE lists SET name = ?, budget = ?, updated_at = strftime('%s', 'now') WHERE id = ?`, name, budget, id)
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		_, err := DB.Exec(`UPDATE lists SET name = ?, icon = ?, updated_at = strftime('%s', 'now') WHERE id = ?`, name, icon, id)
+		_, err := DB.Exec(`UPDATE lists SET name = ?, icon = ?, budget = ?, updated_at = strftime('%s', 'now') WHERE id = ?`, name, icon, budget, id)
 		if err != nil {
 			return nil, err
 		}
 	}
-	return GetListByID(id)
+	return GetListByID(userID, id)
 }
 
 // DeleteList deletes a list and all its sections/items
@@ -170,31 +214,14 @@ func DeleteList(id int64) error {
 	return err
 }
 
-// SetActiveList sets a list as the active one
-func SetActiveList(id int64) error {
-	tx, err := DB.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
-	// Deactivate all lists
-	_, err = tx.Exec("UPDATE lists SET is_active = FALSE")
-	if err != nil {
-		return err
-	}
-
-	// Activate the selected list
-	_, err = tx.Exec("UPDATE lists SET is_active = TRUE, updated_at = strftime('%s', 'now') WHERE id = ?", id)
-	if err != nil {
-		return err
-	}
-
-	return tx.Commit()
+// SetActiveList sets the list a user has open
+func SetActiveList(userID, id int64) error {
+	_, err := DB.Exec("UPDATE users SET active_list_id = ? WHERE id = ?", id, userID)
+	return err
 }
 
-// MoveListUp moves a list up in sort order
-func MoveListUp(id int64) error {
+// MoveListUp swaps a list with the closest list above it that the user can see
+func MoveListUp(userID, id int64) error {
 	tx, err := DB.Begin()
 	if err != nil {
 		return err
@@ -207,16 +234,27 @@ func MoveListUp(id int64) error {
 		return err
 	}
 
-	if currentOrder == 0 {
-		return nil
+	var prevID int64
+	var prevOrder int
+	err = tx.QueryRow(`
+		SELECT id, sort_order FROM lists
+		WHERE sort_order < ? AND id IN (`+userListIDsSQL+`)
+		ORDER BY sort_order DESC
+		LIMIT 1
+	`, currentOrder, userID, userID).Scan(&prevID, &prevOrder)
+	if err == sql.ErrNoRows {
+		return nil // Already at top
+	}
+	if err != nil {
+		return err
 	}
 
-	_, err = tx.Exec(`UPDATE lists SET sort_order = sort_order + 1 WHERE sort_order = ?`, currentOrder-1)
+	_, err = tx.Exec(`UPDATE lists SET sort_order = ? WHERE id = ?`, currentOrder, prevID)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.Exec(`UPDATE lists SET sort_order = ? WHERE id = ?`, currentOrder-1, id)
+	_, err = tx.Exec(`UPDATE lists SET sort_order = ? WHERE id = ?`, prevOrder, id)
 	if err != nil {
 		return err
 	}
@@ -224,34 +262,41 @@ func MoveListUp(id int64) error {
 	return tx.Commit()
 }
 
-// MoveListDown moves a list down in sort order
-func MoveListDown(id int64) error {
+// MoveListDown swaps a list with the closest list below it that the user can see
+func MoveListDown(userID, id int64) error {
 	tx, err := DB.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	var currentOrder, maxOrder int
+	var currentOrder int
 	err = tx.QueryRow("SELECT sort_order FROM lists WHERE id = ?", id).Scan(&currentOrder)
 	if err != nil {
 		return err
 	}
-	err = tx.QueryRow("SELECT MAX(sort_order) FROM lists").Scan(&maxOrder)
+
+	var nextID int64
+	var nextOrder int
+	err = tx.QueryRow(`
+		SELECT id, sort_order FROM lists
+		WHERE sort_order > ? AND id IN (`+userListIDsSQL+`)
+		ORDER BY sort_order ASC
+		LIMIT 1
+	`, currentOrder, userID, userID).Scan(&nextID, &nextOrder)
+	if err == sql.ErrNoRows {
+		return nil // Already at bottom
+	}
 	if err != nil {
 		return err
 	}
 
-	if currentOrder >= maxOrder {
-		return nil
-	}
-
-	_, err = tx.Exec(`UPDATE lists SET sort_order = sort_order - 1 WHERE sort_order = ?`, currentOrder+1)
+	_, err = tx.Exec(`UPDATE lists SET sort_order = ? WHERE id = ?`, currentOrder, nextID)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.Exec(`UPDATE lists SET sort_order = ? WHERE id = ?`, currentOrder+1, id)
+	_, err = tx.Exec(`UPDATE lists SET sort_order = ? WHERE id = ?`, nextOrder, id)
 	if err != nil {
 		return err
 	}
@@ -275,34 +320,69 @@ func GetListStats(listID int64) Stats {
 	if stats.TotalItems > 0 {
 		stats.Percentage = (stats.CompletedItems * 100) / stats.TotalItems
 	}
+
+	// Spending
+	DB.QueryRow(`
+		SELECT ROUND(COALESCE(SUM(i.price * i.quantity), 0), 2), ROUND(COALESCE(SUM(i.paid_price), 0), 2) FROM items i
+		JOIN sections s ON i.section_id = s.id
+		WHERE s.list_id = ?
+	`, listID).Scan(&stats.EstimatedTotal, &stats.Spent)
+	DB.QueryRow(`SELECT budget FROM lists WHERE id = ?`, listID).Scan(&stats.Budget)
+	if stats.Budget > 0 {
+		stats.Remaining = stats.Budget - stats.Spent
+	}
 	return stats
 }
 
-// RestartList resets the completed status of all items in a list
+// RestartList resets the completed status of all items in a list.
+// Paid amounts are moved to the price history before being cleared.
 func RestartList(listID int64) error {
-	_, err := DB.Exec(`
-		UPDATE items 
-		SET completed = FALSE, updated_at = strftime('%s', 'now') 
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO price_history (name, quantity, unit, paid_price, list_id)
+		SELECT i.name, i.quantity, i.unit, i.paid_price, s.list_id FROM items i
+		JOIN sections s ON i.section_id = s.id
+		WHERE s.list_id = ? AND i.paid_price > 0
+	`, listID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE items
+		SET completed = FALSE, completed_by = NULL, completed_at = NULL, paid_price = 0, updated_at = strftime('%s', 'now')
 		WHERE section_id IN (
 			SELECT id FROM sections WHERE list_id = ?
 		)
 	`, listID)
-	
-	if err == nil {
-		// Update list updated_at timestamp
-		DB.Exec(`UPDATE lists SET updated_at = strftime('%s', 'now') WHERE id = ?`, listID)
+	if err != nil {
+		return err
 	}
-	
-	return err
+
+	// Update list updated_at timestamp
+	_, err = tx.Exec(`UPDATE lists SET updated_at = strftime('%s', 'now') WHERE id = ?`, listID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // ==================== SECTIONS ====================
 
-func GetAllSections() ([]Section, error) {
-	activeList, err := GetActiveList()
+// GetAllSections returns the sections of the list a user has open
+func GetAllSections(userID int64) ([]Section, error) {
+	activeList, err := GetActiveList(userID)
+	if err == sql.ErrNoRows {
+		return nil, nil // User has no lists yet
+	}
 	if err != nil {
-		// Fallback: return all sections if no active list (shouldn't happen)
-		return getAllSectionsGlobal()
+		return nil, err
 	}
 	return GetSectionsByList(activeList.ID)
 }
@@ -336,34 +416,6 @@ func GetSectionsByList(listID int64) ([]Section, error) {
 	return sections, nil
 }
 
-// getAllSectionsGlobal returns all sections (fallback, used during migration)
-func getAllSectionsGlobal() ([]Section, error) {
-	rows, err := DB.Query(`
-		SELECT id, list_id, name, sort_order, created_at, COALESCE(updated_at, 0)
-		FROM sections
-		ORDER BY sort_order ASC
-	`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var sections []Section
-	for rows.Next() {
-		var s Section
-		err := rows.Scan(&s.ID, &s.ListID, &s.Name, &s.SortOrder, &s.CreatedAt, &s.UpdatedAt)
-		if err != nil {
-			return nil, err
-		}
-		s.Items, err = GetItemsBySection(s.ID)
-		if err != nil {
-			return nil, err
-		}
-		sections = append(sections, s)
-	}
-	return sections, nil
-}
-
 func GetSectionByID(id int64) (*Section, error) {
 	var s Section
 	err := DB.QueryRow(`
@@ -380,14 +432,6 @@ func GetSectionByID(id int64) (*Section, error) {
 	return &s, nil
 }
 
-func CreateSection(name string) (*Section, error) {
-	activeList, err := GetActiveList()
-	if err != nil {
-		return nil, fmt.Errorf("no active list found")
-	}
-	return CreateSectionForList(activeList.ID, name)
-}
-
 // CreateSectionForList creates a section for a specific list
 func CreateSectionForList(listID int64, name string) (*Section, error) {
 	// Get max sort_order for this list
@@ -500,9 +544,30 @@ func MoveSectionDown(id int64) error {
 
 // ==================== ITEMS ====================
 
+const itemColumns = `id, section_id, name, description, quantity, unit, price, paid_price, completed, uncertain, sort_order,
+	COALESCE(added_by, 0), COALESCE(completed_by, 0), COALESCE(completed_at, 0), created_at, COALESCE(updated_at, 0)`
+
+func scanItem(row interface{ Scan(...interface{}) error }) (*Item, error) {
+	var i Item
+	err := row.Scan(&i.ID, &i.SectionID, &i.Name, &i.Description, &i.Quantity, &i.Unit, &i.Price, &i.PaidPrice, &i.Completed, &i.Uncertain, &i.SortOrder,
+		&i.AddedBy, &i.CompletedBy, &i.CompletedAt, &i.CreatedAt, &i.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// nullableID stores 0 as NULL for optional references
+func nullableID(id int64) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
 func GetItemsBySection(sectionID int64) ([]Item, error) {
 	rows, err := DB.Query(`
-		SELECT id, section_id, name, description, completed, uncertain, sort_order, created_at, COALESCE(updated_at, 0)
+		SELECT `+itemColumns+`
 		FROM items
 		WHERE section_id = ?
 		ORDER BY completed ASC, sort_order ASC
@@ -514,82 +579,193 @@ func GetItemsBySection(sectionID int64) ([]Item, error) {
 
 	var items []Item
 	for rows.Next() {
-		var i Item
-		err := rows.Scan(&i.ID, &i.SectionID, &i.Name, &i.Description, &i.Completed, &i.Uncertain, &i.SortOrder, &i.CreatedAt, &i.UpdatedAt)
+		i, err := scanItem(rows)
 		if err != nil {
 			return nil, err
 		}
-		items = append(items, i)
+		items = append(items, *i)
 	}
 	return items, nil
 }
 
 func GetItemByID(id int64) (*Item, error) {
-	var i Item
-	err := DB.QueryRow(`
-		SELECT id, section_id, name, description, completed, uncertain, sort_order, created_at, COALESCE(updated_at, 0)
-		FROM items WHERE id = ?
-	`, id).Scan(&i.ID, &i.SectionID, &i.Name, &i.Description, &i.Completed, &i.Uncertain, &i.SortOrder, &i.CreatedAt, &i.UpdatedAt)
+	return scanItem(DB.QueryRow("SELECT "+itemColumns+" FROM items WHERE id = ?", id))
+}
+
+// GetCompletedItems returns the completed items of a list
+func GetCompletedItems(listID int64) ([]Item, error) {
+	rows, err := DB.Query(`
+		SELECT `+itemColumns+`
+		FROM items
+		WHERE completed = TRUE AND section_id IN (SELECT id FROM sections WHERE list_id = ?)
+		ORDER BY section_id, sort_order
+	`, listID)
 	if err != nil {
 		return nil, err
 	}
-	return &i, nil
+	defer rows.Close()
+
+	var items []Item
+	for rows.Next() {
+		i, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *i)
+	}
+	return items, rows.Err()
 }
 
-func CreateItem(sectionID int64, name, description string) (*Item, error) {
-	// Get max sort_order for this section
-	var maxOrder int
-	DB.QueryRow("SELECT COALESCE(MAX(sort_order), -1) FROM items WHERE section_id = ?", sectionID).Scan(&maxOrder)
+// CreateItem adds an item to a section for a user (0 if nobody added it). If the section already
+// has an unbought item with the same name and unit, its quantity is increased instead and merged is true.
+func CreateItem(addedBy, sectionID int64, name, description string, quantity float64, unit string) (item *Item, merged bool, err error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
 
-	result, err := DB.Exec(`
-		INSERT INTO items (section_id, name, description, sort_order) VALUES (?, ?, ?, ?)
-	`, sectionID, name, description, maxOrder+1)
+	item, merged, err = CreateItemTx(tx, addedBy, sectionID, name, description, quantity, unit, GetMaxItemOrderTx(tx, sectionID)+1)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	id, _ := result.LastInsertId()
-	return GetItemByID(id)
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	return item, merged, nil
 }
 
-func UpdateItem(id int64, name, description string) (*Item, error) {
+func UpdateItem(id int64, name, description string, quantity float64, unit string, price, paidPrice float64) (*Item, error) {
 	_, err := DB.Exec(`
-		UPDATE items SET name = ?, description = ?, updated_at = strftime('%s', 'now') WHERE id = ?
-	`, name, description, id)
+		UPDATE items SET name = ?, description = ?, quantity = ?, unit = ?, price = ?, paid_price = ?, updated_at = strftime('%s', 'now') WHERE id = ?
+	`, name, description, quantity, unit, price, paidPrice, id)
 	if err != nil {
 		return nil, err
 	}
 	return GetItemByID(id)
 }
 
+// MaxItemQuantity is the largest quantity an item can reach by merging or stepping
+const MaxItemQuantity = 100000
+
+var (
+	// ErrQuantityTooLow is returned when a change would bring an item's quantity to zero or below
+	ErrQuantityTooLow = errors.New("quantity must stay above zero")
+	// ErrQuantityTooHigh is returned when a change would bring an item's quantity above MaxItemQuantity
+	ErrQuantityTooHigh = errors.New("quantity too high")
+)
+
+// ChangeItemQuantity adds delta to an item's quantity. It returns ErrQuantityTooLow or
+// ErrQuantityTooHigh, changing nothing, if the quantity would leave (0, MaxItemQuantity].
+func ChangeItemQuantity(id int64, delta float64) (*Item, error) {
+	result, err := DB.Exec(`
+		UPDATE items SET quantity = ROUND(quantity + ?, 3), updated_at = strftime('%s', 'now')
+		WHERE id = ? AND ROUND(quantity + ?, 3) > 0 AND ROUND(quantity + ?, 3) <= ?
+	`, delta, id, delta, delta, MaxItemQuantity)
+	if err != nil {
+		return nil, err
+	}
+	item, err := GetItemByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		if delta > 0 {
+			return nil, ErrQuantityTooHigh
+		}
+		return nil, ErrQuantityTooLow
+	}
+	return item, nil
+}
+
+// DeleteItem deletes an item. Deleting it on purpose also stops it recurring;
+// DeleteCompletedItems keeps the rules so recurring items come back.
 func DeleteItem(id int64) error {
 	_, err := DB.Exec(`DELETE FROM items WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec(`DELETE FROM recurrences WHERE item_id = ? AND template_item_id IS NULL`, id)
 	return err
 }
 
-// DeleteCompletedItems deletes all completed items from the active list
-func DeleteCompletedItems() (int64, error) {
-	activeList, err := GetActiveList()
+// DeleteCompletedItems deletes all completed items from a list.
+// Paid amounts are moved to the price history first, as when restarting the list.
+func DeleteCompletedItems(listID int64) (int64, error) {
+	tx, err := DB.Begin()
 	if err != nil {
 		return 0, err
 	}
+	defer tx.Rollback()
 
-	result, err := DB.Exec(`
+	_, err = tx.Exec(`
+		INSERT INTO price_history (name, quantity, unit, paid_price, list_id)
+		SELECT i.name, i.quantity, i.unit, i.paid_price, s.list_id FROM items i
+		JOIN sections s ON i.section_id = s.id
+		WHERE s.list_id = ? AND i.completed = TRUE AND i.paid_price > 0
+	`, listID)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.Exec(`
 		DELETE FROM items WHERE completed = TRUE AND section_id IN (
 			SELECT id FROM sections WHERE list_id = ?
 		)
-	`, activeList.ID)
+	`, listID)
 	if err != nil {
 		return 0, err
 	}
-	return result.RowsAffected()
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return count, tx.Commit()
 }
 
-func ToggleItemCompleted(id int64) (*Item, error) {
-	_, err := DB.Exec(`UPDATE items SET completed = NOT completed, updated_at = strftime('%s', 'now') WHERE id = ?`, id)
+// ToggleItemCompleted flips an item's completed status, noting who checked it, and logs the purchase.
+// Unchecking an item removes the purchase its last check recorded.
+func ToggleItemCompleted(userID, id int64) (*Item, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE items SET
+			completed = NOT completed,
+			completed_by = CASE WHEN completed THEN NULL ELSE ? END,
+			completed_at = CASE WHEN completed THEN NULL ELSE strftime('%s', 'now') END,
+			updated_at = strftime('%s', 'now')
+		WHERE id = ?
+	`, nullableID(userID), id)
+	if err != nil {
+		return nil, err
+	}
+
+	var completed bool
+	if err := tx.QueryRow(`SELECT completed FROM items WHERE id = ?`, id).Scan(&completed); err != nil {
+		return nil, err
+	}
+
+	if completed {
+		err = RecordPurchaseTx(tx, id)
+	} else {
+		_, err = tx.Exec(`
+			DELETE FROM purchases WHERE id = (
+				SELECT id FROM purchases WHERE item_id = ? ORDER BY purchased_at DESC, id DESC LIMIT 1
+			)
+		`, id)
+	}
 	if err != nil {
 		return nil, err
 	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
 	return GetItemByID(id)
 }
 
@@ -612,6 +788,7 @@ func MoveItemToSection(id, newSectionID int64) (*Item, error) {
 	if err != nil {
 		return nil, err
 	}
+	DB.Exec(rememberMovedItemSQL, id)
 	return GetItemByID(id)
 }
 
@@ -699,6 +876,7 @@ func MoveItemToSectionAtPosition(id, newSectionID int64, targetPosition int) (*I
 	if err != nil {
 		return nil, err
 	}
+	tx.Exec(rememberMovedItemSQL, id)
 
 	if err := tx.Commit(); err != nil {
 		return nil, err
@@ -879,64 +1057,1474 @@ func MoveItemDown(id int64) error {
 
 // ==================== SESSIONS ====================
 
-func CreateSession(id string, expiresAt int64) error {
-	_, err := DB.Exec(`INSERT INTO sessions (id, expires_at) VALUES (?, ?)`, id, expiresAt)
-	return err
-}
+const sessionColumns = `id, COALESCE(user_id, 0), expires_at, pending_2fa, user_agent, ip, name, created_at, last_seen_at`
 
-func GetSession(id string) (*Session, error) {
+func scanSession(row interface{ Scan(...interface{}) error }) (*Session, error) {
 	var s Session
-	err := DB.QueryRow(`SELECT id, expires_at FROM sessions WHERE id = ?`, id).Scan(&s.ID, &s.ExpiresAt)
+	err := row.Scan(&s.ID, &s.UserID, &s.ExpiresAt, &s.Pending2FA, &s.UserAgent, &s.IP, &s.Name, &s.CreatedAt, &s.LastSeenAt)
 	if err != nil {
 		return nil, err
 	}
+	s.Key = SessionKey(s.ID)
 	return &s, nil
 }
 
-func DeleteSession(id string) error {
-	_, err := DB.Exec(`DELETE FROM sessions WHERE id = ?`, id)
+// SessionKey derives the public identifier of a session from its secret ID
+func SessionKey(id string) string {
+	return hashToken(id)[:16]
+}
+
+// CreateSession stores a logged in device
+func CreateSession(id string, userID int64, expiresAt int64, userAgent, ip, name string) error {
+	now := time.Now().Unix()
+	_, err := DB.Exec(`INSERT INTO sessions (id, user_id, expires_at, user_agent, ip, name, created_at, last_seen_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, userID, expiresAt, userAgent, ip, name, now, now)
 	return err
 }
 
-func CleanExpiredSessions() error {
-	_, err := DB.Exec(`DELETE FROM sessions WHERE expires_at < ?`, time.Now().Unix())
+// CreatePendingSession stores a login that still needs its two-factor code
+func CreatePendingSession(id string, userID int64, expiresAt int64) error {
+	now := time.Now().Unix()
+	_, err := DB.Exec(`INSERT INTO sessions (id, user_id, expires_at, pending_2fa, created_at, last_seen_at) VALUES (?, ?, ?, TRUE, ?, ?)`, id, userID, expiresAt, now, now)
 	return err
 }
 
-// ==================== STATS ====================
+func GetSession(id string) (*Session, error) {
+	return scanSession(DB.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id))
+}
 
-type Stats struct {
-	TotalItems     int `json:"total_items"`
-	CompletedItems int `json:"completed_items"`
-	Percentage     int `json:"percentage"`
+// TouchSession records that a session was used and pushes its expiry back
+func TouchSession(id, ip string, expiresAt int64) error {
+	_, err := DB.Exec(`UPDATE sessions SET last_seen_at = ?, ip = ?, expires_at = ? WHERE id = ?`, time.Now().Unix(), ip, expiresAt, id)
+	return err
 }
 
-func GetStats() Stats {
-	activeList, err := GetActiveList()
+// GetUserSessions returns a user's logged in devices, most recently used first
+func GetUserSessions(userID int64) ([]Session, error) {
+	rows, err := DB.Query(`
+		SELECT `+sessionColumns+` FROM sessions
+		WHERE user_id = ? AND pending_2fa = FALSE AND expires_at >= ?
+		ORDER BY last_seen_at DESC
+	`, userID, time.Now().Unix())
 	if err != nil {
-		// Fallback to global stats
-		return getGlobalStats()
+		return nil, err
 	}
-	return GetListStats(activeList.ID)
-}
+	defer rows.Close()
 
-// getGlobalStats returns stats for all items (fallback)
-func getGlobalStats() Stats {
-	var stats Stats
-	DB.QueryRow("SELECT COUNT(*) FROM items").Scan(&stats.TotalItems)
-	DB.QueryRow("SELECT COUNT(*) FROM items WHERE completed = TRUE").Scan(&stats.CompletedItems)
-	if stats.TotalItems > 0 {
-		stats.Percentage = (stats.CompletedItems * 100) / stats.TotalItems
+	var sessions []Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *s)
 	}
-	return stats
+	return sessions, rows.Err()
 }
 
-// ==================== SECTION STATS ====================
-
-type SectionStats struct {
-	TotalItems     int `json:"total_items"`
-	CompletedItems int `json:"completed_items"`
-	Percentage     int `json:"percentage"`
+// GetUserSessionByKey returns one of a user's sessions by its public key
+func GetUserSessionByKey(userID int64, key string) (*Session, error) {
+	sessions, err := GetUserSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		if sessions[i].Key == key {
+			return &sessions[i], nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// RenameSession changes the device name shown for a session
+func RenameSession(id, name string) error {
+	_, err := DB.Exec(`UPDATE sessions SET name = ? WHERE id = ?`, name, id)
+	return err
+}
+
+func DeleteSession(id string) error {
+	_, err := DB.Exec(`DELETE FROM sessions WHERE id = ?`, id)
+	return err
+}
+
+// DeleteOtherSessions logs a user out everywhere except the session keepID
+// (pass "" to end them all) and returns the IDs of the deleted sessions
+func DeleteOtherSessions(userID int64, keepID string) ([]string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id FROM sessions WHERE user_id = ? AND id != ?`, userID, keepID)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = ? AND id != ?`, userID, keepID); err != nil {
+		return nil, err
+	}
+	return ids, tx.Commit()
+}
+
+func CleanExpiredSessions() error {
+	_, err := DB.Exec(`DELETE FROM sessions WHERE expires_at < ?`, time.Now().Unix())
+	return err
+}
+
+// AssignOrphanSessions gives sessions created before user accounts existed to a user
+func AssignOrphanSessions(userID int64) error {
+	_, err := DB.Exec(`UPDATE sessions SET user_id = ? WHERE user_id IS NULL`, userID)
+	return err
+}
+
+// ==================== USERS ====================
+
+var (
+	// ErrUsernameTaken is returned when registering a username that already exists
+	ErrUsernameTaken = errors.New("username already taken")
+	// ErrInvalidInvite is returned for unknown, used or expired invite codes
+	ErrInvalidInvite = errors.New("invalid or expired invite code")
+	// ErrLastAdmin is returned when deleting the only remaining admin
+	ErrLastAdmin = errors.New("cannot delete the last admin")
+)
+
+// User is an account that can log in
+type User struct {
+	ID           int64  `json:"id"`
+	Username     string `json:"username"`
+	PasswordHash string `json:"-"`
+	IsAdmin      bool   `json:"is_admin"`
+	CreatedAt    int64  `json:"created_at"`
+	LastLoginAt  int64  `json:"last_login_at"` // 0 if never logged in
+	TOTPEnabled  bool   `json:"totp_enabled"`
+	TOTPSecret   string `json:"-"`
+	TOTPPending  string `json:"-"` // secret being enrolled, not confirmed yet
+}
+
+// Invite is a one-time code that lets someone register an account
+type Invite struct {
+	Code      string `json:"code"`
+	CreatedBy int64  `json:"created_by"`
+	IsAdmin   bool   `json:"is_admin"` // registers an admin account
+	ExpiresAt int64  `json:"expires_at"`
+	UsedBy    int64  `json:"used_by"` // 0 while unused
+	UsedAt    int64  `json:"used_at"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+const userColumns = `id, username, password_hash, is_admin, created_at, last_login_at, totp_enabled, totp_secret, totp_pending_secret`
+
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, error) {
+	var u User
+	if err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.IsAdmin, &u.CreatedAt, &u.LastLoginAt, &u.TOTPEnabled, &u.TOTPSecret, &u.TOTPPending); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// isUniqueViolation reports whether err is a SQLite UNIQUE constraint failure
+func isUniqueViolation(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+func CountUsers() (int, error) {
+	var count int
+	err := DB.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count)
+	return count, err
+}
+
+// CreateUser adds an account with an already hashed password
+func CreateUser(username, passwordHash string, isAdmin bool) (*User, error) {
+	return createUserTx(DB, username, passwordHash, isAdmin)
+}
+
+// execer is a *sql.DB or *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// createUserTx inserts a user with a *sql.DB or *sql.Tx
+func createUserTx(q execer, username, passwordHash string, isAdmin bool) (*User, error) {
+	now := time.Now().Unix()
+	result, err := q.Exec(`
+		INSERT INTO users (username, password_hash, is_admin, created_at) VALUES (?, ?, ?, ?)
+	`, username, passwordHash, isAdmin, now)
+	if isUniqueViolation(err) {
+		return nil, ErrUsernameTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+	return &User{ID: id, Username: username, PasswordHash: passwordHash, IsAdmin: isAdmin, CreatedAt: now}, nil
+}
+
+func GetUserByID(id int64) (*User, error) {
+	return scanUser(DB.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+}
+
+// GetUserByUsername looks up a user, ignoring case
+func GetUserByUsername(username string) (*User, error) {
+	return scanUser(DB.QueryRow(`SELECT `+userColumns+` FROM users WHERE username = ?`, username))
+}
+
+// GetFirstAdmin returns the oldest admin account, the actor when auth is disabled or for API tokens
+func GetFirstAdmin() (*User, error) {
+	return scanUser(DB.QueryRow(`SELECT ` + userColumns + ` FROM users WHERE is_admin = TRUE ORDER BY id LIMIT 1`))
+}
+
+func GetAllUsers() ([]User, error) {
+	rows, err := DB.Query(`SELECT ` + userColumns + ` FROM users ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+func UpdateUserPassword(id int64, passwordHash string) error {
+	result, err := DB.Exec(`UPDATE users SET password_hash = ? WHERE id = ?`, passwordHash, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func TouchUserLogin(id int64) error {
+	_, err := DB.Exec(`UPDATE users SET last_login_at = ? WHERE id = ?`, time.Now().Unix(), id)
+	return err
+}
+
+// DeletedUser is what deleting an account did to the data it left behind
+type DeletedUser struct {
+	SessionIDs   []string          // sessions that were logged out
+	Transferred  map[int64]int64   // shared list id -> editor who became its owner
+	DeletedLists map[int64][]int64 // deleted list id -> members who lost it
+}
+
+// DeleteUser removes an account with its sessions, templates and item history. Lists it
+// owns go to the editor they were shared with first; lists without an editor are deleted.
+// The last admin cannot be deleted.
+func DeleteUser(id int64) (*DeletedUser, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var isAdmin bool
+	if err := tx.QueryRow(`SELECT is_admin FROM users WHERE id = ?`, id).Scan(&isAdmin); err != nil {
+		return nil, err
+	}
+	if isAdmin {
+		var admins int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM users WHERE is_admin = TRUE`).Scan(&admins); err != nil {
+			return nil, err
+		}
+		if admins <= 1 {
+			return nil, ErrLastAdmin
+		}
+	}
+
+	deleted := &DeletedUser{
+		Transferred:  make(map[int64]int64),
+		DeletedLists: make(map[int64][]int64),
+	}
+
+	rows, err := tx.Query(`SELECT id FROM sessions WHERE user_id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var sessionID string
+		if err := rows.Scan(&sessionID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		deleted.SessionIDs = append(deleted.SessionIDs, sessionID)
+	}
+	rows.Close()
+
+	rows, err = tx.Query(`SELECT id FROM lists WHERE owner_id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	var listIDs []int64
+	for rows.Next() {
+		var listID int64
+		if err := rows.Scan(&listID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		listIDs = append(listIDs, listID)
+	}
+	rows.Close()
+
+	for _, listID := range listIDs {
+		var editorID int64
+		err := tx.QueryRow(`
+			SELECT user_id FROM list_members WHERE list_id = ? AND role = ?
+			ORDER BY created_at ASC, user_id ASC LIMIT 1
+		`, listID, RoleEditor).Scan(&editorID)
+		if err == nil {
+			if _, err := tx.Exec(`UPDATE lists SET owner_id = ? WHERE id = ?`, editorID, listID); err != nil {
+				return nil, err
+			}
+			if _, err := tx.Exec(`DELETE FROM list_members WHERE list_id = ? AND user_id = ?`, listID, editorID); err != nil {
+				return nil, err
+			}
+			deleted.Transferred[listID] = editorID
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+
+		// Viewers only: the list goes with its owner
+		memberRows, err := tx.Query(`SELECT user_id FROM list_members WHERE list_id = ?`, listID)
+		if err != nil {
+			return nil, err
+		}
+		members := []int64{}
+		for memberRows.Next() {
+			var userID int64
+			if err := memberRows.Scan(&userID); err != nil {
+				memberRows.Close()
+				return nil, err
+			}
+			members = append(members, userID)
+		}
+		memberRows.Close()
+		deleted.DeletedLists[listID] = members
+	}
+
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = ?`, id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = ?`, id); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	// The user's item history went with the account
+	InvalidateSuggestionIndex()
+	return deleted, nil
+}
+
+// CreateInvite stores a new invite code
+func CreateInvite(code string, createdBy int64, isAdmin bool, expiresAt int64) (*Invite, error) {
+	now := time.Now().Unix()
+	_, err := DB.Exec(`
+		INSERT INTO invites (code, created_by, is_admin, expires_at, created_at) VALUES (?, ?, ?, ?, ?)
+	`, code, createdBy, isAdmin, expiresAt, now)
+	if err != nil {
+		return nil, err
+	}
+	return &Invite{Code: code, CreatedBy: createdBy, IsAdmin: isAdmin, ExpiresAt: expiresAt, CreatedAt: now}, nil
+}
+
+// GetInvites returns all invites, newest first
+func GetInvites() ([]Invite, error) {
+	rows, err := DB.Query(`
+		SELECT code, COALESCE(created_by, 0), is_admin, expires_at, COALESCE(used_by, 0), used_at, created_at
+		FROM invites ORDER BY created_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []Invite
+	for rows.Next() {
+		var inv Invite
+		if err := rows.Scan(&inv.Code, &inv.CreatedBy, &inv.IsAdmin, &inv.ExpiresAt, &inv.UsedBy, &inv.UsedAt, &inv.CreatedAt); err != nil {
+			return nil, err
+		}
+		invites = append(invites, inv)
+	}
+	return invites, rows.Err()
+}
+
+func DeleteInvite(code string) error {
+	result, err := DB.Exec(`DELETE FROM invites WHERE code = ?`, code)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RegisterUser creates an account, using up an invite code unless code is empty.
+// The account is an admin only if the invite says so.
+func RegisterUser(username, passwordHash, code string) (*User, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	isAdmin := false
+	if code != "" {
+		var usedBy sql.NullInt64
+		var expiresAt int64
+		err := tx.QueryRow(`SELECT is_admin, expires_at, used_by FROM invites WHERE code = ?`, code).Scan(&isAdmin, &expiresAt, &usedBy)
+		if err == sql.ErrNoRows || err == nil && (usedBy.Valid || expiresAt < time.Now().Unix()) {
+			return nil, ErrInvalidInvite
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := createUserTx(tx, username, passwordHash, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	if code != "" {
+		_, err := tx.Exec(`UPDATE invites SET used_by = ?, used_at = ? WHERE code = ? AND used_by IS NULL`, user.ID, time.Now().Unix(), code)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ==================== USER IDENTITIES ====================
+
+// GetUserByIdentity returns the user linked to an account at an OpenID Connect provider
+func GetUserByIdentity(issuer, subject string) (*User, error) {
+	return scanUser(DB.QueryRow(`
+		SELECT `+userColumns+` FROM users
+		WHERE id = (SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?)
+	`, issuer, subject))
+}
+
+// LinkIdentity links an account at an OpenID Connect provider to an existing user
+func LinkIdentity(userID int64, issuer, subject string) error {
+	_, err := DB.Exec(`INSERT INTO user_identities (user_id, issuer, subject) VALUES (?, ?, ?)`, userID, issuer, subject)
+	return err
+}
+
+// CreateUserWithIdentity creates a user without a password, who logs in through an OpenID Connect provider
+func CreateUserWithIdentity(username, issuer, subject string) (*User, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	user, err := createUserTx(tx, username, "", false)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`INSERT INTO user_identities (user_id, issuer, subject) VALUES (?, ?, ?)`, user.ID, issuer, subject); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ==================== TWO-FACTOR AUTH ====================
+
+// TrustedDevice is a browser that skips the two-factor step after logging in with it once
+type TrustedDevice struct {
+	ID         int64  `json:"id"`
+	UserID     int64  `json:"user_id"`
+	Name       string `json:"name"`
+	CreatedAt  int64  `json:"created_at"`
+	LastUsedAt int64  `json:"last_used_at"`
+	ExpiresAt  int64  `json:"expires_at"`
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes in recovery codes
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
+
+// SetPendingTOTPSecret starts enrollment with a new secret; two-factor stays as it was until EnableTOTP
+func SetPendingTOTPSecret(userID int64, secret string) error {
+	_, err := DB.Exec(`UPDATE users SET totp_pending_secret = ? WHERE id = ?`, secret, userID)
+	return err
+}
+
+// EnableTOTP turns on two-factor with the pending secret and replaces the recovery codes.
+// step is the time step of the code that confirmed enrollment.
+func EnableTOTP(userID, step int64, recoveryCodes []string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE users SET totp_secret = totp_pending_secret, totp_pending_secret = '', totp_enabled = TRUE, totp_last_step = ?
+		WHERE id = ? AND totp_pending_secret != ''
+	`, step, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := replaceRecoveryCodesTx(tx, userID, recoveryCodes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DisableTOTP turns off two-factor and forgets the recovery codes and trusted devices
+func DisableTOTP(userID int64) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		UPDATE users SET totp_secret = '', totp_pending_secret = '', totp_enabled = FALSE, totp_last_step = 0 WHERE id = ?
+	`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM trusted_devices WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that a code from step was accepted. It returns false if that
+// step or a later one was already used, so each code works only once.
+func UseTOTPStep(userID, step int64) (bool, error) {
+	result, err := DB.Exec(`UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// ReplaceRecoveryCodes invalidates a user's recovery codes and stores new ones
+func ReplaceRecoveryCodes(userID int64, codes []string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodesTx(tx, userID, codes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodesTx(tx *sql.Tx, userID int64, codes []string) error {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		if _, err := tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, hashToken(normalizeRecoveryCode(code))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used, returning false if there is none
+func UseRecoveryCode(userID int64, code string) (bool, error) {
+	result, err := DB.Exec(`
+		UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at = 0
+	`, time.Now().Unix(), userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func CountRecoveryCodes(userID int64) (int, error) {
+	var count int
+	err := DB.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at = 0`, userID).Scan(&count)
+	return count, err
+}
+
+// CreateTrustedDevice remembers a browser by the token in its cookie
+func CreateTrustedDevice(userID int64, token, name string, expiresAt int64) error {
+	_, err := DB.Exec(`
+		INSERT INTO trusted_devices (user_id, token_hash, name, created_at, expires_at) VALUES (?, ?, ?, ?, ?)
+	`, userID, hashToken(token), name, time.Now().Unix(), expiresAt)
+	return err
+}
+
+// IsTrustedDevice reports whether token belongs to an unexpired trusted device of the user,
+// and marks the device as used
+func IsTrustedDevice(userID int64, token string) (bool, error) {
+	now := time.Now().Unix()
+	result, err := DB.Exec(`
+		UPDATE trusted_devices SET last_used_at = ? WHERE user_id = ? AND token_hash = ? AND expires_at > ?
+	`, now, userID, hashToken(token), now)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// GetTrustedDevices returns a user's unexpired trusted devices, most recently used first
+func GetTrustedDevices(userID int64) ([]TrustedDevice, error) {
+	rows, err := DB.Query(`
+		SELECT id, user_id, name, created_at, last_used_at, expires_at FROM trusted_devices
+		WHERE user_id = ? AND expires_at > ?
+		ORDER BY MAX(created_at, last_used_at) DESC
+	`, userID, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []TrustedDevice
+	for rows.Next() {
+		var d TrustedDevice
+		if err := rows.Scan(&d.ID, &d.UserID, &d.Name, &d.CreatedAt, &d.LastUsedAt, &d.ExpiresAt); err != nil {
+			return nil, err
+		}
+		devices = append(devices, d)
+	}
+	return devices, rows.Err()
+}
+
+// DeleteTrustedDevice forgets one of a user's trusted devices
+func DeleteTrustedDevice(userID, id int64) error {
+	result, err := DB.Exec(`DELETE FROM trusted_devices WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CleanExpiredTrustedDevices deletes trusted devices past their expiry
+func CleanExpiredTrustedDevices() error {
+	_, err := DB.Exec(`DELETE FROM trusted_devices WHERE expires_at < ?`, time.Now().Unix())
+	return err
+}
+
+// ==================== API TOKENS ====================
+
+// Scopes limit what an API token can do
+const (
+	TokenScopeFull    = "full"    // everything its user can do
+	TokenScopeRead    = "read"    // GET requests only
+	TokenScopeHistory = "history" // only the item history endpoints
+)
+
+const (
+	apiTokenPrefix    = "kof_"
+	apiTokenPrefixLen = len(apiTokenPrefix) + 8
+)
+
+// ErrTokenExpired is returned when authenticating with an API token past its expiry
+var ErrTokenExpired = errors.New("API token has expired")
+
+// APIToken is a named token for the REST API that acts as the user who created it.
+// ListID limits the token to one list; 0 allows all lists of the user.
+type APIToken struct {
+	ID         int64  `json:"id"`
+	UserID     int64  `json:"user_id"`
+	Name       string `json:"name"`
+	Prefix     string `json:"prefix"` // start of the token, to tell tokens apart
+	TokenHash  string `json:"-"`
+	Scope      string `json:"scope"`
+	ListID     int64  `json:"list_id,omitempty"`
+	CreatedAt  int64  `json:"created_at"`
+	LastUsedAt int64  `json:"last_used_at"` // 0 if never used
+	ExpiresAt  int64  `json:"expires_at"`   // 0 if it never expires
+}
+
+// ValidTokenScope reports whether scope is one of the token scopes
+func ValidTokenScope(scope string) bool {
+	return scope == TokenScopeFull || scope == TokenScopeRead || scope == TokenScopeHistory
+}
+
+const apiTokenColumns = `id, user_id, name, prefix, token_hash, scope, COALESCE(list_id, 0), created_at, last_used_at, expires_at`
+
+func scanAPIToken(row interface{ Scan(...interface{}) error }) (*APIToken, error) {
+	var t APIToken
+	if err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, &t.TokenHash, &t.Scope, &t.ListID, &t.CreatedAt, &t.LastUsedAt, &t.ExpiresAt); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// hashToken returns the SHA-256 digest stored in place of a random token or code
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken mints a token for a user. The token itself is only returned here;
+// the database keeps its hash.
+func CreateAPIToken(userID int64, name, scope string, listID, expiresAt int64) (*APIToken, string, error) {
+	bytes := make([]byte, 20)
+	if _, err := rand.Read(bytes); err != nil {
+		return nil, "", err
+	}
+	token := apiTokenPrefix + hex.EncodeToString(bytes)
+
+	t := &APIToken{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    token[:apiTokenPrefixLen],
+		TokenHash: hashToken(token),
+		Scope:     scope,
+		ListID:    listID,
+		CreatedAt: time.Now().Unix(),
+		ExpiresAt: expiresAt,
+	}
+	result, err := DB.Exec(`
+		INSERT INTO api_tokens (user_id, name, prefix, token_hash, scope, list_id, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, t.UserID, t.Name, t.Prefix, t.TokenHash, t.Scope, nullableID(t.ListID), t.CreatedAt, t.ExpiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+	t.ID, _ = result.LastInsertId()
+	return t, token, nil
+}
+
+// AuthenticateAPIToken returns the stored token matching token and marks it as used.
+// It returns sql.ErrNoRows for unknown tokens and ErrTokenExpired for expired ones.
+func AuthenticateAPIToken(token string) (*APIToken, error) {
+	if len(token) < apiTokenPrefixLen {
+		return nil, sql.ErrNoRows
+	}
+
+	rows, err := DB.Query(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE prefix = ?`, token[:apiTokenPrefixLen])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hash := []byte(hashToken(token))
+	var found *APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		if subtle.ConstantTimeCompare(hash, []byte(t.TokenHash)) == 1 {
+			found = t
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	now := time.Now().Unix()
+	if found.ExpiresAt != 0 && found.ExpiresAt < now {
+		return nil, ErrTokenExpired
+	}
+
+	// last_used_at only needs minute precision, which saves a write on most requests
+	if now-found.LastUsedAt >= 60 {
+		if _, err := DB.Exec(`UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, now, found.ID); err != nil {
+			log.Printf("Failed to update API token last use: %v", err)
+		}
+		found.LastUsedAt = now
+	}
+	return found, nil
+}
+
+func GetAPITokenByID(id int64) (*APIToken, error) {
+	return scanAPIToken(DB.QueryRow(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE id = ?`, id))
+}
+
+// GetAPITokens returns a user's tokens, newest first
+func GetAPITokens(userID int64) ([]APIToken, error) {
+	rows, err := DB.Query(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE user_id = ? ORDER BY id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// DeleteAPIToken revokes a token
+func DeleteAPIToken(id int64) error {
+	result, err := DB.Exec(`DELETE FROM api_tokens WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ==================== SHARE LINKS ====================
+
+const shareLinkPrefixLen = 8
+
+// ErrShareLinkExpired is returned when opening a share link past its expiry
+var ErrShareLinkExpired = errors.New("share link has expired")
+
+// ShareLink lets anyone with its URL see a list without logging in.
+// CanCheck also allows checking items off; nothing else can be changed.
+type ShareLink struct {
+	ID        int64  `json:"id"`
+	ListID    int64  `json:"list_id"`
+	Name      string `json:"name"`
+	Prefix    string `json:"prefix"` // start of the token, to tell links apart
+	TokenHash string `json:"-"`
+	CanCheck  bool   `json:"can_check"`
+	CreatedBy int64  `json:"created_by"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+const shareLinkColumns = `id, list_id, name, prefix, token_hash, can_check, created_by, created_at, expires_at`
+
+func scanShareLink(row interface{ Scan(...interface{}) error }) (*ShareLink, error) {
+	var l ShareLink
+	if err := row.Scan(&l.ID, &l.ListID, &l.Name, &l.Prefix, &l.TokenHash, &l.CanCheck, &l.CreatedBy, &l.CreatedAt, &l.ExpiresAt); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// CreateShareLink creates a link to a list. The token for its URL is only returned here;
+// the database keeps its hash.
+func CreateShareLink(listID, createdBy int64, name string, canCheck bool, expiresAt int64) (*ShareLink, string, error) {
+	bytes := make([]byte, 24)
+	if _, err := rand.Read(bytes); err != nil {
+		return nil, "", err
+	}
+	token := hex.EncodeToString(bytes)
+
+	l := &ShareLink{
+		ListID:    listID,
+		Name:      name,
+		Prefix:    token[:shareLinkPrefixLen],
+		TokenHash: hashToken(token),
+		CanCheck:  canCheck,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now().Unix(),
+		ExpiresAt: expiresAt,
+	}
+	result, err := DB.Exec(`
+		INSERT INTO share_links (list_id, name, prefix, token_hash, can_check, created_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, l.ListID, l.Name, l.Prefix, l.TokenHash, l.CanCheck, l.CreatedBy, l.CreatedAt, l.ExpiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+	l.ID, _ = result.LastInsertId()
+	return l, token, nil
+}
+
+// GetShareLinkByToken returns the link with a token. It returns sql.ErrNoRows for
+// unknown tokens and ErrShareLinkExpired for expired ones.
+func GetShareLinkByToken(token string) (*ShareLink, error) {
+	l, err := scanShareLink(DB.QueryRow(`SELECT `+shareLinkColumns+` FROM share_links WHERE token_hash = ?`, hashToken(token)))
+	if err != nil {
+		return nil, err
+	}
+	if l.ExpiresAt < time.Now().Unix() {
+		return nil, ErrShareLinkExpired
+	}
+	return l, nil
+}
+
+func GetShareLinkByID(id int64) (*ShareLink, error) {
+	return scanShareLink(DB.QueryRow(`SELECT `+shareLinkColumns+` FROM share_links WHERE id = ?`, id))
+}
+
+// GetShareLinks returns the unexpired links to a list, newest first
+func GetShareLinks(listID int64) ([]ShareLink, error) {
+	rows, err := DB.Query(`
+		SELECT `+shareLinkColumns+` FROM share_links
+		WHERE list_id = ? AND expires_at >= ?
+		ORDER BY id DESC
+	`, listID, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []ShareLink
+	for rows.Next() {
+		l, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, *l)
+	}
+	return links, rows.Err()
+}
+
+// DeleteShareLink revokes a link
+func DeleteShareLink(id int64) error {
+	result, err := DB.Exec(`DELETE FROM share_links WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CleanExpiredShareLinks removes links past their expiry
+func CleanExpiredShareLinks() error {
+	_, err := DB.Exec(`DELETE FROM share_links WHERE expires_at < ?`, time.Now().Unix())
+	return err
+}
+
+// ==================== CHANGE LOG ====================
+
+// Scopes of change log entries: who the change was sent to
+const (
+	ChangeScopeAll   = "all"   // every user
+	ChangeScopeUsers = "users" // the users in UserIDs
+	ChangeScopeList  = "list"  // the subscribers of ListID
+)
+
+// Change is a change sent to WebSocket clients, kept to be sent again to clients that missed it
+type Change struct {
+	Seq       int64
+	Scope     string
+	ListID    int64
+	UserIDs   []int64
+	Type      string
+	Entity    string
+	Data      string // JSON
+	CreatedAt int64
+}
+
+// AppendChanges logs changes, numbered by the caller, in one transaction
+func AppendChanges(changes []Change) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO change_log (seq, scope, list_id, user_ids, type, entity, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, ch := range changes {
+		ids := make([]string, len(ch.UserIDs))
+		for i, id := range ch.UserIDs {
+			ids[i] = strconv.FormatInt(id, 10)
+		}
+		if _, err := stmt.Exec(ch.Seq, ch.Scope, ch.ListID, strings.Join(ids, ","), ch.Type, ch.Entity, ch.Data); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetChangesSince returns up to limit changes after a sequence number, oldest first
+func GetChangesSince(seq int64, limit int) ([]Change, error) {
+	rows, err := DB.Query(`
+		SELECT seq, scope, list_id, user_ids, type, entity, data, created_at
+		FROM change_log
+		WHERE seq > ?
+		ORDER BY seq ASC
+		LIMIT ?
+	`, seq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []Change
+	for rows.Next() {
+		var ch Change
+		var userIDs string
+		if err := rows.Scan(&ch.Seq, &ch.Scope, &ch.ListID, &userIDs, &ch.Type, &ch.Entity, &ch.Data, &ch.CreatedAt); err != nil {
+			return nil, err
+		}
+		for _, id := range strings.Split(userIDs, ",") {
+			if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+				ch.UserIDs = append(ch.UserIDs, n)
+			}
+		}
+		changes = append(changes, ch)
+	}
+	return changes, rows.Err()
+}
+
+// GetChangeLogBounds returns the sequence numbers of the oldest kept and the latest change.
+// Changes after oldest-1 can be replayed; latest is 0 if nothing has changed yet.
+func GetChangeLogBounds() (oldest, latest int64, err error) {
+	err = DB.QueryRow(`SELECT COALESCE(MIN(seq), 0), COALESCE(MAX(seq), 0) FROM change_log`).Scan(&oldest, &latest)
+	if err == nil && latest == 0 {
+		// Trimmed to nothing or new: the sequence continues from sqlite_sequence
+		err = DB.QueryRow(`SELECT COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'change_log'), 0)`).Scan(&latest)
+		oldest = latest + 1
+	}
+	return oldest, latest, err
+}
+
+// TrimChangeLog keeps the latest keep changes and drops changes older than maxAge
+func TrimChangeLog(keep int, maxAge time.Duration) error {
+	_, err := DB.Exec(`
+		DELETE FROM change_log
+		WHERE seq <= (SELECT COALESCE(MAX(seq), 0) FROM change_log) - ?
+		   OR created_at < ?
+	`, keep, time.Now().Add(-maxAge).Unix())
+	return err
+}
+
+// ==================== LIST ACCESS ====================
+
+// Roles a user can have on a list
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// Access is what a user needs to be allowed to do with a list
+type Access int
+
+const (
+	AccessRead  Access = iota // viewers, editors and the owner
+	AccessWrite               // editors and the owner
+	AccessOwner               // only the owner: deleting and sharing the list
+)
+
+// ErrForbidden is returned when a user can see a list but their role does not allow the change
+var ErrForbidden = errors.New("access denied")
+
+// userListIDsSQL selects the IDs of the lists a user owns or is a member of. It takes the user ID twice.
+const userListIDsSQL = `SELECT id FROM lists WHERE owner_id = ? UNION SELECT list_id FROM list_members WHERE user_id = ?`
+
+// ListMember is the owner of a list or a user it is shared with
+type ListMember struct {
+	UserID    int64  `json:"user_id"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	CreatedAt int64  `json:"created_at"` // when the list was shared, 0 for the owner
+}
+
+func roleAllows(role string, need Access) bool {
+	switch need {
+	case AccessOwner:
+		return role == RoleOwner
+	case AccessWrite:
+		return role == RoleOwner || role == RoleEditor
+	}
+	return role != ""
+}
+
+// CheckListAccess returns sql.ErrNoRows if the list does not exist or is not shared with the user,
+// and ErrForbidden if the user's role on it does not give the access needed
+func CheckListAccess(userID, listID int64, need Access) error {
+	var role string
+	err := DB.QueryRow(`
+		SELECT CASE WHEN l.owner_id = ? THEN 'owner' ELSE m.role END
+		FROM lists l
+		LEFT JOIN list_members m ON m.list_id = l.id AND m.user_id = ?
+		WHERE l.id = ? AND (l.owner_id = ? OR m.user_id IS NOT NULL)
+	`, userID, userID, listID, userID).Scan(&role)
+	if err != nil {
+		return err
+	}
+	if !roleAllows(role, need) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// CheckSectionAccess is CheckListAccess for the list of a section. It returns the list's ID.
+func CheckSectionAccess(userID, sectionID int64, need Access) (int64, error) {
+	var listID int64
+	err := DB.QueryRow(`SELECT COALESCE(list_id, 0) FROM sections WHERE id = ?`, sectionID).Scan(&listID)
+	if err != nil {
+		return 0, err
+	}
+	return listID, CheckListAccess(userID, listID, need)
+}
+
+// CheckItemAccess is CheckListAccess for the list of an item. It returns the list's ID.
+func CheckItemAccess(userID, itemID int64, need Access) (int64, error) {
+	listID, err := GetItemListID(itemID)
+	if err != nil {
+		return 0, err
+	}
+	return listID, CheckListAccess(userID, listID, need)
+}
+
+// CheckTemplateAccess checks that a template belongs to a user. Templates are private,
+// so other users' templates are reported as missing (sql.ErrNoRows).
+func CheckTemplateAccess(userID, templateID int64) error {
+	var ownerID int64
+	err := DB.QueryRow(`SELECT COALESCE(owner_id, 0) FROM templates WHERE id = ?`, templateID).Scan(&ownerID)
+	if err != nil {
+		return err
+	}
+	if ownerID != userID {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CheckTemplateItemAccess is CheckTemplateAccess for the template of a template item.
+// It returns the template's ID.
+func CheckTemplateItemAccess(userID, templateItemID int64) (int64, error) {
+	var templateID int64
+	err := DB.QueryRow(`SELECT template_id FROM template_items WHERE id = ?`, templateItemID).Scan(&templateID)
+	if err != nil {
+		return 0, err
+	}
+	return templateID, CheckTemplateAccess(userID, templateID)
+}
+
+// GetItemListID returns the ID of the list an item is on
+func GetItemListID(itemID int64) (int64, error) {
+	var listID int64
+	err := DB.QueryRow(`
+		SELECT COALESCE(s.list_id, 0) FROM items i
+		JOIN sections s ON s.id = i.section_id
+		WHERE i.id = ?
+	`, itemID).Scan(&listID)
+	return listID, err
+}
+
+// GetListMembers returns the owner of a list followed by the users it is shared with
+func GetListMembers(listID int64) ([]ListMember, error) {
+	rows, err := DB.Query(`
+		SELECT u.id, u.username, 'owner', 0 FROM lists l
+		JOIN users u ON u.id = l.owner_id
+		WHERE l.id = ?
+		UNION ALL
+		SELECT * FROM (
+			SELECT u.id, u.username, m.role, m.created_at FROM list_members m
+			JOIN users u ON u.id = m.user_id
+			WHERE m.list_id = ?
+			ORDER BY u.username
+		)
+	`, listID, listID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []ListMember
+	for rows.Next() {
+		var m ListMember
+		if err := rows.Scan(&m.UserID, &m.Username, &m.Role, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// GetListUserIDs returns the IDs of the owner and members of a list
+func GetListUserIDs(listID int64) ([]int64, error) {
+	rows, err := DB.Query(`
+		SELECT owner_id FROM lists WHERE id = ? AND owner_id IS NOT NULL
+		UNION
+		SELECT user_id FROM list_members WHERE list_id = ?
+	`, listID, listID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ShareList gives a user the editor or viewer role on a list, replacing the role they had.
+// Sharing a list with its owner is ErrForbidden.
+func ShareList(listID, userID int64, role string) error {
+	if role != RoleEditor && role != RoleViewer {
+		return fmt.Errorf("invalid role %q", role)
+	}
+
+	var ownerID int64
+	if err := DB.QueryRow(`SELECT COALESCE(owner_id, 0) FROM lists WHERE id = ?`, listID).Scan(&ownerID); err != nil {
+		return err
+	}
+	if ownerID == userID {
+		return ErrForbidden
+	}
+
+	_, err := DB.Exec(`
+		INSERT INTO list_members (list_id, user_id, role) VALUES (?, ?, ?)
+		ON CONFLICT(list_id, user_id) DO UPDATE SET role = excluded.role
+	`, listID, userID, role)
+	return err
+}
+
+// UnshareList removes a user from a list's members
+func UnshareList(listID, userID int64) error {
+	result, err := DB.Exec(`DELETE FROM list_members WHERE list_id = ? AND user_id = ?`, listID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AssignOrphanLists gives lists from before lists had owners to a user. The list that
+// was active for everyone becomes the one the user has open.
+func AssignOrphanLists(userID int64) error {
+	result, err := DB.Exec(`UPDATE lists SET owner_id = ? WHERE owner_id IS NULL`, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil
+	}
+	_, err = DB.Exec(`
+		UPDATE users SET active_list_id = (SELECT id FROM lists WHERE is_active = TRUE LIMIT 1)
+		WHERE id = ? AND active_list_id IS NULL
+	`, userID)
+	return err
+}
+
+// AssignOrphanTemplates gives templates from before templates had owners to a user
+func AssignOrphanTemplates(userID int64) error {
+	_, err := DB.Exec(`UPDATE templates SET owner_id = ? WHERE owner_id IS NULL`, userID)
+	return err
+}
+
+// AssignOrphanHistory gives item history from before history was kept per user to a user
+func AssignOrphanHistory(userID int64) error {
+	result, err := DB.Exec(`UPDATE OR IGNORE item_history SET user_id = ? WHERE user_id IS NULL`, userID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows > 0 {
+		InvalidateSuggestionIndex()
+	}
+	return nil
+}
+
+// ==================== EVENTS ====================
+
+// Actions recorded in the audit trail
+const (
+	EventCreated     = "created"
+	EventUpdated     = "updated"
+	EventDeleted     = "deleted"
+	EventCompleted   = "completed"
+	EventUncompleted = "uncompleted"
+	EventMoved       = "moved"
+	EventReordered   = "reordered"
+	EventRestarted   = "restarted"
+	EventCleared     = "cleared" // completed items deleted from a list
+	EventApplied     = "applied" // template applied to a list
+	EventShared      = "shared"
+	EventUnshared    = "unshared"
+	EventMerged      = "merged"
+)
+
+// Entity types recorded in the audit trail
+const (
+	EntityList         = "list"
+	EntitySection      = "section"
+	EntityItem         = "item"
+	EntityMember       = "member"
+	EntityTemplate     = "template"
+	EntityTemplateItem = "template_item"
+	EntityRecurrence   = "recurrence"
+	EntityHistory      = "history"
+	EntityUser         = "user"
+	EntityInvite       = "invite"
+	EntityAPIToken     = "api_token"
+	EntitySession      = "session"
+	EntityShareLink    = "share_link"
+)
+
+// Event is a change recorded in the audit trail
+type Event struct {
+	ID         int64           `json:"id"`
+	ListID     int64           `json:"list_id"` // 0 for changes outside lists
+	UserID     int64           `json:"user_id"` // 0 for changes made by the server or by a deleted user
+	Username   string          `json:"username"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityID   int64           `json:"entity_id"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	CreatedAt  int64           `json:"created_at"`
+}
+
+// eventJSON encodes an entity's state for the audit trail, nil when there is none
+func eventJSON(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil || string(data) == "null" {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// RecordEvent adds a change to the audit trail. userID and listID are 0 when there is
+// no actor or list. before and after are the entity's state around the change, nil if none.
+func RecordEvent(userID, listID int64, action, entityType string, entityID int64, before, after interface{}) error {
+	beforeJSON, err := eventJSON(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := eventJSON(after)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec(`
+		INSERT INTO events (list_id, user_id, action, entity_type, entity_id, before_json, after_json)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, nullableID(listID), nullableID(userID), action, entityType, entityID, beforeJSON, afterJSON)
+	return err
+}
+
+// GetListEvents returns the changes made to a list, newest first. Only events with
+// an ID below beforeID are returned, or all of them if beforeID is 0.
+func GetListEvents(listID, beforeID int64, limit int) ([]Event, error) {
+	query := `
+		SELECT e.id, COALESCE(e.list_id, 0), COALESCE(e.user_id, 0), COALESCE(u.username, ''), e.action, e.entity_type, e.entity_id,
+			COALESCE(e.before_json, ''), COALESCE(e.after_json, ''), e.created_at
+		FROM events e
+		LEFT JOIN users u ON u.id = e.user_id
+		WHERE e.list_id = ?`
+	args := []interface{}{listID}
+	if beforeID > 0 {
+		query += " AND e.id < ?"
+		args = append(args, beforeID)
+	}
+	query += " ORDER BY e.id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		var before, after string
+		if err := rows.Scan(&e.ID, &e.ListID, &e.UserID, &e.Username, &e.Action, &e.EntityType, &e.EntityID, &before, &after, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if before != "" {
+			e.Before = json.RawMessage(before)
+		}
+		if after != "" {
+			e.After = json.RawMessage(after)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// ==================== STATS ====================
+
+type Stats struct {
+	TotalItems     int     `json:"total_items"`
+	CompletedItems int     `json:"completed_items"`
+	Percentage     int     `json:"percentage"`
+	EstimatedTotal float64 `json:"estimated_total"` // sum of price * quantity
+	Spent          float64 `json:"spent"`           // sum of paid prices
+	Budget         float64 `json:"budget"`
+	Remaining      float64 `json:"remaining"` // budget - spent, 0 when the list has no budget
+}
+
+// GetStats returns stats for the list a user has open, empty if they have no lists
+func GetStats(userID int64) Stats {
+	activeList, err := GetActiveList(userID)
+	if err != nil {
+		return Stats{}
+	}
+	return GetListStats(activeList.ID)
+}
+
+// ==================== SECTION STATS ====================
+
+type SectionStats struct {
+	TotalItems     int `json:"total_items"`
+	CompletedItems int `json:"completed_items"`
+	Percentage     int `json:"percentage"`
 }
 
 func GetSectionStats(sectionID int64) SectionStats {
@@ -946,296 +2534,1749 @@ func GetSectionStats(sectionID int64) SectionStats {
 	if stats.TotalItems > 0 {
 		stats.Percentage = (stats.CompletedItems * 100) / stats.TotalItems
 	}
-	return stats
+	return stats
+}
+
+// ==================== BATCH DELETE SECTIONS ====================
+
+func DeleteSections(ids []int64) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		_, err := tx.Exec("DELETE FROM sections WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ==================== PURCHASES ====================
+
+// RecordPurchaseTx logs a purchase of an item within a transaction
+func RecordPurchaseTx(tx *sql.Tx, itemID int64) error {
+	_, err := tx.Exec(`
+		INSERT INTO purchases (item_id, name, quantity, unit, list_id, section_id, section_name)
+		SELECT i.id, i.name, i.quantity, i.unit, s.list_id, s.id, s.name
+		FROM items i
+		JOIN sections s ON i.section_id = s.id
+		WHERE i.id = ?
+	`, itemID)
+	return err
+}
+
+// PurchaseFilter narrows purchase stats to a user's lists, a single list and/or a time range
+type PurchaseFilter struct {
+	UserID int64 // only lists owned by or shared with this user
+	ListID int64 // 0 for all of the user's lists
+	Since  int64 // unix time, 0 for all time
+}
+
+func (f PurchaseFilter) where() (string, []interface{}) {
+	conditions := []string{"list_id IN (" + userListIDsSQL + ")"}
+	args := []interface{}{f.UserID, f.UserID}
+	if f.ListID > 0 {
+		conditions = append(conditions, "list_id = ?")
+		args = append(args, f.ListID)
+	}
+	if f.Since > 0 {
+		conditions = append(conditions, "purchased_at >= ?")
+		args = append(args, f.Since)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// TopPurchase is an item name with how often it was bought
+type TopPurchase struct {
+	Name            string `json:"name"`
+	Count           int    `json:"count"`
+	LastPurchasedAt int64  `json:"last_purchased_at"`
+}
+
+// GetTopPurchases returns the most often bought item names
+func GetTopPurchases(filter PurchaseFilter, limit int) ([]TopPurchase, error) {
+	where, args := filter.where()
+	rows, err := DB.Query(`
+		SELECT MAX(name), COUNT(*), MAX(purchased_at)
+		FROM purchases
+		WHERE `+where+`
+		GROUP BY name
+		ORDER BY COUNT(*) DESC, MAX(purchased_at) DESC
+		LIMIT ?
+	`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var top []TopPurchase
+	for rows.Next() {
+		var t TopPurchase
+		if err := rows.Scan(&t.Name, &t.Count, &t.LastPurchasedAt); err != nil {
+			return nil, err
+		}
+		top = append(top, t)
+	}
+	return top, nil
+}
+
+// PurchaseFrequency describes how regularly an item name is bought
+type PurchaseFrequency struct {
+	Name                string  `json:"name"`
+	Count               int     `json:"count"`
+	FirstPurchasedAt    int64   `json:"first_purchased_at"`
+	LastPurchasedAt     int64   `json:"last_purchased_at"`
+	AverageIntervalDays float64 `json:"average_interval_days"` // 0 when bought only once
+}
+
+// GetPurchaseFrequency returns purchase frequency per item name. An empty name returns all items.
+func GetPurchaseFrequency(filter PurchaseFilter, name string, limit int) ([]PurchaseFrequency, error) {
+	where, args := filter.where()
+	if name != "" {
+		where += " AND name = ?"
+		args = append(args, name)
+	}
+	rows, err := DB.Query(`
+		SELECT MAX(name), COUNT(*), MIN(purchased_at), MAX(purchased_at)
+		FROM purchases
+		WHERE `+where+`
+		GROUP BY name
+		ORDER BY COUNT(*) DESC, MAX(name) ASC
+		LIMIT ?
+	`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []PurchaseFrequency
+	for rows.Next() {
+		var f PurchaseFrequency
+		if err := rows.Scan(&f.Name, &f.Count, &f.FirstPurchasedAt, &f.LastPurchasedAt); err != nil {
+			return nil, err
+		}
+		if f.Count > 1 {
+			days := float64(f.LastPurchasedAt-f.FirstPurchasedAt) / 86400 / float64(f.Count-1)
+			f.AverageIntervalDays = math.Round(days*10) / 10
+		}
+		result = append(result, f)
+	}
+	return result, nil
+}
+
+// WeeklyPurchases is the number of purchases in a week starting on Monday (UTC)
+type WeeklyPurchases struct {
+	WeekStart string `json:"week_start"` // YYYY-MM-DD
+	Count     int    `json:"count"`
+}
+
+// GetWeeklyPurchases returns purchase counts for the last n weeks, oldest first.
+// Weeks without purchases are included with a zero count.
+func GetWeeklyPurchases(filter PurchaseFilter, weeks int) ([]WeeklyPurchases, error) {
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	// Monday of the current week
+	monday := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+	first := monday.AddDate(0, 0, -7*(weeks-1))
+	if filter.Since < first.Unix() {
+		filter.Since = first.Unix()
+	}
+
+	where, args := filter.where()
+	rows, err := DB.Query(`
+		SELECT date(purchased_at, 'unixepoch', 'weekday 0', '-6 days') AS week_start, COUNT(*)
+		FROM purchases
+		WHERE `+where+`
+		GROUP BY week_start
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var week string
+		var count int
+		if err := rows.Scan(&week, &count); err != nil {
+			return nil, err
+		}
+		counts[week] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]WeeklyPurchases, 0, weeks)
+	for week := first; !week.After(monday); week = week.AddDate(0, 0, 7) {
+		key := week.Format("2006-01-02")
+		result = append(result, WeeklyPurchases{WeekStart: key, Count: counts[key]})
+	}
+	return result, nil
+}
+
+// ==================== PRICE HISTORY ====================
+
+// PricePoint is a paid price recorded when a list was restarted
+type PricePoint struct {
+	Name       string  `json:"name"`
+	Quantity   float64 `json:"quantity"`
+	Unit       string  `json:"unit"`
+	PaidPrice  float64 `json:"paid_price"`
+	UnitPrice  float64 `json:"unit_price"`
+	ListID     int64   `json:"list_id"`
+	RecordedAt int64   `json:"recorded_at"`
+}
+
+// GetPriceHistory returns the prices recorded for an item name (case-insensitive)
+// on the lists a user can see, newest first
+func GetPriceHistory(userID int64, name string, limit int) ([]PricePoint, error) {
+	rows, err := DB.Query(`
+		SELECT name, quantity, unit, paid_price, COALESCE(list_id, 0), recorded_at
+		FROM price_history
+		WHERE name = ? AND list_id IN (`+userListIDsSQL+`)
+		ORDER BY recorded_at DESC, id DESC
+		LIMIT ?
+	`, name, userID, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []PricePoint
+	for rows.Next() {
+		var p PricePoint
+		if err := rows.Scan(&p.Name, &p.Quantity, &p.Unit, &p.PaidPrice, &p.ListID, &p.RecordedAt); err != nil {
+			return nil, err
+		}
+		if p.Quantity > 0 {
+			p.UnitPrice = math.Round(p.PaidPrice/p.Quantity*10000) / 10000
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+// ==================== ITEM HISTORY (Auto-completion) ====================
+
+type ItemSuggestion struct {
+	Name            string `json:"name"`
+	LastSectionID   int64  `json:"last_section_id"`
+	LastSectionName string `json:"last_section_name"`
+	UsageCount      int    `json:"usage_count"`
+}
+
+// SaveItemHistory saves or updates item name in a user's history for auto-completion.
+// A name saved as an alias counts towards its canonical item.
+func SaveItemHistory(userID int64, name string, sectionID int64) error {
+	name = canonicalHistoryName(DB, userID, name)
+
+	_, err := DB.Exec(`
+		INSERT INTO item_history (user_id, name, last_section_id, usage_count, last_used_at)
+		VALUES (?, ?, ?, 1, strftime('%s', 'now'))
+		ON CONFLICT(user_id, name) DO UPDATE SET
+			last_section_id = excluded.last_section_id,
+			usage_count = usage_count + 1,
+			last_used_at = strftime('%s', 'now')
+	`, userID, name, sectionID)
+	if err != nil {
+		return err
+	}
+	DB.Exec(rememberSectionSQL, name, sectionID)
+
+	// Keep the suggestion index in sync
+	var id int64
+	if err := DB.QueryRow("SELECT id FROM item_history WHERE user_id = ? AND name = ?", userID, name).Scan(&id); err != nil {
+		InvalidateSuggestionIndex()
+		return nil
+	}
+	syncHistoryEntry(id)
+	return nil
+}
+
+// levenshteinDistance calculates the edit distance between two strings, counting runes
+func levenshteinDistance(s1, s2 string) int {
+	r1 := []rune(strings.ToLower(s1))
+	r2 := []rune(strings.ToLower(s2))
+
+	if len(r1) == 0 {
+		return len(r2)
+	}
+	if len(r2) == 0 {
+		return len(r1)
+	}
+
+	// Two rows of the edit matrix are enough
+	prev := make([]int, len(r2)+1)
+	curr := make([]int, len(r2)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(r1); i++ {
+		curr[0] = i
+		for j := 1; j <= len(r2); j++ {
+			cost := 1
+			if r1[i-1] == r2[j-1] {
+				cost = 0
+			}
+			curr[j] = min(
+				prev[j]+1,      // deletion
+				curr[j-1]+1,    // insertion
+				prev[j-1]+cost, // substitution
+			)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(r2)]
+}
+
+// wordPrefixMatch reports whether every query word is a prefix of a name word,
+// in order ("zo se" matches "zolty ser")
+func wordPrefixMatch(nameWords, queryWords []string) bool {
+	if len(queryWords) == 0 {
+		return false
+	}
+	next := 0
+	for _, q := range queryWords {
+		for next < len(nameWords) && !strings.HasPrefix(nameWords[next], q) {
+			next++
+		}
+		if next == len(nameWords) {
+			return false
+		}
+		next++
+	}
+	return true
+}
+
+// scoreSuggestion calculates a match score (higher is better).
+// Names and queries are compared with diacritics and Cyrillic folded to plain Latin.
+func scoreSuggestion(name, query string) int {
+	query = strings.TrimSpace(query)
+	return scoreFolded(name, foldName(strings.TrimSpace(name)), strings.ToLower(query), foldName(query))
+}
+
+// scoreFolded scores a name whose folded form is already known, against a trimmed
+// lowercase query and its folded form. Used by the suggestion index to avoid refolding.
+func scoreFolded(name, nameFolded, lowerQuery, queryFolded string) int {
+	if queryFolded == "" {
+		return 0
+	}
+
+	// Typing the exact spelling ranks above a folded match
+	bonus := 0
+	if strings.Contains(strings.ToLower(name), lowerQuery) {
+		bonus = 10
+	}
+
+	// Exact match: highest score
+	if nameFolded == queryFolded {
+		return 1000 + bonus
+	}
+
+	// Prefix match: high score
+	if strings.HasPrefix(nameFolded, queryFolded) {
+		return 500 + bonus
+	}
+
+	// Word prefix match: "ser" or "zo se" for "żółty ser"
+	nameWords := strings.Fields(nameFolded)
+	if wordPrefixMatch(nameWords, strings.Fields(queryFolded)) {
+		return 300 + bonus
+	}
+
+	// Contains match: medium score
+	if strings.Contains(nameFolded, queryFolded) {
+		return 200 + bonus
+	}
+
+	// Fuzzy match: score based on Levenshtein distance
+	// Only consider if query is at least 3 chars and distance is reasonable
+	queryLen := utf8.RuneCountInString(queryFolded)
+	if queryLen >= 3 {
+		maxDistance := queryLen / 2 // Allow ~50% typos
+		// The distance is at least the difference in length, so skip the ones that can't match
+		withinReach := func(s string) bool {
+			diff := utf8.RuneCountInString(s) - queryLen
+			return diff <= maxDistance && -diff <= maxDistance
+		}
+
+		if withinReach(nameFolded) {
+			if distance := levenshteinDistance(nameFolded, queryFolded); distance <= maxDistance {
+				return 100 - distance*20 // Lower score for more typos
+			}
+		}
+
+		// Also check if any word in the name fuzzy matches
+		for _, word := range nameWords {
+			if !withinReach(word) {
+				continue
+			}
+			if wordDist := levenshteinDistance(word, queryFolded); wordDist <= maxDistance {
+				return 80 - wordDist*15
+			}
+		}
+	}
+
+	return 0 // No match
+}
+
+// GetItemSuggestions returns item name suggestions from a user's history matching the
+// query with fuzzy matching. The history is searched through the in-memory suggestion index.
+// With a listID, sections are the ones remembered for that list.
+func GetItemSuggestions(userID int64, query string, listID int64, limit int) ([]ItemSuggestion, error) {
+	if limit <= 0 {
+		limit = 10
+	}
+
+	entries, err := historyIndex.search(userID, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	// Section names can change, so look them up for the few results only
+	sectionNames := make(map[int64]string)
+	var placeholders []string
+	var args []interface{}
+	for _, e := range entries {
+		if _, ok := sectionNames[e.sectionID]; e.sectionID != 0 && !ok {
+			sectionNames[e.sectionID] = ""
+			placeholders = append(placeholders, "?")
+			args = append(args, e.sectionID)
+		}
+	}
+	if len(args) > 0 {
+		rows, err := DB.Query(fmt.Sprintf("SELECT id, name FROM sections WHERE id IN (%s)", strings.Join(placeholders, ",")), args...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			var name string
+			if err := rows.Scan(&id, &name); err != nil {
+				return nil, err
+			}
+			sectionNames[id] = name
+		}
+	}
+
+	suggestions := make([]ItemSuggestion, len(entries))
+	for i, e := range entries {
+		suggestions[i] = ItemSuggestion{
+			Name:            e.name,
+			LastSectionID:   e.sectionID,
+			LastSectionName: sectionNames[e.sectionID],
+			UsageCount:      e.usageCount,
+		}
+	}
+	if listID > 0 {
+		if err := applySectionMemory(userID, listID, suggestions); err != nil {
+			return nil, err
+		}
+	}
+	return suggestions, nil
+}
+
+// GetAllItemSuggestions returns all of a user's item suggestions for offline cache.
+// With a listID, sections are the ones remembered for that list.
+func GetAllItemSuggestions(userID, listID int64, limit int) ([]ItemSuggestion, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows, err := DB.Query(`
+		SELECT h.name, COALESCE(h.last_section_id, 0), COALESCE(s.name, ''), h.usage_count
+		FROM item_history h
+		LEFT JOIN sections s ON h.last_section_id = s.id
+		WHERE h.user_id = ?
+		ORDER BY h.usage_count DESC, h.last_used_at DESC
+		LIMIT ?
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var suggestions []ItemSuggestion
+	for rows.Next() {
+		var s ItemSuggestion
+		if err := rows.Scan(&s.Name, &s.LastSectionID, &s.LastSectionName, &s.UsageCount); err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, s)
+	}
+	rows.Close()
+
+	if listID > 0 {
+		if err := applySectionMemory(userID, listID, suggestions); err != nil {
+			return nil, err
+		}
+	}
+	return suggestions, nil
+}
+
+// DueSuggestion is an item that is probably running out, based on how often it is bought
+type DueSuggestion struct {
+	Name          string  `json:"name"`
+	SectionID     int64   `json:"section_id"` // matching section in the requested list, 0 if none
+	SectionName   string  `json:"section_name"`
+	IntervalDays  float64 `json:"interval_days"` // typical number of days between purchases
+	DaysSinceLast float64 `json:"days_since_last"`
+	Purchases     int     `json:"purchases"`  // shopping trips it was bought on
+	Confidence    float64 `json:"confidence"` // 0-1
+}
+
+const (
+	minDueTrips        = 3         // at least two intervals are needed to call anything typical
+	sameTripSeconds    = 12 * 3600 // purchases closer than this count as one shopping trip
+	maxDueOverdue      = 3.0       // items overdue by more than this many intervals were probably dropped
+	minDueConfidence   = 0.2
+	secondsPerDay      = 86400.0
+	defaultDueLimit    = 10
+	minDueIntervalDays = 0.5 // ignore sub-day intervals left over after trip grouping
+)
+
+// GetDueSuggestions returns items whose typical repurchase interval has passed on the
+// lists a user can see, skipping items already waiting on the list. Most confident
+// suggestions come first.
+func GetDueSuggestions(userID, listID int64, limit int) ([]DueSuggestion, error) {
+	if limit <= 0 {
+		limit = defaultDueLimit
+	}
+	now := time.Now().Unix()
+
+	// Items already on the list don't need suggesting
+	pending := make(map[string]bool)
+	rows, err := DB.Query(`
+		SELECT i.name FROM items i
+		JOIN sections s ON i.section_id = s.id
+		WHERE s.list_id = ? AND i.completed = FALSE
+	`, listID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		pending[strings.ToLower(name)] = true
+	}
+	rows.Close()
+
+	sections := make(map[string]int64)
+	rows, err = DB.Query(`SELECT id, name FROM sections WHERE list_id = ?`, listID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sections[strings.ToLower(name)] = id
+	}
+	rows.Close()
+
+	// Purchase timeline across the user's lists, grouped by name (name is COLLATE NOCASE)
+	where, args := PurchaseFilter{UserID: userID}.where()
+	rows, err = DB.Query(`
+		SELECT name, purchased_at, section_name FROM purchases
+		WHERE `+where+`
+		ORDER BY name, purchased_at
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type timeline struct {
+		name        string
+		sectionName string
+		trips       []int64
+	}
+	var timelines []*timeline
+	var current *timeline
+	for rows.Next() {
+		var name, sectionName string
+		var at int64
+		if err := rows.Scan(&name, &at, &sectionName); err != nil {
+			return nil, err
+		}
+		if current == nil || !strings.EqualFold(current.name, name) {
+			current = &timeline{name: name}
+			timelines = append(timelines, current)
+		}
+		current.sectionName = sectionName
+		if n := len(current.trips); n == 0 || at-current.trips[n-1] >= sameTripSeconds {
+			current.trips = append(current.trips, at)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var due []DueSuggestion
+	for _, t := range timelines {
+		if len(t.trips) < minDueTrips || pending[strings.ToLower(t.name)] {
+			continue
+		}
+
+		intervals := make([]float64, 0, len(t.trips)-1)
+		for i := 1; i < len(t.trips); i++ {
+			intervals = append(intervals, float64(t.trips[i]-t.trips[i-1])/secondsPerDay)
+		}
+		typical := median(intervals)
+		if typical < minDueIntervalDays {
+			continue
+		}
+
+		since := float64(now-t.trips[len(t.trips)-1]) / secondsPerDay
+		overdue := since / typical
+		if overdue < 1 || overdue > maxDueOverdue {
+			continue
+		}
+
+		// Regular habits and longer histories are more trustworthy
+		var deviation float64
+		for _, d := range intervals {
+			deviation += math.Abs(d - typical)
+		}
+		deviation /= float64(len(intervals)) * typical
+		confidence := math.Max(0, 1-deviation) * (1 - 1/float64(len(intervals)))
+		if overdue > 2 {
+			confidence *= 2 / overdue
+		}
+		if confidence < minDueConfidence {
+			continue
+		}
+
+		due = append(due, DueSuggestion{
+			Name:          t.name,
+			SectionID:     sections[strings.ToLower(t.sectionName)],
+			SectionName:   t.sectionName,
+			IntervalDays:  math.Round(typical*10) / 10,
+			DaysSinceLast: math.Round(since*10) / 10,
+			Purchases:     len(t.trips),
+			Confidence:    math.Round(confidence*100) / 100,
+		})
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].Confidence != due[j].Confidence {
+			return due[i].Confidence > due[j].Confidence
+		}
+		return due[i].DaysSinceLast/due[i].IntervalDays > due[j].DaysSinceLast/due[j].IntervalDays
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+// median returns the middle value of a non-empty slice (sorts it in place)
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	mid := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[mid-1] + values[mid]) / 2
+	}
+	return values[mid]
+}
+
+// HistoryItem represents an item from history with ID for management
+type HistoryItem struct {
+	ID              int64          `json:"id"`
+	Name            string         `json:"name"`
+	LastSectionID   int64          `json:"last_section_id"`
+	LastSectionName string         `json:"last_section_name"`
+	UsageCount      int            `json:"usage_count"`
+	Aliases         []HistoryAlias `json:"aliases"`
+}
+
+// GetItemHistoryList returns a user's history items for management UI
+func GetItemHistoryList(userID int64) ([]HistoryItem, error) {
+	rows, err := DB.Query(`
+		SELECT h.id, h.name, COALESCE(h.last_section_id, 0), COALESCE(s.name, ''), h.usage_count
+		FROM item_history h
+		LEFT JOIN sections s ON h.last_section_id = s.id
+		WHERE h.user_id = ?
+		ORDER BY h.usage_count DESC, h.last_used_at DESC
+		LIMIT 100
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []HistoryItem
+	for rows.Next() {
+		var h HistoryItem
+		if err := rows.Scan(&h.ID, &h.Name, &h.LastSectionID, &h.LastSectionName, &h.UsageCount); err != nil {
+			return nil, err
+		}
+		items = append(items, h)
+	}
+	rows.Close()
+
+	if err := loadHistoryAliases(items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// DeleteItemHistory deletes a single item from a user's history
+func DeleteItemHistory(userID, id int64) error {
+	result, err := DB.Exec("DELETE FROM item_history WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("history item not found")
+	}
+	historyIndex.delete(id)
+	return nil
+}
+
+// DeleteItemHistoryBatch deletes multiple items from a user's history
+func DeleteItemHistoryBatch(userID int64, ids []int64) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	// Build placeholders
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+
+	query := fmt.Sprintf("DELETE FROM item_history WHERE user_id = ? AND id IN (%s)", strings.Join(placeholders, ","))
+	result, err := DB.Exec(query, append([]interface{}{userID}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	historyIndex.delete(ids...)
+	return result.RowsAffected()
 }
 
-// ==================== BATCH DELETE SECTIONS ====================
+// ==================== ITEM ALIASES ====================
 
-func DeleteSections(ids []int64) error {
+// ErrAliasConflict is returned when an alias is already one of the user's history items or another item's alias
+var ErrAliasConflict = errors.New("alias already in use")
+
+// HistoryAlias is another spelling of a history item, counted as that item
+type HistoryAlias struct {
+	ID    int64  `json:"id"`
+	Alias string `json:"alias"`
+}
+
+// DuplicateGroup is a set of history items that look like spellings of one item
+type DuplicateGroup struct {
+	Canonical  HistoryItem   `json:"canonical"` // most used spelling, the proposed merge target
+	Duplicates []HistoryItem `json:"duplicates"`
+}
+
+// rowQuerier is a *sql.DB or *sql.Tx
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// canonicalHistoryName returns the name of the user's history item an alias belongs to,
+// or name unchanged
+func canonicalHistoryName(q rowQuerier, userID int64, name string) string {
+	var canonical string
+	err := q.QueryRow(`
+		SELECT h.name FROM item_aliases a
+		JOIN item_history h ON h.id = a.history_id
+		WHERE a.alias = ? AND h.user_id = ?
+	`, strings.TrimSpace(name), userID).Scan(&canonical)
+	if err != nil {
+		return name
+	}
+	return canonical
+}
+
+// loadHistoryAliases fills in the aliases of history items
+func loadHistoryAliases(items []HistoryItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, len(items))
+	args := make([]interface{}, len(items))
+	byID := make(map[int64]*HistoryItem, len(items))
+	for i := range items {
+		placeholders[i] = "?"
+		args[i] = items[i].ID
+		items[i].Aliases = []HistoryAlias{}
+		byID[items[i].ID] = &items[i]
+	}
+
+	rows, err := DB.Query(fmt.Sprintf(`
+		SELECT id, history_id, alias FROM item_aliases
+		WHERE history_id IN (%s)
+		ORDER BY id
+	`, strings.Join(placeholders, ",")), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a HistoryAlias
+		var historyID int64
+		if err := rows.Scan(&a.ID, &historyID, &a.Alias); err != nil {
+			return err
+		}
+		if h, ok := byID[historyID]; ok {
+			h.Aliases = append(h.Aliases, a)
+		}
+	}
+	return rows.Err()
+}
+
+// getHistoryItems returns a user's history items by id, with aliases, in the order of ids
+func getHistoryItems(userID int64, ids []int64) ([]HistoryItem, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+
+	rows, err := DB.Query(fmt.Sprintf(`
+		SELECT h.id, h.name, COALESCE(h.last_section_id, 0), COALESCE(s.name, ''), h.usage_count
+		FROM item_history h
+		LEFT JOIN sections s ON h.last_section_id = s.id
+		WHERE h.user_id = ? AND h.id IN (%s)
+	`, strings.Join(placeholders, ",")), append([]interface{}{userID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := make(map[int64]HistoryItem, len(ids))
+	for rows.Next() {
+		var h HistoryItem
+		if err := rows.Scan(&h.ID, &h.Name, &h.LastSectionID, &h.LastSectionName, &h.UsageCount); err != nil {
+			return nil, err
+		}
+		byID[h.ID] = h
+	}
+	rows.Close()
+
+	items := make([]HistoryItem, 0, len(byID))
+	for _, id := range ids {
+		if h, ok := byID[id]; ok {
+			items = append(items, h)
+			delete(byID, id)
+		}
+	}
+	if err := loadHistoryAliases(items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// GetHistoryItemByID returns one of a user's history items with its aliases.
+// Returns sql.ErrNoRows if the item is missing or belongs to another user.
+func GetHistoryItemByID(userID, id int64) (*HistoryItem, error) {
+	items, err := getHistoryItems(userID, []int64{id})
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &items[0], nil
+}
+
+// AddHistoryAlias records another spelling of one of a user's history items.
+// Returns ErrAliasConflict if the spelling is already in use in the user's history;
+// existing history items should be merged instead.
+func AddHistoryAlias(userID, historyID int64, alias string) (*HistoryItem, error) {
+	alias = strings.TrimSpace(alias)
+
+	var name string
+	if err := DB.QueryRow("SELECT name FROM item_history WHERE id = ? AND user_id = ?", historyID, userID).Scan(&name); err != nil {
+		return nil, err
+	}
+
+	var count int
+	DB.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM item_history WHERE user_id = ? AND name = ?) +
+			(SELECT COUNT(*) FROM item_aliases a JOIN item_history h ON h.id = a.history_id WHERE h.user_id = ? AND a.alias = ?)
+	`, userID, alias, userID, alias).Scan(&count)
+	if count > 0 {
+		return nil, ErrAliasConflict
+	}
+
+	if _, err := DB.Exec("INSERT INTO item_aliases (alias, history_id) VALUES (?, ?)", alias, historyID); err != nil {
+		return nil, err
+	}
+
+	syncHistoryEntry(historyID)
+	return GetHistoryItemByID(userID, historyID)
+}
+
+// DeleteHistoryAlias removes an alias from one of a user's history items
+func DeleteHistoryAlias(userID, historyID, aliasID int64) error {
+	result, err := DB.Exec(`
+		DELETE FROM item_aliases
+		WHERE id = ? AND history_id = (SELECT id FROM item_history WHERE id = ? AND user_id = ?)
+	`, aliasID, historyID, userID)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	syncHistoryEntry(historyID)
+	return nil
+}
+
+// MergeItemHistory folds a user's history items into a target item. Usage counts are
+// added up, the most recent use keeps its section, the section memory of the user's lists
+// is combined, and the merged names become aliases of the target. Returns sql.ErrNoRows
+// if any item is missing or belongs to another user.
+func MergeItemHistory(userID, targetID int64, sourceIDs []int64) (*HistoryItem, error) {
 	tx, err := DB.Begin()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var targetName string
+	if err := tx.QueryRow("SELECT name FROM item_history WHERE id = ? AND user_id = ?", targetID, userID).Scan(&targetName); err != nil {
+		return nil, err
+	}
+
+	var merged []int64
+	seen := map[int64]bool{targetID: true}
+	for _, sourceID := range sourceIDs {
+		if seen[sourceID] {
+			continue
+		}
+		seen[sourceID] = true
+
+		var name string
+		var usageCount int
+		var lastUsedAt, sectionID int64
+		err := tx.QueryRow(`
+			SELECT name, COALESCE(usage_count, 1), COALESCE(last_used_at, 0), COALESCE(last_section_id, 0)
+			FROM item_history WHERE id = ? AND user_id = ?
+		`, sourceID, userID).Scan(&name, &usageCount, &lastUsedAt, &sectionID)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.Exec(`
+			UPDATE item_history SET
+				usage_count = usage_count + ?,
+				last_section_id = CASE WHEN ? > COALESCE(last_used_at, 0) AND ? != 0 THEN ? ELSE last_section_id END,
+				last_used_at = MAX(COALESCE(last_used_at, 0), ?)
+			WHERE id = ?
+		`, usageCount, lastUsedAt, sectionID, sectionID, lastUsedAt, targetID)
+		if err != nil {
+			return nil, err
+		}
+
+		// The merged name and its aliases become aliases of the target; spellings the
+		// target already has go away with the source row
+		if _, err := tx.Exec("UPDATE OR IGNORE item_aliases SET history_id = ? WHERE history_id = ?", targetID, sourceID); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("INSERT OR IGNORE INTO item_aliases (alias, history_id) VALUES (?, ?)", name, targetID); err != nil {
+			return nil, err
+		}
+
+		// Per-list section memory: counts add up, the most recently used section wins.
+		// Lists the user can't see keep their memory.
+		_, err = tx.Exec(`
+			INSERT INTO item_sections (name, list_id, section_id, section_name, usage_count, last_used_at)
+			SELECT ?, list_id, section_id, section_name, usage_count, last_used_at
+			FROM item_sections WHERE name = ? AND list_id IN (`+userListIDsSQL+`)
+			ON CONFLICT(name, list_id) DO UPDATE SET
+				usage_count = usage_count + excluded.usage_count,
+				section_id = CASE WHEN excluded.last_used_at > last_used_at THEN excluded.section_id ELSE section_id END,
+				section_name = CASE WHEN excluded.last_used_at > last_used_at THEN excluded.section_name ELSE section_name END,
+				last_used_at = MAX(last_used_at, excluded.last_used_at)
+		`, targetName, name, userID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("DELETE FROM item_sections WHERE name = ? AND list_id IN ("+userListIDsSQL+")", name, userID, userID); err != nil {
+			return nil, err
+		}
+
+		if _, err := tx.Exec("DELETE FROM item_history WHERE id = ?", sourceID); err != nil {
+			return nil, err
+		}
+		merged = append(merged, sourceID)
+	}
+
+	// The target's own name is never its alias
+	if _, err := tx.Exec("DELETE FROM item_aliases WHERE history_id = ? AND alias = ?", targetID, targetName); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	historyIndex.delete(merged...)
+	syncHistoryEntry(targetID)
+	return GetHistoryItemByID(userID, targetID)
+}
+
+// duplicateBase strips words with digits ("2%", "1.5l") from a folded name,
+// so sizes and fat contents don't keep spellings of one item apart
+func duplicateBase(folded string) string {
+	var words []string
+	for _, word := range strings.Fields(folded) {
+		if !strings.ContainsAny(word, "0123456789") {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// isNearDuplicate reports whether two folded names look like spellings of one item:
+// equal once sizes are stripped, or one typo per four letters apart and starting with
+// the same letter ("mlk" and "milk", "jogurt" and "jugurt", not "milk" and "silk")
+func isNearDuplicate(a, b string) bool {
+	baseA, baseB := duplicateBase(a), duplicateBase(b)
+	if baseA == "" || baseB == "" {
+		return false
+	}
+	if baseA == baseB {
+		return true
+	}
+
+	ra, rb := []rune(baseA), []rune(baseB)
+	if ra[0] != rb[0] {
+		return false
+	}
+	shorter := min(len(ra), len(rb))
+	if shorter < 3 {
+		return false
+	}
+	return levenshteinDistance(baseA, baseB) <= max(1, shorter/4)
+}
+
+// FindHistoryDuplicates proposes merges of a user's history items that look like spellings
+// of one item. Candidates come from the suggestion index, so the cost is one search per item.
+func FindHistoryDuplicates(userID int64, limit int) ([]DuplicateGroup, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	entries, err := historyIndex.userEntries(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Union-find over near-duplicate pairs
+	parent := make(map[int64]int64)
+	var find func(id int64) int64
+	find = func(id int64) int64 {
+		if parent[id] == id {
+			return id
+		}
+		root := find(parent[id])
+		parent[id] = root
+		return root
+	}
+	union := func(a, b int64) {
+		for _, id := range []int64{a, b} {
+			if _, ok := parent[id]; !ok {
+				parent[id] = id
+			}
+		}
+		parent[find(a)] = find(b)
+	}
+
+	byID := make(map[int64]historyEntry, len(entries))
+	for _, e := range entries {
+		byID[e.id] = e
+		base := duplicateBase(e.folded)
+		if base == "" {
+			continue
+		}
+		candidates, err := historyIndex.search(userID, base, 5)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range candidates {
+			if c.id != e.id && isNearDuplicate(e.folded, c.folded) {
+				union(e.id, c.id)
+			}
+		}
+	}
+
+	groups := make(map[int64][]historyEntry)
+	for id := range parent {
+		if e, ok := byID[id]; ok {
+			groups[find(id)] = append(groups[find(id)], e)
+		}
+	}
+
+	type rankedGroup struct {
+		ids   []int64
+		usage int
+	}
+	var ranked []rankedGroup
+	for _, members := range groups {
+		if len(members) < 2 {
+			continue
+		}
+		// Most used spelling first, oldest entry on ties
+		sort.Slice(members, func(i, j int) bool {
+			if members[i].usageCount != members[j].usageCount {
+				return members[i].usageCount > members[j].usageCount
+			}
+			return members[i].id < members[j].id
+		})
+		g := rankedGroup{}
+		for _, m := range members {
+			g.ids = append(g.ids, m.id)
+			g.usage += m.usageCount
+		}
+		ranked = append(ranked, g)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].usage != ranked[j].usage {
+			return ranked[i].usage > ranked[j].usage
+		}
+		return ranked[i].ids[0] < ranked[j].ids[0]
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	result := make([]DuplicateGroup, 0, len(ranked))
+	for _, g := range ranked {
+		items, err := getHistoryItems(userID, g.ids)
+		if err != nil {
+			return nil, err
+		}
+		if len(items) < 2 {
+			continue
+		}
+		result = append(result, DuplicateGroup{Canonical: items[0], Duplicates: items[1:]})
+	}
+	return result, nil
+}
+
+// ==================== SECTION MEMORY ====================
+
+// rememberSectionSQL records that an item name was put in a section, keyed by the
+// section's list. Args: item name, section id.
+const rememberSectionSQL = `
+	INSERT INTO item_sections (name, list_id, section_id, section_name, usage_count, last_used_at)
+	SELECT ?, list_id, id, name, 1, strftime('%s', 'now') FROM sections WHERE id = ?
+	ON CONFLICT(name, list_id) DO UPDATE SET
+		section_id = excluded.section_id,
+		section_name = excluded.section_name,
+		usage_count = usage_count + 1,
+		last_used_at = excluded.last_used_at
+`
+
+// rememberMovedItemSQL records the section an item was moved to, without counting
+// it as a use. Args: item id.
+const rememberMovedItemSQL = `
+	INSERT INTO item_sections (name, list_id, section_id, section_name, usage_count, last_used_at)
+	SELECT i.name, s.list_id, s.id, s.name, 1, strftime('%s', 'now')
+	FROM items i
+	JOIN sections s ON s.id = i.section_id
+	WHERE i.id = ?
+	ON CONFLICT(name, list_id) DO UPDATE SET
+		section_id = excluded.section_id,
+		section_name = excluded.section_name,
+		last_used_at = excluded.last_used_at
+`
+
+// sectionMatch is the remembered section of an item name for one list
+type sectionMatch struct {
+	ID   int64 // section in the list, 0 if the list has no section with that name yet
+	Name string
+}
+
+// matchSectionsForList looks up the remembered section of each name for a list.
+// The list's own memory wins, matched by section id or else by section name.
+// Otherwise the most used section name from the user's other lists is taken,
+// preferring one the list already has. Names without memory are missing from the
+// result, which is keyed by lowercased name.
+func matchSectionsForList(userID, listID int64, names []string) (map[string]sectionMatch, error) {
+	matches := make(map[string]sectionMatch)
+	if len(names) == 0 {
+		return matches, nil
+	}
+
+	rows, err := DB.Query("SELECT id, name FROM sections WHERE list_id = ? ORDER BY sort_order ASC", listID)
+	if err != nil {
+		return nil, err
+	}
+	sectionsByID := make(map[int64]string)
+	sectionsByName := make(map[string]int64)
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sectionsByID[id] = name
+		if _, ok := sectionsByName[strings.ToLower(name)]; !ok {
+			sectionsByName[strings.ToLower(name)] = id
+		}
+	}
+	rows.Close()
+
+	placeholders := make([]string, len(names))
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		placeholders[i] = "?"
+		args[i] = name
+	}
+	rows, err = DB.Query(fmt.Sprintf(`
+		SELECT name, list_id, COALESCE(section_id, 0), section_name
+		FROM item_sections
+		WHERE name IN (%s) AND (list_id = ? OR list_id IN (`+userListIDsSQL+`))
+		ORDER BY usage_count DESC, last_used_at DESC
+	`, strings.Join(placeholders, ",")), append(args, listID, userID, userID)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	own := make(map[string]bool)
+	for rows.Next() {
+		var name, sectionName string
+		var rowListID, sectionID int64
+		if err := rows.Scan(&name, &rowListID, &sectionID, &sectionName); err != nil {
+			return nil, err
+		}
+		key := strings.ToLower(name)
+
+		if rowListID == listID {
+			if current, ok := sectionsByID[sectionID]; ok {
+				matches[key] = sectionMatch{ID: sectionID, Name: current}
+			} else {
+				matches[key] = sectionMatch{ID: sectionsByName[strings.ToLower(sectionName)], Name: sectionName}
+			}
+			own[key] = true
+			continue
+		}
+		if own[key] {
+			continue
+		}
+		id := sectionsByName[strings.ToLower(sectionName)]
+		if current, ok := matches[key]; !ok || (current.ID == 0 && id != 0) {
+			matches[key] = sectionMatch{ID: id, Name: sectionName}
+		}
+	}
+	return matches, rows.Err()
+}
+
+// applySectionMemory points suggestions at the sections remembered for a list.
+// Suggestions without any memory keep their last section.
+func applySectionMemory(userID, listID int64, suggestions []ItemSuggestion) error {
+	names := make([]string, len(suggestions))
+	for i, s := range suggestions {
+		names[i] = s.Name
+	}
+	matches, err := matchSectionsForList(userID, listID, names)
+	if err != nil {
+		return err
+	}
+	for i, s := range suggestions {
+		if m, ok := matches[strings.ToLower(s.Name)]; ok {
+			suggestions[i].LastSectionID = m.ID
+			suggestions[i].LastSectionName = m.Name
+		}
+	}
+	return nil
+}
+
+// ResolveItemSection picks the section of a list for an item a user added without one:
+// the section remembered for the name, or for a close match of it in the user's history,
+// created in the list when the list does not have it yet. Without memory the list's first
+// section is used. Returns sql.ErrNoRows when the list has no sections to fall back to.
+func ResolveItemSection(userID, listID int64, name string) (section *Section, created bool, err error) {
+	name = canonicalHistoryName(DB, userID, name)
+	names := []string{name}
+
+	// A new spelling of a known item ("zolty ser" for "Żółty ser") follows the known one.
+	// 300 is a word prefix match or better, see scoreSuggestion.
+	if entries, err := historyIndex.search(userID, name, 1); err == nil && len(entries) > 0 &&
+		!strings.EqualFold(entries[0].name, name) && scoreSuggestion(entries[0].name, name) >= 300 {
+		names = append(names, entries[0].name)
+	}
+
+	matches, err := matchSectionsForList(userID, listID, names)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, n := range names {
+		m, ok := matches[strings.ToLower(n)]
+		if !ok {
+			continue
+		}
+		if m.ID != 0 {
+			section, err = GetSectionByID(m.ID)
+			return section, false, err
+		}
+		section, err = CreateSectionForList(listID, m.Name)
+		return section, err == nil, err
+	}
+
+	var sectionID int64
+	err = DB.QueryRow("SELECT id FROM sections WHERE list_id = ? ORDER BY sort_order ASC LIMIT 1", listID).Scan(&sectionID)
+	if err != nil {
+		return nil, false, err
 	}
-	defer tx.Rollback()
+	section, err = GetSectionByID(sectionID)
+	return section, false, err
+}
 
-	for _, id := range ids {
-		_, err := tx.Exec("DELETE FROM sections WHERE id = ?", id)
-		if err != nil {
-			return err
-		}
-	}
+// ==================== RECURRING ITEMS ====================
 
-	return tx.Commit()
+// Recurrence kinds
+const (
+	RecurEveryDays = "interval"  // every IntervalDays days
+	RecurWeekday   = "weekday"   // every week on Weekday, 0 = Sunday
+	RecurMonthDay  = "month_day" // every month on MonthDay, or on the last day of shorter months
+)
+
+// RecurrenceRule is when a recurring item is due
+type RecurrenceRule struct {
+	Kind         string `json:"kind"`
+	IntervalDays int    `json:"interval_days"`
+	Weekday      int    `json:"weekday"`
+	MonthDay     int    `json:"month_day"`
 }
 
-// ==================== ITEM HISTORY (Auto-completion) ====================
+// Recurrence puts an item back on a list when due: un-completes it, or adds it again
+// if it was deleted. It is set on a list item, or on a template item for a target list.
+type Recurrence struct {
+	ID             int64 `json:"id"`
+	ListID         int64 `json:"list_id"`
+	ItemID         int64 `json:"item_id"`          // item last put on the list, may have been deleted since
+	TemplateItemID int64 `json:"template_item_id"` // 0 for rules set on a list item
+	RecurrenceRule
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Quantity    float64 `json:"quantity"`
+	Unit        string  `json:"unit"`
+	SectionName string  `json:"section_name"`
+	NextDueAt   int64   `json:"next_due_at"`
+	LastRunAt   int64   `json:"last_run_at"`
+}
 
-type ItemSuggestion struct {
-	Name            string `json:"name"`
-	LastSectionID   int64  `json:"last_section_id"`
-	LastSectionName string `json:"last_section_name"`
-	UsageCount      int    `json:"usage_count"`
+// RecurrenceRun is what a due recurrence did to its list
+type RecurrenceRun struct {
+	ListID         int64
+	Item           *Item
+	Created        bool     // added again rather than un-completed
+	CreatedSection *Section // section created to hold the item, if any
 }
 
-// SaveItemHistory saves or updates item name in history for auto-completion
-func SaveItemHistory(name string, sectionID int64) error {
-	_, err := DB.Exec(`
-		INSERT INTO item_history (name, last_section_id, usage_count, last_used_at)
-		VALUES (?, ?, 1, strftime('%s', 'now'))
-		ON CONFLICT(name COLLATE NOCASE) DO UPDATE SET
-			last_section_id = excluded.last_section_id,
-			usage_count = usage_count + 1,
-			last_used_at = strftime('%s', 'now')
-	`, name, sectionID)
-	return err
+// Validate checks the rule's fields for its kind
+func (r RecurrenceRule) Validate() error {
+	switch r.Kind {
+	case RecurEveryDays:
+		if r.IntervalDays < 1 || r.IntervalDays > 365 {
+			return fmt.Errorf("interval_days must be between 1 and 365")
+		}
+	case RecurWeekday:
+		if r.Weekday < 0 || r.Weekday > 6 {
+			return fmt.Errorf("weekday must be between 0 (Sunday) and 6 (Saturday)")
+		}
+	case RecurMonthDay:
+		if r.MonthDay < 1 || r.MonthDay > 31 {
+			return fmt.Errorf("month_day must be between 1 and 31")
+		}
+	default:
+		return fmt.Errorf("kind must be one of: %s, %s, %s", RecurEveryDays, RecurWeekday, RecurMonthDay)
+	}
+	return nil
 }
 
-// levenshteinDistance calculates the edit distance between two strings
-func levenshteinDistance(s1, s2 string) int {
-	s1 = strings.ToLower(s1)
-	s2 = strings.ToLower(s2)
+// NextDue returns the first due time after now, at the start of a day in server time.
+// Interval rules count from prev, the previous due time or the time the rule was set.
+func (r RecurrenceRule) NextDue(prev, now time.Time) time.Time {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
 
-	if len(s1) == 0 {
-		return len(s2)
+	switch r.Kind {
+	case RecurEveryDays:
+		due := time.Date(prev.Year(), prev.Month(), prev.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, r.IntervalDays)
+		for !due.After(now) {
+			due = due.AddDate(0, 0, r.IntervalDays)
+		}
+		return due
+	case RecurWeekday:
+		due := today.AddDate(0, 0, 1)
+		for int(due.Weekday()) != r.Weekday {
+			due = due.AddDate(0, 0, 1)
+		}
+		return due
+	default:
+		for month := 0; ; month++ {
+			first := time.Date(today.Year(), today.Month()+time.Month(month), 1, 0, 0, 0, 0, now.Location())
+			lastDay := first.AddDate(0, 1, -1).Day()
+			due := first.AddDate(0, 0, min(r.MonthDay, lastDay)-1)
+			if due.After(now) {
+				return due
+			}
+		}
 	}
-	if len(s2) == 0 {
-		return len(s1)
+}
+
+const recurrenceColumns = `
+	id, list_id, COALESCE(item_id, 0), COALESCE(template_item_id, 0), kind, interval_days, weekday, month_day,
+	name, description, quantity, unit, section_name, next_due_at, last_run_at
+`
+
+func scanRecurrence(row interface{ Scan(...interface{}) error }) (*Recurrence, error) {
+	var r Recurrence
+	err := row.Scan(&r.ID, &r.ListID, &r.ItemID, &r.TemplateItemID, &r.Kind, &r.IntervalDays, &r.Weekday, &r.MonthDay,
+		&r.Name, &r.Description, &r.Quantity, &r.Unit, &r.SectionName, &r.NextDueAt, &r.LastRunAt)
+	if err != nil {
+		return nil, err
 	}
+	return &r, nil
+}
 
-	// Create matrix
-	matrix := make([][]int, len(s1)+1)
-	for i := range matrix {
-		matrix[i] = make([]int, len(s2)+1)
-		matrix[i][0] = i
+// GetRecurrences returns the recurrences of a list, or of all lists the user can see
+// if listID is 0, soonest due first
+func GetRecurrences(userID, listID int64) ([]Recurrence, error) {
+	query := "SELECT " + recurrenceColumns + " FROM recurrences WHERE list_id IN (" + userListIDsSQL + ")"
+	args := []interface{}{userID, userID}
+	if listID > 0 {
+		query += " AND list_id = ?"
+		args = append(args, listID)
 	}
-	for j := range matrix[0] {
-		matrix[0][j] = j
+	query += " ORDER BY next_due_at ASC, id ASC"
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	// Fill matrix
-	for i := 1; i <= len(s1); i++ {
-		for j := 1; j <= len(s2); j++ {
-			cost := 1
-			if s1[i-1] == s2[j-1] {
-				cost = 0
-			}
-			matrix[i][j] = min(
-				matrix[i-1][j]+1,      // deletion
-				matrix[i][j-1]+1,      // insertion
-				matrix[i-1][j-1]+cost, // substitution
-			)
+	var recurrences []Recurrence
+	for rows.Next() {
+		r, err := scanRecurrence(rows)
+		if err != nil {
+			return nil, err
 		}
+		recurrences = append(recurrences, *r)
 	}
-
-	return matrix[len(s1)][len(s2)]
+	return recurrences, rows.Err()
 }
 
-// scoreSuggestion calculates a match score (higher is better)
-func scoreSuggestion(name, query string) int {
-	nameLower := strings.ToLower(name)
-	queryLower := strings.ToLower(query)
+// GetRecurrenceByID returns a single recurrence
+func GetRecurrenceByID(id int64) (*Recurrence, error) {
+	return scanRecurrence(DB.QueryRow("SELECT "+recurrenceColumns+" FROM recurrences WHERE id = ?", id))
+}
 
-	// Exact match: highest score
-	if nameLower == queryLower {
-		return 1000
+// SetItemRecurrence makes a list item recurring. An item has one rule; setting a new one replaces it.
+func SetItemRecurrence(itemID int64, rule RecurrenceRule) (*Recurrence, error) {
+	var listID int64
+	var name, description, unit, sectionName string
+	var quantity float64
+	err := DB.QueryRow(`
+		SELECT s.list_id, i.name, i.description, i.quantity, i.unit, s.name
+		FROM items i
+		JOIN sections s ON s.id = i.section_id
+		WHERE i.id = ?
+	`, itemID).Scan(&listID, &name, &description, &quantity, &unit, &sectionName)
+	if err != nil {
+		return nil, err
 	}
 
-	// Prefix match: high score
-	if strings.HasPrefix(nameLower, queryLower) {
-		return 500
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
 	}
+	defer tx.Rollback()
 
-	// Contains match: medium score
-	if strings.Contains(nameLower, queryLower) {
-		return 200
+	if _, err := tx.Exec("DELETE FROM recurrences WHERE item_id = ? AND template_item_id IS NULL", itemID); err != nil {
+		return nil, err
 	}
 
-	// Fuzzy match: score based on Levenshtein distance
-	// Only consider if query is at least 3 chars and distance is reasonable
-	if len(query) >= 3 {
-		distance := levenshteinDistance(nameLower, queryLower)
-		maxDistance := len(query) / 2 // Allow ~50% typos
-
-		if distance <= maxDistance {
-			return 100 - distance*20 // Lower score for more typos
-		}
+	now := time.Now()
+	result, err := tx.Exec(`
+		INSERT INTO recurrences (list_id, item_id, kind, interval_days, weekday, month_day,
+			name, description, quantity, unit, section_name, next_due_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, listID, itemID, rule.Kind, rule.IntervalDays, rule.Weekday, rule.MonthDay,
+		name, description, quantity, unit, sectionName, rule.NextDue(now, now).Unix())
+	if err != nil {
+		return nil, err
+	}
 
-		// Also check if any word in the name fuzzy matches
-		words := strings.Fields(nameLower)
-		for _, word := range words {
-			wordDist := levenshteinDistance(word, queryLower)
-			if wordDist <= maxDistance {
-				return 80 - wordDist*15
-			}
-		}
+	if err := tx.Commit(); err != nil {
+		return nil, err
 	}
 
-	return 0 // No match
+	id, _ := result.LastInsertId()
+	return GetRecurrenceByID(id)
 }
 
-// GetItemSuggestions returns item name suggestions matching the query with fuzzy matching
-func GetItemSuggestions(query string, limit int) ([]ItemSuggestion, error) {
-	if limit <= 0 {
-		limit = 10
+// SetTemplateItemRecurrence makes a template item recur on a list. A template item has
+// one rule per list; setting a new one replaces it.
+func SetTemplateItemRecurrence(templateItemID, listID int64, rule RecurrenceRule) (*Recurrence, error) {
+	ti, err := GetTemplateItemByID(templateItemID)
+	if err != nil {
+		return nil, err
+	}
+	if err := DB.QueryRow("SELECT id FROM lists WHERE id = ?", listID).Scan(&listID); err != nil {
+		return nil, err
 	}
 
-	// Fetch more items to allow for fuzzy matching and scoring
-	rows, err := DB.Query(`
-		SELECT h.name, COALESCE(h.last_section_id, 0), COALESCE(s.name, ''), h.usage_count
-		FROM item_history h
-		LEFT JOIN sections s ON h.last_section_id = s.id
-		ORDER BY h.usage_count DESC, h.last_used_at DESC
-		LIMIT 200
-	`)
+	tx, err := DB.Begin()
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	type scoredSuggestion struct {
-		suggestion ItemSuggestion
-		score      int
+	if _, err := tx.Exec("DELETE FROM recurrences WHERE template_item_id = ? AND list_id = ?", templateItemID, listID); err != nil {
+		return nil, err
 	}
 
-	var scored []scoredSuggestion
-	for rows.Next() {
-		var s ItemSuggestion
-		if err := rows.Scan(&s.Name, &s.LastSectionID, &s.LastSectionName, &s.UsageCount); err != nil {
-			return nil, err
-		}
+	now := time.Now()
+	result, err := tx.Exec(`
+		INSERT INTO recurrences (list_id, template_item_id, kind, interval_days, weekday, month_day,
+			name, description, quantity, unit, section_name, next_due_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, listID, templateItemID, rule.Kind, rule.IntervalDays, rule.Weekday, rule.MonthDay,
+		ti.Name, ti.Description, ti.Quantity, ti.Unit, ti.SectionName, rule.NextDue(now, now).Unix())
+	if err != nil {
+		return nil, err
+	}
 
-		score := scoreSuggestion(s.Name, query)
-		if score > 0 {
-			// Boost score slightly by usage count
-			score += s.UsageCount / 10
-			scored = append(scored, scoredSuggestion{s, score})
-		}
+	if err := tx.Commit(); err != nil {
+		return nil, err
 	}
 
-	// Sort by score (descending), then by usage_count (descending)
-	sort.Slice(scored, func(i, j int) bool {
-		if scored[i].score != scored[j].score {
-			return scored[i].score > scored[j].score
-		}
-		return scored[i].suggestion.UsageCount > scored[j].suggestion.UsageCount
-	})
+	id, _ := result.LastInsertId()
+	return GetRecurrenceByID(id)
+}
 
-	// Return top results
-	var suggestions []ItemSuggestion
-	for i := 0; i < len(scored) && i < limit; i++ {
-		suggestions = append(suggestions, scored[i].suggestion)
+// UpdateRecurrenceRule changes when a recurrence is due, counting from now
+func UpdateRecurrenceRule(id int64, rule RecurrenceRule) (*Recurrence, error) {
+	now := time.Now()
+	result, err := DB.Exec(`
+		UPDATE recurrences SET kind = ?, interval_days = ?, weekday = ?, month_day = ?, next_due_at = ?
+		WHERE id = ?
+	`, rule.Kind, rule.IntervalDays, rule.Weekday, rule.MonthDay, rule.NextDue(now, now).Unix(), id)
+	if err != nil {
+		return nil, err
 	}
-
-	return suggestions, nil
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return GetRecurrenceByID(id)
 }
 
-// GetAllItemSuggestions returns all item suggestions for offline cache
-func GetAllItemSuggestions(limit int) ([]ItemSuggestion, error) {
-	if limit <= 0 {
-		limit = 100
+// DeleteRecurrence stops an item from recurring
+func DeleteRecurrence(id int64) error {
+	result, err := DB.Exec("DELETE FROM recurrences WHERE id = ?", id)
+	if err != nil {
+		return err
 	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
 
-	rows, err := DB.Query(`
-		SELECT h.name, COALESCE(h.last_section_id, 0), COALESCE(s.name, ''), h.usage_count
-		FROM item_history h
-		LEFT JOIN sections s ON h.last_section_id = s.id
-		ORDER BY h.usage_count DESC, h.last_used_at DESC
-		LIMIT ?
-	`, limit)
+// RunDueRecurrences puts every recurrence due at now back on its list and schedules
+// its next run. A failing recurrence is logged and skipped to its next due time.
+func RunDueRecurrences(now time.Time) ([]RecurrenceRun, error) {
+	rows, err := DB.Query("SELECT "+recurrenceColumns+" FROM recurrences WHERE next_due_at <= ? ORDER BY next_due_at ASC", now.Unix())
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var suggestions []ItemSuggestion
+	var due []*Recurrence
 	for rows.Next() {
-		var s ItemSuggestion
-		if err := rows.Scan(&s.Name, &s.LastSectionID, &s.LastSectionName, &s.UsageCount); err != nil {
+		r, err := scanRecurrence(rows)
+		if err != nil {
+			rows.Close()
 			return nil, err
 		}
-		suggestions = append(suggestions, s)
+		due = append(due, r)
 	}
-	return suggestions, nil
-}
+	rows.Close()
 
-// HistoryItem represents an item from history with ID for management
-type HistoryItem struct {
-	ID              int64  `json:"id"`
-	Name            string `json:"name"`
-	LastSectionID   int64  `json:"last_section_id"`
-	LastSectionName string `json:"last_section_name"`
-	UsageCount      int    `json:"usage_count"`
+	var runs []RecurrenceRun
+	for _, r := range due {
+		run, err := runRecurrence(r)
+		if err != nil {
+			log.Printf("Recurrence %d (%s) failed: %v", r.ID, r.Name, err)
+		} else if run != nil {
+			runs = append(runs, *run)
+		}
+
+		next := r.NextDue(time.Unix(r.NextDueAt, 0), now)
+		_, err = DB.Exec(`
+			UPDATE recurrences SET item_id = ?, name = ?, description = ?, quantity = ?, unit = ?, section_name = ?,
+				next_due_at = ?, last_run_at = ?
+			WHERE id = ?
+		`, r.ItemID, r.Name, r.Description, r.Quantity, r.Unit, r.SectionName, next.Unix(), now.Unix(), r.ID)
+		if err != nil {
+			return runs, err
+		}
+	}
+	return runs, nil
 }
 
-// GetItemHistoryList returns all history items for management UI
-func GetItemHistoryList() ([]HistoryItem, error) {
-	rows, err := DB.Query(`
-		SELECT h.id, h.name, COALESCE(h.last_section_id, 0), COALESCE(s.name, ''), h.usage_count
-		FROM item_history h
-		LEFT JOIN sections s ON h.last_section_id = s.id
-		ORDER BY h.usage_count DESC, h.last_used_at DESC
-		LIMIT 100
-	`)
-	if err != nil {
+// runRecurrence un-completes the recurrence's item, or adds it again if it is gone.
+// Returns nil if the item is already waiting on the list. Updates r with the item it used.
+func runRecurrence(r *Recurrence) (*RecurrenceRun, error) {
+	// The item last put on the list, or else one with the same name
+	var itemID int64
+	err := DB.QueryRow(`
+		SELECT i.id FROM items i
+		JOIN sections s ON s.id = i.section_id
+		WHERE s.list_id = ? AND (i.id = ? OR i.name = ? COLLATE NOCASE)
+		ORDER BY i.id = ? DESC, i.completed ASC
+		LIMIT 1
+	`, r.ListID, r.ItemID, r.Name, r.ItemID).Scan(&itemID)
+	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var items []HistoryItem
-	for rows.Next() {
-		var h HistoryItem
-		if err := rows.Scan(&h.ID, &h.Name, &h.LastSectionID, &h.LastSectionName, &h.UsageCount); err != nil {
+	if err == nil {
+		item, err := GetItemByID(itemID)
+		if err != nil {
 			return nil, err
 		}
-		items = append(items, h)
+		r.ItemID = item.ID
+		if r.TemplateItemID == 0 {
+			// Rules on a list item follow edits to it
+			r.Name, r.Description, r.Quantity, r.Unit = item.Name, item.Description, item.Quantity, item.Unit
+			DB.QueryRow("SELECT name FROM sections WHERE id = ?", item.SectionID).Scan(&r.SectionName)
+		}
+		if !item.Completed {
+			return nil, nil
+		}
+		item, err = uncompleteItem(item.ID)
+		if err != nil {
+			return nil, err
+		}
+		return &RecurrenceRun{ListID: r.ListID, Item: item}, nil
 	}
-	return items, nil
-}
 
-// DeleteItemHistory deletes a single item from history
-func DeleteItemHistory(id int64) error {
-	result, err := DB.Exec("DELETE FROM item_history WHERE id = ?", id)
-	if err != nil {
-		return err
+	// The item was deleted: add it again to its section, created if needed
+	run := &RecurrenceRun{ListID: r.ListID, Created: true}
+	var section *Section
+	if r.SectionName != "" {
+		var sectionID int64
+		err := DB.QueryRow(`
+			SELECT id FROM sections WHERE list_id = ? AND name = ? COLLATE NOCASE
+			ORDER BY sort_order ASC LIMIT 1
+		`, r.ListID, r.SectionName).Scan(&sectionID)
+		switch {
+		case err == nil:
+			section, err = GetSectionByID(sectionID)
+		case err == sql.ErrNoRows:
+			section, err = CreateSectionForList(r.ListID, r.SectionName)
+			run.CreatedSection = section
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Recurring items are added on behalf of the list owner
+		var ownerID int64
+		DB.QueryRow("SELECT COALESCE(owner_id, 0) FROM lists WHERE id = ?", r.ListID).Scan(&ownerID)
+		var created bool
+		section, created, err = ResolveItemSection(ownerID, r.ListID, r.Name)
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			run.CreatedSection = section
+		}
 	}
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return fmt.Errorf("history item not found")
+
+	item, _, err := CreateItem(0, section.ID, r.Name, r.Description, r.Quantity, r.Unit)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	r.ItemID = item.ID
+	run.Item = item
+	return run, nil
 }
 
-// DeleteItemHistoryBatch deletes multiple items from history
-func DeleteItemHistoryBatch(ids []int64) (int64, error) {
-	if len(ids) == 0 {
-		return 0, nil
+// uncompleteItem puts a bought item back on the list. Unlike unchecking it, the logged
+// purchase is kept; a paid price goes to the price history as on RestartList.
+func uncompleteItem(id int64) (*Item, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
 	}
+	defer tx.Rollback()
 
-	// Build placeholders
-	placeholders := make([]string, len(ids))
-	args := make([]interface{}, len(ids))
-	for i, id := range ids {
-		placeholders[i] = "?"
-		args[i] = id
+	_, err = tx.Exec(`
+		INSERT INTO price_history (name, quantity, unit, paid_price, list_id)
+		SELECT i.name, i.quantity, i.unit, i.paid_price, s.list_id FROM items i
+		JOIN sections s ON i.section_id = s.id
+		WHERE i.id = ? AND i.paid_price > 0
+	`, id)
+	if err != nil {
+		return nil, err
 	}
 
-	query := fmt.Sprintf("DELETE FROM item_history WHERE id IN (%s)", strings.Join(placeholders, ","))
-	result, err := DB.Exec(query, args...)
+	_, err = tx.Exec(`
+		UPDATE items SET completed = FALSE, completed_by = NULL, completed_at = NULL, paid_price = 0, updated_at = strftime('%s', 'now')
+		WHERE id = ?
+	`, id)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return result.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return GetItemByID(id)
 }
 
 // ==================== TEMPLATES ====================
 
-// GetAllTemplates returns all templates with their items
-func GetAllTemplates() ([]Template, error) {
+// GetTemplates returns a user's templates with their items
+func GetTemplates(ownerID int64) ([]Template, error) {
 	rows, err := DB.Query(`
-		SELECT id, name, description, sort_order, created_at, COALESCE(updated_at, 0)
+		SELECT id, COALESCE(owner_id, 0), name, description, sort_order, created_at, COALESCE(updated_at, 0)
 		FROM templates
+		WHERE owner_id = ?
 		ORDER BY sort_order ASC
-	`)
+	`, ownerID)
 	if err != nil {
 		return nil, err
 	}
@@ -1244,7 +4285,7 @@ func GetAllTemplates() ([]Template, error) {
 	var templates []Template
 	for rows.Next() {
 		var t Template
-		err := rows.Scan(&t.ID, &t.Name, &t.Description, &t.SortOrder, &t.CreatedAt, &t.UpdatedAt)
+		err := rows.Scan(&t.ID, &t.OwnerID, &t.Name, &t.Description, &t.SortOrder, &t.CreatedAt, &t.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -1261,9 +4302,9 @@ func GetAllTemplates() ([]Template, error) {
 func GetTemplateByID(id int64) (*Template, error) {
 	var t Template
 	err := DB.QueryRow(`
-		SELECT id, name, description, sort_order, created_at, COALESCE(updated_at, 0)
+		SELECT id, COALESCE(owner_id, 0), name, description, sort_order, created_at, COALESCE(updated_at, 0)
 		FROM templates WHERE id = ?
-	`, id).Scan(&t.ID, &t.Name, &t.Description, &t.SortOrder, &t.CreatedAt, &t.UpdatedAt)
+	`, id).Scan(&t.ID, &t.OwnerID, &t.Name, &t.Description, &t.SortOrder, &t.CreatedAt, &t.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -1277,7 +4318,7 @@ func GetTemplateByID(id int64) (*Template, error) {
 // GetTemplateItems returns all items for a template
 func GetTemplateItems(templateID int64) ([]TemplateItem, error) {
 	rows, err := DB.Query(`
-		SELECT id, template_id, section_name, name, description, sort_order, created_at
+		SELECT id, template_id, section_name, name, description, quantity, unit, sort_order, created_at
 		FROM template_items
 		WHERE template_id = ?
 		ORDER BY section_name ASC, sort_order ASC
@@ -1290,7 +4331,7 @@ func GetTemplateItems(templateID int64) ([]TemplateItem, error) {
 	var items []TemplateItem
 	for rows.Next() {
 		var ti TemplateItem
-		err := rows.Scan(&ti.ID, &ti.TemplateID, &ti.SectionName, &ti.Name, &ti.Description, &ti.SortOrder, &ti.CreatedAt)
+		err := rows.Scan(&ti.ID, &ti.TemplateID, &ti.SectionName, &ti.Name, &ti.Description, &ti.Quantity, &ti.Unit, &ti.SortOrder, &ti.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -1299,14 +4340,14 @@ func GetTemplateItems(templateID int64) ([]TemplateItem, error) {
 	return items, nil
 }
 
-// CreateTemplate creates a new template
-func CreateTemplate(name, description string) (*Template, error) {
+// CreateTemplate creates a new template owned by a user
+func CreateTemplate(ownerID int64, name, description string) (*Template, error) {
 	var maxOrder int
-	DB.QueryRow("SELECT COALESCE(MAX(sort_order), -1) FROM templates").Scan(&maxOrder)
+	DB.QueryRow("SELECT COALESCE(MAX(sort_order), -1) FROM templates WHERE owner_id = ?", ownerID).Scan(&maxOrder)
 
 	result, err := DB.Exec(`
-		INSERT INTO templates (name, description, sort_order) VALUES (?, ?, ?)
-	`, name, description, maxOrder+1)
+		INSERT INTO templates (owner_id, name, description, sort_order) VALUES (?, ?, ?, ?)
+	`, ownerID, name, description, maxOrder+1)
 	if err != nil {
 		return nil, err
 	}
@@ -1333,14 +4374,18 @@ func DeleteTemplate(id int64) error {
 }
 
 // AddTemplateItem adds an item to a template
-func AddTemplateItem(templateID int64, sectionName, name, description string) (*TemplateItem, error) {
+func AddTemplateItem(templateID int64, sectionName, name, description string, quantity float64, unit string) (*TemplateItem, error) {
 	var maxOrder int
 	DB.QueryRow("SELECT COALESCE(MAX(sort_order), -1) FROM template_items WHERE template_id = ?", templateID).Scan(&maxOrder)
 
+	if quantity <= 0 {
+		quantity = 1
+	}
+
 	result, err := DB.Exec(`
-		INSERT INTO template_items (template_id, section_name, name, description, sort_order)
-		VALUES (?, ?, ?, ?, ?)
-	`, templateID, sectionName, name, description, maxOrder+1)
+		INSERT INTO template_items (template_id, section_name, name, description, quantity, unit, sort_order)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, templateID, sectionName, name, description, quantity, unit, maxOrder+1)
 	if err != nil {
 		return nil, err
 	}
@@ -1353,9 +4398,9 @@ func AddTemplateItem(templateID int64, sectionName, name, description string) (*
 func GetTemplateItemByID(id int64) (*TemplateItem, error) {
 	var ti TemplateItem
 	err := DB.QueryRow(`
-		SELECT id, template_id, section_name, name, description, sort_order, created_at
+		SELECT id, template_id, section_name, name, description, quantity, unit, sort_order, created_at
 		FROM template_items WHERE id = ?
-	`, id).Scan(&ti.ID, &ti.TemplateID, &ti.SectionName, &ti.Name, &ti.Description, &ti.SortOrder, &ti.CreatedAt)
+	`, id).Scan(&ti.ID, &ti.TemplateID, &ti.SectionName, &ti.Name, &ti.Description, &ti.Quantity, &ti.Unit, &ti.SortOrder, &ti.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -1363,10 +4408,14 @@ func GetTemplateItemByID(id int64) (*TemplateItem, error) {
 }
 
 // UpdateTemplateItem updates a template item
-func UpdateTemplateItem(id int64, sectionName, name, description string) (*TemplateItem, error) {
+func UpdateTemplateItem(id int64, sectionName, name, description string, quantity float64, unit string) (*TemplateItem, error) {
+	if quantity <= 0 {
+		quantity = 1
+	}
+
 	_, err := DB.Exec(`
-		UPDATE template_items SET section_name = ?, name = ?, description = ? WHERE id = ?
-	`, sectionName, name, description, id)
+		UPDATE template_items SET section_name = ?, name = ?, description = ?, quantity = ?, unit = ? WHERE id = ?
+	`, sectionName, name, description, quantity, unit, id)
 	if err != nil {
 		return nil, err
 	}
@@ -1379,8 +4428,8 @@ func DeleteTemplateItem(id int64) error {
 	return err
 }
 
-// ApplyTemplateToList applies a template to a list (adds items from template)
-func ApplyTemplateToList(templateID, listID int64) error {
+// ApplyTemplateToList applies a template to a list (adds items from template on behalf of a user)
+func ApplyTemplateToList(userID, templateID, listID int64) error {
 	template, err := GetTemplateByID(templateID)
 	if err != nil {
 		return err
@@ -1422,34 +4471,34 @@ func ApplyTemplateToList(templateID, listID int64) error {
 
 		// Add items to section
 		for _, item := range items {
-			var maxItemOrder int
-			tx.QueryRow("SELECT COALESCE(MAX(sort_order), -1) FROM items WHERE section_id = ?", sectionID).Scan(&maxItemOrder)
-
-			_, err := tx.Exec(`
-				INSERT INTO items (section_id, name, description, sort_order)
-				VALUES (?, ?, ?, ?)
-			`, sectionID, item.Name, item.Description, maxItemOrder+1)
+			_, _, err := CreateItemTx(tx, userID, sectionID, item.Name, item.Description, item.Quantity, item.Unit, GetMaxItemOrderTx(tx, sectionID)+1)
 			if err != nil {
 				return err
 			}
 
 			// Save to item history
+			historyName := canonicalHistoryName(tx, userID, item.Name)
 			tx.Exec(`
-				INSERT INTO item_history (name, last_section_id, usage_count, last_used_at)
-				VALUES (?, ?, 1, strftime('%s', 'now'))
-				ON CONFLICT(name COLLATE NOCASE) DO UPDATE SET
+				INSERT INTO item_history (user_id, name, last_section_id, usage_count, last_used_at)
+				VALUES (?, ?, ?, 1, strftime('%s', 'now'))
+				ON CONFLICT(user_id, name) DO UPDATE SET
 					last_section_id = excluded.last_section_id,
 					usage_count = usage_count + 1,
 					last_used_at = strftime('%s', 'now')
-			`, item.Name, sectionID)
+			`, userID, historyName, sectionID)
+			tx.Exec(rememberSectionSQL, historyName, sectionID)
 		}
 	}
 
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	InvalidateSuggestionIndex()
+	return nil
 }
 
-// CreateTemplateFromList creates a template from an existing list
-func CreateTemplateFromList(listID int64, templateName, templateDescription string) (*Template, error) {
+// CreateTemplateFromList creates a template owned by a user from an existing list
+func CreateTemplateFromList(ownerID, listID int64, templateName, templateDescription string) (*Template, error) {
 	sections, err := GetSectionsByList(listID)
 	if err != nil {
 		return nil, err
@@ -1463,11 +4512,11 @@ func CreateTemplateFromList(listID int64, templateName, templateDescription stri
 
 	// Create template
 	var maxOrder int
-	tx.QueryRow("SELECT COALESCE(MAX(sort_order), -1) FROM templates").Scan(&maxOrder)
+	tx.QueryRow("SELECT COALESCE(MAX(sort_order), -1) FROM templates WHERE owner_id = ?", ownerID).Scan(&maxOrder)
 
 	result, err := tx.Exec(`
-		INSERT INTO templates (name, description, sort_order) VALUES (?, ?, ?)
-	`, templateName, templateDescription, maxOrder+1)
+		INSERT INTO templates (owner_id, name, description, sort_order) VALUES (?, ?, ?, ?)
+	`, ownerID, templateName, templateDescription, maxOrder+1)
 	if err != nil {
 		return nil, err
 	}
@@ -1479,9 +4528,9 @@ func CreateTemplateFromList(listID int64, templateName, templateDescription stri
 		for _, item := range section.Items {
 			if !item.Completed { // Only add non-completed items
 				_, err := tx.Exec(`
-					INSERT INTO template_items (template_id, section_name, name, description, sort_order)
-					VALUES (?, ?, ?, ?, ?)
-				`, templateID, section.Name, item.Name, item.Description, itemOrder)
+					INSERT INTO template_items (template_id, section_name, name, description, quantity, unit, sort_order)
+					VALUES (?, ?, ?, ?, ?, ?, ?)
+				`, templateID, section.Name, item.Name, item.Description, item.Quantity, item.Unit, itemOrder)
 				if err != nil {
 					return nil, err
 				}
@@ -1500,8 +4549,8 @@ func CreateTemplateFromList(listID int64, templateName, templateDescription stri
 
 // ==================== TRANSACTION HELPERS (for batch API) ====================
 
-// CreateListTx creates a list within a transaction
-func CreateListTx(tx *sql.Tx, name, icon string) (*List, error) {
+// CreateListTx creates a list owned by a user within a transaction
+func CreateListTx(tx *sql.Tx, ownerID int64, name, icon string) (*List, error) {
 	var maxOrder int
 	tx.QueryRow("SELECT COALESCE(MAX(sort_order), -1) FROM lists").Scan(&maxOrder)
 
@@ -1510,19 +4559,19 @@ func CreateListTx(tx *sql.Tx, name, icon string) (*List, error) {
 	}
 
 	result, err := tx.Exec(`
-		INSERT INTO lists (name, icon, sort_order, is_active) VALUES (?, ?, ?, FALSE)
-	`, name, icon, maxOrder+1)
+		INSERT INTO lists (name, icon, sort_order, is_active, owner_id) VALUES (?, ?, ?, FALSE, ?)
+	`, name, icon, maxOrder+1, ownerID)
 	if err != nil {
 		return nil, err
 	}
 
 	id, _ := result.LastInsertId()
 
-	var l List
+	l := List{OwnerID: ownerID, Role: RoleOwner}
 	err = tx.QueryRow(`
-		SELECT id, name, COALESCE(icon, '🛒'), sort_order, is_active, created_at, COALESCE(updated_at, 0)
+		SELECT id, name, COALESCE(icon, '🛒'), sort_order, budget, created_at, COALESCE(updated_at, 0)
 		FROM lists WHERE id = ?
-	`, id).Scan(&l.ID, &l.Name, &l.Icon, &l.SortOrder, &l.IsActive, &l.CreatedAt, &l.UpdatedAt)
+	`, id).Scan(&l.ID, &l.Name, &l.Icon, &l.SortOrder, &l.Budget, &l.CreatedAt, &l.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -1552,37 +4601,68 @@ func CreateSectionForListTx(tx *sql.Tx, listID int64, name string, sortOrder int
 	return &s, nil
 }
 
-// CreateItemTx creates an item within a transaction
-func CreateItemTx(tx *sql.Tx, sectionID int64, name, description string, sortOrder int) (*Item, error) {
-	result, err := tx.Exec(`
-		INSERT INTO items (section_id, name, description, sort_order) VALUES (?, ?, ?, ?)
-	`, sectionID, name, description, sortOrder)
-	if err != nil {
-		return nil, err
+// CreateItemTx creates an item within a transaction, summing the quantity into
+// an existing unbought item with the same name and unit in the section if there is one.
+// Quantities are capped at MaxItemQuantity.
+func CreateItemTx(tx *sql.Tx, addedBy, sectionID int64, name, description string, quantity float64, unit string, sortOrder int) (*Item, bool, error) {
+	if quantity <= 0 {
+		quantity = 1
 	}
+	quantity = min(quantity, MaxItemQuantity)
 
-	id, _ := result.LastInsertId()
+	var id int64
+	merged := true
+	err := tx.QueryRow(`
+		SELECT id FROM items
+		WHERE section_id = ? AND completed = FALSE AND name = ? COLLATE NOCASE AND unit = ? COLLATE NOCASE
+		ORDER BY sort_order ASC
+		LIMIT 1
+	`, sectionID, name, unit).Scan(&id)
+
+	switch {
+	case err == nil:
+		_, err = tx.Exec(`
+			UPDATE items SET
+				quantity = MIN(ROUND(quantity + ?, 3), ?),
+				description = CASE WHEN description = '' THEN ? ELSE description END,
+				updated_at = strftime('%s', 'now')
+			WHERE id = ?
+		`, quantity, MaxItemQuantity, description, id)
+		if err != nil {
+			return nil, false, err
+		}
+	case err == sql.ErrNoRows:
+		merged = false
+		result, err := tx.Exec(`
+			INSERT INTO items (section_id, name, description, quantity, unit, sort_order, added_by) VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, sectionID, name, description, quantity, unit, sortOrder, nullableID(addedBy))
+		if err != nil {
+			return nil, false, err
+		}
+		id, _ = result.LastInsertId()
+	default:
+		return nil, false, err
+	}
 
-	var i Item
-	err = tx.QueryRow(`
-		SELECT id, section_id, name, description, completed, uncertain, sort_order, created_at, COALESCE(updated_at, 0)
-		FROM items WHERE id = ?
-	`, id).Scan(&i.ID, &i.SectionID, &i.Name, &i.Description, &i.Completed, &i.Uncertain, &i.SortOrder, &i.CreatedAt, &i.UpdatedAt)
+	i, err := scanItem(tx.QueryRow("SELECT "+itemColumns+" FROM items WHERE id = ?", id))
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	return &i, nil
+	return i, merged, nil
 }
 
-// SaveItemHistoryTx saves item name to history within a transaction
-func SaveItemHistoryTx(tx *sql.Tx, name string, sectionID int64) {
+// SaveItemHistoryTx saves item name to a user's history within a transaction.
+// Call InvalidateSuggestionIndex after the transaction commits.
+func SaveItemHistoryTx(tx *sql.Tx, userID int64, name string, sectionID int64) {
+	name = canonicalHistoryName(tx, userID, name)
 	tx.Exec(`
-		INSERT INTO item_history (name, last_section_id, usage_count)
-		VALUES (?, ?, 1)
-		ON CONFLICT(name) DO UPDATE SET
+		INSERT INTO item_history (user_id, name, last_section_id, usage_count)
+		VALUES (?, ?, ?, 1)
+		ON CONFLICT(user_id, name) DO UPDATE SET
 			usage_count = usage_count + 1,
 			last_section_id = excluded.last_section_id
-	`, name, sectionID)
+	`, userID, name, sectionID)
+	tx.Exec(rememberSectionSQL, name, sectionID)
 }
 
 // GetMaxSectionOrderTx gets max sort_order for sections in a list within a transaction
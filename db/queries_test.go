@@ -0,0 +1,213 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestDB points DB at a new database with every migration applied
+func openTestDB(t testing.TB) {
+	t.Helper()
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "test.db"))
+	Init()
+	t.Cleanup(func() {
+		Close()
+		DB = nil
+		InvalidateSuggestionIndex()
+	})
+}
+
+func TestScoreSuggestion(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		// Exact matches, the typed spelling earning the bonus
+		{"Молоко", "молоко", 1010},
+		{"Молоко", "moloko", 1000},
+
+		// Prefix matches
+		{"żółty ser", "zolty", 500},
+		{"żółty ser", "żółty", 510},
+		{"zolty ser", "żółty", 500},
+		{"молоко", "мол", 510},
+
+		// Word prefixes and substrings
+		{"żółty ser", "zo se", 300},
+		{"żółty ser", "ser", 310},
+		{"żółty ser", "lty", 200},
+
+		// Typos
+		{"milk", "mlik", 60},
+		{"semi skimmed milk", "mlik", 50},
+
+		// No match
+		{"milk", "bread", 0},
+		{"milk", " ", 0},
+	}
+	for _, tt := range tests {
+		if got := scoreSuggestion(tt.name, tt.query); got != tt.want {
+			t.Errorf("scoreSuggestion(%q, %q) = %d, want %d", tt.name, tt.query, got, tt.want)
+		}
+	}
+}
+
+// TestScoreSuggestionRanking checks that typing the exact spelling ranks that name
+// above names only matching once folded
+func TestScoreSuggestionRanking(t *testing.T) {
+	tests := []struct {
+		query  string
+		better string
+		worse  string
+	}{
+		{"żółty", "żółty ser", "zolty ser"},
+		{"zolty", "zolty ser", "żółty ser"},
+		{"хліб", "хліб", "khlib"},
+		{"mleko", "mleko", "mleko owsiane"},
+		{"ser", "ser żółty", "żółty ser"},
+	}
+	for _, tt := range tests {
+		better, worse := scoreSuggestion(tt.better, tt.query), scoreSuggestion(tt.worse, tt.query)
+		if better <= worse {
+			t.Errorf("query %q: %q scored %d, not above %q with %d", tt.query, tt.better, better, tt.worse, worse)
+		}
+	}
+}
+
+func TestUseTOTPStep(t *testing.T) {
+	openTestDB(t)
+	user, err := CreateUser("anna", "hash", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		step int64
+		want bool
+	}{
+		{41152263, true},
+		{41152263, false}, // the same code again
+		{41152262, false}, // an older code still in the window
+		{41152264, true},
+		{41152265, true},
+	}
+	for _, tt := range tests {
+		got, err := UseTOTPStep(user.ID, tt.step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("UseTOTPStep(%d) = %v, want %v", tt.step, got, tt.want)
+		}
+	}
+}
+
+// TestGetDueSuggestionsPerUser checks purchases on other users' lists never make items due
+func TestGetDueSuggestionsPerUser(t *testing.T) {
+	openTestDB(t)
+	anna, err := CreateUser("anna", "hash", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := CreateUser("bob", "hash", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	annaList, err := CreateList(anna.ID, "Anna", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobList, err := CreateList(bob.ID, "Bob", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, list := range []*List{annaList, bobList} {
+		if _, err := CreateSectionForList(list.ID, "Dairy"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Bob buys milk every week, the last time eight days ago
+	now := time.Now().Unix()
+	for weeks := 4; weeks >= 0; weeks-- {
+		at := now - 8*86400 - int64(weeks)*7*86400
+		if _, err := DB.Exec(`
+			INSERT INTO purchases (name, list_id, section_name, purchased_at) VALUES (?, ?, ?, ?)
+		`, "Milk", bobList.ID, "Dairy", at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	due, err := GetDueSuggestions(bob.ID, bobList.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || due[0].Name != "Milk" {
+		t.Fatalf("bob's due suggestions = %+v, want Milk", due)
+	}
+
+	due, err = GetDueSuggestions(anna.ID, annaList.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 0 {
+		t.Errorf("anna's due suggestions = %+v, want none", due)
+	}
+
+	// Once bob shares his list, his purchases count for anna too
+	if err := ShareList(bobList.ID, anna.ID, RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	due, err = GetDueSuggestions(anna.ID, annaList.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 {
+		t.Errorf("anna's due suggestions with bob's list shared = %+v, want Milk", due)
+	}
+}
+
+func TestItemQuantityCap(t *testing.T) {
+	openTestDB(t)
+	user, err := CreateUser("anna", "hash", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := CreateList(user.ID, "Anna", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	section, err := CreateSectionForList(list.ID, "Dairy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, _, err := CreateItem(user.ID, section.ID, "Milk", "", MaxItemQuantity-1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Adding the same item again sums the quantities, up to the cap
+	merged, ok, err := CreateItem(user.ID, section.ID, "milk", "", 5, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || merged.ID != item.ID || merged.Quantity != MaxItemQuantity {
+		t.Fatalf("CreateItem() = %d with quantity %v, merged %v, want %d with %d, merged", merged.ID, merged.Quantity, ok, item.ID, MaxItemQuantity)
+	}
+
+	if _, err := ChangeItemQuantity(item.ID, 1); err != ErrQuantityTooHigh {
+		t.Errorf("ChangeItemQuantity(+1) error = %v, want %v", err, ErrQuantityTooHigh)
+	}
+	if _, err := ChangeItemQuantity(item.ID, -MaxItemQuantity); err != ErrQuantityTooLow {
+		t.Errorf("ChangeItemQuantity(-max) error = %v, want %v", err, ErrQuantityTooLow)
+	}
+	changed, err := ChangeItemQuantity(item.ID, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.Quantity != MaxItemQuantity-1 {
+		t.Errorf("quantity after -1 = %v, want %d", changed.Quantity, MaxItemQuantity-1)
+	}
+}
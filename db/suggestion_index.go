@@ -0,0 +1,456 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// suggestionIndex is an in-memory trigram index over item_history, so suggestions
+// search the whole history without hitting the database on every keystroke.
+// It is built lazily on first use and kept in sync by the item_history writers.
+type suggestionIndex struct {
+	mu      sync.RWMutex
+	loaded  bool
+	entries []*historyEntry      // slot -> entry, nil once removed
+	slots   map[int64]int32      // item_history id -> slot
+	users   map[int64]*userIndex // owner -> their part of the index
+	removed int
+}
+
+// userIndex holds one user's slots, so a search only walks postings it can match
+type userIndex struct {
+	slots    []int32            // may still list removed slots
+	postings map[string][]int32 // trigram -> slots, may still list removed slots
+}
+
+// searchScratch is reused across searches to count shared trigrams without
+// allocating or clearing a counter per history row
+type searchScratch struct {
+	counts  []uint16 // slot -> shared trigrams, all zero between searches
+	touched []int32
+}
+
+var scratchPool = sync.Pool{New: func() interface{} { return &searchScratch{} }}
+
+type historyEntry struct {
+	id            int64
+	userID        int64 // owner of the history row, only their searches see it
+	name          string
+	folded        string
+	aliases       []string // other spellings, matched as if they were the name
+	foldedAliases []string
+	sectionID     int64
+	usageCount    int
+	lastUsedAt    int64
+}
+
+// fold fills in the folded name and aliases
+func (e *historyEntry) fold() {
+	e.folded = foldName(strings.TrimSpace(e.name))
+	e.foldedAliases = make([]string, len(e.aliases))
+	for i, alias := range e.aliases {
+		e.foldedAliases[i] = foldName(strings.TrimSpace(alias))
+	}
+}
+
+// sameKeys reports whether two entries are indexed under the same user and trigrams
+func (e *historyEntry) sameKeys(other *historyEntry) bool {
+	if e.userID != other.userID || e.folded != other.folded || len(e.foldedAliases) != len(other.foldedAliases) {
+		return false
+	}
+	for i := range e.foldedAliases {
+		if e.foldedAliases[i] != other.foldedAliases[i] {
+			return false
+		}
+	}
+	return true
+}
+
+const (
+	// Only the candidates sharing the most trigrams with the query are scored
+	maxScoredCandidates = 300
+	// Removed slots are compacted away once there are this many and they make up a quarter of the index
+	compactThreshold = 1024
+	// Word starts are padded so one and two letter queries still hit the index ("$$m", "$mo")
+	trigramPad = "$$"
+)
+
+var historyIndex = &suggestionIndex{}
+
+// InvalidateSuggestionIndex drops the suggestion index so it is rebuilt on next use.
+// Call it after committing a transaction that wrote to item_history.
+func InvalidateSuggestionIndex() {
+	historyIndex.mu.Lock()
+	historyIndex.reset()
+	historyIndex.mu.Unlock()
+}
+
+// nameTrigrams returns the distinct padded trigrams of every word in a folded name
+func nameTrigrams(folded string) []string {
+	var grams []string
+	seen := make(map[string]struct{})
+	for _, word := range strings.Fields(folded) {
+		runes := []rune(trigramPad + word)
+		for i := 0; i+3 <= len(runes); i++ {
+			gram := string(runes[i : i+3])
+			if _, ok := seen[gram]; !ok {
+				seen[gram] = struct{}{}
+				grams = append(grams, gram)
+			}
+		}
+	}
+	return grams
+}
+
+// reset empties the index and marks it unloaded. Caller must hold the write lock.
+func (idx *suggestionIndex) reset() {
+	idx.loaded = false
+	idx.entries = nil
+	idx.slots = nil
+	idx.users = nil
+	idx.removed = 0
+}
+
+// ensureLoaded builds the index from item_history if needed. Caller must not hold the lock.
+func (idx *suggestionIndex) ensureLoaded() error {
+	idx.mu.RLock()
+	loaded := idx.loaded
+	idx.mu.RUnlock()
+	if loaded {
+		return nil
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.loaded {
+		return nil
+	}
+
+	rows, err := DB.Query(`
+		SELECT id, COALESCE(user_id, 0), name, COALESCE(last_section_id, 0), COALESCE(usage_count, 1), COALESCE(last_used_at, 0)
+		FROM item_history
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var entries []*historyEntry
+	byID := make(map[int64]*historyEntry)
+	for rows.Next() {
+		e := &historyEntry{}
+		if err := rows.Scan(&e.id, &e.userID, &e.name, &e.sectionID, &e.usageCount, &e.lastUsedAt); err != nil {
+			return err
+		}
+		entries = append(entries, e)
+		byID[e.id] = e
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	aliasRows, err := DB.Query("SELECT history_id, alias FROM item_aliases ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer aliasRows.Close()
+	for aliasRows.Next() {
+		var historyID int64
+		var alias string
+		if err := aliasRows.Scan(&historyID, &alias); err != nil {
+			return err
+		}
+		if e, ok := byID[historyID]; ok {
+			e.aliases = append(e.aliases, alias)
+		}
+	}
+	if err := aliasRows.Err(); err != nil {
+		return err
+	}
+
+	idx.reset()
+	idx.slots = make(map[int64]int32, len(entries))
+	idx.users = make(map[int64]*userIndex)
+	for _, e := range entries {
+		e.fold()
+		idx.add(e)
+	}
+
+	idx.loaded = true
+	return nil
+}
+
+// syncHistoryEntry reloads one item_history row and its aliases into the index,
+// or drops it from the index if the row is gone
+func syncHistoryEntry(id int64) {
+	e := &historyEntry{}
+	err := DB.QueryRow(`
+		SELECT id, COALESCE(user_id, 0), name, COALESCE(last_section_id, 0), COALESCE(usage_count, 1), COALESCE(last_used_at, 0)
+		FROM item_history WHERE id = ?
+	`, id).Scan(&e.id, &e.userID, &e.name, &e.sectionID, &e.usageCount, &e.lastUsedAt)
+	if err == sql.ErrNoRows {
+		historyIndex.delete(id)
+		return
+	}
+	if err != nil {
+		InvalidateSuggestionIndex()
+		return
+	}
+
+	rows, err := DB.Query("SELECT alias FROM item_aliases WHERE history_id = ? ORDER BY id", id)
+	if err != nil {
+		InvalidateSuggestionIndex()
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var alias string
+		if err := rows.Scan(&alias); err != nil {
+			InvalidateSuggestionIndex()
+			return
+		}
+		e.aliases = append(e.aliases, alias)
+	}
+
+	historyIndex.upsert(e)
+}
+
+// add appends an entry with a folded name. Caller must hold the write lock.
+func (idx *suggestionIndex) add(e *historyEntry) {
+	slot := int32(len(idx.entries))
+	idx.entries = append(idx.entries, e)
+	idx.slots[e.id] = slot
+
+	user := idx.users[e.userID]
+	if user == nil {
+		user = &userIndex{postings: make(map[string][]int32)}
+		idx.users[e.userID] = user
+	}
+	user.slots = append(user.slots, slot)
+	keys := strings.Join(append([]string{e.folded}, e.foldedAliases...), " ")
+	for _, gram := range nameTrigrams(keys) {
+		user.postings[gram] = append(user.postings[gram], slot)
+	}
+}
+
+// remove drops an entry by history id. Caller must hold the write lock.
+func (idx *suggestionIndex) remove(id int64) {
+	slot, ok := idx.slots[id]
+	if !ok {
+		return
+	}
+	delete(idx.slots, id)
+	idx.entries[slot] = nil
+	idx.removed++
+
+	if idx.removed >= compactThreshold && idx.removed*4 >= len(idx.entries) {
+		idx.compact()
+	}
+}
+
+// compact rebuilds the slots and postings from the live entries. Caller must hold the write lock.
+func (idx *suggestionIndex) compact() {
+	live := idx.entries
+	idx.entries = make([]*historyEntry, 0, len(live)-idx.removed)
+	idx.slots = make(map[int64]int32, len(live)-idx.removed)
+	idx.users = make(map[int64]*userIndex)
+	idx.removed = 0
+	for _, e := range live {
+		if e != nil {
+			idx.add(e)
+		}
+	}
+}
+
+// upsert adds or replaces the entry for a history row. Does nothing until the index is loaded.
+func (idx *suggestionIndex) upsert(e *historyEntry) {
+	e.fold()
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if !idx.loaded {
+		return
+	}
+
+	// Same name and aliases: only the counters and section changed, no need to touch the postings
+	if slot, ok := idx.slots[e.id]; ok {
+		if idx.entries[slot].sameKeys(e) {
+			idx.entries[slot] = e
+			return
+		}
+		idx.remove(e.id)
+	}
+	idx.add(e)
+}
+
+// delete removes history rows from the index. Does nothing until the index is loaded.
+func (idx *suggestionIndex) delete(ids ...int64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if !idx.loaded {
+		return
+	}
+	for _, id := range ids {
+		idx.remove(id)
+	}
+}
+
+// scoredEntry is a search candidate with its match score
+type scoredEntry struct {
+	entry *historyEntry
+	score int
+}
+
+// search returns copies of a user's best matching entries for a query, highest score first
+func (idx *suggestionIndex) search(userID int64, query string, limit int) ([]historyEntry, error) {
+	if err := idx.ensureLoaded(); err != nil {
+		return nil, err
+	}
+
+	query = strings.TrimSpace(query)
+	folded := foldName(query)
+	if folded == "" {
+		return nil, nil
+	}
+	grams := nameTrigrams(folded)
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	user := idx.users[userID]
+	if user == nil {
+		return nil, nil
+	}
+
+	scratch := scratchPool.Get().(*searchScratch)
+	defer scratchPool.Put(scratch)
+	if len(scratch.counts) < len(idx.entries) {
+		scratch.counts = make([]uint16, len(idx.entries))
+	}
+	counts, touched := scratch.counts, scratch.touched[:0]
+	defer func() {
+		for _, slot := range touched {
+			counts[slot] = 0
+		}
+		scratch.touched = touched[:0]
+	}()
+
+	// Count shared trigrams per slot
+	for _, gram := range grams {
+		for _, slot := range user.postings[gram] {
+			if counts[slot] == 0 {
+				if idx.entries[slot] == nil {
+					continue
+				}
+				touched = append(touched, slot)
+			}
+			counts[slot]++
+		}
+	}
+
+	// Very short queries without a word-start match can still match inside words ("lk" in "milk")
+	if len(touched) == 0 && utf8.RuneCountInString(folded) < 3 {
+		for _, slot := range user.slots {
+			if e := idx.entries[slot]; e != nil && strings.Contains(e.folded, folded) {
+				touched = append(touched, slot)
+				counts[slot] = 1
+			}
+		}
+	}
+
+	// Keep the candidates with the most shared trigrams: find the lowest count that
+	// still fits, then take everything above it and fill up from it
+	perCount := make([]int, len(grams)+1)
+	for _, slot := range touched {
+		perCount[counts[slot]]++
+	}
+	cutoff, above := 1, 0
+	for n := len(perCount) - 1; n > 0; n-- {
+		if above+perCount[n] >= maxScoredCandidates {
+			cutoff = n
+			break
+		}
+		above += perCount[n]
+	}
+	candidates := make([]int32, 0, min(len(touched), maxScoredCandidates))
+	atCutoff := maxScoredCandidates - above
+	for _, slot := range touched {
+		switch n := int(counts[slot]); {
+		case n > cutoff:
+			candidates = append(candidates, slot)
+		case n == cutoff && atCutoff > 0:
+			candidates = append(candidates, slot)
+			atCutoff--
+		}
+	}
+
+	top := make([]scoredEntry, 0, limit)
+	lowerQuery := strings.ToLower(query)
+	for _, slot := range candidates {
+		e := idx.entries[slot]
+		score := scoreFolded(e.name, e.folded, lowerQuery, folded)
+		for i, alias := range e.aliases {
+			score = max(score, scoreFolded(alias, e.foldedAliases[i], lowerQuery, folded))
+		}
+		if score > 0 {
+			// Boost score slightly by usage count
+			top = keepTop(top, scoredEntry{e, score + e.usageCount/10}, limit)
+		}
+	}
+
+	results := make([]historyEntry, len(top))
+	for i, s := range top {
+		results[i] = *s.entry
+	}
+	return results, nil
+}
+
+// userEntries returns copies of all of a user's entries
+func (idx *suggestionIndex) userEntries(userID int64) ([]historyEntry, error) {
+	if err := idx.ensureLoaded(); err != nil {
+		return nil, err
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	user := idx.users[userID]
+	if user == nil {
+		return nil, nil
+	}
+	entries := make([]historyEntry, 0, len(user.slots))
+	for _, slot := range user.slots {
+		if e := idx.entries[slot]; e != nil {
+			entries = append(entries, *e)
+		}
+	}
+	return entries, nil
+}
+
+// keepTop inserts a candidate into the best limit candidates so far, kept sorted by
+// score, then usage count, then most recently used. Earlier candidates win ties.
+func keepTop(top []scoredEntry, candidate scoredEntry, limit int) []scoredEntry {
+	better := func(a, b scoredEntry) bool {
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if a.entry.usageCount != b.entry.usageCount {
+			return a.entry.usageCount > b.entry.usageCount
+		}
+		return a.entry.lastUsedAt > b.entry.lastUsedAt
+	}
+	i := len(top)
+	for i > 0 && better(candidate, top[i-1]) {
+		i--
+	}
+	if i >= limit {
+		return top
+	}
+	if len(top) < limit {
+		top = append(top, scoredEntry{})
+	}
+	copy(top[i+1:], top[i:])
+	top[i] = candidate
+	return top
+}
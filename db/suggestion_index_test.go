@@ -0,0 +1,373 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// benchHistorySize is a large item history, far beyond what one household collects
+const benchHistorySize = 50000
+
+var benchWords = []string{
+	"mleko", "żółty", "ser", "chleb", "masło", "jogurt", "milk", "cheese", "bread", "butter",
+	"Milch", "Käse", "Brot", "молоко", "хліб", "сир", "organic", "light", "smoked", "fresh",
+	"pomidory", "tomatoes", "apples", "jabłka", "coffee", "kawa", "tea", "herbata", "rice", "pasta",
+}
+
+// benchEntry returns the i-th generated history row
+func benchEntry(i int) *historyEntry {
+	w := len(benchWords)
+	e := &historyEntry{
+		id:         int64(i + 1),
+		userID:     1,
+		name:       fmt.Sprintf("%s %s %d", benchWords[i%w], benchWords[(i/w)%w], i),
+		usageCount: i % 50,
+		lastUsedAt: int64(i),
+	}
+	if i%10 == 0 {
+		e.aliases = []string{fmt.Sprintf("%s %d", benchWords[(i/7)%w], i)}
+	}
+	return e
+}
+
+// newTestIndex returns a loaded, empty index that isn't backed by the database
+func newTestIndex() *suggestionIndex {
+	return &suggestionIndex{
+		slots:  make(map[int64]int32),
+		users:  make(map[int64]*userIndex),
+		loaded: true,
+	}
+}
+
+// benchIndex builds a loaded index over n generated history names without a database
+func benchIndex(n int) *suggestionIndex {
+	idx := newTestIndex()
+	for i := 0; i < n; i++ {
+		idx.upsert(benchEntry(i))
+	}
+	return idx
+}
+
+// searchNames returns the names an index search finds
+func searchNames(t *testing.T, idx *suggestionIndex, userID int64, query string) []string {
+	t.Helper()
+	entries, err := idx.search(userID, query, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.name
+	}
+	return names
+}
+
+func suggestionNames(t *testing.T, userID int64, query string) []string {
+	t.Helper()
+	suggestions, err := GetItemSuggestions(userID, query, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(suggestions))
+	for i, s := range suggestions {
+		names[i] = s.Name
+	}
+	return names
+}
+
+func TestSuggestionIndexUpsertDelete(t *testing.T) {
+	idx := newTestIndex()
+	idx.upsert(&historyEntry{id: 1, userID: 1, name: "Żółty ser"})
+	idx.upsert(&historyEntry{id: 2, userID: 1, name: "Milk"})
+
+	if got := searchNames(t, idx, 1, "zolty"); len(got) != 1 || got[0] != "Żółty ser" {
+		t.Fatalf("search(zolty) = %v, want [Żółty ser]", got)
+	}
+
+	// Renaming moves the entry to the new name's trigrams
+	idx.upsert(&historyEntry{id: 1, userID: 1, name: "Gouda"})
+	if got := searchNames(t, idx, 1, "zolty"); len(got) != 0 {
+		t.Errorf("search(zolty) after rename = %v, want none", got)
+	}
+	if got := searchNames(t, idx, 1, "goud"); len(got) != 1 || got[0] != "Gouda" {
+		t.Errorf("search(goud) after rename = %v, want [Gouda]", got)
+	}
+
+	// Counters change in place
+	idx.upsert(&historyEntry{id: 2, userID: 1, name: "Milk", usageCount: 7})
+	entries, _ := idx.search(1, "milk", 10)
+	if len(entries) != 1 || entries[0].usageCount != 7 {
+		t.Errorf("search(milk) after update = %+v, want usage count 7", entries)
+	}
+
+	idx.delete(2)
+	if got := searchNames(t, idx, 1, "milk"); len(got) != 0 {
+		t.Errorf("search(milk) after delete = %v, want none", got)
+	}
+	if got := searchNames(t, idx, 1, "lk"); len(got) != 0 {
+		t.Errorf("search(lk) after delete = %v, want none", got)
+	}
+}
+
+func TestSuggestionIndexAliases(t *testing.T) {
+	idx := newTestIndex()
+	idx.upsert(&historyEntry{id: 1, userID: 1, name: "Mleko", aliases: []string{"Milk", "Молоко"}})
+	for _, query := range []string{"mle", "milk", "моло"} {
+		if got := searchNames(t, idx, 1, query); len(got) != 1 || got[0] != "Mleko" {
+			t.Errorf("search(%s) = %v, want [Mleko]", query, got)
+		}
+	}
+
+	// Dropping an alias drops its trigrams
+	idx.upsert(&historyEntry{id: 1, userID: 1, name: "Mleko", aliases: []string{"Молоко"}})
+	if got := searchNames(t, idx, 1, "milk"); len(got) != 0 {
+		t.Errorf("search(milk) after removing the alias = %v, want none", got)
+	}
+}
+
+func TestSuggestionIndexUsers(t *testing.T) {
+	idx := newTestIndex()
+	idx.upsert(&historyEntry{id: 1, userID: 1, name: "Milk"})
+	idx.upsert(&historyEntry{id: 2, userID: 2, name: "Milk chocolate"})
+	idx.upsert(&historyEntry{id: 3, userID: 2, name: "Silk tofu"})
+
+	tests := []struct {
+		userID int64
+		query  string
+		want   []string
+	}{
+		{1, "milk", []string{"Milk"}},
+		{2, "choc", []string{"Milk chocolate"}},
+		{1, "choc", nil},
+		{1, "lk", []string{"Milk"}}, // inside a word, found by scanning the user's entries
+		{2, "lk", []string{"Milk chocolate", "Silk tofu"}},
+		{1, "tofu", nil},
+		{3, "milk", nil},
+	}
+	for _, tt := range tests {
+		got := searchNames(t, idx, tt.userID, tt.query)
+		sort.Strings(got)
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("search(%d, %s) = %v, want %v", tt.userID, tt.query, got, tt.want)
+		}
+	}
+
+	entries, err := idx.userEntries(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("userEntries(2) has %d entries, want 2", len(entries))
+	}
+}
+
+func TestSuggestionIndexCompact(t *testing.T) {
+	n := compactThreshold * 4
+	idx := benchIndex(n)
+
+	// Deleting a quarter of the rows compacts the index
+	var deleted []int64
+	for i := 0; i < n; i += 4 {
+		deleted = append(deleted, benchEntry(i).id)
+	}
+	idx.delete(deleted...)
+	if idx.removed != 0 || len(idx.entries) != n-len(deleted) || len(idx.slots) != len(idx.entries) {
+		t.Fatalf("after compaction: %d removed, %d entries, %d slots, want 0, %d, %d",
+			idx.removed, len(idx.entries), len(idx.slots), n-len(deleted), n-len(deleted))
+	}
+	for id, slot := range idx.slots {
+		if idx.entries[slot].id != id {
+			t.Fatalf("slot %d holds history %d, want %d", slot, idx.entries[slot].id, id)
+		}
+	}
+
+	for _, i := range []int{1, 2, 3, n - 1} {
+		e := benchEntry(i)
+		entries, _ := idx.search(1, e.name, 1)
+		if len(entries) != 1 || entries[0].id != e.id {
+			t.Errorf("search(%s) after compaction = %+v, want history %d", e.name, entries, e.id)
+		}
+	}
+	for _, i := range []int{0, 4, n - 4} {
+		e := benchEntry(i)
+		entries, _ := idx.search(1, e.name, 1)
+		if len(entries) == 1 && entries[0].id == e.id {
+			t.Errorf("search(%s) after compaction found deleted history %d", e.name, e.id)
+		}
+	}
+}
+
+// TestSuggestionIndexSync checks the item_history writers keep a loaded index in sync
+func TestSuggestionIndexSync(t *testing.T) {
+	openTestDB(t)
+	anna, err := CreateUser("anna", "hash", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := CreateUser("bob", "hash", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SaveItemHistory(anna.ID, "Milk", 0); err != nil {
+		t.Fatal(err)
+	}
+	// Loads the index; the writes below update it in place
+	if got := suggestionNames(t, anna.ID, "mil"); len(got) != 1 || got[0] != "Milk" {
+		t.Fatalf("suggestions(mil) = %v, want [Milk]", got)
+	}
+
+	if err := SaveItemHistory(bob.ID, "Milk chocolate", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := suggestionNames(t, anna.ID, "choc"); len(got) != 0 {
+		t.Errorf("anna's suggestions(choc) = %v, want none", got)
+	}
+	if got := suggestionNames(t, bob.ID, "choc"); len(got) != 1 {
+		t.Errorf("bob's suggestions(choc) = %v, want [Milk chocolate]", got)
+	}
+
+	history, err := GetItemHistoryList(anna.ID)
+	if err != nil || len(history) != 1 {
+		t.Fatalf("GetItemHistoryList() = %v, %v", history, err)
+	}
+	if _, err := AddHistoryAlias(anna.ID, history[0].ID, "Mleko"); err != nil {
+		t.Fatal(err)
+	}
+	if got := suggestionNames(t, anna.ID, "mlek"); len(got) != 1 || got[0] != "Milk" {
+		t.Errorf("suggestions(mlek) after adding an alias = %v, want [Milk]", got)
+	}
+
+	if err := DeleteItemHistory(anna.ID, history[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := suggestionNames(t, anna.ID, "mil"); len(got) != 0 {
+		t.Errorf("suggestions(mil) after delete = %v, want none", got)
+	}
+}
+
+var benchQueries = []struct {
+	name  string
+	query string
+}{
+	{"prefix", "mle"},
+	{"folded", "zolty ser"},
+	{"cyrillic", "моло"},
+	{"typo", "chese"},
+	{"short", "lk"},
+	{"none", "xyzzy"},
+}
+
+func BenchmarkSuggestionIndexBuild(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchIndex(benchHistorySize)
+	}
+}
+
+func BenchmarkSuggestionIndexSearch(b *testing.B) {
+	idx := benchIndex(benchHistorySize)
+	for _, q := range benchQueries {
+		b.Run(q.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := idx.search(1, q.query, 10); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// seedBenchHistory opens a test database with benchHistorySize history rows for one user,
+// another user's rows alongside, and returns the first user
+func seedBenchHistory(b *testing.B) int64 {
+	openTestDB(b)
+	var users []int64
+	for _, name := range []string{"anna", "bob"} {
+		user, err := CreateUser(name, "hash", false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		users = append(users, user.ID)
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tx.Rollback()
+	for i := 0; i < benchHistorySize; i++ {
+		e := benchEntry(i)
+		result, err := tx.Exec(`INSERT INTO item_history (user_id, name, usage_count, last_used_at) VALUES (?, ?, ?, ?)`,
+			users[i%2], e.name, e.usageCount, e.lastUsedAt)
+		if err != nil {
+			b.Fatal(err)
+		}
+		historyID, _ := result.LastInsertId()
+		for _, alias := range e.aliases {
+			if _, err := tx.Exec(`INSERT INTO item_aliases (history_id, alias) VALUES (?, ?)`, historyID, alias); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+	InvalidateSuggestionIndex()
+	return users[0]
+}
+
+// BenchmarkGetItemSuggestions searches a seeded database through the suggestion index
+func BenchmarkGetItemSuggestions(b *testing.B) {
+	userID := seedBenchHistory(b)
+	if _, err := GetItemSuggestions(userID, "warm up", 0, 10); err != nil {
+		b.Fatal(err)
+	}
+	for _, q := range benchQueries {
+		b.Run(q.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := GetItemSuggestions(userID, q.query, 0, 10); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkGetItemSuggestionsQuery is the query path the index replaced: load the user's
+// 200 most used history rows and score them, so older rows are never suggested
+func BenchmarkGetItemSuggestionsQuery(b *testing.B) {
+	userID := seedBenchHistory(b)
+	for _, q := range benchQueries {
+		b.Run(q.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				rows, err := DB.Query(`
+					SELECT h.name, COALESCE(h.last_section_id, 0), COALESCE(s.name, ''), h.usage_count
+					FROM item_history h
+					LEFT JOIN sections s ON h.last_section_id = s.id
+					WHERE h.user_id = ?
+					ORDER BY h.usage_count DESC, h.last_used_at DESC
+					LIMIT 200
+				`, userID)
+				if err != nil {
+					b.Fatal(err)
+				}
+				var scored []ItemSuggestion
+				for rows.Next() {
+					var s ItemSuggestion
+					if err := rows.Scan(&s.Name, &s.LastSectionID, &s.LastSectionName, &s.UsageCount); err != nil {
+						b.Fatal(err)
+					}
+					if scoreSuggestion(s.Name, q.query) > 0 {
+						scored = append(scored, s)
+					}
+				}
+				rows.Close()
+			}
+		})
+	}
+}
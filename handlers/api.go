@@ -9,12 +9,13 @@ import (
 
 // GetAllData returns all sections with items and stats for offline caching
 func GetAllData(c *fiber.Ctx) error {
-	sections, err := db.GetAllSections()
+	userID := CurrentUser(c).ID
+	sections, err := db.GetAllSections(userID)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch data"})
 	}
 
-	stats := db.GetStats()
+	stats := db.GetStats(userID)
 
 	return c.JSON(fiber.Map{
 		"sections":  sections,
@@ -2,11 +2,13 @@ package handlers
 
 import (
 	"crypto/rand"
+	"database/sql"
 	"encoding/hex"
 	"log"
 	"os"
 	"shopping-list/db"
 	"shopping-list/i18n"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -14,7 +16,10 @@ import (
 
 const (
 	SessionCookieName = "session"
-	SessionDuration   = 7 * 24 * time.Hour // 7 days
+	// Sessions end after SessionDuration without use; every use pushes the expiry back.
+	// The expiry is refreshed at most once per SessionTouchInterval to keep writes down.
+	SessionDuration      = 7 * 24 * time.Hour // 7 days
+	SessionTouchInterval = time.Minute
 )
 
 func getAppPassword() string {
@@ -50,28 +55,41 @@ func generateSessionID() string {
 
 // LoginPage renders the login page
 func LoginPage(c *fiber.Ctx) error {
+	// Behind a forward-auth proxy the proxy does the login
+	if user, _ := proxyUser(c); user != nil {
+		return c.Redirect("/")
+	}
+
 	// Check if already logged in
 	sessionID := c.Cookies(SessionCookieName)
 	if sessionID != "" {
 		session, err := db.GetSession(sessionID)
-		if err == nil && session.ExpiresAt > time.Now().Unix() {
+		if err == nil && !session.Pending2FA && session.ExpiresAt > time.Now().Unix() {
 			return c.Redirect("/")
 		}
 	}
+	if url := proxyLoginURL(); url != "" {
+		return c.Redirect(url)
+	}
 	return c.Render("login", fiber.Map{
-		"Error":        c.Query("error"),
-		"Translations": i18n.GetAllLocales(),
-		"Locales":      i18n.AvailableLocales(),
-		"DefaultLang":  i18n.GetDefaultLang(),
+		"Error":            c.Query("error"),
+		"RegistrationOpen": isRegistrationOpen(),
+		"OIDCName":         oidcName(),
+		"Translations":     i18n.GetAllLocales(),
+		"Locales":          i18n.AvailableLocales(),
+		"DefaultLang":      i18n.GetDefaultLang(),
 	}, "")
 }
 
 // Login handles login form submission
 func Login(c *fiber.Ctx) error {
 	ip := c.IP()
-	password := c.FormValue("password")
 
-	if password != getAppPassword() {
+	user, err := checkPassword(strings.TrimSpace(c.FormValue("username")), c.FormValue("password"))
+	if err != nil {
+		return c.Status(500).SendString("Login failed")
+	}
+	if user == nil {
 		// Record failed attempt
 		if loginLimiter != nil {
 			if loginLimiter.RecordAttempt(ip) {
@@ -82,31 +100,23 @@ func Login(c *fiber.Ctx) error {
 		return c.Redirect("/login?error=1")
 	}
 
+	// With two-factor on, the password only leads to the second step.
+	// Attempts are reset once that is passed too.
+	if user.TOTPEnabled && !isTrustedDevice(c, user) {
+		if err := startPendingLogin(c, user); err != nil {
+			return c.Status(500).SendString("Login failed")
+		}
+		return c.Redirect("/login/2fa")
+	}
+
 	// Successful login - reset attempts
 	if loginLimiter != nil {
 		loginLimiter.ResetAttempts(ip)
 	}
 
-	// Create session
-	sessionID := generateSessionID()
-	expiresAt := time.Now().Add(SessionDuration).Unix()
-
-	err := db.CreateSession(sessionID, expiresAt)
-	if err != nil {
+	if err := startSession(c, user); err != nil {
 		return c.Status(500).SendString("Session creation failed")
 	}
-	log.Printf("[AUTH] New session created: %s... (expires: %d)", sessionID[:8], expiresAt)
-
-	// Set cookie
-	c.Cookie(&fiber.Cookie{
-		Name:     SessionCookieName,
-		Value:    sessionID,
-		Expires:  time.Now().Add(SessionDuration),
-		HTTPOnly: true,
-		Secure:   isSecureConnection(c),
-		SameSite: "Lax",
-		Path:     "/",
-	})
 
 	return c.Redirect("/")
 }
@@ -116,6 +126,7 @@ func Logout(c *fiber.Ctx) error {
 	sessionID := c.Cookies(SessionCookieName)
 	if sessionID != "" {
 		db.DeleteSession(sessionID)
+		CloseSessionConnections([]string{sessionID})
 	}
 
 	// Clear cookie
@@ -129,18 +140,39 @@ func Logout(c *fiber.Ctx) error {
 		Path:     "/",
 	})
 
+	if url := proxyLogoutURL(); url != "" {
+		return c.Redirect(url)
+	}
 	return c.Redirect("/login")
 }
 
 // AuthMiddleware checks if user is authenticated
 func AuthMiddleware(c *fiber.Ctx) error {
 	if isAuthDisabled() {
+		// Everything is done as the first admin
+		admin, err := db.GetFirstAdmin()
+		if err != nil {
+			log.Printf("[AUTH] Failed to load admin user: %v", err)
+			return c.Status(503).SendString("Database temporarily unavailable, please retry")
+		}
+		c.Locals("user", admin)
 		return c.Next()
 	}
 
 	// Skip auth for login page and static files
 	path := c.Path()
-	if path == "/login" || path == "/static" || len(path) > 7 && path[:8] == "/static/" {
+	if path == "/login" || path == "/login/2fa" || path == "/register" || path == "/static" || len(path) > 7 && path[:8] == "/static/" {
+		return c.Next()
+	}
+
+	// Forward auth: a trusted proxy has already logged the user in
+	user, err := proxyUser(c)
+	if err != nil {
+		log.Printf("[AUTH] Database error loading proxy user for %s %s: %v", c.Method(), path, err)
+		return c.Status(503).SendString("Database temporarily unavailable, please retry")
+	}
+	if user != nil {
+		c.Locals("user", user)
 		return c.Next()
 	}
 
@@ -183,8 +215,9 @@ func AuthMiddleware(c *fiber.Ctx) error {
 		return c.Redirect("/login")
 	}
 
-	if session.ExpiresAt < time.Now().Unix() {
-		log.Printf("[AUTH] Session expired for %s %s (expired: %d, now: %d)", c.Method(), path, session.ExpiresAt, time.Now().Unix())
+	// A pending session has not passed two-factor yet and does not log anyone in
+	if session.ExpiresAt < time.Now().Unix() || session.Pending2FA {
+		log.Printf("[AUTH] Session expired or pending for %s %s (expired: %d, now: %d)", c.Method(), path, session.ExpiresAt, time.Now().Unix())
 		db.DeleteSession(sessionID)
 		c.Cookie(&fiber.Cookie{
 			Name:     SessionCookieName,
@@ -202,5 +235,41 @@ func AuthMiddleware(c *fiber.Ctx) error {
 		return c.Redirect("/login")
 	}
 
+	user, err = db.GetUserByID(session.UserID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("[AUTH] Database error loading user for %s %s: %v", c.Method(), path, err)
+			return c.Status(503).SendString("Database temporarily unavailable, please retry")
+		}
+		// Session of a deleted user, or from before user accounts without an admin to take it over
+		log.Printf("[AUTH] No user for session %s... on %s %s", sessionID[:8], c.Method(), path)
+		db.DeleteSession(sessionID)
+		c.Cookie(&fiber.Cookie{
+			Name:     SessionCookieName,
+			Value:    "",
+			Expires:  time.Now().Add(-time.Hour),
+			HTTPOnly: true,
+			Secure:   isSecureConnection(c),
+			SameSite: "Lax",
+			Path:     "/",
+		})
+		if c.Get("HX-Request") == "true" {
+			c.Set("HX-Redirect", "/login")
+			return c.SendStatus(401)
+		}
+		return c.Redirect("/login")
+	}
+	c.Locals("user", user)
+	c.Locals("session_id", session.ID)
+
+	// Sliding expiry: a session in use stays logged in
+	if time.Now().Unix()-session.LastSeenAt >= int64(SessionTouchInterval.Seconds()) {
+		if err := db.TouchSession(session.ID, c.IP(), time.Now().Add(SessionDuration).Unix()); err != nil {
+			log.Printf("[AUTH] Failed to refresh session %s...: %v", sessionID[:8], err)
+		} else {
+			setSessionCookie(c, session.ID)
+		}
+	}
+
 	return c.Next()
 }
@@ -0,0 +1,438 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"shopping-list/db"
+	"sync"
+	"time"
+)
+
+// The event bus: every change to lists, sections, items and templates is numbered,
+// kept in the change log and sent to the subscribers that may see it, the WebSocket
+// clients of the app's pages and the Server-Sent Events streams of the REST API.
+
+// Changes kept in the change log for clients that reconnect
+const (
+	ChangeLogSize      = 1000
+	ChangeLogMaxAge    = 24 * time.Hour
+	changeLogTrimEvery = 10 * time.Minute
+)
+
+// Changes that came in while a subscriber's missed changes were loaded, held back
+// to be sent after them. More make the subscriber resync.
+const replayMaxHeld = 256
+
+// Slow client policies: what happens when a subscriber's send queue is full
+const (
+	SlowClientDisconnect = "disconnect" // close the connection; the browser reconnects and reloads
+	SlowClientDrop       = "drop"       // drop the message and keep the connection
+)
+
+// slowClientPolicy is set with WS_SLOW_CLIENT_POLICY
+var slowClientPolicy = getEnv("WS_SLOW_CLIENT_POLICY", SlowClientDisconnect)
+
+// eventSubscriber is a WebSocket client or an event stream
+type eventSubscriber interface {
+	// receives reports whether the subscriber gets a change with the given scope, sent to
+	// the given users (nil for everyone) and about listID (0 if none)
+	receives(scope string, users map[int64]bool, listID int64) bool
+	// deliver queues a change without blocking and reports whether it was queued
+	deliver(change db.Change, message []byte) bool
+}
+
+// Subscribers of the event bus. Each has its own send queue,
+// so broadcasting never waits for the network.
+var (
+	subscribers   = make(map[eventSubscriber]bool)
+	subscribersMu sync.RWMutex
+)
+
+// WebSocketMessage represents a message sent to clients. Changes carry a sequence
+// number, increasing by one with every change; replies to the client's own
+// requests (pong, subscriptions) have none.
+type WebSocketMessage struct {
+	Seq    int64       `json:"seq,omitempty"`
+	Type   string      `json:"type"`
+	ListID int64       `json:"list_id,omitempty"` // the list the change is about, if any
+	Entity string      `json:"entity,omitempty"`  // the kind of thing Data is, e.g. "item"
+	Data   interface{} `json:"data"`              // the changed thing as it is now, or was before a delete
+}
+
+// Entities of the events, for the envelope
+var eventEntities = map[string]string{
+	"list_created":            db.EntityList,
+	"list_updated":            db.EntityList,
+	"list_deleted":            db.EntityList,
+	"list_activated":          db.EntityList,
+	"lists_reordered":         db.EntityList,
+	"list_members_updated":    db.EntityMember,
+	"section_created":         db.EntitySection,
+	"section_updated":         db.EntitySection,
+	"section_deleted":         db.EntitySection,
+	"sections_deleted":        db.EntitySection,
+	"sections_reordered":      db.EntitySection,
+	"item_created":            db.EntityItem,
+	"item_updated":            db.EntityItem,
+	"item_deleted":            db.EntityItem,
+	"item_toggled":            db.EntityItem,
+	"item_moved":              db.EntityItem,
+	"items_reordered":         db.EntityItem,
+	"items_updated":           db.EntitySection,
+	"completed_items_deleted": db.EntityItem,
+	"batch_created":           db.EntityItem,
+	"template_created":        db.EntityTemplate,
+	"template_updated":        db.EntityTemplate,
+	"template_deleted":        db.EntityTemplate,
+	"template_applied":        db.EntityTemplate,
+	"presence_joined":         "presence",
+	"presence_left":           "presence",
+	"presence_activity":       "presence",
+}
+
+// noticeScope is the scope of notices, messages about a list that are not changes, e.g.
+// presence. Like the list scope, but share link clients do not get them.
+const noticeScope = "notice"
+
+// Sequence number of the last change sent. The mutex is held while a change is numbered
+// and queued, so every subscriber gets changes in order; take it after subscribersMu.
+// Nothing is read from or written to the database with it held.
+var (
+	eventSeq   int64
+	eventSeqMu sync.Mutex
+)
+
+// Changes waiting to be written to the change log, in order, and the sequence number
+// of the last one written (or that failed to be)
+var (
+	unloggedChanges []db.Change
+	loggedSeq       int64
+	changeLogMu     sync.Mutex
+	changeLogCond   = sync.NewCond(&changeLogMu)
+)
+
+// InitChangeLog trims the change log, continues its sequence numbers and starts
+// writing changes to it, trimming it every 10 minutes
+func InitChangeLog() {
+	trimChangeLog()
+	_, latest, err := db.GetChangeLogBounds()
+	if err != nil {
+		log.Printf("Failed to read change log: %v", err)
+	}
+	eventSeqMu.Lock()
+	eventSeq = latest
+	eventSeqMu.Unlock()
+	changeLogMu.Lock()
+	loggedSeq = latest
+	changeLogMu.Unlock()
+
+	go writeChangeLog()
+	go func() {
+		ticker := time.NewTicker(changeLogTrimEvery)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			trimChangeLog()
+		}
+	}()
+}
+
+func trimChangeLog() {
+	if err := db.TrimChangeLog(ChangeLogSize, ChangeLogMaxAge); err != nil {
+		log.Printf("Failed to trim change log: %v", err)
+	}
+}
+
+// writeChangeLog writes the changes broadcast queues, in batches, so broadcasting
+// never waits for the database
+func writeChangeLog() {
+	for {
+		changeLogMu.Lock()
+		for len(unloggedChanges) == 0 {
+			changeLogCond.Wait()
+		}
+		changes := unloggedChanges
+		unloggedChanges = nil
+		changeLogMu.Unlock()
+
+		// A change that is not logged is still sent, but cannot be replayed;
+		// missedChanges notices the gap
+		if err := db.AppendChanges(changes); err != nil {
+			log.Printf("Failed to log %d changes: %v", len(changes), err)
+		}
+
+		changeLogMu.Lock()
+		loggedSeq = changes[len(changes)-1].Seq
+		changeLogCond.Broadcast()
+		changeLogMu.Unlock()
+	}
+}
+
+// logChange queues a change to be written to the change log. Called with eventSeqMu held.
+func logChange(change db.Change) {
+	changeLogMu.Lock()
+	unloggedChanges = append(unloggedChanges, change)
+	changeLogCond.Broadcast()
+	changeLogMu.Unlock()
+}
+
+// waitLogged waits until the changes up to seq have been written to the change log
+func waitLogged(seq int64) {
+	changeLogMu.Lock()
+	for loggedSeq < seq {
+		changeLogCond.Wait()
+	}
+	changeLogMu.Unlock()
+}
+
+// BroadcastUpdate sends an update to the clients of all users.
+// Changes to a list go through BroadcastListUpdate instead.
+func BroadcastUpdate(eventType string, data interface{}) {
+	broadcast(db.ChangeScopeAll, 0, nil, eventType, data)
+}
+
+// BroadcastListUpdate sends an update to the clients subscribed to a list whose users
+// can see it, and to the clients of its share links
+func BroadcastListUpdate(listID int64, eventType string, data interface{}) {
+	userIDs, err := db.GetListUserIDs(listID)
+	if err != nil {
+		log.Printf("Failed to get users of list %d: %v", listID, err)
+		return
+	}
+	broadcast(db.ChangeScopeList, listID, userIDs, eventType, data)
+}
+
+// BroadcastToUsers sends an update to all clients of the given users, subscribed or not,
+// e.g. about a list they got or lost. listID is the list it is about, 0 if none.
+func BroadcastToUsers(userIDs []int64, listID int64, eventType string, data interface{}) {
+	broadcast(db.ChangeScopeUsers, listID, userIDs, eventType, data)
+}
+
+// BroadcastListsReordered sends a user's lists in their new order to the user's clients
+func BroadcastListsReordered(userID int64) {
+	lists, err := db.GetAllLists(userID)
+	if err != nil {
+		log.Printf("Failed to get lists of user %d: %v", userID, err)
+		return
+	}
+	BroadcastToUsers([]int64{userID}, 0, "lists_reordered", lists)
+}
+
+// BroadcastSectionsReordered sends a list's sections in their new order to its subscribers
+func BroadcastSectionsReordered(listID int64) {
+	sections, err := db.GetSectionsByList(listID)
+	if err != nil {
+		log.Printf("Failed to get sections of list %d: %v", listID, err)
+		return
+	}
+	BroadcastListUpdate(listID, "sections_reordered", sections)
+}
+
+func userSet(userIDs []int64) map[int64]bool {
+	users := make(map[int64]bool, len(userIDs))
+	for _, id := range userIDs {
+		users[id] = true
+	}
+	return users
+}
+
+// changeMessage returns the message for a change
+func changeMessage(change db.Change) ([]byte, error) {
+	return json.Marshal(WebSocketMessage{
+		Seq:    change.Seq,
+		Type:   change.Type,
+		ListID: change.ListID,
+		Entity: change.Entity,
+		Data:   json.RawMessage(change.Data),
+	})
+}
+
+// broadcast logs a change and sends it to the subscribers of the given users, or of every
+// user for the "all" scope. listID is the list the change is about, 0 if none; with the list
+// scope only the list's WebSocket subscribers and share link clients get it.
+func broadcast(scope string, listID int64, userIDs []int64, eventType string, data interface{}) {
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Failed to marshal WebSocket message: %v", err)
+		return
+	}
+	change := db.Change{
+		Scope:   scope,
+		ListID:  listID,
+		UserIDs: userIDs,
+		Type:    eventType,
+		Entity:  eventEntities[eventType],
+		Data:    string(dataBytes),
+	}
+	var users map[int64]bool
+	if scope != db.ChangeScopeAll {
+		users = userSet(userIDs)
+	}
+	if scope == db.ChangeScopeList {
+		change.UserIDs = nil // looked up again on replay
+	}
+
+	subscribersMu.RLock()
+	defer subscribersMu.RUnlock()
+	eventSeqMu.Lock()
+	defer eventSeqMu.Unlock()
+
+	eventSeq++
+	change.Seq = eventSeq
+	logChange(change)
+
+	messageBytes, err := changeMessage(change)
+	if err != nil {
+		log.Printf("Failed to marshal WebSocket message: %v", err)
+		return
+	}
+
+	clientCount := 0
+	queuedCount := 0
+	for sub := range subscribers {
+		if !sub.receives(scope, users, listID) {
+			continue
+		}
+		clientCount++
+		if sub.deliver(change, messageBytes) {
+			queuedCount++
+		}
+	}
+
+	log.Printf("Broadcast %s #%d completed: %d/%d clients queued", eventType, change.Seq, queuedCount, clientCount)
+}
+
+// notify sends a notice about a list to the subscribers of the given users. Notices are not
+// numbered or kept in the change log; reconnecting clients load the current state instead.
+func notify(listID int64, userIDs []int64, eventType string, data interface{}) {
+	messageBytes, err := json.Marshal(WebSocketMessage{
+		Type:   eventType,
+		ListID: listID,
+		Entity: eventEntities[eventType],
+		Data:   data,
+	})
+	if err != nil {
+		log.Printf("Failed to marshal WebSocket message: %v", err)
+		return
+	}
+	change := db.Change{Scope: noticeScope, ListID: listID, Type: eventType}
+	users := userSet(userIDs)
+
+	subscribersMu.RLock()
+	defer subscribersMu.RUnlock()
+	for sub := range subscribers {
+		if sub.receives(noticeScope, users, listID) {
+			sub.deliver(change, messageBytes)
+		}
+	}
+}
+
+// missedChanges returns the changes in (seq, upTo] that sub gets. ok is false if they are
+// no longer all kept. The changes are read without holding any lock.
+func missedChanges(sub eventSubscriber, seq, upTo int64) (missed []db.Change, ok bool) {
+	if seq == upTo {
+		return nil, true
+	}
+	if seq > upTo || upTo-seq > ChangeLogSize {
+		return nil, false
+	}
+
+	waitLogged(upTo)
+	changes, err := db.GetChangesSince(seq, int(upTo-seq))
+	if err != nil {
+		log.Printf("Failed to read change log: %v", err)
+		return nil, false
+	}
+	// Sequence numbers are consecutive, so anything trimmed or not logged leaves a gap
+	if int64(len(changes)) != upTo-seq || changes[len(changes)-1].Seq != upTo {
+		return nil, false
+	}
+
+	// Whoever can see a list now; people removed since should not get its changes
+	listUsers := make(map[int64]map[int64]bool)
+	for _, change := range changes {
+		if change.Scope == db.ChangeScopeList {
+			if _, ok := listUsers[change.ListID]; !ok {
+				userIDs, _ := db.GetListUserIDs(change.ListID)
+				listUsers[change.ListID] = userSet(userIDs)
+			}
+		}
+	}
+
+	subscribersMu.RLock()
+	defer subscribersMu.RUnlock()
+	for _, change := range changes {
+		users := userSet(change.UserIDs)
+		switch change.Scope {
+		case db.ChangeScopeAll:
+			users = nil
+		case db.ChangeScopeList:
+			users = listUsers[change.ListID]
+		}
+		if sub.receives(change.Scope, users, change.ListID) {
+			missed = append(missed, change)
+		}
+	}
+	return missed, true
+}
+
+// replay holds back a subscriber's live changes while the changes it missed are loaded,
+// so everything goes out in order. The zero value lets changes through.
+type replay struct {
+	mu      sync.Mutex
+	pending bool
+	held    []heldChange
+	lastSeq int64          // of the last change that came in while pending, held or not
+	dropped bool           // more came in than are held
+	queued  map[int64]bool // changes queued before the replay, not to be sent again; nil if not tracked
+}
+
+type heldChange struct {
+	change  db.Change
+	message []byte
+}
+
+// hold keeps a change back if a replay is pending, and reports whether it did
+func (r *replay) hold(change db.Change, message []byte) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.pending {
+		if r.queued != nil && change.Seq != 0 && len(r.queued) < replayMaxHeld {
+			r.queued[change.Seq] = true
+		}
+		return false
+	}
+	if change.Seq != 0 {
+		r.lastSeq = change.Seq
+	}
+	if len(r.held) >= replayMaxHeld {
+		r.dropped = true
+	} else {
+		r.held = append(r.held, heldChange{change, message})
+	}
+	return true
+}
+
+// start holds back changes from now on. Called with eventSeqMu held, so every change
+// after the current sequence number is held.
+func (r *replay) start() {
+	r.mu.Lock()
+	r.pending = true
+	r.lastSeq = eventSeq
+	r.mu.Unlock()
+}
+
+// finish calls flush with the changes held back, and whether some were dropped, then lets
+// changes through again. queued tells which changes were queued before the replay.
+// flush runs with the lock held, so no change is queued in between.
+func (r *replay) finish(flush func(held []heldChange, lastSeq int64, dropped bool, queued map[int64]bool)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	flush(r.held, r.lastSeq, r.dropped, r.queued)
+	r.pending = false
+	r.held = nil
+	r.dropped = false
+	r.queued = nil
+}
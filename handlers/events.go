@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"log"
+	"shopping-list/db"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RecordEvent adds a change made by the logged in user (or API token user) to the audit trail.
+// listID is 0 for changes outside lists. A failure is logged and does not fail the request.
+func RecordEvent(c *fiber.Ctx, listID int64, action, entityType string, entityID int64, before, after interface{}) {
+	var userID int64
+	if user := CurrentUser(c); user != nil {
+		userID = user.ID
+	}
+	recordEvent(userID, listID, action, entityType, entityID, before, after)
+}
+
+func recordEvent(userID, listID int64, action, entityType string, entityID int64, before, after interface{}) {
+	if err := db.RecordEvent(userID, listID, action, entityType, entityID, before, after); err != nil {
+		log.Printf("Failed to record %s %s event: %v", entityType, action, err)
+	}
+	// Shown to the others who have the list open as what the user last did
+	if entityType == db.EntityItem && listID != 0 && userID != 0 {
+		presenceActivity(userID, listID, entityID, action)
+	}
+}
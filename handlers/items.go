@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
 	"log"
 	"shopping-list/db"
+	"shopping-list/parser"
 	"strconv"
 	"strings"
 	"time"
@@ -30,11 +32,53 @@ func retryOnBusy[T any](maxRetries int, operation func() (T, error)) (T, error)
 	return result, err
 }
 
+// ParseQuantity parses a quantity form value, accepting a decimal comma.
+// An empty value yields fallback.
+func ParseQuantity(value string, fallback float64) (float64, error) {
+	value = strings.TrimSpace(strings.Replace(value, ",", ".", 1))
+	if value == "" {
+		return fallback, nil
+	}
+	quantity, err := strconv.ParseFloat(value, 64)
+	if err != nil || quantity <= 0 || quantity > MaxQuantity {
+		return 0, errors.New("invalid quantity")
+	}
+	return quantity, nil
+}
+
+// ParsePrice parses a price or budget amount. An empty value means no price (0).
+func ParsePrice(value string) (float64, error) {
+	value = strings.TrimSpace(strings.Replace(value, ",", ".", 1))
+	if value == "" {
+		return 0, nil
+	}
+	price, err := strconv.ParseFloat(value, 64)
+	if err != nil || price < 0 || price > MaxPrice {
+		return 0, errors.New("invalid price")
+	}
+	return price, nil
+}
+
+// QuickAdd splits quick-add input like "3x milk 1.5l" into name, quantity and unit.
+// A parsed note becomes the description unless one was given.
+func QuickAdd(input, description string) (string, string, float64, string) {
+	parsed := parser.ParseItem(input)
+	if description == "" {
+		description = parsed.Note
+	}
+	return parsed.Name, description, parsed.Quantity, parsed.Unit
+}
+
 // CreateItem creates a new item in a section
 func CreateItem(c *fiber.Ctx) error {
-	sectionID, err := strconv.ParseInt(c.FormValue("section_id"), 10, 64)
-	if err != nil {
-		return c.Status(400).SendString("Invalid section ID")
+	userID := CurrentUser(c).ID
+	var sectionID int64
+	if raw := c.FormValue("section_id"); raw != "" {
+		var err error
+		sectionID, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return c.Status(400).SendString("Invalid section ID")
+		}
 	}
 
 	name := c.FormValue("name")
@@ -44,21 +88,85 @@ func CreateItem(c *fiber.Ctx) error {
 
 	description := c.FormValue("description")
 
-	item, err := db.CreateItem(sectionID, name, description)
+	quantity, err := ParseQuantity(c.FormValue("quantity"), 0)
+	if err != nil {
+		return c.Status(400).SendString("Invalid quantity")
+	}
+
+	unit := parser.UnitKey(strings.TrimSpace(c.FormValue("unit")))
+
+	// Without explicit quantity fields, read them from the name ("500 g mince")
+	if quantity == 0 && unit == "" {
+		name, description, quantity, unit = QuickAdd(name, description)
+		if quantity > MaxQuantity {
+			return c.Status(400).SendString("Invalid quantity")
+		}
+	}
+	if len(unit) > MaxUnitLength {
+		return c.Status(400).SendString("Unit too long")
+	}
+
+	var listID int64
+	if sectionID > 0 {
+		if listID, err = db.CheckSectionAccess(userID, sectionID, db.AccessWrite); err != nil {
+			return accessDenied(c, err, "Section")
+		}
+	}
+
+	// Without a section, route the item to the section it was last put in on this list
+	if sectionID == 0 {
+		var list *db.List
+		var err error
+		if raw := c.FormValue("list_id"); raw != "" {
+			listID, parseErr := strconv.ParseInt(raw, 10, 64)
+			if parseErr != nil {
+				return c.Status(400).SendString("Invalid list ID")
+			}
+			list, err = db.GetListByID(userID, listID)
+		} else {
+			list, err = db.GetActiveList(userID)
+		}
+		if err != nil {
+			return c.Status(404).SendString("List not found")
+		}
+		if err := db.CheckListAccess(userID, list.ID, db.AccessWrite); err != nil {
+			return accessDenied(c, err, "List")
+		}
+		listID = list.ID
+		section, created, err := db.ResolveItemSection(userID, list.ID, name)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(400).SendString("List has no sections")
+			}
+			return c.Status(500).SendString("Failed to find section")
+		}
+		if created {
+			BroadcastListUpdate(listID, "section_created", section)
+		}
+		sectionID = section.ID
+	}
+
+	item, merged, err := db.CreateItem(userID, sectionID, name, description, quantity, unit)
 	if err != nil {
 		return c.Status(500).SendString("Failed to create item")
 	}
 
 	// Save to item history for auto-completion
-	db.SaveItemHistory(name, sectionID)
+	db.SaveItemHistory(userID, name, sectionID)
 
 	// Broadcast to WebSocket clients
-	BroadcastUpdate("item_created", item)
+	if merged {
+		RecordEvent(c, listID, db.EventUpdated, db.EntityItem, item.ID, nil, item)
+		BroadcastListUpdate(listID, "item_updated", item)
+	} else {
+		RecordEvent(c, listID, db.EventCreated, db.EntityItem, item.ID, nil, item)
+		BroadcastListUpdate(listID, "item_created", item)
+	}
 
 	// Return the new item partial for HTMX
 	return c.Render("partials/item", fiber.Map{
 		"Item":     item,
-		"Sections": getSectionsForDropdown(),
+		"Sections": getSectionsForDropdown(c),
 	}, "")
 }
 
@@ -76,18 +184,60 @@ func UpdateItem(c *fiber.Ctx) error {
 
 	description := c.FormValue("description")
 
-	item, err := db.UpdateItem(id, name, description)
+	listID, err := db.CheckItemAccess(CurrentUser(c).ID, id, db.AccessWrite)
+	if err != nil {
+		return accessDenied(c, err, "Item")
+	}
+
+	existing, err := db.GetItemByID(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).SendString("Item not found")
+		}
+		return c.Status(500).SendString("Failed to fetch item")
+	}
+
+	// Quantity and unit are optional so older clients keep the current values
+	quantity, err := ParseQuantity(c.FormValue("quantity"), existing.Quantity)
+	if err != nil {
+		return c.Status(400).SendString("Invalid quantity")
+	}
+
+	unit := existing.Unit
+	if c.Request().PostArgs().Has("unit") {
+		unit = parser.UnitKey(strings.TrimSpace(c.FormValue("unit")))
+	}
+	if len(unit) > MaxUnitLength {
+		return c.Status(400).SendString("Unit too long")
+	}
+
+	// Prices are kept unless the form sends them; an empty field clears the price
+	price := existing.Price
+	if c.Request().PostArgs().Has("price") {
+		if price, err = ParsePrice(c.FormValue("price")); err != nil {
+			return c.Status(400).SendString("Invalid price")
+		}
+	}
+	paidPrice := existing.PaidPrice
+	if c.Request().PostArgs().Has("paid_price") {
+		if paidPrice, err = ParsePrice(c.FormValue("paid_price")); err != nil {
+			return c.Status(400).SendString("Invalid price")
+		}
+	}
+
+	item, err := db.UpdateItem(id, name, description, quantity, unit, price, paidPrice)
 	if err != nil {
 		return c.Status(500).SendString("Failed to update item")
 	}
+	RecordEvent(c, listID, db.EventUpdated, db.EntityItem, id, existing, item)
 
 	// Broadcast to WebSocket clients
-	BroadcastUpdate("item_updated", item)
+	BroadcastListUpdate(listID, "item_updated", item)
 
 	// Return updated item partial
 	return c.Render("partials/item", fiber.Map{
 		"Item":     item,
-		"Sections": getSectionsForDropdown(),
+		"Sections": getSectionsForDropdown(c),
 	}, "")
 }
 
@@ -98,13 +248,20 @@ func DeleteItem(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Invalid ID")
 	}
 
+	listID, err := db.CheckItemAccess(CurrentUser(c).ID, id, db.AccessWrite)
+	if err != nil {
+		return accessDenied(c, err, "Item")
+	}
+
+	before, _ := db.GetItemByID(id)
 	err = db.DeleteItem(id)
 	if err != nil {
 		return c.Status(500).SendString("Failed to delete item")
 	}
+	RecordEvent(c, listID, db.EventDeleted, db.EntityItem, id, before, nil)
 
 	// Broadcast to WebSocket clients
-	BroadcastUpdate("item_deleted", map[string]int64{"id": id})
+	BroadcastListUpdate(listID, "item_deleted", before)
 
 	// Return empty string (HTMX will remove the element)
 	return c.SendString("")
@@ -112,13 +269,24 @@ func DeleteItem(c *fiber.Ctx) error {
 
 // DeleteCompletedItems deletes all completed items
 func DeleteCompletedItems(c *fiber.Ctx) error {
-	count, err := db.DeleteCompletedItems()
+	userID := CurrentUser(c).ID
+	list, err := db.GetActiveList(userID)
+	if err != nil {
+		return accessDenied(c, err, "List")
+	}
+	if err := db.CheckListAccess(userID, list.ID, db.AccessWrite); err != nil {
+		return accessDenied(c, err, "List")
+	}
+
+	completed, _ := db.GetCompletedItems(list.ID)
+	count, err := db.DeleteCompletedItems(list.ID)
 	if err != nil {
 		return c.Status(500).SendString("Failed to delete completed items")
 	}
+	RecordEvent(c, list.ID, db.EventCleared, db.EntityList, list.ID, completed, nil)
 
 	// Broadcast to WebSocket clients
-	BroadcastUpdate("completed_items_deleted", map[string]int64{"count": count})
+	BroadcastListUpdate(list.ID, "completed_items_deleted", fiber.Map{"count": count, "items": completed})
 
 	return c.JSON(fiber.Map{"deleted": count})
 }
@@ -130,24 +298,31 @@ func ToggleItem(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Invalid ID")
 	}
 
-	item, err := db.ToggleItemCompleted(id)
+	listID, err := db.CheckItemAccess(CurrentUser(c).ID, id, db.AccessWrite)
+	if err != nil {
+		return accessDenied(c, err, "Item")
+	}
+
+	before, _ := db.GetItemByID(id)
+	item, err := db.ToggleItemCompleted(CurrentUser(c).ID, id)
 	if err != nil {
 		return c.Status(500).SendString("Failed to toggle item")
 	}
+	RecordEvent(c, listID, CompletionAction(item), db.EntityItem, id, before, item)
 
 	// Broadcast to WebSocket clients
-	BroadcastUpdate("item_toggled", item)
+	BroadcastListUpdate(listID, "item_toggled", item)
 
 	// Return the appropriate item partial based on completed status
 	if item.Completed {
 		return c.Render("partials/item_completed", fiber.Map{
 			"Item":     item,
-			"Sections": getSectionsForDropdown(),
+			"Sections": getSectionsForDropdown(c),
 		}, "")
 	}
 	return c.Render("partials/item", fiber.Map{
 		"Item":     item,
-		"Sections": getSectionsForDropdown(),
+		"Sections": getSectionsForDropdown(c),
 	}, "")
 }
 
@@ -158,24 +333,88 @@ func ToggleUncertain(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Invalid ID")
 	}
 
+	listID, err := db.CheckItemAccess(CurrentUser(c).ID, id, db.AccessWrite)
+	if err != nil {
+		return accessDenied(c, err, "Item")
+	}
+
+	before, _ := db.GetItemByID(id)
 	item, err := db.ToggleItemUncertain(id)
 	if err != nil {
 		return c.Status(500).SendString("Failed to toggle uncertain")
 	}
+	RecordEvent(c, listID, db.EventUpdated, db.EntityItem, id, before, item)
 
 	// Broadcast to WebSocket clients
-	BroadcastUpdate("item_updated", item)
+	BroadcastListUpdate(listID, "item_updated", item)
 
 	// Return the appropriate item partial based on completed status
 	if item.Completed {
 		return c.Render("partials/item_completed", fiber.Map{
 			"Item":     item,
-			"Sections": getSectionsForDropdown(),
+			"Sections": getSectionsForDropdown(c),
 		}, "")
 	}
 	return c.Render("partials/item", fiber.Map{
 		"Item":     item,
-		"Sections": getSectionsForDropdown(),
+		"Sections": getSectionsForDropdown(c),
+	}, "")
+}
+
+// IncrementItem increases an item's quantity by one (or by the optional "step")
+func IncrementItem(c *fiber.Ctx) error {
+	return changeItemQuantity(c, 1)
+}
+
+// DecrementItem decreases an item's quantity by one (or by the optional "step")
+func DecrementItem(c *fiber.Ctx) error {
+	return changeItemQuantity(c, -1)
+}
+
+func changeItemQuantity(c *fiber.Ctx, sign float64) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(400).SendString("Invalid ID")
+	}
+
+	listID, err := db.CheckItemAccess(CurrentUser(c).ID, id, db.AccessWrite)
+	if err != nil {
+		return accessDenied(c, err, "Item")
+	}
+
+	step, err := ParseQuantity(c.FormValue("step"), 1)
+	if err != nil {
+		return c.Status(400).SendString("Invalid step")
+	}
+
+	before, _ := db.GetItemByID(id)
+	item, err := db.ChangeItemQuantity(id, sign*step)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).SendString("Item not found")
+		}
+		if err == db.ErrQuantityTooLow {
+			return c.Status(409).SendString("Quantity must stay above zero")
+		}
+		if err == db.ErrQuantityTooHigh {
+			return c.Status(409).SendString("Quantity can't exceed 100000")
+		}
+		return c.Status(500).SendString("Failed to change quantity")
+	}
+	RecordEvent(c, listID, db.EventUpdated, db.EntityItem, id, before, item)
+
+	// Broadcast to WebSocket clients
+	BroadcastListUpdate(listID, "item_updated", item)
+
+	if item.Completed {
+		return c.Render("partials/item_completed", fiber.Map{
+			"Item":     item,
+			"Sections": getSectionsForDropdown(c),
+		}, "")
+	}
+	return c.Render("partials/item", fiber.Map{
+		"Item":     item,
+		"Sections": getSectionsForDropdown(c),
 	}, "")
 }
 
@@ -187,11 +426,26 @@ func MoveItemToSection(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Invalid ID")
 	}
 
+	listID, err := db.CheckItemAccess(CurrentUser(c).ID, id, db.AccessWrite)
+	if err != nil {
+		return accessDenied(c, err, "Item")
+	}
+
 	newSectionID, err := strconv.ParseInt(c.FormValue("section_id"), 10, 64)
 	if err != nil {
 		return c.Status(400).SendString("Invalid section ID")
 	}
 
+	// Items can only move between sections of the same list
+	targetListID, err := db.CheckSectionAccess(CurrentUser(c).ID, newSectionID, db.AccessWrite)
+	if err != nil {
+		return accessDenied(c, err, "Section")
+	}
+	if targetListID != listID {
+		return c.Status(400).SendString("Items can only be moved within their list")
+	}
+
+	before, _ := db.GetItemByID(id)
 	var item *db.Item
 
 	// Check if position parameter is provided (for cross-section drag-and-drop)
@@ -216,8 +470,10 @@ func MoveItemToSection(c *fiber.Ctx) error {
 		}
 	}
 
+	RecordEvent(c, listID, db.EventMoved, db.EntityItem, id, before, item)
+
 	// Broadcast to WebSocket clients
-	BroadcastUpdate("item_moved", item)
+	BroadcastListUpdate(listID, "item_moved", item)
 
 	// Trigger full refresh for simplicity (item moved between sections)
 	c.Set("HX-Trigger", "refreshList")
@@ -231,15 +487,21 @@ func MoveItemUp(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Invalid ID")
 	}
 
+	listID, err := db.CheckItemAccess(CurrentUser(c).ID, id, db.AccessWrite)
+	if err != nil {
+		return accessDenied(c, err, "Item")
+	}
+
 	err = db.MoveItemUp(id)
 	if err != nil {
 		return c.Status(500).SendString("Failed to move item")
 	}
+	RecordEvent(c, listID, db.EventReordered, db.EntityItem, id, nil, nil)
 
 	// Get the item's section and return all items in that section
 	item, _ := db.GetItemByID(id)
 	if item != nil {
-		BroadcastUpdate("items_reordered", map[string]int64{"section_id": item.SectionID})
+		BroadcastListUpdate(listID, "items_reordered", map[string]int64{"section_id": item.SectionID})
 		return returnSectionItems(c, item.SectionID)
 	}
 
@@ -253,15 +515,21 @@ func MoveItemDown(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Invalid ID")
 	}
 
+	listID, err := db.CheckItemAccess(CurrentUser(c).ID, id, db.AccessWrite)
+	if err != nil {
+		return accessDenied(c, err, "Item")
+	}
+
 	err = db.MoveItemDown(id)
 	if err != nil {
 		return c.Status(500).SendString("Failed to move item")
 	}
+	RecordEvent(c, listID, db.EventReordered, db.EntityItem, id, nil, nil)
 
 	// Get the item's section and return all items in that section
 	item, _ := db.GetItemByID(id)
 	if item != nil {
-		BroadcastUpdate("items_reordered", map[string]int64{"section_id": item.SectionID})
+		BroadcastListUpdate(listID, "items_reordered", map[string]int64{"section_id": item.SectionID})
 		return returnSectionItems(c, item.SectionID)
 	}
 
@@ -277,13 +545,21 @@ func returnSectionItems(c *fiber.Ctx, sectionID int64) error {
 
 	return c.Render("partials/section", fiber.Map{
 		"Section":  section,
-		"Sections": getSectionsForDropdown(),
+		"Sections": getSectionsForDropdown(c),
 	}, "")
 }
 
+// CompletionAction is the audit trail action for an item that was just checked or unchecked
+func CompletionAction(item *db.Item) string {
+	if item.Completed {
+		return db.EventCompleted
+	}
+	return db.EventUncompleted
+}
+
 // GetStats returns current stats as JSON (for Alpine.js updates)
 func GetStats(c *fiber.Ctx) error {
-	stats := db.GetStats()
+	stats := db.GetStats(CurrentUser(c).ID)
 	return c.JSON(stats)
 }
 
@@ -294,6 +570,10 @@ func GetItemVersion(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid ID"})
 	}
 
+	if _, err := db.CheckItemAccess(CurrentUser(c).ID, id, db.AccessRead); err != nil {
+		return accessDeniedJSON(c, err, "Item")
+	}
+
 	item, err := db.GetItemByID(id)
 	if err != nil {
 		if err == sql.ErrNoRows {
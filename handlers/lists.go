@@ -17,20 +17,24 @@ const (
 	MaxSectionNameLength = 100
 	MaxItemNameLength    = 200
 	MaxDescriptionLength = 500
+	MaxUnitLength        = 20
+	MaxQuantity          = db.MaxItemQuantity
+	MaxPrice             = 1000000
 )
 
 // GetListsPage returns the homepage with all lists
 func GetListsPage(c *fiber.Ctx) error {
-	lists, err := db.GetAllLists()
+	lists, err := db.GetAllLists(CurrentUser(c).ID)
 	if err != nil {
 		return c.Status(500).SendString("Failed to fetch lists")
 	}
 
-	templates, _ := db.GetAllTemplates()
+	templates, _ := db.GetTemplates(CurrentUser(c).ID)
 
 	return c.Render("home", fiber.Map{
 		"Lists":        lists,
 		"Templates":    templates,
+		"User":         CurrentUser(c),
 		"Translations": i18n.GetAllLocales(),
 		"Locales":      i18n.AvailableLocales(),
 		"DefaultLang":  i18n.GetDefaultLang(),
@@ -44,10 +48,11 @@ func GetListView(c *fiber.Ctx) error {
 		return c.Redirect("/")
 	}
 
-	list, err := db.GetListByID(id)
+	userID := CurrentUser(c).ID
+	list, err := db.GetListByID(userID, id)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			// List not found - redirect to home
+			// List not found or not shared with this user - redirect to home
 			return c.Redirect("/")
 		}
 		// Database error - log and show error
@@ -56,7 +61,7 @@ func GetListView(c *fiber.Ctx) error {
 	}
 
 	// Set this list as active
-	db.SetActiveList(id)
+	db.SetActiveList(userID, id)
 
 	sections, err := db.GetSectionsByList(id)
 	if err != nil {
@@ -64,13 +69,14 @@ func GetListView(c *fiber.Ctx) error {
 	}
 
 	stats := db.GetListStats(id)
-	lists, _ := db.GetAllLists()
+	lists, _ := db.GetAllLists(userID)
 
 	return c.Render("list", fiber.Map{
 		"List":         list,
 		"Lists":        lists,
 		"Sections":     sections,
 		"Stats":        stats,
+		"User":         CurrentUser(c),
 		"Translations": i18n.GetAllLocales(),
 		"Locales":      i18n.AvailableLocales(),
 		"DefaultLang":  i18n.GetDefaultLang(),
@@ -79,7 +85,7 @@ func GetListView(c *fiber.Ctx) error {
 
 // GetLists returns all lists (JSON API)
 func GetLists(c *fiber.Ctx) error {
-	lists, err := db.GetAllLists()
+	lists, err := db.GetAllLists(CurrentUser(c).ID)
 	if err != nil {
 		return c.Status(500).SendString("Failed to fetch lists")
 	}
@@ -111,13 +117,15 @@ func CreateList(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Icon too long")
 	}
 
-	list, err := db.CreateList(name, icon)
+	user := CurrentUser(c)
+	list, err := db.CreateList(user.ID, name, icon)
 	if err != nil {
 		return c.Status(500).SendString("Failed to create list")
 	}
+	RecordEvent(c, list.ID, db.EventCreated, db.EntityList, list.ID, nil, list)
 
 	// Broadcast to WebSocket clients
-	BroadcastUpdate("list_created", list)
+	BroadcastToUsers([]int64{user.ID}, list.ID, "list_created", list)
 
 	// Return the new list item partial for HTMX
 	return c.Render("partials/list_item", fiber.Map{
@@ -145,13 +153,32 @@ func UpdateList(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Icon too long")
 	}
 
-	list, err := db.UpdateList(id, name, icon)
+	userID := CurrentUser(c).ID
+	if err := db.CheckListAccess(userID, id, db.AccessWrite); err != nil {
+		return accessDenied(c, err, "List")
+	}
+
+	existing, err := db.GetListByID(userID, id)
+	if err != nil {
+		return c.Status(404).SendString("List not found")
+	}
+
+	// Budget is optional so forms without it keep the current value
+	budget := existing.Budget
+	if c.Request().PostArgs().Has("budget") {
+		if budget, err = ParsePrice(c.FormValue("budget")); err != nil {
+			return c.Status(400).SendString("Invalid budget")
+		}
+	}
+
+	list, err := db.UpdateList(userID, id, name, icon, budget)
 	if err != nil {
 		return c.Status(500).SendString("Failed to update list")
 	}
+	RecordEvent(c, id, db.EventUpdated, db.EntityList, id, existing, list)
 
 	// Broadcast to WebSocket clients
-	BroadcastUpdate("list_updated", list)
+	BroadcastListUpdate(id, "list_updated", list)
 
 	// Return updated list item partial
 	return c.Render("partials/list_item", fiber.Map{
@@ -166,19 +193,26 @@ func RestartList(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Invalid ID")
 	}
 
+	userID := CurrentUser(c).ID
+	if err := db.CheckListAccess(userID, id, db.AccessWrite); err != nil {
+		return accessDenied(c, err, "List")
+	}
+
 	err = db.RestartList(id)
 	if err != nil {
 		return c.Status(500).SendString("Failed to restart list")
 	}
+	RecordEvent(c, id, db.EventRestarted, db.EntityList, id, nil, nil)
 
 	// Get updated list for broadcast and return
-	list, _ := db.GetListByID(id)
+	list, _ := db.GetListByID(userID, id)
 
 	// Broadcast to WebSocket clients
-	BroadcastUpdate("list_updated", list)
+	BroadcastListUpdate(id, "list_updated", list)
 
 	// Also broadcast items update to refresh the list view if active
-	BroadcastUpdate("items_updated", nil)
+	sections, _ := db.GetSectionsByList(id)
+	BroadcastListUpdate(id, "items_updated", sections)
 
 	// If HTMX request from list page settings, we might want to refresh the page
 	// or return a success toast/notification.
@@ -206,13 +240,24 @@ func DeleteList(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Invalid ID")
 	}
 
+	if err := db.CheckListAccess(CurrentUser(c).ID, id, db.AccessOwner); err != nil {
+		return accessDenied(c, err, "List")
+	}
+
+	// Members have to be looked up before the list is gone
+	userIDs, _ := db.GetListUserIDs(id)
+	before, _ := db.GetListByID(CurrentUser(c).ID, id)
+
 	err = db.DeleteList(id)
 	if err != nil {
 		return c.Status(400).SendString(err.Error())
 	}
+	// The list's own events are deleted with it, so this one is kept outside the list
+	RecordEvent(c, 0, db.EventDeleted, db.EntityList, id, before, nil)
 
 	// Broadcast to WebSocket clients
-	BroadcastUpdate("list_deleted", map[string]int64{"id": id})
+	BroadcastToUsers(userIDs, id, "list_deleted", before)
+	closeListShareConnections(id)
 
 	// Return empty string (HTMX will remove the element)
 	return c.SendString("")
@@ -225,13 +270,20 @@ func SetActiveList(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Invalid ID")
 	}
 
-	err = db.SetActiveList(id)
+	userID := CurrentUser(c).ID
+	if err := db.CheckListAccess(userID, id, db.AccessRead); err != nil {
+		return accessDenied(c, err, "List")
+	}
+
+	err = db.SetActiveList(userID, id)
 	if err != nil {
 		return c.Status(500).SendString("Failed to activate list")
 	}
 
-	// Broadcast to WebSocket clients
-	BroadcastUpdate("list_activated", map[string]int64{"id": id})
+	// Broadcast to the user's other clients; the active list is per user
+	if list, err := db.GetListByID(userID, id); err == nil {
+		BroadcastToUsers([]int64{userID}, id, "list_activated", list)
+	}
 
 	// Check if this is from the main page (needs redirect) or lists page
 	if c.Get("HX-Current-URL") != "" && !contains(c.Get("HX-Current-URL"), "/lists") {
@@ -250,13 +302,19 @@ func MoveListUp(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Invalid ID")
 	}
 
-	err = db.MoveListUp(id)
+	userID := CurrentUser(c).ID
+	if err := db.CheckListAccess(userID, id, db.AccessRead); err != nil {
+		return accessDenied(c, err, "List")
+	}
+
+	err = db.MoveListUp(userID, id)
 	if err != nil {
 		return c.Status(500).SendString("Failed to move list")
 	}
+	RecordEvent(c, id, db.EventReordered, db.EntityList, id, nil, nil)
 
 	// Broadcast and return full lists
-	BroadcastUpdate("lists_reordered", nil)
+	BroadcastListsReordered(userID)
 	return returnAllLists(c)
 }
 
@@ -267,24 +325,31 @@ func MoveListDown(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Invalid ID")
 	}
 
-	err = db.MoveListDown(id)
+	userID := CurrentUser(c).ID
+	if err := db.CheckListAccess(userID, id, db.AccessRead); err != nil {
+		return accessDenied(c, err, "List")
+	}
+
+	err = db.MoveListDown(userID, id)
 	if err != nil {
 		return c.Status(500).SendString("Failed to move list")
 	}
+	RecordEvent(c, id, db.EventReordered, db.EntityList, id, nil, nil)
 
 	// Broadcast and return full lists
-	BroadcastUpdate("lists_reordered", nil)
+	BroadcastListsReordered(userID)
 	return returnAllLists(c)
 }
 
 // Helper to return all lists as HTML partials
 func returnAllLists(c *fiber.Ctx) error {
-	lists, err := db.GetAllLists()
+	userID := CurrentUser(c).ID
+	lists, err := db.GetAllLists(userID)
 	if err != nil {
 		return c.Status(500).SendString("Failed to fetch lists")
 	}
 
-	activeList, _ := db.GetActiveList()
+	activeList, _ := db.GetActiveList(userID)
 
 	return c.Render("partials/lists_container", fiber.Map{
 		"Lists":      lists,
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"shopping-list/db"
+	"shopping-list/oidc"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	OIDCStateCookieName = "oidc_state"
+	OIDCLoginDuration   = 10 * time.Minute
+)
+
+// OIDCConfig holds the OpenID Connect login settings from environment variables
+type OIDCConfig struct {
+	Name          string // shown on the login button
+	Issuer        string
+	UsernameClaim string
+	GroupsClaim   string
+	AllowedGroups []string // empty allows everyone the provider lets in
+	// LinkUsernames links existing accounts with the same username on their first login.
+	// The username claim can usually be changed by the user at the provider, so an account
+	// is only linked when its username is the login's verified email.
+	LinkUsernames bool
+}
+
+// oidcLogin is a login started at the provider, waiting for the callback
+type oidcLogin struct {
+	nonce     string
+	verifier  string
+	expiresAt time.Time
+}
+
+// Singleton instances, nil when OpenID Connect is off
+var (
+	oidcConfig   *OIDCConfig
+	oidcProvider *oidc.Provider
+
+	oidcLogins   = make(map[string]oidcLogin) // by state
+	oidcLoginsMu sync.Mutex
+)
+
+// InitOIDC turns on OpenID Connect login when OIDC_ISSUER is set.
+// The provider is contacted on the first login, so Koffan starts even if it is down.
+func InitOIDC() {
+	issuer := strings.TrimRight(os.Getenv("OIDC_ISSUER"), "/")
+	if issuer == "" {
+		return
+	}
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+	if clientID == "" || redirectURL == "" {
+		log.Fatal("OIDC_ISSUER is set but OIDC_CLIENT_ID or OIDC_REDIRECT_URL is missing")
+	}
+
+	config := &OIDCConfig{
+		Name:          getEnv("OIDC_PROVIDER_NAME", "SSO"),
+		Issuer:        issuer,
+		UsernameClaim: getEnv("OIDC_USERNAME_CLAIM", "preferred_username"),
+		GroupsClaim:   getEnv("OIDC_GROUPS_CLAIM", "groups"),
+		LinkUsernames: os.Getenv("OIDC_LINK_USERNAMES") == "true",
+	}
+	for _, group := range strings.Split(os.Getenv("OIDC_ALLOWED_GROUPS"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			config.AllowedGroups = append(config.AllowedGroups, group)
+		}
+	}
+
+	SetOIDCProvider(config, oidc.NewProvider(oidc.Config{
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  redirectURL,
+		Scopes:       strings.Fields(os.Getenv("OIDC_SCOPES")),
+	}))
+	log.Printf("[AUTH] OpenID Connect login with %s (%s)", config.Name, issuer)
+}
+
+// SetOIDCProvider sets the OpenID Connect settings and provider, e.g. one pointed at a local issuer
+func SetOIDCProvider(config *OIDCConfig, provider *oidc.Provider) {
+	oidcConfig = config
+	oidcProvider = provider
+}
+
+func getEnv(key, defaultVal string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return defaultVal
+}
+
+// oidcName returns the name for the login button, "" when OpenID Connect is off
+func oidcName() string {
+	if oidcConfig == nil {
+		return ""
+	}
+	return oidcConfig.Name
+}
+
+// OIDCLogin sends the browser to the provider to log in
+func OIDCLogin(c *fiber.Ctx) error {
+	if oidcProvider == nil {
+		return c.Redirect("/login")
+	}
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		return c.Status(500).SendString("Login failed")
+	}
+	login := oidcLogin{expiresAt: time.Now().Add(OIDCLoginDuration)}
+	if login.nonce, err = oidc.RandomString(); err != nil {
+		return c.Status(500).SendString("Login failed")
+	}
+	if login.verifier, err = oidc.RandomString(); err != nil {
+		return c.Status(500).SendString("Login failed")
+	}
+
+	url, err := oidcProvider.AuthCodeURL(c.Context(), state, login.nonce, login.verifier)
+	if err != nil {
+		log.Printf("[AUTH] OpenID Connect login failed: %v", err)
+		return c.Redirect("/login?error=oidc")
+	}
+
+	oidcLoginsMu.Lock()
+	for s, l := range oidcLogins {
+		if time.Now().After(l.expiresAt) {
+			delete(oidcLogins, s)
+		}
+	}
+	oidcLogins[state] = login
+	oidcLoginsMu.Unlock()
+
+	// The state is also kept in the browser, so a callback only works in the browser that started it
+	c.Cookie(&fiber.Cookie{
+		Name:     OIDCStateCookieName,
+		Value:    state,
+		Expires:  login.expiresAt,
+		HTTPOnly: true,
+		Secure:   isSecureConnection(c),
+		SameSite: "Lax",
+		Path:     "/",
+	})
+	return c.Redirect(url)
+}
+
+// OIDCCallback finishes a login at the provider: it checks the response, finds or
+// creates the user and starts a session. The provider is trusted to have done any
+// second factor, so Koffan's own two-factor step is skipped.
+func OIDCCallback(c *fiber.Ctx) error {
+	if oidcProvider == nil {
+		return c.Redirect("/login")
+	}
+
+	state := c.Query("state")
+	cookie := c.Cookies(OIDCStateCookieName)
+	clearCookie(c, OIDCStateCookieName)
+
+	oidcLoginsMu.Lock()
+	login, ok := oidcLogins[state]
+	delete(oidcLogins, state)
+	oidcLoginsMu.Unlock()
+
+	if !ok || state == "" || cookie != state || time.Now().After(login.expiresAt) {
+		return c.Redirect("/login?error=expired")
+	}
+	if errCode := c.Query("error"); errCode != "" {
+		log.Printf("[AUTH] OpenID Connect provider returned %s: %s", errCode, c.Query("error_description"))
+		return c.Redirect("/login?error=oidc")
+	}
+
+	claims, err := oidcProvider.Exchange(c.Context(), c.Query("code"), login.nonce, login.verifier)
+	if err != nil {
+		log.Printf("[AUTH] OpenID Connect login failed: %v", err)
+		return c.Redirect("/login?error=oidc")
+	}
+
+	if !oidcGroupAllowed(claims.Strings(oidcConfig.GroupsClaim)) {
+		log.Printf("[AUTH] OpenID Connect user %q is not in an allowed group", claims.Subject())
+		return c.Redirect("/login?error=oidc_denied")
+	}
+
+	user, errKey := oidcUser(claims)
+	if errKey != "" {
+		return c.Redirect("/login?error=" + errKey)
+	}
+
+	if err := startSession(c, user); err != nil {
+		return c.Status(500).SendString("Session creation failed")
+	}
+	return c.Redirect("/")
+}
+
+func oidcGroupAllowed(groups []string) bool {
+	if len(oidcConfig.AllowedGroups) == 0 {
+		return true
+	}
+	for _, allowed := range oidcConfig.AllowedGroups {
+		for _, group := range groups {
+			if group == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// oidcUser returns the user linked to the provider account, linking or creating one
+// on the first login. It returns an error key for the login page if that fails.
+func oidcUser(claims oidc.Claims) (*db.User, string) {
+	issuer, subject := oidcConfig.Issuer, claims.Subject()
+
+	user, err := db.GetUserByIdentity(issuer, subject)
+	if err == nil {
+		return user, ""
+	}
+	if err != sql.ErrNoRows {
+		log.Printf("[AUTH] Failed to look up OpenID Connect user: %v", err)
+		return nil, "oidc"
+	}
+
+	username := claims.String(oidcConfig.UsernameClaim)
+	if username == "" {
+		username = claims.String("email")
+	}
+	if !externalUsernamePattern.MatchString(username) {
+		log.Printf("[AUTH] OpenID Connect user %q has no usable %s claim: %q", subject, oidcConfig.UsernameClaim, username)
+		return nil, "oidc"
+	}
+
+	existing, err := db.GetUserByUsername(username)
+	switch {
+	case err == nil && oidcConfig.LinkUsernames && isVerifiedEmail(claims, existing.Username):
+		if err := db.LinkIdentity(existing.ID, issuer, subject); err != nil {
+			log.Printf("[AUTH] Failed to link OpenID Connect user: %v", err)
+			return nil, "oidc"
+		}
+		log.Printf("[AUTH] Linked user %q to OpenID Connect subject %q", existing.Username, subject)
+		recordEvent(existing.ID, 0, db.EventUpdated, db.EntityUser, existing.ID, nil, fiber.Map{"oidc_subject": subject})
+		return existing, ""
+	case err == nil && oidcConfig.LinkUsernames:
+		log.Printf("[AUTH] OpenID Connect user %q matches existing user %q but not by verified email, not linking", subject, username)
+		return nil, "oidc_exists"
+	case err == nil:
+		log.Printf("[AUTH] OpenID Connect user %q matches existing user %q, not linking", subject, username)
+		return nil, "oidc_exists"
+	case err != sql.ErrNoRows:
+		log.Printf("[AUTH] Failed to look up user: %v", err)
+		return nil, "oidc"
+	}
+
+	user, err = db.CreateUserWithIdentity(username, issuer, subject)
+	if err != nil {
+		if err == db.ErrUsernameTaken {
+			return nil, "oidc_exists"
+		}
+		log.Printf("[AUTH] Failed to create OpenID Connect user: %v", err)
+		return nil, "oidc"
+	}
+	log.Printf("[AUTH] Created user %q from OpenID Connect subject %q", user.Username, subject)
+	recordEvent(user.ID, 0, db.EventCreated, db.EntityUser, user.ID, nil, user)
+	return user, ""
+}
+
+// isVerifiedEmail reports whether username is the email the provider has verified for the login
+func isVerifiedEmail(claims oidc.Claims, username string) bool {
+	email := claims.String("email")
+	return email != "" && claims.Bool("email_verified") && strings.EqualFold(email, username)
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"path/filepath"
+	"shopping-list/db"
+	"shopping-list/oidc"
+	"testing"
+)
+
+func openTestDB(t *testing.T) {
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "test.db"))
+	db.Init()
+	t.Cleanup(func() {
+		db.Close()
+		db.DB = nil
+	})
+}
+
+// TestOIDCUserLinking checks existing accounts are only linked by the login's verified email,
+// never by a username claim the user can pick at the provider
+func TestOIDCUserLinking(t *testing.T) {
+	openTestDB(t)
+	old := oidcConfig
+	t.Cleanup(func() { oidcConfig = old })
+
+	for _, name := range []string{"anna", "anna@example.com"} {
+		if _, err := db.CreateUser(name, "hash", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		link   bool
+		claims oidc.Claims
+		user   string // linked username, "" when refused
+	}{
+		{"username claim set to an existing user", true, oidc.Claims{
+			"preferred_username": "anna", "email": "mallory@example.com", "email_verified": true}, ""},
+		{"unverified email", true, oidc.Claims{
+			"preferred_username": "anna@example.com", "email": "anna@example.com", "email_verified": false}, ""},
+		{"verified email of another user", true, oidc.Claims{
+			"preferred_username": "anna", "email": "anna@example.com", "email_verified": true}, ""},
+		{"linking off", false, oidc.Claims{
+			"preferred_username": "anna@example.com", "email": "anna@example.com", "email_verified": true}, ""},
+		{"verified email", true, oidc.Claims{
+			"preferred_username": "anna@example.com", "email": "Anna@Example.com", "email_verified": "true"}, "anna@example.com"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oidcConfig = &OIDCConfig{Issuer: "https://id.example", UsernameClaim: "preferred_username", LinkUsernames: tt.link}
+			tt.claims["sub"] = "subject-" + string(rune('a'+i))
+
+			user, errCode := oidcUser(tt.claims)
+			if tt.user == "" {
+				if user != nil || errCode != "oidc_exists" {
+					t.Errorf("oidcUser() = %v, %q, want refused with oidc_exists", user, errCode)
+				}
+				return
+			}
+			if user == nil || user.Username != tt.user {
+				t.Fatalf("oidcUser() = %v, %q, want %s", user, errCode, tt.user)
+			}
+		})
+	}
+}
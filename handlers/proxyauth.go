@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"shopping-list/db"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ProxyAuthConfig holds the forward-auth settings from environment variables.
+// A reverse proxy such as Authelia or oauth2-proxy logs users in and passes
+// the username in Header; Koffan trusts it only from the Trusted networks.
+type ProxyAuthConfig struct {
+	Header    string
+	Trusted   []*net.IPNet
+	LoginURL  string // where /login sends users, "" to keep the built-in login
+	LogoutURL string // where /logout sends users, "" for /login
+}
+
+// Singleton instance, nil when forward auth is off
+var proxyAuth *ProxyAuthConfig
+
+// Usernames from login proxies and OpenID Connect providers are often e-mail addresses
+var externalUsernamePattern = regexp.MustCompile(`^[A-Za-z0-9._@+-]{1,64}$`)
+
+// InitProxyAuth turns on forward auth when AUTH_PROXY_HEADER is set.
+// AUTH_PROXY_TRUSTED must list the proxies' addresses or CIDRs, comma separated.
+func InitProxyAuth() {
+	header := strings.TrimSpace(os.Getenv("AUTH_PROXY_HEADER"))
+	if header == "" {
+		return
+	}
+
+	var trusted []*net.IPNet
+	for _, entry := range strings.Split(os.Getenv("AUTH_PROXY_TRUSTED"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Fatalf("Invalid AUTH_PROXY_TRUSTED entry %q: %v", entry, err)
+		}
+		trusted = append(trusted, network)
+	}
+	if len(trusted) == 0 {
+		log.Fatal("AUTH_PROXY_HEADER is set but AUTH_PROXY_TRUSTED lists no proxies")
+	}
+
+	proxyAuth = &ProxyAuthConfig{
+		Header:    header,
+		Trusted:   trusted,
+		LoginURL:  os.Getenv("AUTH_PROXY_LOGIN_URL"),
+		LogoutURL: os.Getenv("AUTH_PROXY_LOGOUT_URL"),
+	}
+	log.Printf("[AUTH] Forward auth: trusting %s from %d networks", header, len(trusted))
+}
+
+// isTrustedProxy checks the address the request came from, not any forwarded-for header
+func (p *ProxyAuthConfig) isTrustedProxy(c *fiber.Ctx) bool {
+	ip := c.Context().RemoteIP()
+	for _, network := range p.Trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyUser returns the user named in the forward-auth header, creating the account
+// on first sight. It returns nil if forward auth is off, the request did not come
+// from a trusted proxy or the header is missing.
+func proxyUser(c *fiber.Ctx) (*db.User, error) {
+	if proxyAuth == nil || !proxyAuth.isTrustedProxy(c) {
+		return nil, nil
+	}
+	username := strings.TrimSpace(c.Get(proxyAuth.Header))
+	if username == "" {
+		return nil, nil
+	}
+	if !externalUsernamePattern.MatchString(username) {
+		log.Printf("[AUTH] Ignoring invalid username from %s header: %q", proxyAuth.Header, username)
+		return nil, nil
+	}
+
+	user, err := db.GetUserByUsername(username)
+	if err != sql.ErrNoRows {
+		return user, err
+	}
+
+	// The account has no password and can only log in through the proxy
+	user, err = db.CreateUser(username, "", false)
+	if err == db.ErrUsernameTaken {
+		// Created by a concurrent request
+		return db.GetUserByUsername(username)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[AUTH] Created user %q from %s header", user.Username, proxyAuth.Header)
+	recordEvent(user.ID, 0, db.EventCreated, db.EntityUser, user.ID, nil, user)
+	return user, nil
+}
+
+// proxyLoginURL returns where to send users who need to log in, "" for the built-in login
+func proxyLoginURL() string {
+	if proxyAuth == nil {
+		return ""
+	}
+	return proxyAuth.LoginURL
+}
+
+// proxyLogoutURL returns where to send users after logging out, "" for the built-in login
+func proxyLogoutURL() string {
+	if proxyAuth == nil {
+		return ""
+	}
+	return proxyAuth.LogoutURL
+}
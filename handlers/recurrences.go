@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"log"
+	"shopping-list/db"
+	"time"
+)
+
+// StartRecurrenceScheduler puts recurring items back on their lists when they are due.
+// It checks once at startup and then every minute.
+func StartRecurrenceScheduler() {
+	go func() {
+		runDueRecurrences()
+
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			runDueRecurrences()
+		}
+	}()
+}
+
+func runDueRecurrences() {
+	runs, err := db.RunDueRecurrences(time.Now())
+	if err != nil {
+		log.Printf("[RECURRENCE] Failed to run due recurrences: %v", err)
+	}
+
+	// Changes made by the scheduler are recorded without a user
+	for _, run := range runs {
+		if run.CreatedSection != nil {
+			recordEvent(0, run.ListID, db.EventCreated, db.EntitySection, run.CreatedSection.ID, nil, run.CreatedSection)
+			BroadcastListUpdate(run.ListID, "section_created", run.CreatedSection)
+		}
+		if run.Created {
+			recordEvent(0, run.ListID, db.EventCreated, db.EntityItem, run.Item.ID, nil, run.Item)
+			BroadcastListUpdate(run.ListID, "item_created", run.Item)
+		} else {
+			recordEvent(0, run.ListID, db.EventUncompleted, db.EntityItem, run.Item.ID, nil, run.Item)
+			BroadcastListUpdate(run.ListID, "item_toggled", run.Item)
+		}
+		log.Printf("[RECURRENCE] %s is back on the list", run.Item.Name)
+	}
+}
@@ -10,29 +10,31 @@ import (
 
 // GetSections returns all sections with items (for full page render)
 func GetSections(c *fiber.Ctx) error {
-	sections, err := db.GetAllSections()
+	userID := CurrentUser(c).ID
+	sections, err := db.GetAllSections(userID)
 	if err != nil {
 		return c.Status(500).SendString("Failed to fetch sections")
 	}
 
-	stats := db.GetStats()
+	stats := db.GetStats(userID)
 
 	// Get lists for dropdown
-	lists, _ := db.GetAllLists()
-	activeList, _ := db.GetActiveList()
+	lists, _ := db.GetAllLists(userID)
+	activeList, _ := db.GetActiveList(userID)
 
 	return c.Render("list", fiber.Map{
 		"Sections":     sections,
 		"Stats":        stats,
 		"Lists":        lists,
 		"ActiveList":   activeList,
+		"User":         CurrentUser(c),
 		"Translations": i18n.GetAllLocales(),
 		"Locales":      i18n.AvailableLocales(),
 		"DefaultLang":  i18n.GetDefaultLang(),
 	})
 }
 
-// CreateSection creates a new section
+// CreateSection creates a new section in the active list
 func CreateSection(c *fiber.Ctx) error {
 	name := c.FormValue("name")
 	if name == "" {
@@ -42,18 +44,28 @@ func CreateSection(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Name too long (max 100 characters)")
 	}
 
-	section, err := db.CreateSection(name)
+	userID := CurrentUser(c).ID
+	list, err := db.GetActiveList(userID)
+	if err != nil {
+		return accessDenied(c, err, "List")
+	}
+	if err := db.CheckListAccess(userID, list.ID, db.AccessWrite); err != nil {
+		return accessDenied(c, err, "List")
+	}
+
+	section, err := db.CreateSectionForList(list.ID, name)
 	if err != nil {
 		return c.Status(500).SendString("Failed to create section")
 	}
+	RecordEvent(c, list.ID, db.EventCreated, db.EntitySection, section.ID, nil, section)
 
 	// Broadcast to WebSocket clients
-	BroadcastUpdate("section_created", section)
+	BroadcastListUpdate(list.ID, "section_created", section)
 
 	// Return the new section partial for HTMX
 	return c.Render("partials/section", fiber.Map{
 		"Section":  section,
-		"Sections": getSectionsForDropdown(),
+		"Sections": getSectionsForDropdown(c),
 	}, "")
 }
 
@@ -64,6 +76,11 @@ func UpdateSection(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Invalid ID")
 	}
 
+	listID, err := db.CheckSectionAccess(CurrentUser(c).ID, id, db.AccessWrite)
+	if err != nil {
+		return accessDenied(c, err, "Section")
+	}
+
 	name := c.FormValue("name")
 	if name == "" {
 		return c.Status(400).SendString("Name is required")
@@ -72,18 +89,20 @@ func UpdateSection(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Name too long (max 100 characters)")
 	}
 
+	before, _ := db.GetSectionByID(id)
 	section, err := db.UpdateSection(id, name)
 	if err != nil {
 		return c.Status(500).SendString("Failed to update section")
 	}
+	RecordEvent(c, listID, db.EventUpdated, db.EntitySection, id, before, section)
 
 	// Broadcast to WebSocket clients
-	BroadcastUpdate("section_updated", section)
+	BroadcastListUpdate(listID, "section_updated", section)
 
 	// Return updated section partial
 	return c.Render("partials/section", fiber.Map{
 		"Section":  section,
-		"Sections": getSectionsForDropdown(),
+		"Sections": getSectionsForDropdown(c),
 	}, "")
 }
 
@@ -94,13 +113,20 @@ func DeleteSection(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Invalid ID")
 	}
 
+	listID, err := db.CheckSectionAccess(CurrentUser(c).ID, id, db.AccessWrite)
+	if err != nil {
+		return accessDenied(c, err, "Section")
+	}
+
+	before, _ := db.GetSectionByID(id)
 	err = db.DeleteSection(id)
 	if err != nil {
 		return c.Status(500).SendString("Failed to delete section")
 	}
+	RecordEvent(c, listID, db.EventDeleted, db.EntitySection, id, before, nil)
 
 	// Broadcast to WebSocket clients
-	BroadcastUpdate("section_deleted", map[string]int64{"id": id})
+	BroadcastListUpdate(listID, "section_deleted", before)
 
 	// Return empty string (HTMX will remove the element)
 	return c.SendString("")
@@ -113,13 +139,19 @@ func MoveSectionUp(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Invalid ID")
 	}
 
+	listID, err := db.CheckSectionAccess(CurrentUser(c).ID, id, db.AccessWrite)
+	if err != nil {
+		return accessDenied(c, err, "Section")
+	}
+
 	err = db.MoveSectionUp(id)
 	if err != nil {
 		return c.Status(500).SendString("Failed to move section")
 	}
+	RecordEvent(c, listID, db.EventReordered, db.EntitySection, id, nil, nil)
 
 	// Broadcast and return full sections list
-	BroadcastUpdate("sections_reordered", nil)
+	BroadcastSectionsReordered(listID)
 	return returnAllSections(c)
 }
 
@@ -130,19 +162,25 @@ func MoveSectionDown(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Invalid ID")
 	}
 
+	listID, err := db.CheckSectionAccess(CurrentUser(c).ID, id, db.AccessWrite)
+	if err != nil {
+		return accessDenied(c, err, "Section")
+	}
+
 	err = db.MoveSectionDown(id)
 	if err != nil {
 		return c.Status(500).SendString("Failed to move section")
 	}
+	RecordEvent(c, listID, db.EventReordered, db.EntitySection, id, nil, nil)
 
 	// Broadcast and return full sections list
-	BroadcastUpdate("sections_reordered", nil)
+	BroadcastSectionsReordered(listID)
 	return returnAllSections(c)
 }
 
 // Helper to return all sections as HTML partials
 func returnAllSections(c *fiber.Ctx) error {
-	sections, err := db.GetAllSections()
+	sections, err := db.GetAllSections(CurrentUser(c).ID)
 	if err != nil {
 		return c.Status(500).SendString("Failed to fetch sections")
 	}
@@ -152,9 +190,9 @@ func returnAllSections(c *fiber.Ctx) error {
 	}, "")
 }
 
-// Helper to get sections for dropdown
-func getSectionsForDropdown() []db.Section {
-	sections, _ := db.GetAllSections()
+// Helper to get sections of the user's active list for dropdown
+func getSectionsForDropdown(c *fiber.Ctx) []db.Section {
+	sections, _ := db.GetAllSections(CurrentUser(c).ID)
 	return sections
 }
 
@@ -180,13 +218,37 @@ func BatchDeleteSections(c *fiber.Ctx) error {
 		return c.Status(400).SendString("No valid IDs provided")
 	}
 
+	// Every section has to be writable; group them by list for the broadcasts
+	userID := CurrentUser(c).ID
+	byList := make(map[int64][]int64)
+	before := make(map[int64]*db.Section)
+	for _, id := range ids {
+		listID, err := db.CheckSectionAccess(userID, id, db.AccessWrite)
+		if err != nil {
+			return accessDenied(c, err, "Section")
+		}
+		byList[listID] = append(byList[listID], id)
+		before[id], _ = db.GetSectionByID(id)
+	}
+
 	err := db.DeleteSections(ids)
 	if err != nil {
 		return c.Status(500).SendString("Failed to delete sections")
 	}
+	for listID, listSectionIDs := range byList {
+		for _, id := range listSectionIDs {
+			RecordEvent(c, listID, db.EventDeleted, db.EntitySection, id, before[id], nil)
+		}
+	}
 
 	// Broadcast to WebSocket clients
-	BroadcastUpdate("sections_deleted", map[string]interface{}{"ids": ids})
+	for listID, listSectionIDs := range byList {
+		sections := make([]*db.Section, 0, len(listSectionIDs))
+		for _, id := range listSectionIDs {
+			sections = append(sections, before[id])
+		}
+		BroadcastListUpdate(listID, "sections_deleted", fiber.Map{"ids": listSectionIDs, "sections": sections})
+	}
 
 	// Return updated sections list for modal
 	return returnSectionsForModal(c)
@@ -232,7 +294,7 @@ func trimSpace(s string) string {
 
 // Helper to return sections for modal
 func returnSectionsForModal(c *fiber.Ctx) error {
-	sections, err := db.GetAllSections()
+	sections, err := db.GetAllSections(CurrentUser(c).ID)
 	if err != nil {
 		return c.Status(500).SendString("Failed to fetch sections")
 	}
@@ -246,7 +308,7 @@ func returnSectionsForModal(c *fiber.Ctx) error {
 func GetSectionsListForModal(c *fiber.Ctx) error {
 	// Check if JSON format is requested
 	if c.Query("format") == "json" {
-		sections, err := db.GetAllSections()
+		sections, err := db.GetAllSections(CurrentUser(c).ID)
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch sections"})
 		}
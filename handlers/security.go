@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	CSRFCookieName = "csrf_token"
+	CSRFHeaderName = "X-CSRF-Token"
+	CSRFFormField  = "_csrf" // for plain HTML forms
+	CSRFDuration   = 365 * 24 * time.Hour
+
+	// DefaultHSTSMaxAge is 180 days; HSTS is only sent over HTTPS
+	DefaultHSTSMaxAge = 180 * 24 * 60 * 60
+)
+
+// CDNs the templates load Tailwind, HTMX, Alpine and Sortable from
+const cdnSources = "https://cdn.tailwindcss.com https://unpkg.com https://cdn.jsdelivr.net"
+
+// The default Content-Security-Policy, without frame-ancestors. Alpine needs 'unsafe-eval'
+// and the templates use inline scripts and styles; the service worker caches the CDN files.
+const defaultContentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' 'unsafe-inline' 'unsafe-eval' " + cdnSources + "; " +
+	"style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data:; " +
+	"connect-src 'self' " + cdnSources + "; " +
+	"object-src 'none'; " +
+	"base-uri 'self'"
+
+// SecurityConfig holds the security header settings from environment variables
+type SecurityConfig struct {
+	ContentSecurityPolicy string // "" sends no policy
+	FrameAncestors        string // who may show Koffan in a frame, e.g. a Home Assistant dashboard
+	HSTSMaxAge            int    // seconds, 0 sends no HSTS
+	ReferrerPolicy        string
+	TrustedOrigins        []string // other origins allowed to make changes and open WebSockets
+}
+
+// Defaults, used until InitSecurity runs
+var security = &SecurityConfig{
+	ContentSecurityPolicy: defaultContentSecurityPolicy + "; frame-ancestors 'self'",
+	FrameAncestors:        "'self'",
+	HSTSMaxAge:            DefaultHSTSMaxAge,
+	ReferrerPolicy:        "same-origin",
+}
+
+// InitSecurity reads the security header settings: CONTENT_SECURITY_POLICY (a whole policy,
+// or "off"), FRAME_ANCESTORS, HSTS_MAX_AGE, REFERRER_POLICY and TRUSTED_ORIGINS (comma separated)
+func InitSecurity() {
+	config := &SecurityConfig{
+		FrameAncestors: getEnv("FRAME_ANCESTORS", "'self'"),
+		HSTSMaxAge:     DefaultHSTSMaxAge,
+		ReferrerPolicy: getEnv("REFERRER_POLICY", "same-origin"),
+	}
+
+	switch csp := os.Getenv("CONTENT_SECURITY_POLICY"); csp {
+	case "":
+		config.ContentSecurityPolicy = defaultContentSecurityPolicy + "; frame-ancestors " + config.FrameAncestors
+	case "off":
+	default:
+		config.ContentSecurityPolicy = csp
+	}
+
+	if val := os.Getenv("HSTS_MAX_AGE"); val != "" {
+		maxAge, err := strconv.Atoi(val)
+		if err != nil || maxAge < 0 {
+			log.Fatalf("Invalid HSTS_MAX_AGE %q: must be a number of seconds", val)
+		}
+		config.HSTSMaxAge = maxAge
+	}
+
+	for _, origin := range strings.Split(os.Getenv("TRUSTED_ORIGINS"), ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin == "" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Host == "" {
+			log.Fatalf("Invalid TRUSTED_ORIGINS entry %q: must be like https://example.com", origin)
+		}
+		config.TrustedOrigins = append(config.TrustedOrigins, u.Host)
+	}
+
+	security = config
+}
+
+// SecurityHeaders sets the security headers on every response
+func SecurityHeaders(c *fiber.Ctx) error {
+	c.Set("X-Content-Type-Options", "nosniff")
+	if security.ContentSecurityPolicy != "" {
+		c.Set("Content-Security-Policy", security.ContentSecurityPolicy)
+	}
+	// For browsers without frame-ancestors; other values cannot be expressed in X-Frame-Options
+	switch security.FrameAncestors {
+	case "'self'":
+		c.Set("X-Frame-Options", "SAMEORIGIN")
+	case "'none'":
+		c.Set("X-Frame-Options", "DENY")
+	}
+	if security.ReferrerPolicy != "" {
+		c.Set("Referrer-Policy", security.ReferrerPolicy)
+	}
+	if security.HSTSMaxAge > 0 && isSecureConnection(c) {
+		c.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(security.HSTSMaxAge))
+	}
+	return c.Next()
+}
+
+// IsSameOrigin reports whether a request comes from a page of this site (or a trusted origin).
+// Browsers send Origin with every WebSocket handshake and cross-site request; requests without
+// it come from other clients, which cannot be made to carry the user's cookies.
+func IsSameOrigin(c *fiber.Ctx) bool {
+	origin := c.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false // includes "null" from sandboxed frames and file:// pages
+	}
+	if u.Host == c.Hostname() {
+		return true
+	}
+	for _, trusted := range security.TrustedOrigins {
+		if u.Host == trusted {
+			return true
+		}
+	}
+	return false
+}
+
+// CSRFMiddleware protects the routes behind AuthMiddleware from cross-site requests.
+// Each browser gets a random token in a cookie; pages send it back in the X-CSRF-Token
+// header (or a _csrf form field), which another site cannot do because it cannot read it.
+// The token is passed to the templates as CSRFToken.
+func CSRFMiddleware(c *fiber.Ctx) error {
+	token := c.Cookies(CSRFCookieName)
+	if len(token) != 64 {
+		token = generateSessionID()
+		c.Cookie(&fiber.Cookie{
+			Name:     CSRFCookieName,
+			Value:    token,
+			Expires:  time.Now().Add(CSRFDuration),
+			HTTPOnly: true,
+			Secure:   isSecureConnection(c),
+			SameSite: "Lax",
+			Path:     "/",
+		})
+	}
+	c.Bind(fiber.Map{"CSRFToken": token})
+
+	switch c.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return c.Next()
+	}
+
+	sent := c.Get(CSRFHeaderName)
+	if sent == "" {
+		sent = c.FormValue(CSRFFormField)
+	}
+	if !IsSameOrigin(c) || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+		log.Printf("[AUTH] Rejected %s %s: missing or invalid CSRF token (origin %q)", c.Method(), c.Path(), c.Get("Origin"))
+		if strings.HasPrefix(c.Path(), "/api/") {
+			return c.Status(403).JSON(fiber.Map{"error": "Invalid CSRF token, reload the page"})
+		}
+		return c.Status(403).SendString("Invalid CSRF token, reload the page")
+	}
+	return c.Next()
+}
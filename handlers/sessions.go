@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"shopping-list/db"
+	"shopping-list/i18n"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	MaxDeviceNameLength = 100
+	MaxUserAgentLength  = 300
+)
+
+// Browsers and systems recognised in user agents, checked in order
+// (Edge and Opera also claim to be Chrome, Chrome claims to be Safari)
+var (
+	userAgentBrowsers = []struct{ token, name string }{
+		{"Edg", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"FxiOS/", "Firefox"},
+		{"CriOS/", "Chrome"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+	}
+	userAgentSystems = []struct{ token, name string }{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"CrOS", "ChromeOS"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// DeviceName derives a name like "Firefox on Windows" from a user agent,
+// or returns "" if the user agent is not recognised
+func DeviceName(userAgent string) string {
+	var browser, system string
+	for _, b := range userAgentBrowsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, s := range userAgentSystems {
+		if strings.Contains(userAgent, s.token) {
+			system = s.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	default:
+		return system
+	}
+}
+
+// truncate shortens s to at most max characters
+func truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max])
+}
+
+// CurrentSessionID returns the ID of the session set by AuthMiddleware, or ""
+// when the request is not made with a session (API tokens, DISABLE_AUTH)
+func CurrentSessionID(c *fiber.Ctx) string {
+	id, _ := c.Locals("session_id").(string)
+	return id
+}
+
+// userSessions returns the logged in user's sessions with the current one marked
+func userSessions(c *fiber.Ctx) ([]db.Session, error) {
+	sessions, err := db.GetUserSessions(CurrentUser(c).ID)
+	if err != nil {
+		return nil, err
+	}
+	current := CurrentSessionID(c)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+	return sessions, nil
+}
+
+// RevokeOtherSessions logs a user out of every session except keepID ("" for all of them)
+// and closes their WebSockets. It returns the number of sessions ended.
+func RevokeOtherSessions(userID int64, keepID string) (int, error) {
+	ids, err := db.DeleteOtherSessions(userID, keepID)
+	if err != nil {
+		return 0, err
+	}
+	CloseSessionConnections(ids)
+	return len(ids), nil
+}
+
+// DevicesPage lists the devices the user is logged in on
+func DevicesPage(c *fiber.Ctx) error {
+	sessions, err := userSessions(c)
+	if err != nil {
+		return c.Status(500).SendString("Failed to fetch devices")
+	}
+
+	return c.Render("devices", fiber.Map{
+		"Sessions":     sessions,
+		"User":         CurrentUser(c),
+		"Translations": i18n.GetAllLocales(),
+		"Locales":      i18n.AvailableLocales(),
+		"DefaultLang":  i18n.GetDefaultLang(),
+	})
+}
+
+// RenameSession changes the name of one of the user's devices
+func RenameSession(c *fiber.Ctx) error {
+	session, err := db.GetUserSessionByKey(CurrentUser(c).ID, c.Params("id"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).SendString("Device not found")
+		}
+		return c.Status(500).SendString("Failed to rename device")
+	}
+
+	name := strings.TrimSpace(c.FormValue("name"))
+	if utf8.RuneCountInString(name) > MaxDeviceNameLength {
+		return c.Status(400).SendString("Name too long")
+	}
+
+	if err := db.RenameSession(session.ID, name); err != nil {
+		return c.Status(500).SendString("Failed to rename device")
+	}
+	session.Name = name
+	session.Current = session.ID == CurrentSessionID(c)
+
+	return c.Render("partials/session_item", session, "")
+}
+
+// RevokeSession logs one of the user's devices out. Revoking the current session is a logout.
+func RevokeSession(c *fiber.Ctx) error {
+	session, err := db.GetUserSessionByKey(CurrentUser(c).ID, c.Params("id"))
+	if err == nil {
+		err = db.DeleteSession(session.ID)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).SendString("Device not found")
+		}
+		return c.Status(500).SendString("Failed to log out device")
+	}
+	CloseSessionConnections([]string{session.ID})
+	RecordEvent(c, 0, db.EventDeleted, db.EntitySession, 0, session, nil)
+	log.Printf("[AUTH] Session %s... revoked by %s", session.ID[:8], CurrentUser(c).Username)
+
+	if session.ID == CurrentSessionID(c) {
+		clearCookie(c, SessionCookieName)
+		c.Set("HX-Redirect", "/login")
+	}
+	return c.SendString("")
+}
+
+// RevokeAllOtherSessions logs the user out on every device except this one
+// and returns the remaining device
+func RevokeAllOtherSessions(c *fiber.Ctx) error {
+	user := CurrentUser(c)
+	current := CurrentSessionID(c)
+	count, err := RevokeOtherSessions(user.ID, current)
+	if err != nil {
+		return c.Status(500).SendString("Failed to log out devices")
+	}
+	if count > 0 {
+		RecordEvent(c, 0, db.EventDeleted, db.EntitySession, 0, fiber.Map{"sessions": count}, nil)
+		log.Printf("[AUTH] %d other sessions revoked by %s", count, user.Username)
+	}
+
+	if current == "" {
+		return c.SendString("")
+	}
+	session, err := db.GetSession(current)
+	if err != nil {
+		return c.Status(500).SendString("Failed to fetch devices")
+	}
+	session.Current = true
+	return c.Render("partials/session_item", session, "")
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"shopping-list/db"
+	"shopping-list/i18n"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	MaxShareLinkNameLength = 100
+	MaxShareLinkDays       = 365
+	DefaultShareLinkDays   = 7
+)
+
+// ShareView tells the list templates they are rendered for a share link:
+// edit controls are left out and item toggles go through URL
+type ShareView struct {
+	URL      string // "/share/<token>"
+	CanCheck bool
+}
+
+// ShareLinkMiddleware loads the share link named in the URL for the public share routes
+func ShareLinkMiddleware(c *fiber.Ctx) error {
+	link, err := db.GetShareLinkByToken(c.Params("token"))
+	if err != nil {
+		if err == sql.ErrNoRows || err == db.ErrShareLinkExpired {
+			return c.Status(404).SendString("This link is invalid or has expired")
+		}
+		log.Printf("Failed to look up share link: %v", err)
+		return c.Status(500).SendString("Database error")
+	}
+
+	// The token is in the URL, keep it out of referrers and search engines
+	c.Set("Referrer-Policy", "no-referrer")
+	c.Set("X-Robots-Tag", "noindex")
+
+	c.Locals("share_link", link)
+	return c.Next()
+}
+
+// currentShareLink returns the share link set by ShareLinkMiddleware
+func currentShareLink(c *fiber.Ctx) *db.ShareLink {
+	link, _ := c.Locals("share_link").(*db.ShareLink)
+	return link
+}
+
+func shareView(c *fiber.Ctx) ShareView {
+	return ShareView{
+		URL:      "/share/" + c.Params("token"),
+		CanCheck: currentShareLink(c).CanCheck,
+	}
+}
+
+// SharedListView renders a list for a share link, read-only or with only checking off allowed
+func SharedListView(c *fiber.Ctx) error {
+	link := currentShareLink(c)
+
+	// Only owners create share links, so the list is looked up as its owner
+	list, err := db.GetListByID(link.CreatedBy, link.ListID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).SendString("This link is invalid or has expired")
+		}
+		log.Printf("Error fetching shared list %d: %v", link.ListID, err)
+		return c.Status(500).SendString("Database error")
+	}
+
+	sections, err := db.GetSectionsByList(list.ID)
+	if err != nil {
+		return c.Status(500).SendString("Failed to fetch sections")
+	}
+
+	return c.Render("list", fiber.Map{
+		"List":         list,
+		"Sections":     sections,
+		"Stats":        db.GetListStats(list.ID),
+		"Share":        shareView(c),
+		"Translations": i18n.GetAllLocales(),
+		"Locales":      i18n.AvailableLocales(),
+		"DefaultLang":  i18n.GetDefaultLang(),
+	})
+}
+
+// SharedListStats returns the stats of a shared list as JSON
+func SharedListStats(c *fiber.Ctx) error {
+	return c.JSON(db.GetListStats(currentShareLink(c).ListID))
+}
+
+// SharedToggleItem checks an item on a shared list off, or back on, for links that allow it
+func SharedToggleItem(c *fiber.Ctx) error {
+	link := currentShareLink(c)
+	if !link.CanCheck {
+		return c.Status(403).SendString("This link does not allow checking off items")
+	}
+
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(400).SendString("Invalid ID")
+	}
+	listID, err := db.GetItemListID(id)
+	if err == nil && listID != link.ListID {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).SendString("Item not found")
+		}
+		return c.Status(500).SendString("Database error")
+	}
+
+	// Nobody is logged in, so the change is not attributed to a user
+	before, _ := db.GetItemByID(id)
+	item, err := db.ToggleItemCompleted(0, id)
+	if err != nil {
+		return c.Status(500).SendString("Failed to toggle item")
+	}
+	recordEvent(0, listID, CompletionAction(item), db.EntityItem, id, before, item)
+
+	BroadcastListUpdate(listID, "item_toggled", item)
+
+	if item.Completed {
+		return c.Render("partials/item_completed", fiber.Map{
+			"Item":  item,
+			"Share": shareView(c),
+		}, "")
+	}
+	return c.Render("partials/item", fiber.Map{
+		"Item":  item,
+		"Share": shareView(c),
+	}, "")
+}
+
+// GetShareLinks returns the unexpired share links of a list
+func GetShareLinks(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	if err := db.CheckListAccess(CurrentUser(c).ID, id, db.AccessOwner); err != nil {
+		return accessDeniedJSON(c, err, "List")
+	}
+
+	links, err := db.GetShareLinks(id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch share links"})
+	}
+	if links == nil {
+		links = []db.ShareLink{}
+	}
+	return c.JSON(links)
+}
+
+// CreateShareLink creates a share link to a list. Form fields: name, can_check and
+// expires_in_days (1 to 365, default 7), all optional. The link's URL is only shown in this response.
+func CreateShareLink(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	user := CurrentUser(c)
+	if err := db.CheckListAccess(user.ID, id, db.AccessOwner); err != nil {
+		return accessDeniedJSON(c, err, "List")
+	}
+
+	name := strings.TrimSpace(c.FormValue("name"))
+	if utf8.RuneCountInString(name) > MaxShareLinkNameLength {
+		return c.Status(400).JSON(fiber.Map{"error": "Name too long"})
+	}
+	days, err := strconv.Atoi(c.FormValue("expires_in_days", strconv.Itoa(DefaultShareLinkDays)))
+	if err != nil || days < 1 || days > MaxShareLinkDays {
+		return c.Status(400).JSON(fiber.Map{"error": "Expiry must be between 1 and 365 days"})
+	}
+	canCheck := c.FormValue("can_check") == "true" || c.FormValue("can_check") == "on"
+
+	link, token, err := db.CreateShareLink(id, user.ID, name, canCheck, time.Now().AddDate(0, 0, days).Unix())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create share link"})
+	}
+	RecordEvent(c, id, db.EventCreated, db.EntityShareLink, link.ID, nil, link)
+
+	return c.Status(201).JSON(fiber.Map{
+		"link": link,
+		"url":  "/share/" + token,
+	})
+}
+
+// DeleteShareLink revokes a share link and disconnects anyone viewing the list through it
+func DeleteShareLink(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+	linkID, err := strconv.ParseInt(c.Params("linkId"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid link ID"})
+	}
+
+	if err := db.CheckListAccess(CurrentUser(c).ID, id, db.AccessOwner); err != nil {
+		return accessDeniedJSON(c, err, "List")
+	}
+
+	link, err := db.GetShareLinkByID(linkID)
+	if err == nil && link.ListID != id {
+		err = sql.ErrNoRows
+	}
+	if err == nil {
+		err = db.DeleteShareLink(linkID)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "Share link not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to revoke share link"})
+	}
+	CloseShareLinkConnections(linkID)
+	RecordEvent(c, id, db.EventDeleted, db.EntityShareLink, linkID, link, nil)
+
+	return c.SendStatus(204)
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"shopping-list/db"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// accessDenied responds to a failed list access check with a plain text error (for HTMX).
+// what names the thing that was looked up, e.g. "List" or "Item".
+func accessDenied(c *fiber.Ctx, err error, what string) error {
+	switch {
+	case err == sql.ErrNoRows:
+		return c.Status(404).SendString(what + " not found")
+	case errors.Is(err, db.ErrForbidden):
+		return c.Status(403).SendString("You don't have permission to change this list")
+	}
+	log.Printf("List access check failed: %v", err)
+	return c.Status(500).SendString("Database error")
+}
+
+// accessDeniedJSON is accessDenied for JSON endpoints
+func accessDeniedJSON(c *fiber.Ctx, err error, what string) error {
+	switch {
+	case err == sql.ErrNoRows:
+		return c.Status(404).JSON(fiber.Map{"error": what + " not found"})
+	case errors.Is(err, db.ErrForbidden):
+		return c.Status(403).JSON(fiber.Map{"error": "You don't have permission to change this list"})
+	}
+	log.Printf("List access check failed: %v", err)
+	return c.Status(500).JSON(fiber.Map{"error": "Database error"})
+}
+
+// GetListMembers returns the owner of a list and the users it is shared with
+func GetListMembers(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	if err := db.CheckListAccess(CurrentUser(c).ID, id, db.AccessRead); err != nil {
+		return accessDeniedJSON(c, err, "List")
+	}
+
+	members, err := db.GetListMembers(id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch members"})
+	}
+	return c.JSON(members)
+}
+
+// ShareList shares a list with a user as editor or viewer. Sharing again changes the role.
+func ShareList(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	if err := db.CheckListAccess(CurrentUser(c).ID, id, db.AccessOwner); err != nil {
+		return accessDeniedJSON(c, err, "List")
+	}
+
+	role := c.FormValue("role", db.RoleEditor)
+	if role != db.RoleEditor && role != db.RoleViewer {
+		return c.Status(400).JSON(fiber.Map{"error": "Role must be editor or viewer"})
+	}
+
+	user, err := db.GetUserByUsername(strings.TrimSpace(c.FormValue("username")))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch user"})
+	}
+
+	if err := db.ShareList(id, user.ID, role); err != nil {
+		if errors.Is(err, db.ErrForbidden) {
+			return c.Status(400).JSON(fiber.Map{"error": "You already own this list"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to share list"})
+	}
+	RecordEvent(c, id, db.EventShared, db.EntityMember, user.ID, nil, fiber.Map{"username": user.Username, "role": role})
+
+	// The new member's clients load the list, the others refresh its members
+	if list, err := db.GetListByID(user.ID, id); err == nil {
+		BroadcastToUsers([]int64{user.ID}, id, "list_created", list)
+	}
+
+	members, err := db.GetListMembers(id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch members"})
+	}
+	BroadcastListUpdate(id, "list_members_updated", members)
+	return c.JSON(members)
+}
+
+// UnshareList removes a user from a list. The owner can remove anyone; members can leave.
+func UnshareList(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+	userID, err := strconv.ParseInt(c.Params("userId"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	need := db.AccessOwner
+	if userID == CurrentUser(c).ID {
+		need = db.AccessRead
+	}
+	if err := db.CheckListAccess(CurrentUser(c).ID, id, need); err != nil {
+		return accessDeniedJSON(c, err, "List")
+	}
+
+	if err := db.UnshareList(id, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "User is not a member of this list"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to remove member"})
+	}
+	RecordEvent(c, id, db.EventUnshared, db.EntityMember, userID, nil, nil)
+
+	// For the removed user the list is gone
+	BroadcastToUsers([]int64{userID}, id, "list_deleted", fiber.Map{"id": id})
+	if members, err := db.GetListMembers(id); err == nil {
+		BroadcastListUpdate(id, "list_members_updated", members)
+	}
+
+	return c.JSON(fiber.Map{"success": true})
+}
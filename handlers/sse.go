@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"shopping-list/db"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Timing and limits of Server-Sent Events streams
+const (
+	sseHeartbeatPeriod = 30 * time.Second // keeps proxies from closing idle streams
+	sseSendQueueSize   = 64               // events waiting for a slow client
+)
+
+// sseClient is a Server-Sent Events stream opened with an API token
+type sseClient struct {
+	userID    int64
+	token     *db.APIToken
+	lists     map[int64]bool // lists the stream is limited to, nil for all of the user's changes
+	send      chan []byte
+	done      chan struct{} // closed when the stream should end
+	closeOnce sync.Once
+	replay    replay
+}
+
+// receives reports whether the stream gets a change sent to the given users, or to every
+// user if users is nil. Streams limited to lists only get changes about those lists.
+func (s *sseClient) receives(scope string, users map[int64]bool, listID int64) bool {
+	if s.lists != nil && !s.lists[listID] {
+		return false
+	}
+	return users == nil || users[s.userID]
+}
+
+// deliver queues a change as an event without blocking. When the queue is full the
+// slow client policy applies; a disconnected client can resume with Last-Event-ID.
+func (s *sseClient) deliver(change db.Change, message []byte) bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+
+	if s.replay.hold(change, message) {
+		return true
+	}
+
+	select {
+	case s.send <- sseEvent(change.Seq, change.Type, message):
+		return true
+	default:
+	}
+
+	if slowClientPolicy == SlowClientDrop {
+		log.Printf("Event stream of user %d is too slow, dropping event", s.userID)
+	} else {
+		log.Printf("Event stream of user %d is too slow, disconnecting", s.userID)
+		s.close()
+	}
+	return false
+}
+
+func (s *sseClient) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// tokenValid reports whether the stream's token is still there and unexpired
+func (s *sseClient) tokenValid() bool {
+	if s.token.ExpiresAt != 0 && s.token.ExpiresAt < time.Now().Unix() {
+		return false
+	}
+	if s.token.ID == 0 {
+		return true // the API_TOKEN env var
+	}
+	_, err := db.GetAPITokenByID(s.token.ID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to look up API token %d: %v", s.token.ID, err)
+		return true
+	}
+	return err == nil
+}
+
+// sseEvent formats an event; changes without a sequence number get no ID
+func sseEvent(seq int64, eventType string, data []byte) []byte {
+	if seq == 0 {
+		return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", eventType, data))
+	}
+	return []byte(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", seq, eventType, data))
+}
+
+// resyncEvent tells the client to load everything again, continuing after seq
+func resyncEvent(seq int64) []byte {
+	data, _ := json.Marshal(WebSocketMessage{Type: "resync_required", Data: fiber.Map{"seq": seq}})
+	return sseEvent(seq, "resync_required", data)
+}
+
+// StreamEvents sends the changes the token's user may see as Server-Sent Events, limited
+// to listIDs unless it is empty. Each event's data is the same message WebSocket clients
+// get. With lastEventID the changes missed since are sent first, or a resync_required
+// event if they are no longer kept. The stream ends when the token is revoked or expires.
+func StreamEvents(c *fiber.Ctx, token *db.APIToken, listIDs []int64, lastEventID string) error {
+	client := &sseClient{
+		userID: token.UserID,
+		token:  token,
+		send:   make(chan []byte, sseSendQueueSize),
+		done:   make(chan struct{}),
+	}
+	if len(listIDs) > 0 {
+		client.lists = make(map[int64]bool, len(listIDs))
+		for _, id := range listIDs {
+			client.lists[id] = true
+		}
+	}
+
+	// Register client; with lastEventID, changes are held back while the missed ones are loaded
+	var latest int64
+	subscribersMu.Lock()
+	if lastEventID != "" {
+		eventSeqMu.Lock()
+		latest = eventSeq
+		client.replay.start()
+		eventSeqMu.Unlock()
+	}
+	subscribers[client] = true
+	count := len(subscribers)
+	subscribersMu.Unlock()
+
+	// Events sent before the queued ones: the missed changes, or resync_required
+	var first [][]byte
+	if lastEventID != "" {
+		var missed []db.Change
+		seq, err := strconv.ParseInt(lastEventID, 10, 64)
+		ok := err == nil
+		if ok {
+			missed, ok = missedChanges(client, seq, latest)
+		}
+		client.replay.finish(func(held []heldChange, lastSeq int64, dropped bool, queued map[int64]bool) {
+			switch {
+			case dropped:
+				first = append(first, resyncEvent(lastSeq))
+				return
+			case !ok:
+				first = append(first, resyncEvent(latest))
+			default:
+				for _, change := range missed {
+					if message, err := changeMessage(change); err == nil {
+						first = append(first, sseEvent(change.Seq, change.Type, message))
+					}
+				}
+			}
+			for _, h := range held {
+				first = append(first, sseEvent(h.change.Seq, h.change.Type, h.message))
+			}
+		})
+	}
+
+	log.Printf("Event stream of user %d opened. Total clients: %d", client.userID, count)
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no") // nginx would hold the events back
+
+	// The writer runs after the handler has returned, until writing fails or the stream ends
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ticker := time.NewTicker(sseHeartbeatPeriod)
+		defer func() {
+			ticker.Stop()
+			// Unregister client
+			subscribersMu.Lock()
+			delete(subscribers, client)
+			count := len(subscribers)
+			subscribersMu.Unlock()
+			client.close()
+			log.Printf("Event stream of user %d closed. Total clients: %d", client.userID, count)
+		}()
+
+		// Browsers wait this long before reconnecting
+		w.WriteString("retry: 3000\n\n")
+		for _, event := range first {
+			w.Write(event)
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		for {
+			select {
+			case event := <-client.send:
+				w.Write(event)
+			case <-ticker.C:
+				if !client.tokenValid() {
+					return
+				}
+				w.WriteString(": heartbeat\n\n")
+			case <-client.done:
+				return
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
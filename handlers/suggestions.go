@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"database/sql"
 	"shopping-list/db"
 	"strconv"
 	"strings"
@@ -20,9 +21,20 @@ func GetSuggestions(c *fiber.Ctx) error {
 		limit = 100 // Cap at reasonable maximum
 	}
 
+	// Sections are resolved for the list being edited (default: active list)
+	userID := CurrentUser(c).ID
+	listID := int64(c.QueryInt("list_id", 0))
+	if listID <= 0 {
+		if activeList, err := db.GetActiveList(userID); err == nil {
+			listID = activeList.ID
+		}
+	} else if err := db.CheckListAccess(userID, listID, db.AccessRead); err != nil {
+		return accessDeniedJSON(c, err, "List")
+	}
+
 	// If no query, return all suggestions (for offline cache)
 	if query == "" {
-		suggestions, err := db.GetAllItemSuggestions(limit)
+		suggestions, err := db.GetAllItemSuggestions(userID, listID, limit)
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch suggestions"})
 		}
@@ -32,7 +44,7 @@ func GetSuggestions(c *fiber.Ctx) error {
 		return c.JSON(suggestions)
 	}
 
-	suggestions, err := db.GetItemSuggestions(query, limit)
+	suggestions, err := db.GetItemSuggestions(userID, query, listID, limit)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch suggestions"})
 	}
@@ -44,9 +56,40 @@ func GetSuggestions(c *fiber.Ctx) error {
 	return c.JSON(suggestions)
 }
 
+// GetDueSuggestions returns items that are probably running out for a list (default: active list)
+func GetDueSuggestions(c *fiber.Ctx) error {
+	userID := CurrentUser(c).ID
+	listID := int64(c.QueryInt("list_id", 0))
+	if listID <= 0 {
+		activeList, err := db.GetActiveList(userID)
+		if err != nil {
+			return c.JSON([]db.DueSuggestion{})
+		}
+		listID = activeList.ID
+	} else if err := db.CheckListAccess(userID, listID, db.AccessRead); err != nil {
+		return accessDeniedJSON(c, err, "List")
+	}
+
+	limit := c.QueryInt("limit", 10)
+	if limit <= 0 || limit > 50 {
+		limit = 10
+	}
+
+	suggestions, err := db.GetDueSuggestions(userID, listID, limit)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch suggestions"})
+	}
+
+	if suggestions == nil {
+		suggestions = []db.DueSuggestion{}
+	}
+
+	return c.JSON(suggestions)
+}
+
 // GetHistory returns all history items for management UI
 func GetHistory(c *fiber.Ctx) error {
-	items, err := db.GetItemHistoryList()
+	items, err := db.GetItemHistoryList(CurrentUser(c).ID)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch history"})
 	}
@@ -65,24 +108,28 @@ func DeleteHistoryItem(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid ID"})
 	}
 
-	err = db.DeleteItemHistory(id)
+	userID := CurrentUser(c).ID
+	before, _ := db.GetHistoryItemByID(userID, id)
+	err = db.DeleteItemHistory(userID, id)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete history item"})
+		return c.Status(404).JSON(fiber.Map{"error": "History item not found"})
 	}
+	RecordEvent(c, 0, db.EventDeleted, db.EntityHistory, id, before, nil)
 
 	return c.JSON(fiber.Map{"success": true})
 }
 
-// BatchDeleteHistory deletes multiple items from history
-func BatchDeleteHistory(c *fiber.Ctx) error {
+// parseHistoryIDs reads the comma-separated "ids" form value of the history endpoints.
+// On failure it returns the error message for the client.
+func parseHistoryIDs(c *fiber.Ctx) ([]int64, string) {
 	idsStr := c.FormValue("ids")
 	if idsStr == "" {
-		return c.Status(400).JSON(fiber.Map{"error": "No IDs provided"})
+		return nil, "No IDs provided"
 	}
 
 	idStrings := strings.Split(idsStr, ",")
 	if len(idStrings) > 100 {
-		return c.Status(400).JSON(fiber.Map{"error": "Too many IDs (max 100)"})
+		return nil, "Too many IDs (max 100)"
 	}
 	ids := make([]int64, 0, len(idStrings))
 
@@ -95,13 +142,147 @@ func BatchDeleteHistory(c *fiber.Ctx) error {
 	}
 
 	if len(ids) == 0 {
-		return c.Status(400).JSON(fiber.Map{"error": "No valid IDs provided"})
+		return nil, "No valid IDs provided"
 	}
+	return ids, ""
+}
 
-	deleted, err := db.DeleteItemHistoryBatch(ids)
+// BatchDeleteHistory deletes multiple items from history
+func BatchDeleteHistory(c *fiber.Ctx) error {
+	ids, msg := parseHistoryIDs(c)
+	if msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+
+	deleted, err := db.DeleteItemHistoryBatch(CurrentUser(c).ID, ids)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete history items"})
 	}
+	for _, id := range ids {
+		RecordEvent(c, 0, db.EventDeleted, db.EntityHistory, id, nil, nil)
+	}
 
 	return c.JSON(fiber.Map{"deleted": deleted})
 }
+
+// MergeHistoryItems folds the history items in "ids" into the item in the URL
+func MergeHistoryItems(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	ids, msg := parseHistoryIDs(c)
+	if msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+
+	item, err := db.MergeItemHistory(CurrentUser(c).ID, id, ids)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "History item not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to merge history items"})
+	}
+	RecordEvent(c, 0, db.EventMerged, db.EntityHistory, id, fiber.Map{"merged_ids": ids}, item)
+
+	return c.JSON(item)
+}
+
+// GetHistoryDuplicates returns proposed merges of near-duplicate history items
+func GetHistoryDuplicates(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	groups, err := db.FindHistoryDuplicates(CurrentUser(c).ID, limit)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to find duplicates"})
+	}
+
+	if groups == nil {
+		groups = []db.DuplicateGroup{}
+	}
+
+	return c.JSON(groups)
+}
+
+// AddHistoryAlias adds another spelling to a history item
+func AddHistoryAlias(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	alias := strings.TrimSpace(c.FormValue("alias"))
+	if alias == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Alias is required"})
+	}
+
+	userID := CurrentUser(c).ID
+	before, _ := db.GetHistoryItemByID(userID, id)
+	item, err := db.AddHistoryAlias(userID, id, alias)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "History item not found"})
+		}
+		if err == db.ErrAliasConflict {
+			return c.Status(409).JSON(fiber.Map{"error": "Alias is already in use, merge the items instead"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to add alias"})
+	}
+	RecordEvent(c, 0, db.EventUpdated, db.EntityHistory, id, before, item)
+
+	return c.JSON(item)
+}
+
+// DeleteHistoryAlias removes a spelling from a history item
+func DeleteHistoryAlias(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	aliasID, err := strconv.ParseInt(c.Params("aliasId"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid alias ID"})
+	}
+
+	userID := CurrentUser(c).ID
+	before, _ := db.GetHistoryItemByID(userID, id)
+	if err := db.DeleteHistoryAlias(userID, id, aliasID); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "Alias not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete alias"})
+	}
+	after, _ := db.GetHistoryItemByID(userID, id)
+	RecordEvent(c, 0, db.EventUpdated, db.EntityHistory, id, before, after)
+
+	return c.JSON(fiber.Map{"success": true})
+}
+
+// GetPriceHistory returns recorded paid prices for an item name
+func GetPriceHistory(c *fiber.Ctx) error {
+	name := strings.TrimSpace(c.Query("name"))
+	if name == "" {
+		return c.JSON([]db.PricePoint{})
+	}
+
+	limit := c.QueryInt("limit", 10)
+	if limit <= 0 || limit > 100 {
+		limit = 10
+	}
+
+	prices, err := db.GetPriceHistory(CurrentUser(c).ID, name, limit)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch price history"})
+	}
+
+	if prices == nil {
+		prices = []db.PricePoint{}
+	}
+
+	return c.JSON(prices)
+}
@@ -1,15 +1,18 @@
 package handlers
 
 import (
+	"database/sql"
 	"shopping-list/db"
+	"shopping-list/parser"
 	"strconv"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-// GetTemplates returns all templates
+// GetTemplates returns the current user's templates
 func GetTemplates(c *fiber.Ctx) error {
-	templates, err := db.GetAllTemplates()
+	templates, err := db.GetTemplates(CurrentUser(c).ID)
 	if err != nil {
 		return c.Status(500).SendString("Failed to fetch templates")
 	}
@@ -31,6 +34,10 @@ func GetTemplate(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Invalid ID")
 	}
 
+	if err := db.CheckTemplateAccess(CurrentUser(c).ID, id); err != nil {
+		return accessDenied(c, err, "Template")
+	}
+
 	template, err := db.GetTemplateByID(id)
 	if err != nil {
 		return c.Status(404).SendString("Template not found")
@@ -55,13 +62,15 @@ func CreateTemplate(c *fiber.Ctx) error {
 
 	description := c.FormValue("description")
 
-	template, err := db.CreateTemplate(name, description)
+	userID := CurrentUser(c).ID
+	template, err := db.CreateTemplate(userID, name, description)
 	if err != nil {
 		return c.Status(500).SendString("Failed to create template")
 	}
+	RecordEvent(c, 0, db.EventCreated, db.EntityTemplate, template.ID, nil, template)
 
-	// Broadcast to WebSocket clients
-	BroadcastUpdate("template_created", template)
+	// Templates are private, so only the owner's clients hear about them
+	BroadcastToUsers([]int64{userID}, 0, "template_created", template)
 
 	// Return the new template partial
 	return c.Render("partials/template_item", fiber.Map{
@@ -83,13 +92,19 @@ func UpdateTemplate(c *fiber.Ctx) error {
 
 	description := c.FormValue("description")
 
+	userID := CurrentUser(c).ID
+	if err := db.CheckTemplateAccess(userID, id); err != nil {
+		return accessDenied(c, err, "Template")
+	}
+
+	before, _ := db.GetTemplateByID(id)
 	template, err := db.UpdateTemplate(id, name, description)
 	if err != nil {
 		return c.Status(500).SendString("Failed to update template")
 	}
+	RecordEvent(c, 0, db.EventUpdated, db.EntityTemplate, id, before, template)
 
-	// Broadcast to WebSocket clients
-	BroadcastUpdate("template_updated", template)
+	BroadcastToUsers([]int64{userID}, 0, "template_updated", template)
 
 	// Return updated template partial
 	return c.Render("partials/template_item", fiber.Map{
@@ -104,23 +119,45 @@ func DeleteTemplate(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Invalid ID")
 	}
 
+	userID := CurrentUser(c).ID
+	if err := db.CheckTemplateAccess(userID, id); err != nil {
+		return accessDenied(c, err, "Template")
+	}
+
+	before, _ := db.GetTemplateByID(id)
 	err = db.DeleteTemplate(id)
 	if err != nil {
 		return c.Status(500).SendString("Failed to delete template")
 	}
+	RecordEvent(c, 0, db.EventDeleted, db.EntityTemplate, id, before, nil)
 
-	// Broadcast to WebSocket clients
-	BroadcastUpdate("template_deleted", map[string]int64{"id": id})
+	BroadcastToUsers([]int64{userID}, 0, "template_deleted", before)
 
 	return c.SendString("")
 }
 
+// checkTemplateItemAccess checks that the current user owns the template item's
+// template, and that it is the template in the route
+func checkTemplateItemAccess(c *fiber.Ctx, itemID int64) error {
+	templateID, err := db.CheckTemplateItemAccess(CurrentUser(c).ID, itemID)
+	if err != nil {
+		return err
+	}
+	if strconv.FormatInt(templateID, 10) != c.Params("id") {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 // AddTemplateItem adds an item to a template
 func AddTemplateItem(c *fiber.Ctx) error {
 	templateID, err := strconv.ParseInt(c.Params("id"), 10, 64)
 	if err != nil {
 		return c.Status(400).SendString("Invalid template ID")
 	}
+	if err := db.CheckTemplateAccess(CurrentUser(c).ID, templateID); err != nil {
+		return accessDenied(c, err, "Template")
+	}
 
 	sectionName := c.FormValue("section_name")
 	if sectionName == "" {
@@ -134,10 +171,21 @@ func AddTemplateItem(c *fiber.Ctx) error {
 
 	description := c.FormValue("description")
 
-	item, err := db.AddTemplateItem(templateID, sectionName, name, description)
+	quantity, err := ParseQuantity(c.FormValue("quantity"), 1)
+	if err != nil {
+		return c.Status(400).SendString("Invalid quantity")
+	}
+
+	unit := parser.UnitKey(strings.TrimSpace(c.FormValue("unit")))
+	if len(unit) > MaxUnitLength {
+		return c.Status(400).SendString("Unit too long")
+	}
+
+	item, err := db.AddTemplateItem(templateID, sectionName, name, description, quantity, unit)
 	if err != nil {
 		return c.Status(500).SendString("Failed to add item to template")
 	}
+	RecordEvent(c, 0, db.EventCreated, db.EntityTemplateItem, item.ID, nil, item)
 
 	// Return the template item partial
 	return c.Render("partials/template_item_row", fiber.Map{
@@ -151,6 +199,9 @@ func UpdateTemplateItem(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(400).SendString("Invalid item ID")
 	}
+	if err := checkTemplateItemAccess(c, itemID); err != nil {
+		return accessDenied(c, err, "Template item")
+	}
 
 	sectionName := c.FormValue("section_name")
 	if sectionName == "" {
@@ -164,10 +215,22 @@ func UpdateTemplateItem(c *fiber.Ctx) error {
 
 	description := c.FormValue("description")
 
-	item, err := db.UpdateTemplateItem(itemID, sectionName, name, description)
+	quantity, err := ParseQuantity(c.FormValue("quantity"), 1)
+	if err != nil {
+		return c.Status(400).SendString("Invalid quantity")
+	}
+
+	unit := parser.UnitKey(strings.TrimSpace(c.FormValue("unit")))
+	if len(unit) > MaxUnitLength {
+		return c.Status(400).SendString("Unit too long")
+	}
+
+	before, _ := db.GetTemplateItemByID(itemID)
+	item, err := db.UpdateTemplateItem(itemID, sectionName, name, description, quantity, unit)
 	if err != nil {
 		return c.Status(500).SendString("Failed to update template item")
 	}
+	RecordEvent(c, 0, db.EventUpdated, db.EntityTemplateItem, itemID, before, item)
 
 	return c.Render("partials/template_item_row", fiber.Map{
 		"Item": item,
@@ -180,11 +243,16 @@ func DeleteTemplateItem(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(400).SendString("Invalid item ID")
 	}
+	if err := checkTemplateItemAccess(c, itemID); err != nil {
+		return accessDenied(c, err, "Template item")
+	}
 
+	before, _ := db.GetTemplateItemByID(itemID)
 	err = db.DeleteTemplateItem(itemID)
 	if err != nil {
 		return c.Status(500).SendString("Failed to delete template item")
 	}
+	RecordEvent(c, 0, db.EventDeleted, db.EntityTemplateItem, itemID, before, nil)
 
 	return c.SendString("")
 }
@@ -196,20 +264,30 @@ func ApplyTemplate(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Invalid template ID")
 	}
 
-	activeList, err := db.GetActiveList()
+	userID := CurrentUser(c).ID
+	if err := db.CheckTemplateAccess(userID, templateID); err != nil {
+		return accessDenied(c, err, "Template")
+	}
+	activeList, err := db.GetActiveList(userID)
 	if err != nil {
 		return c.Status(500).SendString("No active list found")
 	}
+	if err := db.CheckListAccess(userID, activeList.ID, db.AccessWrite); err != nil {
+		return accessDenied(c, err, "List")
+	}
 
-	err = db.ApplyTemplateToList(templateID, activeList.ID)
+	err = db.ApplyTemplateToList(userID, templateID, activeList.ID)
 	if err != nil {
 		return c.Status(500).SendString("Failed to apply template")
 	}
+	RecordEvent(c, activeList.ID, db.EventApplied, db.EntityTemplate, templateID, nil, nil)
 
 	// Broadcast to WebSocket clients
-	BroadcastUpdate("template_applied", map[string]interface{}{
+	sections, _ := db.GetSectionsByList(activeList.ID)
+	BroadcastListUpdate(activeList.ID, "template_applied", map[string]interface{}{
 		"template_id": templateID,
 		"list_id":     activeList.ID,
+		"sections":    sections,
 	})
 
 	// Trigger a full refresh
@@ -226,18 +304,22 @@ func CreateTemplateFromList(c *fiber.Ctx) error {
 
 	description := c.FormValue("description")
 
-	activeList, err := db.GetActiveList()
+	userID := CurrentUser(c).ID
+	activeList, err := db.GetActiveList(userID)
 	if err != nil {
 		return c.Status(500).SendString("No active list found")
 	}
+	if err := db.CheckListAccess(userID, activeList.ID, db.AccessRead); err != nil {
+		return accessDenied(c, err, "List")
+	}
 
-	template, err := db.CreateTemplateFromList(activeList.ID, name, description)
+	template, err := db.CreateTemplateFromList(userID, activeList.ID, name, description)
 	if err != nil {
 		return c.Status(500).SendString("Failed to create template from list")
 	}
+	RecordEvent(c, 0, db.EventCreated, db.EntityTemplate, template.ID, nil, template)
 
-	// Broadcast to WebSocket clients
-	BroadcastUpdate("template_created", template)
+	BroadcastToUsers([]int64{userID}, 0, "template_created", template)
 
 	// Return the new template partial
 	return c.Render("partials/template_item", fiber.Map{
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"database/sql"
+	"shopping-list/db"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const MaxTokenNameLength = 100
+
+// ValidateAPIToken checks the settings of a new API token for a user,
+// returning an error message or "" if they are valid
+func ValidateAPIToken(userID int64, name, scope string, listID int64) string {
+	if name == "" || utf8.RuneCountInString(name) > MaxTokenNameLength {
+		return "Name must be between 1 and 100 characters"
+	}
+	if !db.ValidTokenScope(scope) {
+		return "Scope must be full, read or history"
+	}
+	if listID != 0 {
+		if scope == db.TokenScopeHistory {
+			return "History tokens cannot be limited to a list"
+		}
+		if err := db.CheckListAccess(userID, listID, db.AccessRead); err != nil {
+			return "List not found"
+		}
+	}
+	return ""
+}
+
+// GetAPITokens returns the logged in user's API tokens
+func GetAPITokens(c *fiber.Ctx) error {
+	tokens, err := db.GetAPITokens(CurrentUser(c).ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch tokens"})
+	}
+	if tokens == nil {
+		tokens = []db.APIToken{}
+	}
+	return c.JSON(tokens)
+}
+
+// CreateAPIToken mints an API token for the logged in user. Form fields: name,
+// scope (full, read or history), list_id and expires_in_days, all but name optional.
+// The token is only shown in this response.
+func CreateAPIToken(c *fiber.Ctx) error {
+	user := CurrentUser(c)
+	name := strings.TrimSpace(c.FormValue("name"))
+	scope := c.FormValue("scope", db.TokenScopeFull)
+
+	listID, err := strconv.ParseInt(c.FormValue("list_id", "0"), 10, 64)
+	if err != nil || listID < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid list ID"})
+	}
+	days, err := strconv.Atoi(c.FormValue("expires_in_days", "0"))
+	if err != nil || days < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid expiry"})
+	}
+
+	if msg := ValidateAPIToken(user.ID, name, scope, listID); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+
+	var expiresAt int64
+	if days > 0 {
+		expiresAt = time.Now().AddDate(0, 0, days).Unix()
+	}
+
+	token, secret, err := db.CreateAPIToken(user.ID, name, scope, listID, expiresAt)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create token"})
+	}
+	RecordEvent(c, 0, db.EventCreated, db.EntityAPIToken, token.ID, nil, token)
+
+	return c.Status(201).JSON(fiber.Map{
+		"token":  token,
+		"secret": secret,
+	})
+}
+
+// DeleteAPIToken revokes one of the logged in user's API tokens. Admins can revoke any token.
+func DeleteAPIToken(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	user := CurrentUser(c)
+	token, err := db.GetAPITokenByID(id)
+	if err == nil && token.UserID != user.ID && !user.IsAdmin {
+		err = sql.ErrNoRows
+	}
+	if err == nil {
+		err = db.DeleteAPIToken(id)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "Token not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to revoke token"})
+	}
+	RecordEvent(c, 0, db.EventDeleted, db.EntityAPIToken, id, token, nil)
+
+	return c.SendStatus(204)
+}
@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"log"
+	"shopping-list/db"
+	"shopping-list/i18n"
+	"shopping-list/totp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"rsc.io/qr"
+)
+
+const (
+	LoginChallengeCookieName = "login_challenge"
+	TrustedDeviceCookieName  = "trusted_device"
+	PendingLoginDuration     = 5 * time.Minute
+	TrustedDeviceDuration    = 30 * 24 * time.Hour
+	RecoveryCodeCount        = 10
+	TOTPIssuer               = "Koffan"
+)
+
+// generateRecoveryCodes returns new one-time recovery codes like "abcd-efgh-ijkl-mnop"
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		bytes := make([]byte, 10)
+		if _, err := rand.Read(bytes); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(base32.StdEncoding.EncodeToString(bytes))
+		codes[i] = raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+	}
+	return codes, nil
+}
+
+// checkSecondFactor accepts a current authenticator code or an unused recovery code.
+// Each authenticator code and recovery code works only once.
+func checkSecondFactor(user *db.User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return false, nil
+	}
+	if step, ok := totp.Validate(user.TOTPSecret, code, time.Now()); ok {
+		return db.UseTOTPStep(user.ID, step)
+	}
+	return db.UseRecoveryCode(user.ID, code)
+}
+
+// isTrustedDevice reports whether the browser was remembered by the user after a two-factor login
+func isTrustedDevice(c *fiber.Ctx, user *db.User) bool {
+	token := c.Cookies(TrustedDeviceCookieName)
+	if token == "" {
+		return false
+	}
+	trusted, err := db.IsTrustedDevice(user.ID, token)
+	if err != nil {
+		log.Printf("[AUTH] Failed to check trusted device: %v", err)
+	}
+	return trusted
+}
+
+func clearCookie(c *fiber.Ctx, name string) {
+	c.Cookie(&fiber.Cookie{
+		Name:     name,
+		Value:    "",
+		Expires:  time.Now().Add(-time.Hour),
+		HTTPOnly: true,
+		Secure:   isSecureConnection(c),
+		SameSite: "Lax",
+		Path:     "/",
+	})
+}
+
+// startPendingLogin remembers a user who passed the password check until the code is entered
+func startPendingLogin(c *fiber.Ctx, user *db.User) error {
+	id := generateSessionID()
+	if err := db.CreatePendingSession(id, user.ID, time.Now().Add(PendingLoginDuration).Unix()); err != nil {
+		return err
+	}
+	c.Cookie(&fiber.Cookie{
+		Name:     LoginChallengeCookieName,
+		Value:    id,
+		Expires:  time.Now().Add(PendingLoginDuration),
+		HTTPOnly: true,
+		Secure:   isSecureConnection(c),
+		SameSite: "Lax",
+		Path:     "/",
+	})
+	return nil
+}
+
+// pendingLogin returns the pending session from the login challenge cookie and its user
+func pendingLogin(c *fiber.Ctx) (*db.Session, *db.User) {
+	id := c.Cookies(LoginChallengeCookieName)
+	if id == "" {
+		return nil, nil
+	}
+	session, err := db.GetSession(id)
+	if err != nil || !session.Pending2FA || session.ExpiresAt < time.Now().Unix() {
+		return nil, nil
+	}
+	user, err := db.GetUserByID(session.UserID)
+	if err != nil {
+		return nil, nil
+	}
+	return session, user
+}
+
+// LoginTwoFactorPage renders the second login step
+func LoginTwoFactorPage(c *fiber.Ctx) error {
+	if session, _ := pendingLogin(c); session == nil {
+		return c.Redirect("/login")
+	}
+	return c.Render("login", fiber.Map{
+		"Error":        c.Query("error"),
+		"TwoFactor":    true,
+		"Translations": i18n.GetAllLocales(),
+		"Locales":      i18n.AvailableLocales(),
+		"DefaultLang":  i18n.GetDefaultLang(),
+	}, "")
+}
+
+// LoginTwoFactor checks the code of the second login step and logs the user in.
+// With trust_device=on the browser skips this step for TrustedDeviceDuration.
+func LoginTwoFactor(c *fiber.Ctx) error {
+	ip := c.IP()
+
+	session, user := pendingLogin(c)
+	if session == nil {
+		return c.Redirect("/login?error=expired")
+	}
+
+	ok, err := checkSecondFactor(user, c.FormValue("code"))
+	if err != nil {
+		return c.Status(500).SendString("Login failed")
+	}
+	if !ok {
+		if loginLimiter != nil && loginLimiter.RecordAttempt(ip) {
+			db.DeleteSession(session.ID)
+			clearCookie(c, LoginChallengeCookieName)
+			return c.Redirect("/login?error=rate_limited")
+		}
+		return c.Redirect("/login/2fa?error=1")
+	}
+
+	if loginLimiter != nil {
+		loginLimiter.ResetAttempts(ip)
+	}
+	db.DeleteSession(session.ID)
+	clearCookie(c, LoginChallengeCookieName)
+
+	if c.FormValue("trust_device") == "on" {
+		token := generateSessionID()
+		name := c.Get("User-Agent")
+		if len(name) > 200 {
+			name = name[:200]
+		}
+		if err := db.CreateTrustedDevice(user.ID, token, name, time.Now().Add(TrustedDeviceDuration).Unix()); err != nil {
+			log.Printf("[AUTH] Failed to remember trusted device: %v", err)
+		} else {
+			c.Cookie(&fiber.Cookie{
+				Name:     TrustedDeviceCookieName,
+				Value:    token,
+				Expires:  time.Now().Add(TrustedDeviceDuration),
+				HTTPOnly: true,
+				Secure:   isSecureConnection(c),
+				SameSite: "Lax",
+				Path:     "/",
+			})
+		}
+	}
+
+	if err := startSession(c, user); err != nil {
+		return c.Status(500).SendString("Session creation failed")
+	}
+	return c.Redirect("/")
+}
+
+// GetTwoFactorStatus returns whether two-factor is on and how many recovery codes are left
+func GetTwoFactorStatus(c *fiber.Ctx) error {
+	user := CurrentUser(c)
+	left, err := db.CountRecoveryCodes(user.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch two-factor status"})
+	}
+	return c.JSON(fiber.Map{
+		"enabled":             user.TOTPEnabled,
+		"recovery_codes_left": left,
+	})
+}
+
+// SetupTwoFactor starts enrollment: it creates a secret and returns it with an otpauth://
+// URL and a QR code (PNG data URL) for authenticator apps. Requires the current password.
+func SetupTwoFactor(c *fiber.Ctx) error {
+	user := CurrentUser(c)
+	current, err := checkPassword(user.Username, c.FormValue("password"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to set up two-factor"})
+	}
+	if current == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Password is incorrect"})
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to set up two-factor"})
+	}
+	url := totp.URL(TOTPIssuer, user.Username, secret)
+	code, err := qr.Encode(url, qr.M)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to set up two-factor"})
+	}
+
+	if err := db.SetPendingTOTPSecret(user.ID, secret); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to set up two-factor"})
+	}
+
+	return c.JSON(fiber.Map{
+		"secret":      secret,
+		"otpauth_url": url,
+		"qr_code":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(code.PNG()),
+	})
+}
+
+// EnableTwoFactor confirms enrollment with a code from the authenticator app.
+// The recovery codes are only shown in this response.
+func EnableTwoFactor(c *fiber.Ctx) error {
+	user := CurrentUser(c)
+	if user.TOTPPending == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Set up two-factor first"})
+	}
+
+	step, ok := totp.Validate(user.TOTPPending, c.FormValue("code"), time.Now())
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid code"})
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to enable two-factor"})
+	}
+	if err := db.EnableTOTP(user.ID, step, codes); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to enable two-factor"})
+	}
+	RecordEvent(c, 0, db.EventUpdated, db.EntityUser, user.ID, nil, fiber.Map{"totp_enabled": true})
+
+	return c.JSON(fiber.Map{"recovery_codes": codes})
+}
+
+// DisableTwoFactor turns two-factor off. Requires the password and a code or recovery code.
+func DisableTwoFactor(c *fiber.Ctx) error {
+	user := CurrentUser(c)
+	if !user.TOTPEnabled {
+		return c.Status(400).JSON(fiber.Map{"error": "Two-factor is not enabled"})
+	}
+
+	current, err := checkPassword(user.Username, c.FormValue("password"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to disable two-factor"})
+	}
+	if current == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Password is incorrect"})
+	}
+	ok, err := checkSecondFactor(user, c.FormValue("code"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to disable two-factor"})
+	}
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid code"})
+	}
+
+	if err := db.DisableTOTP(user.ID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to disable two-factor"})
+	}
+	RecordEvent(c, 0, db.EventUpdated, db.EntityUser, user.ID, nil, fiber.Map{"totp_enabled": false})
+
+	return c.SendStatus(204)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes after checking an authenticator code
+func RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	user := CurrentUser(c)
+	if !user.TOTPEnabled {
+		return c.Status(400).JSON(fiber.Map{"error": "Two-factor is not enabled"})
+	}
+
+	step, ok := totp.Validate(user.TOTPSecret, c.FormValue("code"), time.Now())
+	if ok {
+		ok, _ = db.UseTOTPStep(user.ID, step)
+	}
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid code"})
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create recovery codes"})
+	}
+	if err := db.ReplaceRecoveryCodes(user.ID, codes); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create recovery codes"})
+	}
+
+	return c.JSON(fiber.Map{"recovery_codes": codes})
+}
+
+// GetTrustedDevices returns the browsers that skip the two-factor step
+func GetTrustedDevices(c *fiber.Ctx) error {
+	devices, err := db.GetTrustedDevices(CurrentUser(c).ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch trusted devices"})
+	}
+	if devices == nil {
+		devices = []db.TrustedDevice{}
+	}
+	return c.JSON(devices)
+}
+
+// DeleteTrustedDevice makes a browser ask for the two-factor code again
+func DeleteTrustedDevice(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	if err := db.DeleteTrustedDevice(CurrentUser(c).ID, id); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "Trusted device not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete trusted device"})
+	}
+	return c.SendStatus(204)
+}
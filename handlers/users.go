@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"shopping-list/db"
+	"shopping-list/i18n"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 72 // bcrypt ignores anything longer
+	InviteDuration    = 7 * 24 * time.Hour
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{3,32}$`)
+
+// dummyHash is compared against when a username does not exist,
+// so unknown and known usernames take the same time to reject
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+
+func getAdminUsername() string {
+	name := os.Getenv("ADMIN_USERNAME")
+	if name == "" {
+		name = "admin"
+	}
+	return name
+}
+
+func isRegistrationOpen() bool {
+	return os.Getenv("ALLOW_REGISTRATION") == "true"
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// checkPassword returns the user for a username and password, or nil if they don't match
+func checkPassword(username, password string) (*db.User, error) {
+	user, err := db.GetUserByUsername(username)
+	if err == sql.ErrNoRows {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, nil
+	}
+	return user, nil
+}
+
+// validateCredentials returns an i18n error key for an invalid username or password, or ""
+func validateCredentials(username, password string) string {
+	if !usernamePattern.MatchString(username) {
+		return "invalid_username"
+	}
+	return validatePassword(password)
+}
+
+// validatePassword returns "invalid_password" for a password that is too short or too long, or "".
+// Usernames of OpenID Connect and forward auth users don't have to fit usernamePattern.
+func validatePassword(password string) string {
+	if n := utf8.RuneCountInString(password); n < MinPasswordLength || len(password) > MaxPasswordLength {
+		return "invalid_password"
+	}
+	return ""
+}
+
+// EnsureAdminUser creates the first admin account from APP_PASSWORD when there are no users yet,
+// and hands sessions logged in with the old shared password and lists and templates without an owner over to it
+func EnsureAdminUser() {
+	count, err := db.CountUsers()
+	if err != nil {
+		log.Fatal("Failed to count users:", err)
+	}
+	if count == 0 {
+		hash, err := hashPassword(getAppPassword())
+		if err != nil {
+			log.Fatal("Failed to hash admin password:", err)
+		}
+		admin, err := db.CreateUser(getAdminUsername(), hash, true)
+		if err != nil {
+			log.Fatal("Failed to create admin user:", err)
+		}
+		if err := db.AssignOrphanSessions(admin.ID); err != nil {
+			log.Println("Failed to assign existing sessions to admin:", err)
+		}
+		log.Printf("[AUTH] Created admin user %q from APP_PASSWORD", admin.Username)
+	}
+
+	// Lists, templates and item history from before they had owners belong to the first admin
+	admin, err := db.GetFirstAdmin()
+	if err != nil {
+		log.Fatal("Failed to find admin user:", err)
+	}
+	if err := db.AssignOrphanLists(admin.ID); err != nil {
+		log.Println("Failed to assign existing lists to admin:", err)
+	}
+	if err := db.AssignOrphanTemplates(admin.ID); err != nil {
+		log.Println("Failed to assign existing templates to admin:", err)
+	}
+	if err := db.AssignOrphanHistory(admin.ID); err != nil {
+		log.Println("Failed to assign existing item history to admin:", err)
+	}
+}
+
+// CurrentUser returns the logged in user set by AuthMiddleware, or nil
+func CurrentUser(c *fiber.Ctx) *db.User {
+	user, _ := c.Locals("user").(*db.User)
+	return user
+}
+
+// AdminOnly rejects requests from users who are not admins
+func AdminOnly(c *fiber.Ctx) error {
+	if user := CurrentUser(c); user == nil || !user.IsAdmin {
+		return c.Status(403).JSON(fiber.Map{"error": "Admin access required"})
+	}
+	return c.Next()
+}
+
+// startSession creates a session for a user and sets the session cookie
+func startSession(c *fiber.Ctx, user *db.User) error {
+	sessionID := generateSessionID()
+	expiresAt := time.Now().Add(SessionDuration).Unix()
+
+	userAgent := truncate(c.Get("User-Agent"), MaxUserAgentLength)
+	if err := db.CreateSession(sessionID, user.ID, expiresAt, userAgent, c.IP(), DeviceName(userAgent)); err != nil {
+		return err
+	}
+	db.TouchUserLogin(user.ID)
+	log.Printf("[AUTH] New session created for %s: %s... (expires: %d)", user.Username, sessionID[:8], expiresAt)
+
+	setSessionCookie(c, sessionID)
+	return nil
+}
+
+// setSessionCookie sets the session cookie to expire along with the session
+func setSessionCookie(c *fiber.Ctx, sessionID string) {
+	c.Cookie(&fiber.Cookie{
+		Name:     SessionCookieName,
+		Value:    sessionID,
+		Expires:  time.Now().Add(SessionDuration),
+		HTTPOnly: true,
+		Secure:   isSecureConnection(c),
+		SameSite: "Lax",
+		Path:     "/",
+	})
+}
+
+// RegisterPage renders the registration form
+func RegisterPage(c *fiber.Ctx) error {
+	code := c.Query("invite")
+	if code == "" && !isRegistrationOpen() {
+		return c.Redirect("/login")
+	}
+	return c.Render("register", fiber.Map{
+		"Error":        c.Query("error"),
+		"Invite":       code,
+		"Translations": i18n.GetAllLocales(),
+		"Locales":      i18n.AvailableLocales(),
+		"DefaultLang":  i18n.GetDefaultLang(),
+	}, "")
+}
+
+// Register creates an account from the registration form and logs it in
+func Register(c *fiber.Ctx) error {
+	username := strings.TrimSpace(c.FormValue("username"))
+	password := c.FormValue("password")
+	code := strings.TrimSpace(c.FormValue("invite"))
+
+	failed := func(key string) error {
+		return c.Redirect("/register?invite=" + url.QueryEscape(code) + "&error=" + key)
+	}
+
+	if code == "" && !isRegistrationOpen() {
+		return c.Redirect("/login")
+	}
+	if key := validateCredentials(username, password); key != "" {
+		return failed(key)
+	}
+	if password != c.FormValue("password_confirm") {
+		return failed("password_mismatch")
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return c.Status(500).SendString("Registration failed")
+	}
+
+	user, err := db.RegisterUser(username, hash, code)
+	switch {
+	case err == db.ErrInvalidInvite:
+		// Guessing invite codes counts as failed logins
+		if loginLimiter != nil && loginLimiter.RecordAttempt(c.IP()) {
+			return c.Redirect("/login?error=rate_limited")
+		}
+		return failed("invalid_invite")
+	case err == db.ErrUsernameTaken:
+		return failed("username_taken")
+	case err != nil:
+		return c.Status(500).SendString("Registration failed")
+	}
+	log.Printf("[AUTH] Registered user %q (admin: %v)", user.Username, user.IsAdmin)
+	recordEvent(user.ID, 0, db.EventCreated, db.EntityUser, user.ID, nil, user)
+
+	if err := startSession(c, user); err != nil {
+		return c.Status(500).SendString("Session creation failed")
+	}
+	return c.Redirect("/")
+}
+
+// GetUsers returns all accounts (admin only)
+func GetUsers(c *fiber.Ctx) error {
+	users, err := db.GetAllUsers()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch users"})
+	}
+	if users == nil {
+		users = []db.User{}
+	}
+	return c.JSON(users)
+}
+
+// DeleteUser removes an account and logs it out everywhere (admin only).
+// Lists it shared with an editor are handed over to them.
+func DeleteUser(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+	if id == CurrentUser(c).ID {
+		return c.Status(400).JSON(fiber.Map{"error": "You cannot delete your own account"})
+	}
+
+	before, _ := db.GetUserByID(id)
+	deleted, err := db.DeleteUser(id)
+	switch {
+	case err == sql.ErrNoRows:
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	case err == db.ErrLastAdmin:
+		return c.Status(400).JSON(fiber.Map{"error": "Cannot delete the last admin"})
+	case err != nil:
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete user"})
+	}
+	RecordEvent(c, 0, db.EventDeleted, db.EntityUser, id, before, nil)
+	CloseSessionConnections(deleted.SessionIDs)
+
+	// Shared lists stay with their editors, the new owner's clients reload it
+	for listID, ownerID := range deleted.Transferred {
+		members, err := db.GetListMembers(listID)
+		if err != nil {
+			continue
+		}
+		RecordEvent(c, listID, db.EventShared, db.EntityMember, ownerID, nil, fiber.Map{"role": db.RoleOwner})
+		if list, err := db.GetListByID(ownerID, listID); err == nil {
+			BroadcastToUsers([]int64{ownerID}, listID, "list_updated", list)
+		}
+		BroadcastListUpdate(listID, "list_members_updated", members)
+	}
+	for listID, memberIDs := range deleted.DeletedLists {
+		BroadcastToUsers(memberIDs, listID, "list_deleted", fiber.Map{"id": listID})
+		closeListShareConnections(listID)
+	}
+	return c.SendStatus(204)
+}
+
+// GetInvites returns all invite codes (admin only)
+func GetInvites(c *fiber.Ctx) error {
+	invites, err := db.GetInvites()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch invites"})
+	}
+	if invites == nil {
+		invites = []db.Invite{}
+	}
+	return c.JSON(invites)
+}
+
+// CreateInvite creates a one-time registration code valid for a week (admin only).
+// Form field is_admin=true makes the invited account an admin.
+func CreateInvite(c *fiber.Ctx) error {
+	bytes := make([]byte, 15)
+	if _, err := rand.Read(bytes); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create invite"})
+	}
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(bytes))
+
+	invite, err := db.CreateInvite(code, CurrentUser(c).ID, c.FormValue("is_admin") == "true", time.Now().Add(InviteDuration).Unix())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create invite"})
+	}
+	// The code itself stays out of the audit trail
+	RecordEvent(c, 0, db.EventCreated, db.EntityInvite, 0, nil, fiber.Map{"is_admin": invite.IsAdmin, "expires_at": invite.ExpiresAt})
+
+	return c.Status(201).JSON(fiber.Map{
+		"invite": invite,
+		"url":    c.BaseURL() + "/register?invite=" + invite.Code,
+	})
+}
+
+// DeleteInvite revokes an invite code (admin only)
+func DeleteInvite(c *fiber.Ctx) error {
+	if err := db.DeleteInvite(c.Params("code")); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "Invite not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete invite"})
+	}
+	RecordEvent(c, 0, db.EventDeleted, db.EntityInvite, 0, nil, nil)
+	return c.SendStatus(204)
+}
+
+// ChangePassword changes the logged in user's password
+func ChangePassword(c *fiber.Ctx) error {
+	user := CurrentUser(c)
+	current, err := checkPassword(user.Username, c.FormValue("current_password"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to change password"})
+	}
+	if current == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Current password is incorrect"})
+	}
+
+	password := c.FormValue("new_password")
+	if validatePassword(password) != "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Password must be between 8 and 72 characters"})
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to change password"})
+	}
+	if err := db.UpdateUserPassword(user.ID, hash); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to change password"})
+	}
+	RecordEvent(c, 0, db.EventUpdated, db.EntityUser, user.ID, nil, nil)
+	return c.SendStatus(204)
+}
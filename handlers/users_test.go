@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"shopping-list/db"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestChangePassword checks only the new password is validated, so users named by
+// OpenID Connect or forward auth, e.g. with an email, can change theirs
+func TestChangePassword(t *testing.T) {
+	openTestDB(t)
+	hash, err := hashPassword("old password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.CreateUser("anna@example.com", hash, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Post("/password", func(c *fiber.Ctx) error {
+		c.Locals("user", user)
+		return c.Next()
+	}, ChangePassword)
+
+	tests := []struct {
+		name     string
+		current  string
+		password string
+		status   int
+	}{
+		{"wrong current password", "wrong password", "new password", 400},
+		{"too short", "old password", "short", 400},
+		{"too long", "old password", strings.Repeat("x", MaxPasswordLength+1), 400},
+		{"changed", "old password", "new password", 204},
+	}
+	for _, tt := range tests {
+		form := url.Values{"current_password": {tt.current}, "new_password": {tt.password}}
+		req := httptest.NewRequest("POST", "/password", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, tt.status)
+		}
+	}
+
+	if changed, err := checkPassword(user.Username, "new password"); err != nil || changed == nil {
+		t.Errorf("new password doesn't work: %v", err)
+	}
+}
@@ -3,42 +3,203 @@ package handlers
 import (
 	"encoding/json"
 	"log"
+	"shopping-list/db"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 
+	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
 )
 
-// WebSocket client connections
-var (
-	clients   = make(map[*websocket.Conn]bool)
-	clientsMu sync.RWMutex
+// wsWriteWait is the time allowed to write a message. A client that doesn't take it in
+// time is disconnected. A variable so tests can shorten it.
+var wsWriteWait = 10 * time.Second
+
+// Timing and limits of WebSocket connections
+const (
+	wsPongWait       = 60 * time.Second    // time allowed between messages from the client
+	wsPingPeriod     = wsPongWait * 9 / 10 // must be shorter than wsPongWait
+	wsSendQueueSize  = 64                  // messages waiting for a slow client
+	wsMaxMessageSize = 4096
+	wsMaxLists       = 100                 // lists one connection can subscribe to
+	wsMaxReplay      = wsSendQueueSize / 2 // missed changes sent on resume; more need a resync
 )
 
-// WebSocketMessage represents a message sent to clients
-type WebSocketMessage struct {
-	Type string      `json:"type"`
-	Data interface{} `json:"data"`
+// wsClient is the user and session a WebSocket connection was opened with,
+// or the share link for connections from a shared list
+type wsClient struct {
+	userID    int64
+	username  string
+	sessionID string
+	shareLink *db.ShareLink
+}
+
+// wsConn is a registered WebSocket connection. Only its writer goroutine writes
+// to conn; everyone else queues messages in send or calls close.
+type wsConn struct {
+	wsClient
+	conn         *websocket.Conn
+	send         chan []byte
+	done         chan struct{} // closed when the connection should be closed
+	stopped      chan struct{} // closed when the writer goroutine has finished
+	closeOnce    sync.Once
+	closeMessage []byte
+	lists        map[int64]bool // subscribed lists, guarded by subscribersMu
+	seenAt       time.Time      // last message from the client, guarded by presenceMu
+	replay       replay
+}
+
+// receives reports whether the client gets a change sent to the given users, or to every
+// user if users is nil. Changes with the list scope and notices only go to the list's
+// subscribers. Share link clients only get changes to their list, until the link expires.
+// Called with subscribersMu held.
+func (w *wsConn) receives(scope string, users map[int64]bool, listID int64) bool {
+	if w.shareLink != nil {
+		return scope == db.ChangeScopeList && listID == w.shareLink.ListID && time.Now().Unix() <= w.shareLink.ExpiresAt
+	}
+	if (scope == db.ChangeScopeList || scope == noticeScope) && !w.lists[listID] {
+		return false
+	}
+	return users == nil || users[w.userID]
+}
+
+// deliver queues a change, unless it is held back while the client resumes
+func (w *wsConn) deliver(change db.Change, message []byte) bool {
+	if w.replay.hold(change, message) {
+		return true
+	}
+	return w.enqueue(message)
 }
 
-// WebSocketHandler handles WebSocket connections
+// enqueue queues a message without blocking. When the queue is full the slow client
+// policy applies; enqueue returns false if the message was not queued.
+func (w *wsConn) enqueue(message []byte) bool {
+	select {
+	case <-w.done:
+		return false
+	default:
+	}
+
+	select {
+	case w.send <- message:
+		return true
+	default:
+	}
+
+	if slowClientPolicy == SlowClientDrop {
+		log.Printf("WebSocket client of user %d is too slow, dropping message", w.userID)
+	} else {
+		log.Printf("WebSocket client of user %d is too slow, disconnecting", w.userID)
+		w.close(websocket.CloseTryAgainLater, "too slow")
+	}
+	return false
+}
+
+// close makes the writer goroutine send a close message with the given code and reason
+// and close the connection; the read loop then fails and unregisters the client.
+// It does not block and is safe to call more than once.
+func (w *wsConn) close(code int, reason string) {
+	w.closeOnce.Do(func() {
+		w.closeMessage = websocket.FormatCloseMessage(code, reason)
+		close(w.done)
+	})
+}
+
+// writeLoop writes queued messages and pings to the connection until it is closed
+func (w *wsConn) writeLoop() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		// Close does not close hijacked connections, which fasthttp closes after the
+		// handler returns; the read deadline makes the read loop stop right away
+		w.conn.SetReadDeadline(time.Now())
+		close(w.stopped)
+	}()
+
+	for {
+		select {
+		case message := <-w.send:
+			w.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := w.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				log.Printf("Failed to send WebSocket message to client: %v", err)
+				return
+			}
+		case <-ticker.C:
+			if err := w.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		case <-w.done:
+			w.conn.WriteControl(websocket.CloseMessage, w.closeMessage, time.Now().Add(wsWriteWait))
+			return
+		}
+	}
+}
+
+// wsRequest is a message from a client: ping, subscribe/unsubscribe with list IDs,
+// or resume with the sequence number of the last change it got
+type wsRequest struct {
+	Type    string  `json:"type"`
+	ListIDs []int64 `json:"list_ids"`
+	Seq     int64   `json:"seq"`
+}
+
+// WebSocketHandler handles WebSocket connections. It reads from the connection
+// while writeLoop writes to it; a client that sends nothing, not even a pong,
+// for wsPongWait is disconnected.
 func WebSocketHandler(c *websocket.Conn) {
+	client := &wsConn{
+		conn:    c,
+		send:    make(chan []byte, wsSendQueueSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	// Changes queued before resume are not replayed again
+	client.replay.queued = make(map[int64]bool)
+	if user, ok := c.Locals("user").(*db.User); ok && user != nil {
+		client.userID = user.ID
+		client.username = user.Username
+	}
+	client.sessionID, _ = c.Locals("session_id").(string)
+	client.shareLink, _ = c.Locals("share_link").(*db.ShareLink)
+
+	// Share link clients are subscribed to their list, and only to it
+	client.lists = make(map[int64]bool)
+	if client.shareLink != nil {
+		client.lists[client.shareLink.ListID] = true
+	}
+
 	// Register client
-	clientsMu.Lock()
-	clients[c] = true
-	clientsMu.Unlock()
+	subscribersMu.Lock()
+	subscribers[client] = true
+	count := len(subscribers)
+	subscribersMu.Unlock()
+
+	log.Printf("WebSocket client connected. Total clients: %d", count)
 
-	log.Printf("WebSocket client connected. Total clients: %d", len(clients))
+	go client.writeLoop()
 
 	defer func() {
 		// Unregister client
-		clientsMu.Lock()
-		delete(clients, c)
-		clientsMu.Unlock()
-		c.Close()
-		log.Printf("WebSocket client disconnected. Total clients: %d", len(clients))
+		subscribersMu.Lock()
+		delete(subscribers, client)
+		count := len(subscribers)
+		subscribersMu.Unlock()
+		presenceLeave(client, nil)
+		client.close(websocket.CloseNormalClosure, "")
+		// The connection is reused once the handler returns, so wait for the writer
+		<-client.stopped
+		log.Printf("WebSocket client disconnected. Total clients: %d", count)
 	}()
 
-	// Keep connection alive and handle incoming messages
+	c.SetReadLimit(wsMaxMessageSize)
+	c.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.SetPongHandler(func(string) error {
+		return c.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	// Handle incoming messages
 	for {
 		messageType, msg, err := c.ReadMessage()
 		if err != nil {
@@ -47,52 +208,430 @@ func WebSocketHandler(c *websocket.Conn) {
 			}
 			break
 		}
+		c.SetReadDeadline(time.Now().Add(wsPongWait))
+		presenceHeartbeat(client)
+
+		if messageType != websocket.TextMessage {
+			continue
+		}
+		var request wsRequest
+		if err := json.Unmarshal(msg, &request); err != nil {
+			continue
+		}
+		switch request.Type {
+		case "ping":
+			client.reply("pong", nil)
+		case "subscribe":
+			client.subscribe(request.ListIDs)
+		case "unsubscribe":
+			client.unsubscribe(request.ListIDs)
+		case "resume":
+			client.resume(request.Seq)
+		}
+	}
+}
 
-		// Handle ping/pong
-		if messageType == websocket.TextMessage {
-			var message map[string]string
-			if err := json.Unmarshal(msg, &message); err == nil {
-				if message["type"] == "ping" {
-					c.WriteJSON(map[string]string{"type": "pong"})
+// reply sends a message that is not a change, only to this client
+func (w *wsConn) reply(messageType string, data interface{}) {
+	messageBytes, err := json.Marshal(WebSocketMessage{Type: messageType, Data: data})
+	if err != nil {
+		log.Printf("Failed to marshal WebSocket message: %v", err)
+		return
+	}
+	w.enqueue(messageBytes)
+}
+
+// subscribe adds lists the client may read to its subscriptions and replies with
+// all of them, the lists it may not read and the sequence number changes continue from
+func (w *wsConn) subscribe(listIDs []int64) {
+	var allowed, denied []int64
+	for _, id := range listIDs {
+		if w.shareLink != nil {
+			if id == w.shareLink.ListID {
+				continue // already subscribed
+			}
+			denied = append(denied, id)
+		} else if err := db.CheckListAccess(w.userID, id, db.AccessRead); err != nil {
+			denied = append(denied, id)
+		} else {
+			allowed = append(allowed, id)
+		}
+	}
+
+	var added []int64
+	subscribersMu.Lock()
+	for _, id := range allowed {
+		if len(w.lists) >= wsMaxLists {
+			denied = append(denied, id)
+			continue
+		}
+		w.lists[id] = true
+		added = append(added, id)
+	}
+	subscribersMu.Unlock()
+
+	w.replySubscriptions(denied)
+	presenceJoin(w, added)
+}
+
+// unsubscribe removes lists from the client's subscriptions and replies with the rest
+func (w *wsConn) unsubscribe(listIDs []int64) {
+	subscribersMu.Lock()
+	for _, id := range listIDs {
+		if w.shareLink == nil || id != w.shareLink.ListID {
+			delete(w.lists, id)
+		}
+	}
+	subscribersMu.Unlock()
+
+	w.replySubscriptions(nil)
+	presenceLeave(w, listIDs)
+}
+
+func (w *wsConn) replySubscriptions(denied []int64) {
+	// Holding the lock keeps changes from being queued between reading seq and replying
+	subscribersMu.RLock()
+	defer subscribersMu.RUnlock()
+
+	listIDs := make([]int64, 0, len(w.lists))
+	for id := range w.lists {
+		listIDs = append(listIDs, id)
+	}
+	sort.Slice(listIDs, func(i, j int) bool { return listIDs[i] < listIDs[j] })
+
+	eventSeqMu.Lock()
+	seq := eventSeq
+	eventSeqMu.Unlock()
+
+	w.reply("subscriptions", fiber.Map{
+		"list_ids": listIDs,
+		"denied":   denied,
+		"seq":      seq,
+	})
+}
+
+// resume sends the changes for the client after seq, e.g. after reconnecting, then
+// "resumed". If they are no longer all kept, or too many, it replies "resync_required"
+// and the client has to load everything again. Subscribe first: only changes the
+// client would get now are sent. Changes that come in meanwhile are held back until then.
+func (w *wsConn) resume(seq int64) {
+	eventSeqMu.Lock()
+	latest := eventSeq
+	w.replay.start()
+	eventSeqMu.Unlock()
+
+	missed, ok := missedChanges(w, seq, latest)
+	w.finishResume(missed, ok, latest)
+}
+
+// finishResume queues the missed changes up to latest, or "resync_required" if ok is false
+// or there are too many, then the changes held back meanwhile, and lets changes through again
+func (w *wsConn) finishResume(missed []db.Change, ok bool, latest int64) {
+	w.replay.finish(func(held []heldChange, lastSeq int64, dropped bool, queued map[int64]bool) {
+		resync := dropped || !ok || len(missed) > wsMaxReplay
+		var replayed [][]byte
+		if !resync {
+			for _, change := range missed {
+				if queued[change.Seq] {
+					continue
 				}
+				if messageBytes, err := changeMessage(change); err == nil {
+					replayed = append(replayed, messageBytes)
+				}
+			}
+		}
+
+		// Everything has to fit in the send queue, or the client would be dropped as too
+		// slow; if it doesn't, the client loads everything and continues after the held changes
+		if dropped || len(replayed)+1+len(held) > cap(w.send)-len(w.send) {
+			w.reply("resync_required", fiber.Map{"seq": lastSeq})
+			return
+		}
+		if resync {
+			w.reply("resync_required", fiber.Map{"seq": latest})
+		} else {
+			for _, messageBytes := range replayed {
+				w.enqueue(messageBytes)
 			}
+			w.reply("resumed", fiber.Map{"seq": latest, "count": len(replayed)})
+		}
+		for _, h := range held {
+			w.enqueue(h.message)
 		}
+	})
+}
+
+// CloseSessionConnections closes the WebSockets opened with the given sessions,
+// so a revoked device stops receiving updates right away
+func CloseSessionConnections(sessionIDs []string) {
+	if len(sessionIDs) == 0 {
+		return
 	}
+	revoked := make(map[string]bool, len(sessionIDs))
+	for _, id := range sessionIDs {
+		revoked[id] = true
+	}
+	closeConnections("session revoked", func(info wsClient) bool {
+		return info.sessionID != "" && revoked[info.sessionID]
+	})
+}
+
+// CloseShareLinkConnections closes the WebSockets opened with a share link
+func CloseShareLinkConnections(linkID int64) {
+	closeConnections("share link revoked", func(info wsClient) bool {
+		return info.shareLink != nil && info.shareLink.ID == linkID
+	})
 }
 
-// BroadcastUpdate sends an update to all connected WebSocket clients
-func BroadcastUpdate(eventType string, data interface{}) {
-	message := WebSocketMessage{
-		Type: eventType,
-		Data: data,
+// closeListShareConnections closes the WebSockets opened with any share link to a list
+func closeListShareConnections(listID int64) {
+	closeConnections("list deleted", func(info wsClient) bool {
+		return info.shareLink != nil && info.shareLink.ListID == listID
+	})
+}
+
+// closeConnections closes the WebSockets of the clients match picks, with reason in the close message
+func closeConnections(reason string, match func(wsClient) bool) {
+	subscribersMu.RLock()
+	for sub := range subscribers {
+		if client, ok := sub.(*wsConn); ok && match(client.wsClient) {
+			client.close(websocket.ClosePolicyViolation, reason)
+		}
 	}
+	subscribersMu.RUnlock()
+}
 
-	messageBytes, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Failed to marshal WebSocket message: %v", err)
+// Presence: who has a list open. A connection is present on the lists it is subscribed to
+// while the client sends messages; the app pings every 30 seconds while it is visible.
+// Pongs do not count, browsers answer them in background tabs too. Share link clients
+// are anonymous and not tracked.
+
+const (
+	PresenceTimeout     = 90 * time.Second // without a message from any of the user's connections
+	presenceSweepPeriod = 15 * time.Second
+	presenceQueueSize   = 1024 // events waiting to be sent; more are dropped
+)
+
+// Presence is a user who has a list open, on one or more devices
+type Presence struct {
+	ListID   int64  `json:"list_id"`
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username"`
+	Devices  int    `json:"devices"`
+	JoinedAt int64  `json:"joined_at"`
+	ItemID   int64  `json:"item_id,omitempty"` // the item the user last changed on the list
+	Action   string `json:"action,omitempty"`  // what they did to it, e.g. "completed"
+	ActiveAt int64  `json:"active_at,omitempty"`
+
+	conns map[*wsConn]bool
+}
+
+type presenceKey struct {
+	listID int64
+	userID int64
+}
+
+// Users on lists. Nothing is read from the database with the mutex held: presence events
+// are queued in order and sent by sendPresenceEvents, which looks up who gets them.
+var (
+	presences      = make(map[presenceKey]*Presence)
+	presenceMu     sync.Mutex
+	presenceEvents = make(chan presenceEvent, presenceQueueSize)
+)
+
+type presenceEvent struct {
+	eventType string
+	presence  Presence
+}
+
+// StartPresenceSweeper starts sending presence events, and removes connections that stopped
+// sending heartbeats from the lists they were on, sending presence_left for users with no
+// connection left
+func StartPresenceSweeper() {
+	go sendPresenceEvents()
+	go func() {
+		ticker := time.NewTicker(presenceSweepPeriod)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			expirePresence(time.Now().Add(-PresenceTimeout))
+		}
+	}()
+}
+
+func expirePresence(cutoff time.Time) {
+	presenceMu.Lock()
+	defer presenceMu.Unlock()
+
+	for key, p := range presences {
+		for conn := range p.conns {
+			if conn.seenAt.Before(cutoff) {
+				delete(p.conns, conn)
+			}
+		}
+		removeIfGone(key, p)
+	}
+}
+
+// presenceJoin puts a connection on lists, sending presence_joined for users who
+// were not on them yet
+func presenceJoin(w *wsConn, listIDs []int64) {
+	if w.shareLink != nil {
 		return
 	}
+	presenceMu.Lock()
+	defer presenceMu.Unlock()
 
-	clientsMu.RLock()
-	clientCount := len(clients)
-	log.Printf("Broadcasting %s to %d clients", eventType, clientCount)
+	now := time.Now()
+	w.seenAt = now
+	for _, id := range listIDs {
+		key := presenceKey{listID: id, userID: w.userID}
+		p := presences[key]
+		if p == nil {
+			p = &Presence{
+				ListID:   id,
+				UserID:   w.userID,
+				Username: w.username,
+				JoinedAt: now.Unix(),
+				conns:    make(map[*wsConn]bool),
+			}
+			presences[key] = p
+		}
+		if p.conns[w] {
+			continue
+		}
+		p.conns[w] = true
+		p.Devices = len(p.conns)
+		if p.Devices == 1 {
+			notifyPresence("presence_joined", p)
+		}
+	}
+}
+
+// presenceLeave takes a connection off lists, or all of them if listIDs is nil,
+// sending presence_left for users with no connection left on a list
+func presenceLeave(w *wsConn, listIDs []int64) {
+	if w.shareLink != nil {
+		return
+	}
+	presenceMu.Lock()
+	defer presenceMu.Unlock()
+
+	if listIDs == nil {
+		for key, p := range presences {
+			if p.conns[w] {
+				delete(p.conns, w)
+				removeIfGone(key, p)
+			}
+		}
+		return
+	}
+	for _, id := range listIDs {
+		key := presenceKey{listID: id, userID: w.userID}
+		if p := presences[key]; p != nil && p.conns[w] {
+			delete(p.conns, w)
+			removeIfGone(key, p)
+		}
+	}
+}
+
+// presenceHeartbeat puts a connection back on its lists after a message from the client.
+// Lists it was taken off for missing heartbeats are joined again.
+func presenceHeartbeat(w *wsConn) {
+	if w.shareLink != nil {
+		return
+	}
+	subscribersMu.RLock()
+	listIDs := make([]int64, 0, len(w.lists))
+	for id := range w.lists {
+		listIDs = append(listIDs, id)
+	}
+	subscribersMu.RUnlock()
+
+	presenceJoin(w, listIDs)
+}
+
+// presenceActivity records that a user changed an item on a list, if they have it open
+func presenceActivity(userID, listID, itemID int64, action string) {
+	presenceMu.Lock()
+	defer presenceMu.Unlock()
+
+	p := presences[presenceKey{listID: listID, userID: userID}]
+	if p == nil {
+		return
+	}
+	p.ItemID = itemID
+	p.Action = action
+	p.ActiveAt = time.Now().Unix()
+	notifyPresence("presence_activity", p)
+}
+
+// removeIfGone removes a user with no connections left from a list. Called with presenceMu held.
+func removeIfGone(key presenceKey, p *Presence) {
+	p.Devices = len(p.conns)
+	if p.Devices > 0 {
+		return
+	}
+	delete(presences, key)
+	notifyPresence("presence_left", p)
+}
+
+// notifyPresence queues a presence event. Called with presenceMu held. Presence is shown
+// on a best effort basis: if the queue is full the event is dropped, and clients catch
+// up when they load the list's presence again.
+func notifyPresence(eventType string, p *Presence) {
+	event := presenceEvent{eventType: eventType, presence: *p}
+	event.presence.conns = nil
+	select {
+	case presenceEvents <- event:
+	default:
+		log.Printf("Presence queue is full, dropping %s on list %d", eventType, p.ListID)
+	}
+}
 
-	successCount := 0
-	for client := range clients {
-		err := client.WriteMessage(websocket.TextMessage, messageBytes)
+// sendPresenceEvents sends the queued presence events to the users of their lists
+func sendPresenceEvents() {
+	for event := range presenceEvents {
+		userIDs, err := db.GetListUserIDs(event.presence.ListID)
 		if err != nil {
-			log.Printf("Failed to send WebSocket message to client: %v", err)
-			// Don't remove client here, let the read loop handle it
-		} else {
-			successCount++
+			log.Printf("Failed to get users of list %d: %v", event.presence.ListID, err)
+			continue
 		}
+		notify(event.presence.ListID, userIDs, event.eventType, event.presence)
 	}
-	clientsMu.RUnlock()
+}
+
+// ListPresence returns the users who have a list open, in the order they opened it
+func ListPresence(listID int64) []Presence {
+	presenceMu.Lock()
+	defer presenceMu.Unlock()
 
-	log.Printf("Broadcast %s completed: %d/%d clients received", eventType, successCount, clientCount)
+	list := []Presence{}
+	for key, p := range presences {
+		if key.listID == listID {
+			entry := *p
+			entry.conns = nil
+			list = append(list, entry)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].JoinedAt != list[j].JoinedAt {
+			return list[i].JoinedAt < list[j].JoinedAt
+		}
+		return list[i].UserID < list[j].UserID
+	})
+	return list
 }
 
-// WebSocketUpgrade middleware to upgrade HTTP to WebSocket
-func WebSocketUpgrade(c *websocket.Conn) error {
-	return nil
+// GetListPresence returns who has a list open, and what they last did on it
+func GetListPresence(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	if err := db.CheckListAccess(CurrentUser(c).ID, id, db.AccessRead); err != nil {
+		return accessDeniedJSON(c, err, "List")
+	}
+	return c.JSON(ListPresence(id))
 }
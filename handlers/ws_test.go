@@ -0,0 +1,402 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"shopping-list/db"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+)
+
+func newTestConn() *wsConn {
+	return &wsConn{
+		send:    make(chan []byte, wsSendQueueSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		lists:   make(map[int64]bool),
+	}
+}
+
+func register(t *testing.T, clients ...*wsConn) {
+	subscribersMu.Lock()
+	for _, client := range clients {
+		subscribers[client] = true
+	}
+	subscribersMu.Unlock()
+
+	t.Cleanup(func() {
+		subscribersMu.Lock()
+		for _, client := range clients {
+			delete(subscribers, client)
+		}
+		subscribersMu.Unlock()
+	})
+}
+
+func setSlowClientPolicy(t *testing.T, policy string) {
+	old := slowClientPolicy
+	slowClientPolicy = policy
+	t.Cleanup(func() { slowClientPolicy = old })
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// broadcastAll sends n changes to everyone, draining fast after each one
+func broadcastAll(n int, fast *wsConn) int {
+	received := 0
+	for i := 0; i < n; i++ {
+		BroadcastUpdate("template_updated", map[string]int{"i": i})
+		for len(fast.send) > 0 {
+			<-fast.send
+			received++
+		}
+	}
+	return received
+}
+
+func TestSlowClientIsDisconnected(t *testing.T) {
+	setSlowClientPolicy(t, SlowClientDisconnect)
+	fast, slow := newTestConn(), newTestConn()
+	register(t, fast, slow)
+
+	n := wsSendQueueSize * 2
+	if received := broadcastAll(n, fast); received != n {
+		t.Errorf("fast client got %d of %d messages", received, n)
+	}
+	if isClosed(fast.done) {
+		t.Error("fast client was closed")
+	}
+	if !isClosed(slow.done) {
+		t.Error("slow client was not closed when its queue overflowed")
+	}
+	if len(slow.send) != wsSendQueueSize {
+		t.Errorf("slow client has %d queued messages, want %d", len(slow.send), wsSendQueueSize)
+	}
+}
+
+func TestSlowClientDropsMessages(t *testing.T) {
+	setSlowClientPolicy(t, SlowClientDrop)
+	fast, slow := newTestConn(), newTestConn()
+	register(t, fast, slow)
+
+	n := wsSendQueueSize * 2
+	if received := broadcastAll(n, fast); received != n {
+		t.Errorf("fast client got %d of %d messages", received, n)
+	}
+	if isClosed(slow.done) {
+		t.Error("slow client was closed, want its messages dropped")
+	}
+	if len(slow.send) != wsSendQueueSize {
+		t.Errorf("slow client has %d queued messages, want %d", len(slow.send), wsSendQueueSize)
+	}
+}
+
+// TestBroadcastWhileClientsLeave broadcasts from several goroutines while a client keeps
+// registering and unregistering, as reconnecting browsers do. Run with -race.
+func TestBroadcastWhileClientsLeave(t *testing.T) {
+	setSlowClientPolicy(t, SlowClientDisconnect)
+	const broadcasters, perBroadcaster = 4, 100
+	total := broadcasters * perBroadcaster
+
+	steady := make([]*wsConn, 3)
+	for i := range steady {
+		steady[i] = newTestConn()
+		steady[i].send = make(chan []byte, total)
+	}
+	register(t, steady...)
+
+	stop := make(chan struct{})
+	started := make(chan struct{})
+	churned := make(chan int)
+	go func() {
+		n := 0
+		defer func() { churned <- n }()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			client := newTestConn()
+			subscribersMu.Lock()
+			subscribers[client] = true
+			subscribersMu.Unlock()
+			if n == 0 {
+				close(started)
+			}
+			select {
+			case <-client.send:
+			case <-time.After(time.Millisecond):
+			}
+			// Unregister and close as WebSocketHandler does when the read loop ends
+			subscribersMu.Lock()
+			delete(subscribers, client)
+			subscribersMu.Unlock()
+			presenceLeave(client, nil)
+			client.close(websocket.CloseNormalClosure, "")
+			n++
+		}
+	}()
+
+	<-started
+	var wg sync.WaitGroup
+	for b := 0; b < broadcasters; b++ {
+		wg.Add(1)
+		go func(b int) {
+			defer wg.Done()
+			for i := 0; i < perBroadcaster; i++ {
+				BroadcastUpdate("template_updated", map[string]int{"broadcaster": b, "i": i})
+			}
+		}(b)
+	}
+	wg.Wait()
+	close(stop)
+	t.Logf("a client registered and unregistered %d times", <-churned)
+
+	for i, client := range steady {
+		if isClosed(client.done) {
+			t.Errorf("client %d was closed", i)
+		}
+		if len(client.send) != total {
+			t.Fatalf("client %d got %d of %d messages", i, len(client.send), total)
+		}
+		// Every client gets the changes in sequence order, whoever broadcast them
+		var last int64
+		for len(client.send) > 0 {
+			var message WebSocketMessage
+			if err := json.Unmarshal(<-client.send, &message); err != nil {
+				t.Fatal(err)
+			}
+			if message.Seq != last+1 && last != 0 {
+				t.Fatalf("client %d got change %d after %d", i, message.Seq, last)
+			}
+			last = message.Seq
+		}
+	}
+}
+
+// startWSServer serves WebSocketHandler on a local port and returns its URL.
+// Cleanup waits for the handlers to return, once the clients have closed.
+func startWSServer(t *testing.T) string {
+	var handlers sync.WaitGroup
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/ws", websocket.New(func(c *websocket.Conn) {
+		handlers.Add(1)
+		defer handlers.Done()
+		WebSocketHandler(c)
+	}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() {
+		handlers.Wait()
+		app.ShutdownWithTimeout(time.Second)
+	})
+	return "ws://" + ln.Addr().String() + "/ws"
+}
+
+// waitSubscribers waits until n subscribers are registered
+func waitSubscribers(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		subscribersMu.RLock()
+		count := len(subscribers)
+		subscribersMu.RUnlock()
+		if count == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d subscribers, want %d", count, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestStuckClientIsClosed checks a client that stops reading is closed once a write
+// misses the write deadline, while broadcasts and the other clients go on
+func TestStuckClientIsClosed(t *testing.T) {
+	// Dropping overflowing messages leaves the write deadline as what closes the client
+	setSlowClientPolicy(t, SlowClientDrop)
+	oldWait := wsWriteWait
+	wsWriteWait = 2 * time.Second
+	t.Cleanup(func() { wsWriteWait = oldWait })
+
+	url := startWSServer(t)
+	// A small receive buffer keeps the kernel from taking in much for the stuck client
+	dialer := &fastws.Dialer{NetDial: func(network, addr string) (net.Conn, error) {
+		conn, err := net.Dial(network, addr)
+		if err == nil {
+			conn.(*net.TCPConn).SetReadBuffer(4096)
+		}
+		return conn, err
+	}}
+	stuck, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stuck.Close()
+	fast, _, err := fastws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fast.Close()
+	waitSubscribers(t, 2)
+
+	// More than the socket buffers hold, so writes to the stuck client block
+	n := wsSendQueueSize / 4
+	payload := strings.Repeat("x", 512<<10)
+	received := make(chan int)
+	go func() {
+		count := 0
+		fast.SetReadDeadline(time.Now().Add(10 * time.Second))
+		for count < n {
+			if _, _, err := fast.ReadMessage(); err != nil {
+				break
+			}
+			count++
+		}
+		received <- count
+	}()
+
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		BroadcastUpdate("template_updated", map[string]interface{}{"i": i, "payload": payload})
+	}
+	if elapsed := time.Since(start); elapsed > wsWriteWait {
+		t.Errorf("broadcasting took %v, it waited for the stuck client", elapsed)
+	}
+	if count := <-received; count != n {
+		t.Errorf("other client got %d of %d messages", count, n)
+	}
+
+	// The stuck client is closed and unregistered; the other one stays
+	waitSubscribers(t, 1)
+	fast.SetWriteDeadline(time.Now().Add(time.Second))
+	if err := fast.WriteMessage(fastws.TextMessage, []byte(`{"type":"ping"}`)); err != nil {
+		t.Fatal(err)
+	}
+	fast.SetReadDeadline(time.Now().Add(time.Second))
+	if _, message, err := fast.ReadMessage(); err != nil || !strings.Contains(string(message), "pong") {
+		t.Errorf("other client after the stuck one was closed: %s, %v", message, err)
+	}
+	fast.Close()
+}
+
+// startResume holds back changes for a client as resume does while it loads the missed ones
+func startResume(client *wsConn) int64 {
+	eventSeqMu.Lock()
+	defer eventSeqMu.Unlock()
+	client.replay.start()
+	return eventSeq
+}
+
+// queuedMessages drains a client's send queue
+func queuedMessages(t *testing.T, client *wsConn) []WebSocketMessage {
+	t.Helper()
+	var messages []WebSocketMessage
+	for len(client.send) > 0 {
+		var message WebSocketMessage
+		if err := json.Unmarshal(<-client.send, &message); err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+func TestResumeWithMoreHeldThanQueued(t *testing.T) {
+	setSlowClientPolicy(t, SlowClientDisconnect)
+	client := newTestConn()
+	register(t, client)
+
+	latest := startResume(client)
+	n := wsSendQueueSize + 36
+	for i := 0; i < n; i++ {
+		BroadcastUpdate("template_updated", map[string]int{"i": i})
+	}
+	client.finishResume(nil, true, latest)
+
+	if isClosed(client.done) {
+		t.Fatal("client was disconnected as too slow")
+	}
+	messages := queuedMessages(t, client)
+	if len(messages) != 1 || messages[0].Type != "resync_required" {
+		t.Fatalf("got %d messages, first %+v, want only resync_required", len(messages), messages[0])
+	}
+	if seq := messages[0].Data.(map[string]interface{})["seq"]; seq != float64(latest+int64(n)) {
+		t.Errorf("resync_required seq = %v, want %d", seq, latest+int64(n))
+	}
+
+	// Held back no more, changes are queued again
+	BroadcastUpdate("template_updated", map[string]int{"i": n})
+	if messages := queuedMessages(t, client); len(messages) != 1 || messages[0].Seq != latest+int64(n)+1 {
+		t.Errorf("after resync got %+v, want change %d", messages, latest+int64(n)+1)
+	}
+}
+
+func TestResumeWithMissedAndHeld(t *testing.T) {
+	setSlowClientPolicy(t, SlowClientDisconnect)
+
+	tests := []struct {
+		name   string
+		missed int
+		held   int
+		resync bool
+	}{
+		{"both fit", 20, 20, false},
+		{"together more than the queue", wsMaxReplay, wsSendQueueSize - wsMaxReplay, true},
+		{"too many missed", wsMaxReplay + 1, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestConn()
+			register(t, client)
+
+			latest := startResume(client)
+			missed := make([]db.Change, tt.missed)
+			for i := range missed {
+				missed[i] = db.Change{Seq: latest - int64(tt.missed-i) + 1, Type: "item_updated", Data: "{}"}
+			}
+			for i := 0; i < tt.held; i++ {
+				BroadcastUpdate("template_updated", map[string]int{"i": i})
+			}
+			client.finishResume(missed, true, latest)
+
+			if isClosed(client.done) {
+				t.Fatal("client was disconnected as too slow")
+			}
+			messages := queuedMessages(t, client)
+			if tt.resync {
+				if messages[0].Type != "resync_required" {
+					t.Fatalf("first message = %+v, want resync_required", messages[0])
+				}
+				return
+			}
+			if len(messages) != tt.missed+1+tt.held || messages[tt.missed].Type != "resumed" {
+				t.Fatalf("got %d messages, want %d missed, resumed and %d held", len(messages), tt.missed, tt.held)
+			}
+			// Missed, then held changes, in order
+			for i := 1; i < len(messages); i++ {
+				if i != tt.missed && i != tt.missed+1 && messages[i].Seq != messages[i-1].Seq+1 {
+					t.Errorf("message %d is change %d after %d", i, messages[i].Seq, messages[i-1].Seq)
+				}
+			}
+		})
+	}
+}
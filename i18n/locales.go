@@ -4,6 +4,7 @@ import (
 	"embed"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -181,3 +182,44 @@ func AvailableLocales() []LocaleMeta {
 func T(lang, key string) string {
 	return Get(lang, key)
 }
+
+// Units returns the "units" section of every locale, mapping the unit key
+// stored on items ("kg", "pcs") to its spellings in that language, the first
+// one being the label shown. The default language comes first so its units
+// win when two languages share a spelling.
+func Units() []map[string][]string {
+	localesMu.RLock()
+	defer localesMu.RUnlock()
+
+	codes := make([]string, 0, len(locales))
+	for code := range locales {
+		if code != defaultLang {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	if _, ok := locales[defaultLang]; ok {
+		codes = append([]string{defaultLang}, codes...)
+	}
+
+	result := make([]map[string][]string, 0, len(codes))
+	for _, code := range codes {
+		section, ok := locales[code].Raw["units"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		units := make(map[string][]string, len(section))
+		for label, value := range section {
+			values, _ := value.([]interface{})
+			spellings := make([]string, 0, len(values))
+			for _, v := range values {
+				if str, ok := v.(string); ok {
+					spellings = append(spellings, str)
+				}
+			}
+			units[label] = spellings
+		}
+		result = append(result, units)
+	}
+	return result
+}
@@ -9,6 +9,8 @@ import (
 	"shopping-list/db"
 	"shopping-list/handlers"
 	"shopping-list/i18n"
+	"shopping-list/parser"
+	"strconv"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
@@ -22,8 +24,22 @@ func main() {
 	db.Init()
 	defer db.Close()
 
-	// Clean expired sessions on startup
+	// Clean expired sessions, trusted devices and share links on startup
 	db.CleanExpiredSessions()
+	db.CleanExpiredTrustedDevices()
+	db.CleanExpiredShareLinks()
+
+	// Continue numbering live updates where the change log left off
+	handlers.InitChangeLog()
+
+	// Create the first admin user from APP_PASSWORD
+	handlers.EnsureAdminUser()
+
+	// Commands like "token create" run instead of the server
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1:])
+		return
+	}
 
 	// Initialize i18n
 	if err := i18n.Init(); err != nil {
@@ -35,9 +51,27 @@ func main() {
 		i18n.SetDefaultLang(lang)
 	}
 
+	// Load unit spellings for the quick-add parser
+	parser.LoadUnits()
+
 	// Initialize login rate limiter
 	handlers.InitLoginRateLimiter()
 
+	// Trust a login header from reverse proxies (if configured)
+	handlers.InitProxyAuth()
+
+	// Log in with an OpenID Connect provider (if configured)
+	handlers.InitOIDC()
+
+	// Security headers and trusted origins
+	handlers.InitSecurity()
+
+	// Put recurring items back on their lists when due
+	handlers.StartRecurrenceScheduler()
+
+	// Expire presence on lists of devices that stopped sending heartbeats
+	handlers.StartPresenceSweeper()
+
 	// Initialize template engine
 	engine := html.New("./templates", ".html")
 	engine.Reload(os.Getenv("APP_ENV") != "production")
@@ -85,6 +119,23 @@ func main() {
 		"ne": func(a, b interface{}) bool {
 			return a != b
 		},
+		// formatQuantity renders labels like "2 kg"; empty for a single unitless item
+		"formatQuantity": func(quantity float64, unit string) string {
+			if quantity == 1 && unit == "" {
+				return ""
+			}
+			label := strconv.FormatFloat(quantity, 'f', -1, 64)
+			if unit != "" {
+				label += " " + unit
+			}
+			return label
+		},
+		// unitKey maps units saved in a language ("Stk.") to their key ("pcs")
+		"unitKey": parser.UnitKey,
+		// formatPrice renders amounts with two decimals ("12.50")
+		"formatPrice": func(amount float64) string {
+			return strconv.FormatFloat(amount, 'f', 2, 64)
+		},
 		// i18n functions
 		"T": i18n.T,
 		"toJSON": func(v interface{}) template.JS {
@@ -105,6 +156,7 @@ func main() {
 	// Middleware
 	app.Use(logger.New())
 	app.Use(recover.New())
+	app.Use(handlers.SecurityHeaders)
 
 	// Static files
 	app.Static("/static", "./static")
@@ -112,7 +164,13 @@ func main() {
 	// Auth routes (before middleware)
 	app.Get("/login", handlers.LoginPage)
 	app.Post("/login", handlers.LoginRateLimitMiddleware, handlers.Login)
-	app.Post("/logout", handlers.Logout)
+	app.Get("/login/2fa", handlers.LoginTwoFactorPage)
+	app.Post("/login/2fa", handlers.LoginRateLimitMiddleware, handlers.LoginTwoFactor)
+	app.Get("/login/oidc", handlers.OIDCLogin)
+	app.Get("/login/oidc/callback", handlers.OIDCCallback)
+	app.Post("/logout", handlers.CSRFMiddleware, handlers.Logout)
+	app.Get("/register", handlers.RegisterPage)
+	app.Post("/register", handlers.LoginRateLimitMiddleware, handlers.Register)
 
 	// i18n API (before auth middleware - needed for login page)
 	app.Get("/locales", handlers.GetLocales)
@@ -120,17 +178,33 @@ func main() {
 	// REST API (before auth middleware - uses token auth)
 	api.Register(app)
 
-	// Auth middleware for all other routes
-	app.Use(handlers.AuthMiddleware)
-
-	// WebSocket upgrade middleware
-	app.Use("/ws", func(c *fiber.Ctx) error {
+	// WebSocket upgrade middleware. Browsers let any site open a WebSocket with the
+	// user's cookies, so the Origin is checked to stop cross-site WebSocket hijacking.
+	wsUpgrade := func(c *fiber.Ctx) error {
+		if !handlers.IsSameOrigin(c) {
+			return fiber.ErrForbidden
+		}
 		if websocket.IsWebSocketUpgrade(c) {
 			c.Locals("allowed", true)
 			return c.Next()
 		}
 		return fiber.ErrUpgradeRequired
-	})
+	}
+
+	// Public share links (before auth middleware - the token in the URL grants access)
+	share := app.Group("/share/:token", handlers.ShareLinkMiddleware)
+	share.Get("/", handlers.SharedListView)
+	share.Get("/stats", handlers.SharedListStats)
+	share.Post("/items/:id/toggle", handlers.SharedToggleItem)
+	share.Get("/ws", wsUpgrade, websocket.New(handlers.WebSocketHandler))
+
+	// Auth middleware for all other routes
+	app.Use(handlers.AuthMiddleware)
+
+	// CSRF tokens for all changes made from the app's pages
+	app.Use(handlers.CSRFMiddleware)
+
+	app.Use("/ws", wsUpgrade)
 
 	// WebSocket endpoint
 	app.Get("/ws", websocket.New(handlers.WebSocketHandler))
@@ -159,6 +233,15 @@ func main() {
 	app.Post("/lists/:id/move-up", handlers.MoveListUp)
 	app.Post("/lists/:id/move-down", handlers.MoveListDown)
 
+	// List sharing API
+	app.Get("/api/lists/:id/members", handlers.GetListMembers)
+	app.Put("/api/lists/:id/members", handlers.ShareList)
+	app.Delete("/api/lists/:id/members/:userId", handlers.UnshareList)
+	app.Get("/api/lists/:id/presence", handlers.GetListPresence)
+	app.Get("/api/lists/:id/share-links", handlers.GetShareLinks)
+	app.Post("/api/lists/:id/share-links", handlers.CreateShareLink)
+	app.Delete("/api/lists/:id/share-links/:linkId", handlers.DeleteShareLink)
+
 	// Templates API
 	app.Get("/templates", handlers.GetTemplates)
 	app.Get("/templates/:id", handlers.GetTemplate)
@@ -178,6 +261,8 @@ func main() {
 	app.Delete("/items/:id", handlers.DeleteItem)
 	app.Post("/items/:id/toggle", handlers.ToggleItem)
 	app.Post("/items/:id/uncertain", handlers.ToggleUncertain)
+	app.Post("/items/:id/increment", handlers.IncrementItem)
+	app.Post("/items/:id/decrement", handlers.DecrementItem)
 	app.Post("/items/:id/move", handlers.MoveItemToSection)
 	app.Post("/items/:id/move-up", handlers.MoveItemUp)
 	app.Post("/items/:id/move-down", handlers.MoveItemDown)
@@ -189,15 +274,53 @@ func main() {
 	app.Get("/api/data", handlers.GetAllData)
 	app.Get("/api/item/:id/version", handlers.GetItemVersion)
 	app.Get("/api/suggestions", handlers.GetSuggestions)
+	app.Get("/api/suggestions/due", handlers.GetDueSuggestions)
 
 	// History management API
 	app.Get("/api/history", handlers.GetHistory)
 	app.Delete("/api/history/:id", handlers.DeleteHistoryItem)
 	app.Post("/api/history/batch-delete", handlers.BatchDeleteHistory)
+	app.Get("/api/history/duplicates", handlers.GetHistoryDuplicates)
+	app.Post("/api/history/:id/merge", handlers.MergeHistoryItems)
+	app.Post("/api/history/:id/aliases", handlers.AddHistoryAlias)
+	app.Delete("/api/history/:id/aliases/:aliasId", handlers.DeleteHistoryAlias)
+
+	// Price history API
+	app.Get("/api/prices", handlers.GetPriceHistory)
 
 	// Batch operations
 	app.Post("/sections/batch-delete", handlers.BatchDeleteSections)
 
+	// Account API
+	app.Post("/api/account/password", handlers.ChangePassword)
+
+	// Two-factor authentication API
+	app.Get("/api/account/2fa", handlers.GetTwoFactorStatus)
+	app.Post("/api/account/2fa/setup", handlers.SetupTwoFactor)
+	app.Post("/api/account/2fa/enable", handlers.EnableTwoFactor)
+	app.Post("/api/account/2fa/disable", handlers.DisableTwoFactor)
+	app.Post("/api/account/2fa/recovery-codes", handlers.RegenerateRecoveryCodes)
+	app.Get("/api/account/trusted-devices", handlers.GetTrustedDevices)
+	app.Delete("/api/account/trusted-devices/:id", handlers.DeleteTrustedDevice)
+
+	// Devices (sessions)
+	app.Get("/devices", handlers.DevicesPage)
+	app.Put("/sessions/:id", handlers.RenameSession)
+	app.Delete("/sessions/:id", handlers.RevokeSession)
+	app.Post("/sessions/revoke-others", handlers.RevokeAllOtherSessions)
+
+	// API tokens
+	app.Get("/api/tokens", handlers.GetAPITokens)
+	app.Post("/api/tokens", handlers.CreateAPIToken)
+	app.Delete("/api/tokens/:id", handlers.DeleteAPIToken)
+
+	// User management API (admin only)
+	app.Get("/api/users", handlers.AdminOnly, handlers.GetUsers)
+	app.Delete("/api/users/:id", handlers.AdminOnly, handlers.DeleteUser)
+	app.Get("/api/invites", handlers.AdminOnly, handlers.GetInvites)
+	app.Post("/api/invites", handlers.AdminOnly, handlers.CreateInvite)
+	app.Delete("/api/invites/:code", handlers.AdminOnly, handlers.DeleteInvite)
+
 	// Get port from env or default to 3000
 	port := os.Getenv("PORT")
 	if port == "" {
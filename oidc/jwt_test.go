@@ -0,0 +1,305 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testClientID = "koffan"
+
+// testIssuer is a local provider serving discovery and a JWKS document
+type testIssuer struct {
+	server  *httptest.Server
+	fetches atomic.Int32 // JWKS requests
+
+	mu    sync.Mutex
+	keys  []jwk
+	block chan struct{} // when set, JWKS requests wait for it to be closed
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	iss := &testIssuer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discovery{
+			Issuer:                iss.server.URL,
+			AuthorizationEndpoint: iss.server.URL + "/authorize",
+			TokenEndpoint:         iss.server.URL + "/token",
+			JWKSURI:               iss.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		iss.fetches.Add(1)
+		iss.mu.Lock()
+		block, keys := iss.block, iss.keys
+		iss.mu.Unlock()
+		if block != nil {
+			<-block
+		}
+		json.NewEncoder(w).Encode(map[string][]jwk{"keys": keys})
+	})
+	iss.server = httptest.NewServer(mux)
+	t.Cleanup(iss.server.Close)
+	return iss
+}
+
+// publish replaces the keys in the JWKS document
+func (iss *testIssuer) publish(keys ...jwk) {
+	iss.mu.Lock()
+	iss.keys = keys
+	iss.mu.Unlock()
+}
+
+// provider returns a discovered provider for the issuer, with its clock at now
+func (iss *testIssuer) provider(t *testing.T, now *time.Time) *Provider {
+	t.Helper()
+	p := NewProvider(Config{
+		Issuer:      iss.server.URL,
+		ClientID:    testClientID,
+		RedirectURL: "http://localhost/login/oidc/callback",
+		HTTPClient:  iss.server.Client(),
+	})
+	p.now = func() time.Time { return *now }
+	if _, err := p.discover(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) jwk {
+	return jwk{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) jwk {
+	return jwk{
+		Kty: "EC",
+		Kid: kid,
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+// sign returns an ID token with the claims, signed with an RSA or P-256 key
+func sign(t *testing.T, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	alg := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerify(t *testing.T) {
+	iss := newTestIssuer(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss.publish(rsaJWK("rsa", &rsaKey.PublicKey), ecJWK("ec", &ecKey.PublicKey))
+
+	now := time.Unix(1700000000, 0)
+	p := iss.provider(t, &now)
+
+	// claims returns valid claims with some of them changed
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":   iss.server.URL,
+			"aud":   testClientID,
+			"sub":   "user-1",
+			"nonce": "n0nce",
+			"iat":   now.Unix(),
+			"exp":   now.Add(5 * time.Minute).Unix(),
+		}
+		for name, value := range changes {
+			if value == nil {
+				delete(c, name)
+			} else {
+				c[name] = value
+			}
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error // nil for a valid token
+	}{
+		{"valid RS256", sign(t, "rsa", rsaKey, claims(nil)), nil},
+		{"valid ES256", sign(t, "ec", ecKey, claims(nil)), nil},
+		{"audience list with azp", sign(t, "rsa", rsaKey, claims(map[string]interface{}{
+			"aud": []string{"other", testClientID}, "azp": testClientID})), nil},
+		{"expired within leeway", sign(t, "rsa", rsaKey, claims(map[string]interface{}{
+			"exp": now.Add(-Leeway / 2).Unix()})), nil},
+
+		{"expired", sign(t, "rsa", rsaKey, claims(map[string]interface{}{
+			"exp": now.Add(-Leeway - time.Second).Unix()})), ErrInvalidToken},
+		{"no expiry", sign(t, "rsa", rsaKey, claims(map[string]interface{}{"exp": nil})), ErrInvalidToken},
+		{"issued in the future", sign(t, "rsa", rsaKey, claims(map[string]interface{}{
+			"iat": now.Add(Leeway + time.Minute).Unix()})), ErrInvalidToken},
+		{"wrong audience", sign(t, "rsa", rsaKey, claims(map[string]interface{}{"aud": "someone-else"})), ErrInvalidToken},
+		{"audience list without azp", sign(t, "rsa", rsaKey, claims(map[string]interface{}{
+			"aud": []string{"other", testClientID}})), ErrInvalidToken},
+		{"wrong issuer", sign(t, "rsa", rsaKey, claims(map[string]interface{}{"iss": "https://evil.example"})), ErrInvalidToken},
+		{"wrong nonce", sign(t, "rsa", rsaKey, claims(map[string]interface{}{"nonce": "replayed"})), ErrInvalidToken},
+		{"no subject", sign(t, "rsa", rsaKey, claims(map[string]interface{}{"sub": nil})), ErrInvalidToken},
+		{"wrong key", sign(t, "rsa", otherKey, claims(nil)), ErrInvalidToken},
+		{"RSA key ID on an EC signature", sign(t, "rsa", ecKey, claims(nil)), ErrInvalidToken},
+		{"unknown key", sign(t, "rotated", otherKey, claims(nil)), ErrUnknownKey},
+		{"malformed", "not.a-token", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.verify(context.Background(), tt.token, "n0nce")
+			if tt.err == nil {
+				if err != nil {
+					t.Fatalf("verify() error = %v", err)
+				}
+				if got.Subject() != "user-1" {
+					t.Errorf("Subject() = %q, want user-1", got.Subject())
+				}
+				return
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("verify() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+// TestVerifyKeyRotation checks unknown key IDs fetch the keys again, at most once per keyRefreshInterval
+func TestVerifyKeyRotation(t *testing.T) {
+	iss := newTestIssuer(t)
+	oldKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	iss.publish(ecJWK("old", &oldKey.PublicKey))
+
+	now := time.Unix(1700000000, 0)
+	p := iss.provider(t, &now)
+	claims := map[string]interface{}{
+		"iss": iss.server.URL, "aud": testClientID, "sub": "user-1", "nonce": "n",
+		"exp": now.Add(time.Hour).Unix(),
+	}
+
+	if _, err := p.verify(context.Background(), sign(t, "old", oldKey, claims), "n"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The provider rotates; the new key isn't fetched again right away
+	iss.publish(ecJWK("old", &oldKey.PublicKey), ecJWK("new", &newKey.PublicKey))
+	if _, err := p.verify(context.Background(), sign(t, "new", newKey, claims), "n"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("verify() error = %v, want %v", err, ErrUnknownKey)
+	}
+	if n := iss.fetches.Load(); n != 1 {
+		t.Errorf("keys fetched %d times, want 1", n)
+	}
+
+	now = now.Add(keyRefreshInterval)
+	if _, err := p.verify(context.Background(), sign(t, "new", newKey, claims), "n"); err != nil {
+		t.Fatalf("verify() after refresh interval: %v", err)
+	}
+	if n := iss.fetches.Load(); n != 2 {
+		t.Errorf("keys fetched %d times, want 2", n)
+	}
+}
+
+// TestVerifyDuringKeyFetch checks a slow key fetch doesn't hold up tokens signed with known keys
+func TestVerifyDuringKeyFetch(t *testing.T) {
+	iss := newTestIssuer(t)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	iss.publish(ecJWK("known", &key.PublicKey))
+
+	now := time.Unix(1700000000, 0)
+	p := iss.provider(t, &now)
+	claims := map[string]interface{}{
+		"iss": iss.server.URL, "aud": testClientID, "sub": "user-1", "nonce": "n",
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	if _, err := p.verify(context.Background(), sign(t, "known", key, claims), "n"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A token with an unknown key makes the keys be fetched again, and the fetch hangs
+	now = now.Add(keyRefreshInterval)
+	block := make(chan struct{})
+	iss.mu.Lock()
+	iss.block = block
+	iss.mu.Unlock()
+	fetching := make(chan error, 1)
+	go func() {
+		_, err := p.verify(context.Background(), sign(t, "unknown", key, claims), "n")
+		fetching <- err
+	}()
+	for iss.fetches.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.verify(context.Background(), sign(t, "known", key, claims), "n")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("verify() with a known key: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("verify() with a known key waited for the key fetch")
+	}
+
+	close(block)
+	if err := <-fetching; !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("verify() with an unknown key: error = %v, want %v", err, ErrUnknownKey)
+	}
+}
@@ -0,0 +1,29 @@
+package oidc
+
+import "testing"
+
+func TestClaimsBool(t *testing.T) {
+	claims := Claims{
+		"email_verified": true,
+		"phone_verified": false,
+		"string_true":    "true",
+		"string_false":   "false",
+		"number":         float64(1),
+	}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"email_verified", true},
+		{"phone_verified", false},
+		{"string_true", true},
+		{"string_false", false},
+		{"number", false},
+		{"missing", false},
+	}
+	for _, tt := range tests {
+		if got := claims.Bool(tt.name); got != tt.want {
+			t.Errorf("Bool(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"shopping-list/i18n"
+)
+
+// ParsedItem is the result of parsing a quick-add input such as "3x milk 1.5l"
+type ParsedItem struct {
+	Name     string  `json:"name"`
+	Quantity float64 `json:"quantity"` // 0 when the input has no quantity
+	Unit     string  `json:"unit"`     // unit key such as "kg" or "pcs", translated for display
+	Note     string  `json:"note"`
+}
+
+// maxBareNumber caps numbers without a unit or multiplier ("eggs 10"),
+// so names like "2024 calendar" are left alone
+const maxBareNumber = 1000
+
+var (
+	units   = make(map[string]string) // lowercased spelling -> unit key
+	unitsMu sync.RWMutex
+
+	numberPattern     = regexp.MustCompile(`^(\d+(?:[.,]\d+)?|\d+/\d+|[½¼¾])$`)
+	gluedUnitPattern  = regexp.MustCompile(`^(\d+(?:[.,]\d+)?)(\p{L}+\.?)$`)
+	multiplierPattern = regexp.MustCompile(`^(?:(\d+)[x×*]|[x×*](\d+))$`)
+
+	// Words joining a unit and a name ("2 kg of potatoes", "500 g de farine")
+	connectors = map[string]bool{"of": true, "de": true, "du": true, "des": true, "di": true}
+
+	// Separators that start a trailing note ("milk, lactose free")
+	noteSeparators = []string{" (", ", ", " - ", " – ", " — ", ": "}
+)
+
+// LoadUnits builds the unit table from the "units" section of every locale (must be called after i18n.Init)
+func LoadUnits() {
+	table := make(map[string]string)
+	for _, locale := range i18n.Units() {
+		for key, spellings := range locale {
+			for _, spelling := range append([]string{key}, spellings...) {
+				spelling = normalizeUnit(spelling)
+				if _, exists := table[spelling]; !exists {
+					table[spelling] = key
+				}
+			}
+		}
+	}
+
+	unitsMu.Lock()
+	units = table
+	unitsMu.Unlock()
+}
+
+func normalizeUnit(s string) string {
+	return strings.TrimSuffix(strings.ToLower(s), ".")
+}
+
+// lookupUnit returns the unit key for a spelling like "Szt." or "kilos"
+func lookupUnit(s string) (string, bool) {
+	unitsMu.RLock()
+	defer unitsMu.RUnlock()
+	key, ok := units[normalizeUnit(s)]
+	return key, ok
+}
+
+// UnitKey returns the key of a unit typed in any language ("Stk." -> "pcs"),
+// or the unit as given if it is not a known one
+func UnitKey(unit string) string {
+	if key, ok := lookupUnit(unit); ok {
+		return key
+	}
+	return unit
+}
+
+// parseNumber parses "2", "1.5", "1,5", "1/2" and "½"
+func parseNumber(s string) (float64, bool) {
+	if !numberPattern.MatchString(s) {
+		return 0, false
+	}
+	switch s {
+	case "½":
+		return 0.5, true
+	case "¼":
+		return 0.25, true
+	case "¾":
+		return 0.75, true
+	}
+	if num, den, ok := strings.Cut(s, "/"); ok {
+		n, _ := strconv.ParseFloat(num, 64)
+		d, _ := strconv.ParseFloat(den, 64)
+		if d == 0 {
+			return 0, false
+		}
+		return n / d, true
+	}
+	value, err := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+	return value, err == nil && value > 0
+}
+
+// measure is a quantity, optionally with a unit, found at one end of the input
+type measure struct {
+	quantity   float64
+	unit       string // unit key
+	unitText   string // the unit as typed, kept for notes
+	multiplier bool   // "3x", "x3", "3 x" or "x 3"
+	tokens     int    // number of tokens consumed
+}
+
+// leadingMeasure reads a measure from the start of tokens
+func leadingMeasure(tokens []string) (measure, bool) {
+	if len(tokens) == 0 {
+		return measure{}, false
+	}
+	first := tokens[0]
+
+	if m := multiplierPattern.FindStringSubmatch(first); m != nil {
+		n, _ := strconv.ParseFloat(m[1]+m[2], 64)
+		return measure{quantity: n, multiplier: true, tokens: 1}, n > 0
+	}
+	if m := gluedUnitPattern.FindStringSubmatch(first); m != nil {
+		if key, ok := lookupUnit(m[2]); ok {
+			n, ok := parseNumber(m[1])
+			return measure{quantity: n, unit: key, unitText: m[2], tokens: 1}, ok
+		}
+		return measure{}, false
+	}
+
+	n, ok := parseNumber(first)
+	if !ok {
+		return measure{}, false
+	}
+	if len(tokens) > 1 {
+		if next := strings.ToLower(tokens[1]); next == "x" || next == "×" {
+			return measure{quantity: n, multiplier: true, tokens: 2}, true
+		}
+		if key, ok := lookupUnit(tokens[1]); ok {
+			return measure{quantity: n, unit: key, unitText: tokens[1], tokens: 2}, true
+		}
+	}
+	return measure{quantity: n, tokens: 1}, n < maxBareNumber
+}
+
+// trailingMeasure reads a measure from the end of tokens
+func trailingMeasure(tokens []string) (measure, bool) {
+	n := len(tokens)
+	if n == 0 {
+		return measure{}, false
+	}
+	if n >= 2 {
+		// "milk 500 g" - number followed by a unit
+		if key, ok := lookupUnit(tokens[n-1]); ok {
+			if q, ok := parseNumber(tokens[n-2]); ok {
+				return measure{quantity: q, unit: key, unitText: tokens[n-1], tokens: 2}, true
+			}
+		}
+		// "cola x 6" - separate multiplier sign
+		if sign := strings.ToLower(tokens[n-2]); sign == "x" || sign == "×" {
+			if q, ok := parseNumber(tokens[n-1]); ok {
+				return measure{quantity: q, multiplier: true, tokens: 2}, true
+			}
+		}
+	}
+	m, ok := leadingMeasure(tokens[n-1:])
+	return m, ok
+}
+
+// splitNote separates a trailing note from the rest of the input
+func splitNote(input string) (string, string) {
+	cut := -1
+	sepLen := 0
+	for _, sep := range noteSeparators {
+		if i := strings.Index(input, sep); i > 0 && (cut == -1 || i < cut) {
+			cut = i
+			sepLen = len(sep)
+		}
+	}
+	if cut == -1 {
+		return input, ""
+	}
+	note := strings.TrimSpace(input[cut+sepLen:])
+	if strings.HasPrefix(input[cut:], " (") {
+		note = strings.TrimSpace(strings.TrimSuffix(note, ")"))
+	}
+	return strings.TrimSpace(input[:cut]), note
+}
+
+func formatMeasure(m measure) string {
+	label := strconv.FormatFloat(m.quantity, 'f', -1, 64)
+	if m.unitText != "" {
+		label += " " + m.unitText
+	}
+	return label
+}
+
+// ParseItem extracts the quantity, unit and trailing note from free-form input.
+// Input without a recognisable quantity keeps its full text (minus any note) as the name.
+//
+//	"3x milk 1.5l"          -> milk, 3, "", note "1.5 l"
+//	"coca cola 0,5 l x 6"   -> coca cola, 6, "", note "0.5 l"
+//	"500 g mince"           -> mince, 500, g
+//	"Milch 2 Stück, bio"    -> Milch, 2, pcs, note "bio"
+//	"2 kg de patatas"       -> patatas, 2, kg
+//	"1 can"                 -> can, 1, ""
+func ParseItem(input string) ParsedItem {
+	input = strings.Join(strings.Fields(input), " ")
+	result := ParsedItem{Name: input}
+
+	head, note := splitNote(input)
+	tokens := strings.Fields(head)
+
+	// "1 can", "2 kg": with nothing else to name the item, the unit is its name
+	if m, ok := leadingMeasure(tokens); ok && m.unit != "" && m.tokens == len(tokens) {
+		result.Name = m.unitText
+		result.Quantity = m.quantity
+		result.Note = note
+		return result
+	}
+
+	var found []measure
+	if m, ok := leadingMeasure(tokens); ok && m.tokens < len(tokens) {
+		found = append(found, m)
+		tokens = tokens[m.tokens:]
+		if m.unit != "" && len(tokens) > 1 && connectors[strings.ToLower(tokens[0])] {
+			tokens = tokens[1:]
+		}
+		// French elision: "2 l d'eau"
+		if m.unit != "" && len(tokens) > 0 {
+			if rest, ok := cutElision(tokens[0]); ok {
+				tokens[0] = rest
+			}
+		}
+	}
+	if m, ok := trailingMeasure(tokens); ok && m.tokens < len(tokens) {
+		// Only one plain quantity makes sense; "2 milk 3" is left as is
+		if len(found) == 0 || m.unit != "" || m.multiplier != found[0].multiplier {
+			found = append(found, m)
+			tokens = tokens[:len(tokens)-m.tokens]
+
+			// "coca cola 0,5 l x 6": a pack size before the count
+			if m.multiplier && len(found) == 1 {
+				if size, ok := trailingMeasure(tokens); ok && size.unit != "" && size.tokens < len(tokens) {
+					found = append(found, size)
+					tokens = tokens[:len(tokens)-size.tokens]
+				}
+			}
+		}
+	}
+
+	if len(tokens) == 0 {
+		return result
+	}
+
+	result.Name = strings.Join(tokens, " ")
+	result.Note = note
+	if len(found) == 0 {
+		return result
+	}
+
+	var sizes []string
+	switch {
+	case len(found) == 2 && (found[0].unit == "") != (found[1].unit == ""):
+		// "3x milk 1.5l": the plain count is the quantity, the measure is the pack size
+		count, size := found[0], found[1]
+		if count.unit != "" {
+			count, size = size, count
+		}
+		result.Quantity = count.quantity
+		sizes = append(sizes, formatMeasure(size))
+	default:
+		result.Quantity = found[0].quantity
+		result.Unit = found[0].unit
+		for _, m := range found[1:] {
+			sizes = append(sizes, formatMeasure(m))
+		}
+	}
+
+	if len(sizes) > 0 {
+		if result.Note != "" {
+			sizes = append(sizes, result.Note)
+		}
+		result.Note = strings.Join(sizes, ", ")
+	}
+
+	return result
+}
+
+// cutElision strips a French elided article ("d'eau" -> "eau")
+func cutElision(token string) (string, bool) {
+	for _, prefix := range []string{"d'", "d’"} {
+		if len(token) > len(prefix) && strings.HasPrefix(strings.ToLower(token), prefix) {
+			return token[len(prefix):], true
+		}
+	}
+	return token, false
+}
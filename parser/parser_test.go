@@ -0,0 +1,139 @@
+package parser
+
+import (
+	"os"
+	"testing"
+
+	"shopping-list/i18n"
+)
+
+func TestMain(m *testing.M) {
+	if err := i18n.Init(); err != nil {
+		panic(err)
+	}
+	LoadUnits()
+	os.Exit(m.Run())
+}
+
+func TestParseItem(t *testing.T) {
+	tests := []struct {
+		input string
+		want  ParsedItem
+	}{
+		// Plain names
+		{"milk", ParsedItem{Name: "milk"}},
+		{"2024 calendar", ParsedItem{Name: "2024 calendar"}},
+		{"  bread   rolls ", ParsedItem{Name: "bread rolls"}},
+
+		// Counts
+		{"3x milk", ParsedItem{Name: "milk", Quantity: 3}},
+		{"milk x3", ParsedItem{Name: "milk", Quantity: 3}},
+		{"eggs 10", ParsedItem{Name: "eggs", Quantity: 10}},
+		{"2 eggs", ParsedItem{Name: "eggs", Quantity: 2}},
+		{"½ watermelon", ParsedItem{Name: "watermelon", Quantity: 0.5}},
+		{"1/2 cabbage", ParsedItem{Name: "cabbage", Quantity: 0.5}},
+		{"2 milk 3", ParsedItem{Name: "milk 3", Quantity: 2}},
+
+		// Units
+		{"500 g mince", ParsedItem{Name: "mince", Quantity: 500, Unit: "g"}},
+		{"mince 500g", ParsedItem{Name: "mince", Quantity: 500, Unit: "g"}},
+		{"potatoes 2 kilos", ParsedItem{Name: "potatoes", Quantity: 2, Unit: "kg"}},
+		{"2 kg of potatoes", ParsedItem{Name: "potatoes", Quantity: 2, Unit: "kg"}},
+		{"juice 1,5 l", ParsedItem{Name: "juice", Quantity: 1.5, Unit: "l"}},
+
+		// Count and pack size
+		{"3x milk 1.5l", ParsedItem{Name: "milk", Quantity: 3, Note: "1.5 l"}},
+		{"coca cola 0,5 l x 6", ParsedItem{Name: "coca cola", Quantity: 6, Note: "0.5 l"}},
+		{"coca cola 0,5l x6", ParsedItem{Name: "coca cola", Quantity: 6, Note: "0.5 l"}},
+		{"water 1.5 l × 6", ParsedItem{Name: "water", Quantity: 6, Note: "1.5 l"}},
+		{"6 x beer 0.5 l, cold", ParsedItem{Name: "beer", Quantity: 6, Note: "0.5 l, cold"}},
+
+		// Only a measure: the unit names the item
+		{"2 kg", ParsedItem{Name: "kg", Quantity: 2}},
+		{"1 can", ParsedItem{Name: "can", Quantity: 1}},
+		{"2 cans, diet", ParsedItem{Name: "cans", Quantity: 2, Note: "diet"}},
+
+		// Notes
+		{"milk, lactose free", ParsedItem{Name: "milk", Note: "lactose free"}},
+		{"cheese (sliced)", ParsedItem{Name: "cheese", Note: "sliced"}},
+		{"500 g mince - lean", ParsedItem{Name: "mince", Quantity: 500, Unit: "g", Note: "lean"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := ParseItem(tt.input); got != tt.want {
+				t.Errorf("ParseItem(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseItemLocales checks the units of every locale are stored as unit keys
+func TestParseItemLocales(t *testing.T) {
+	tests := []struct {
+		locale string
+		input  string
+		want   ParsedItem
+	}{
+		{"de", "Milch 2 Stück, bio", ParsedItem{Name: "Milch", Quantity: 2, Unit: "pcs", Note: "bio"}},
+		{"de", "3 Flaschen Wasser", ParsedItem{Name: "Wasser", Quantity: 3, Unit: "bottle"}},
+		{"de", "Mehl 1 kg", ParsedItem{Name: "Mehl", Quantity: 1, Unit: "kg"}},
+		{"en", "2 packets of crisps", ParsedItem{Name: "crisps", Quantity: 2, Unit: "pack"}},
+		{"en", "tomatoes 3 tins", ParsedItem{Name: "tomatoes", Quantity: 3, Unit: "can"}},
+		{"es", "2 kg de patatas", ParsedItem{Name: "patatas", Quantity: 2, Unit: "kg"}},
+		{"es", "huevos 12 unidades", ParsedItem{Name: "huevos", Quantity: 12, Unit: "pcs"}},
+		{"fr", "2 l d'eau", ParsedItem{Name: "eau", Quantity: 2, Unit: "l"}},
+		{"fr", "500 g de farine", ParsedItem{Name: "farine", Quantity: 500, Unit: "g"}},
+		{"fr", "thon 3 boîtes", ParsedItem{Name: "thon", Quantity: 3, Unit: "box"}},
+		{"lt", "pienas 2 buteliai", ParsedItem{Name: "pienas", Quantity: 2, Unit: "bottle"}},
+		{"lt", "kiaušiniai 10 vnt.", ParsedItem{Name: "kiaušiniai", Quantity: 10, Unit: "pcs"}},
+		{"no", "melk 2 flasker", ParsedItem{Name: "melk", Quantity: 2, Unit: "bottle"}},
+		{"no", "egg 12 stk", ParsedItem{Name: "egg", Quantity: 12, Unit: "pcs"}},
+		{"pl", "mleko 2 szt.", ParsedItem{Name: "mleko", Quantity: 2, Unit: "pcs"}},
+		{"pl", "szynka 20 deko", ParsedItem{Name: "szynka", Quantity: 20, Unit: "dag"}},
+		{"pl", "3 puszki pomidorów", ParsedItem{Name: "pomidorów", Quantity: 3, Unit: "can"}},
+		{"pl", "piwo 0,5 l x 4", ParsedItem{Name: "piwo", Quantity: 4, Note: "0.5 l"}},
+		{"pt", "leite 2 litros", ParsedItem{Name: "leite", Quantity: 2, Unit: "l"}},
+		{"pt", "3 garrafas de vinho", ParsedItem{Name: "vinho", Quantity: 3, Unit: "bottle"}},
+		{"sv", "mjölk 2 förpackningar", ParsedItem{Name: "mjölk", Quantity: 2, Unit: "pack"}},
+		{"sv", "ägg 12 st", ParsedItem{Name: "ägg", Quantity: 12, Unit: "pcs"}},
+		{"uk", "молоко 2 л", ParsedItem{Name: "молоко", Quantity: 2, Unit: "l"}},
+		{"uk", "яйця 10 шт.", ParsedItem{Name: "яйця", Quantity: 10, Unit: "pcs"}},
+		{"uk", "2 пляшки води", ParsedItem{Name: "води", Quantity: 2, Unit: "bottle"}},
+	}
+
+	covered := make(map[string]bool)
+	for _, tt := range tests {
+		covered[tt.locale] = true
+		t.Run(tt.locale+"/"+tt.input, func(t *testing.T) {
+			if got := ParseItem(tt.input); got != tt.want {
+				t.Errorf("ParseItem(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+	for _, locale := range i18n.AvailableLocales() {
+		if !covered[locale.Code] {
+			t.Errorf("no test cases for locale %q", locale.Code)
+		}
+	}
+}
+
+func TestUnitKey(t *testing.T) {
+	tests := []struct {
+		unit string
+		want string
+	}{
+		{"pcs", "pcs"},
+		{"Stk.", "pcs"},
+		{"szt.", "pcs"},
+		{"Kilos", "kg"},
+		{"кг", "kg"},
+		{"jar", "jar"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := UnitKey(tt.unit); got != tt.want {
+			t.Errorf("UnitKey(%q) = %q, want %q", tt.unit, got, tt.want)
+		}
+	}
+}
@@ -50,12 +50,24 @@ window.Toast = {
 };
 
 // Shopping List Alpine.js Component
-function shoppingList() {
+// shareURL is set when the list is opened through a share link ("/share/<token>")
+function shoppingList(shareURL) {
     return {
+        // Read-only view through a share link: no offline copy, suggestions or editing
+        shareURL: shareURL || '',
+        listId: null, // the list on the page; its changes come over the WebSocket
+
         // WebSocket
         ws: null,
         connected: false,
+        wsOpenedBefore: false,
+        lastSeq: null, // sequence number of the last change received, to resume after reconnecting
+        pingTimer: null,
         reconnectAttempts: 0,
+
+        // Presence: the other users who have the list open
+        userId: null,
+        presence: [],
         maxReconnectAttempts: 5,
 
         // Offline support
@@ -78,6 +90,7 @@ function shoppingList() {
         // History management
         showHistoryModal: false,
         historyItems: [],
+        dueSuggestions: [],
         historySearch: '',
         selectedHistoryIds: [],
         historySectionMode: localStorage.getItem('history_section_mode') || 'use_first_section',
@@ -86,7 +99,11 @@ function shoppingList() {
         stats: {
             total: window.initialStats?.total || 0,
             completed: window.initialStats?.completed || 0,
-            percentage: window.initialStats?.percentage || 0
+            percentage: window.initialStats?.percentage || 0,
+            estimated: 0,
+            spent: 0,
+            budget: 0,
+            remaining: 0
         },
 
         // Current item for mobile actions
@@ -96,6 +113,11 @@ function shoppingList() {
         editingItem: null,
         editItemName: '',
         editItemDescription: '',
+        editItemQuantity: '',
+        editItemUnit: '',
+        editItemPrice: '',
+        editItemPaidPrice: '',
+        editItemLastPaid: '',
 
         // Auto-completion
         suggestions: [],
@@ -131,11 +153,22 @@ function shoppingList() {
         },
 
         async init() {
+            this.listId = Number(this.$el.dataset.listId) || null;
+            this.userId = Number(this.$el.dataset.userId) || null;
+
+            if (this.shareURL) {
+                this.initWebSocket();
+                this.initCompletedSectionsStore();
+                this.initLocalActionTracking();
+                return;
+            }
+
             await this.initOffline();
             this.initWebSocket();
             this.initCompletedSectionsStore();
             this.initLocalActionTracking();
             this.cacheSuggestions();
+            this.loadDueSuggestions();
 
             // Listen for mobile action modal
             this.$el.addEventListener('open-mobile-action', (e) => {
@@ -369,9 +402,7 @@ function shoppingList() {
                 // Force full htmx refresh of sections list
                 const sectionsList = document.getElementById('sections-list');
                 if (sectionsList) {
-                    const refreshUrl = window.location.pathname.startsWith('/lists/')
-                        ? window.location.pathname
-                        : '/';
+                    const refreshUrl = this.listPageURL();
                     await htmx.ajax('GET', refreshUrl, {
                         target: '#sections-list',
                         swap: 'innerHTML',
@@ -460,13 +491,15 @@ function shoppingList() {
                 if (document.visibilityState === 'visible') {
                     // Full refresh when returning from background
                     this.fullRefresh();
+                    // Be shown on the list again right away
+                    this.sendPing();
                 }
             });
         },
 
         connect() {
             const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-            const wsUrl = `${protocol}//${window.location.host}/ws`;
+            const wsUrl = `${protocol}//${window.location.host}${this.shareURL}/ws`;
 
             try {
                 this.ws = new WebSocket(wsUrl);
@@ -475,6 +508,24 @@ function shoppingList() {
                     console.log('WebSocket connected');
                     this.connected = true;
                     this.reconnectAttempts = 0;
+
+                    // Only changes to subscribed lists are sent
+                    if (this.listId) {
+                        this.ws.send(JSON.stringify({ type: 'subscribe', list_ids: [this.listId] }));
+                        this.loadPresence();
+                    }
+
+                    // Ask for the changes missed while disconnected; the server replies
+                    // resync_required if it no longer has them all
+                    if (this.wsOpenedBefore) {
+                        if (this.lastSeq !== null) {
+                            this.ws.send(JSON.stringify({ type: 'resume', seq: this.lastSeq }));
+                        } else {
+                            this.refreshList();
+                            this.refreshStats();
+                        }
+                    }
+                    this.wsOpenedBefore = true;
                 };
 
                 this.ws.onclose = () => {
@@ -509,19 +560,61 @@ function shoppingList() {
             setTimeout(() => this.connect(), delay);
         },
 
+        // The pings also keep the user shown on the list; they stop while the page is
+        // hidden, so others stop seeing the user after a while
         startPingPong() {
-            setInterval(() => {
-                if (this.ws && this.ws.readyState === WebSocket.OPEN) {
-                    this.ws.send(JSON.stringify({ type: 'ping' }));
+            if (this.pingTimer) return;
+            this.pingTimer = setInterval(() => {
+                if (document.visibilityState === 'visible') {
+                    this.sendPing();
                 }
             }, 30000);
         },
 
+        sendPing() {
+            if (this.ws && this.ws.readyState === WebSocket.OPEN) {
+                this.ws.send(JSON.stringify({ type: 'ping' }));
+            }
+        },
+
+        // ===== PRESENCE =====
+
+        async loadPresence() {
+            if (this.shareURL) return;
+            try {
+                const response = await fetch(`/api/lists/${this.listId}/presence`);
+                if (response.ok) {
+                    const presence = await response.json();
+                    this.presence = presence.filter(p => p.user_id !== this.userId);
+                }
+            } catch (error) {
+                console.error('Failed to load presence:', error);
+            }
+        },
+
+        updatePresence(type, entry) {
+            if (entry.list_id !== this.listId || entry.user_id === this.userId) return;
+            const others = this.presence.filter(p => p.user_id !== entry.user_id);
+            this.presence = type === 'presence_left' ? others : [...others, entry];
+        },
+
+        // "Anna" or "Anna: Milk" with the item the user last changed
+        presenceTitle(entry) {
+            const item = entry.item_id && document.getElementById(`item-${entry.item_id}`);
+            return item ? `${entry.username}: ${item.dataset.name}` : entry.username;
+        },
+
         handleMessage(data) {
             try {
                 const message = JSON.parse(data);
                 console.log('WebSocket message:', message.type);
 
+                // Changes carry the changed item or section in data
+                const data = message.data || {};
+                if (message.seq) {
+                    this.lastSeq = message.seq;
+                }
+
                 switch (message.type) {
                     case 'section_created':
                     case 'section_updated':
@@ -532,40 +625,33 @@ function shoppingList() {
                         this.refreshSectionsAndSelects();
                         break;
                     case 'item_created':
+                        // New items only change their own section
+                        this.refreshSection(data.section_id);
+                        this.refreshStats();
+                        break;
                     case 'item_moved':
+                    case 'items_updated':
+                    case 'template_applied':
+                    case 'batch_created':
                         // Requires full list refresh
                         this.refreshList();
                         this.refreshStats();
                         break;
                     case 'item_deleted':
-                        // If local action - HTMX already deleted element
-                        // If remote - refresh list to sync
-                        if (!this.isLocalAction('item_deleted')) {
-                            this.refreshList();
-                        }
-                        this.refreshStats();
-                        break;
                     case 'items_reordered':
-                        // If local action - HTMX already updated order
-                        // If remote - refresh list to sync
-                        if (!this.isLocalAction('items_reordered')) {
-                            this.refreshList();
-                        }
-                        this.refreshStats();
-                        break;
                     case 'item_toggled':
-                        // If local action - HTMX already updated element
-                        // If remote - refresh list to sync
-                        if (!this.isLocalAction('item_toggled')) {
-                            this.refreshList();
+                        // If local action - HTMX already updated the element
+                        // If remote - refresh the item's section to sync
+                        if (!this.isLocalAction(message.type)) {
+                            this.refreshSection(data.section_id);
                         }
                         this.refreshStats();
                         break;
                     case 'item_updated':
                         // If local action - HTMX already updated element
-                        // If remote - refresh list to sync
+                        // If remote - refresh just the item
                         if (!this.isLocalAction('item_updated')) {
-                            this.refreshList();
+                            this.refreshItem(data.id);
                         }
                         this.refreshStats();
                         break;
@@ -574,6 +660,32 @@ function shoppingList() {
                         this.refreshList();
                         this.refreshStats();
                         break;
+                    case 'list_deleted':
+                        // The list was deleted or this user was removed from it
+                        if (message.list_id === this.listId && !this.shareURL) {
+                            window.location.href = '/';
+                        }
+                        break;
+                    case 'subscriptions':
+                        if (this.lastSeq === null) {
+                            this.lastSeq = data.seq;
+                        }
+                        break;
+                    case 'resumed':
+                        console.log(`WebSocket resumed, ${data.count} missed changes`);
+                        this.lastSeq = data.seq;
+                        break;
+                    case 'resync_required':
+                        // Too much was missed to replay
+                        this.lastSeq = data.seq;
+                        this.refreshList();
+                        this.refreshStats();
+                        break;
+                    case 'presence_joined':
+                    case 'presence_activity':
+                    case 'presence_left':
+                        this.updatePresence(message.type, data);
+                        break;
                     case 'pong':
                         break;
                     default:
@@ -604,9 +716,7 @@ function shoppingList() {
                     }
 
                     // Use current URL if on a list page, otherwise use /
-                    const refreshUrl = window.location.pathname.startsWith('/lists/')
-                        ? window.location.pathname
-                        : '/';
+                    const refreshUrl = this.listPageURL();
 
                     htmx.ajax('GET', refreshUrl, {
                         target: '#sections-list',
@@ -645,12 +755,19 @@ function shoppingList() {
             }, 100); // 100ms debounce
         },
 
+        // The page the sections list is reloaded from
+        listPageURL() {
+            if (this.shareURL) return this.shareURL;
+            return window.location.pathname.startsWith('/lists/') ? window.location.pathname : '/';
+        },
+
+        // refreshSection and refreshItem refresh the whole list if the section or item is not shown
         refreshSection(sectionId) {
             const section = document.getElementById(`section-${sectionId}`);
-            if (section) {
-                const refreshUrl = window.location.pathname.startsWith('/lists/')
-                    ? window.location.pathname
-                    : '/';
+            if (!section) {
+                this.refreshList();
+            } else {
+                const refreshUrl = this.listPageURL();
                 htmx.ajax('GET', refreshUrl, {
                     target: `#section-${sectionId}`,
                     swap: 'outerHTML',
@@ -661,10 +778,10 @@ function shoppingList() {
 
         refreshItem(itemId) {
             const item = document.getElementById(`item-${itemId}`);
-            if (item) {
-                const refreshUrl = window.location.pathname.startsWith('/lists/')
-                    ? window.location.pathname
-                    : '/';
+            if (!item) {
+                this.refreshList();
+            } else {
+                const refreshUrl = this.listPageURL();
                 htmx.ajax('GET', refreshUrl, {
                     target: `#item-${itemId}`,
                     swap: 'outerHTML',
@@ -674,6 +791,12 @@ function shoppingList() {
         },
 
         async refreshSectionsAndSelects() {
+            // Share links have no section management or add-item selects
+            if (this.shareURL) {
+                this.refreshList();
+                return;
+            }
+
             // Refresh sections list in management modal
             const manageSectionsList = document.getElementById('manage-sections-list');
             if (manageSectionsList) {
@@ -736,14 +859,18 @@ function shoppingList() {
 
             this._refreshStatsTimer = setTimeout(async () => {
                 try {
-                    const response = await fetch('/stats');
+                    const response = await fetch(`${this.shareURL}/stats`);
                     if (response.ok) {
                         const data = await response.json();
                         // JSON uses snake_case
                         this.stats = {
                             total: data.total_items || 0,
                             completed: data.completed_items || 0,
-                            percentage: data.percentage || 0
+                            percentage: data.percentage || 0,
+                            estimated: data.estimated_total || 0,
+                            spent: data.spent || 0,
+                            budget: data.budget || 0,
+                            remaining: data.remaining || 0
                         };
                     }
                 } catch (error) {
@@ -1061,6 +1188,40 @@ function shoppingList() {
             }
         },
 
+        // Items that are probably running out, based on purchase history
+        async loadDueSuggestions() {
+            if (!this.isOnline) return;
+            try {
+                const response = await fetch('/api/suggestions/due?limit=8');
+                if (response.ok) {
+                    this.dueSuggestions = await response.json();
+                }
+            } catch (error) {
+                console.error('Failed to load due suggestions:', error);
+            }
+        },
+
+        async addDueSuggestion(due) {
+            const select = document.querySelector('#add-item-form select[name="section_id"]') || this.$refs.mobileSectionSelect;
+            const sectionId = due.section_id || (select && select.options.length > 0 ? select.options[0].value : '');
+            if (!sectionId) return;
+
+            this.dueSuggestions = this.dueSuggestions.filter(d => d.name !== due.name);
+            try {
+                const response = await fetch('/items', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/x-www-form-urlencoded' },
+                    body: `name=${encodeURIComponent(due.name)}&section_id=${encodeURIComponent(sectionId)}`
+                });
+                if (response.ok) {
+                    this.refreshList();
+                    this.refreshStats();
+                }
+            } catch (error) {
+                console.error('Failed to add due suggestion:', error);
+            }
+        },
+
         async fetchSuggestions(query) {
             // Clear previous timer
             if (this._suggestionTimer) {
@@ -1127,7 +1288,7 @@ function shoppingList() {
             }
 
             // Auto-select section with smart mapping
-            if (suggestion.last_section_id) {
+            if (suggestion.last_section_id || suggestion.last_section_name) {
                 const options = Array.from(targetSelect.options);
 
                 // 1. Try exact ID match (same section)
@@ -1276,6 +1437,11 @@ function shoppingList() {
                 this.editItemName = item.name;
                 this.editItemDescription = item.description || '';
             }
+            this.editItemQuantity = item.quantity ? String(item.quantity) : '';
+            this.editItemUnit = item.unit ? unitLabel(item.unit) : '';
+            this.editItemPrice = item.price ? String(item.price) : '';
+            this.editItemPaidPrice = item.paid_price ? String(item.paid_price) : '';
+            this.loadLastPaid(item.name);
 
             this.$nextTick(() => {
                 const input = document.querySelector('[x-model="editItemName"]');
@@ -1283,17 +1449,46 @@ function shoppingList() {
             });
         },
 
+        // Show the most recent recorded price for an item in the edit modal
+        async loadLastPaid(name) {
+            this.editItemLastPaid = '';
+            if (!this.isOnline || !name) return;
+            try {
+                const response = await fetch(`/api/prices?name=${encodeURIComponent(name)}&limit=1`);
+                if (!response.ok) return;
+                const prices = await response.json();
+                if (prices.length > 0) {
+                    const last = prices[0];
+                    this.editItemLastPaid = last.paid_price.toFixed(2) +
+                        (last.quantity !== 1 || last.unit ? ` (${formatQuantity(last.quantity, last.unit)})` : '');
+                }
+            } catch (error) {
+                console.error('Failed to load price history:', error);
+            }
+        },
+
         async submitEditItem() {
             if (!this.editItemName.trim() || !this.editingItem) return;
 
             const itemId = this.editingItem.id;
             const name = this.editItemName.trim();
             const description = this.editItemDescription.trim();
-            const body = `name=${encodeURIComponent(name)}&description=${encodeURIComponent(description)}`;
+            const quantity = String(this.editItemQuantity).trim();
+            const unit = this.editItemUnit.trim();
+            const price = String(this.editItemPrice).trim();
+            const paidPrice = String(this.editItemPaidPrice).trim();
+            const body = `name=${encodeURIComponent(name)}&description=${encodeURIComponent(description)}` +
+                `&quantity=${encodeURIComponent(quantity)}&unit=${encodeURIComponent(unit)}` +
+                `&price=${encodeURIComponent(price)}&paid_price=${encodeURIComponent(paidPrice)}`;
 
             this.editingItem = null;
             this.editItemName = '';
             this.editItemDescription = '';
+            this.editItemQuantity = '';
+            this.editItemUnit = '';
+            this.editItemPrice = '';
+            this.editItemPaidPrice = '';
+            this.editItemLastPaid = '';
 
             // If offline, do optimistic UI update
             if (!this.isOnline) {
@@ -2020,6 +2215,69 @@ document.addEventListener('DOMContentLoaded', function() {
 
 });
 
+// Share links of a list, managed by its owner in the list settings
+function shareLinks(listId) {
+    return {
+        links: [],
+        newURL: '',
+
+        async load() {
+            try {
+                const response = await fetch(`/api/lists/${listId}/share-links`);
+                if (response.ok) {
+                    this.links = await response.json();
+                }
+            } catch (error) {
+                console.error('Failed to load share links:', error);
+            }
+        },
+
+        async create(form) {
+            try {
+                const response = await fetch(`/api/lists/${listId}/share-links`, {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/x-www-form-urlencoded' },
+                    body: new URLSearchParams(new FormData(form))
+                });
+                const data = await response.json();
+                if (!response.ok) {
+                    window.Toast.show(data.error || t('share.create_failed'), 'error');
+                    return;
+                }
+                // The URL can only be shown now, the server keeps a hash of its token
+                this.newURL = window.location.origin + data.url;
+                form.reset();
+                this.load();
+            } catch (error) {
+                console.error('Failed to create share link:', error);
+                window.Toast.show(t('share.create_failed'), 'error');
+            }
+        },
+
+        async copy() {
+            try {
+                await navigator.clipboard.writeText(this.newURL);
+                window.Toast.show(t('share.copied'), 'success', 2000);
+            } catch (error) {
+                // Clipboard access needs HTTPS; the field is selected for copying by hand
+                this.$refs.shareURL.select();
+            }
+        },
+
+        async revoke(link) {
+            if (!confirm(t('share.revoke_confirm'))) return;
+            try {
+                const response = await fetch(`/api/lists/${listId}/share-links/${link.id}`, { method: 'DELETE' });
+                if (response.ok || response.status === 404) {
+                    this.links = this.links.filter(l => l.id !== link.id);
+                }
+            } catch (error) {
+                console.error('Failed to revoke share link:', error);
+            }
+        }
+    };
+}
+
 // Create HTML for offline item (simplified version without all actions)
 function createOfflineItemHtml(id, name, description, sectionId) {
     const descHtml = description
@@ -229,10 +229,26 @@ class OfflineStorage {
         });
     }
 
-    // Calculate Levenshtein distance between two strings
+    // Lowercase and fold diacritics and Cyrillic to plain Latin (mirrors db/fold.go)
+    _foldName(s) {
+        const table = {
+            'æ': 'ae', 'đ': 'd', 'ð': 'd', 'ı': 'i', 'ł': 'l', 'ø': 'o', 'œ': 'oe', 'ß': 'ss', 'þ': 'th',
+            'а': 'a', 'б': 'b', 'в': 'v', 'г': 'h', 'ґ': 'g', 'д': 'd', 'е': 'e', 'є': 'ie',
+            'ж': 'zh', 'з': 'z', 'и': 'y', 'і': 'i', 'ї': 'i', 'й': 'i', 'к': 'k', 'л': 'l',
+            'м': 'm', 'н': 'n', 'о': 'o', 'п': 'p', 'р': 'r', 'с': 's', 'т': 't', 'у': 'u',
+            'ф': 'f', 'х': 'kh', 'ц': 'ts', 'ч': 'ch', 'ш': 'sh', 'щ': 'shch', 'ь': '', 'ъ': '',
+            'ю': 'iu', 'я': 'ia', 'ё': 'e', 'ы': 'y', 'э': 'e',
+            "'": '', '’': '', 'ʼ': ''
+        };
+        return Array.from(s.toLowerCase())
+            .map(ch => ch in table ? table[ch] : ch.normalize('NFD').replace(/\p{M}/gu, ''))
+            .join('');
+    }
+
+    // Calculate Levenshtein distance between two strings (by code point)
     _levenshteinDistance(s1, s2) {
-        s1 = s1.toLowerCase();
-        s2 = s2.toLowerCase();
+        s1 = Array.from(s1.toLowerCase());
+        s2 = Array.from(s2.toLowerCase());
 
         if (s1.length === 0) return s2.length;
         if (s2.length === 0) return s1.length;
@@ -262,39 +278,63 @@ class OfflineStorage {
         return matrix[s1.length][s2.length];
     }
 
-    // Score a suggestion match (higher is better)
+    // Every query word is a prefix of a name word, in order ("zo se" matches "zolty ser")
+    _wordPrefixMatch(nameWords, queryWords) {
+        if (queryWords.length === 0) return false;
+        let next = 0;
+        for (const q of queryWords) {
+            while (next < nameWords.length && !nameWords[next].startsWith(q)) {
+                next++;
+            }
+            if (next === nameWords.length) return false;
+            next++;
+        }
+        return true;
+    }
+
+    // Score a suggestion match (higher is better), mirrors scoreSuggestion in db/queries.go
     _scoreSuggestion(name, query) {
-        const nameLower = name.toLowerCase();
-        const queryLower = query.toLowerCase();
+        const nameFolded = this._foldName(name.trim());
+        const queryFolded = this._foldName(query.trim());
+        if (!queryFolded) return 0;
+
+        // Typing the exact spelling ranks above a folded match
+        const bonus = name.toLowerCase().includes(query.trim().toLowerCase()) ? 10 : 0;
 
         // Exact match: highest score
-        if (nameLower === queryLower) {
-            return 1000;
+        if (nameFolded === queryFolded) {
+            return 1000 + bonus;
         }
 
         // Prefix match: high score
-        if (nameLower.startsWith(queryLower)) {
-            return 500;
+        if (nameFolded.startsWith(queryFolded)) {
+            return 500 + bonus;
+        }
+
+        // Word prefix match
+        const nameWords = nameFolded.split(/\s+/).filter(Boolean);
+        if (this._wordPrefixMatch(nameWords, queryFolded.split(/\s+/).filter(Boolean))) {
+            return 300 + bonus;
         }
 
         // Contains match: medium score
-        if (nameLower.includes(queryLower)) {
-            return 200;
+        if (nameFolded.includes(queryFolded)) {
+            return 200 + bonus;
         }
 
         // Fuzzy match: score based on Levenshtein distance
-        if (query.length >= 3) {
-            const distance = this._levenshteinDistance(nameLower, queryLower);
-            const maxDistance = Math.floor(query.length / 2); // Allow ~50% typos
+        const queryLength = Array.from(queryFolded).length;
+        if (queryLength >= 3) {
+            const distance = this._levenshteinDistance(nameFolded, queryFolded);
+            const maxDistance = Math.floor(queryLength / 2); // Allow ~50% typos
 
             if (distance <= maxDistance) {
                 return 100 - distance * 20;
             }
 
             // Check if any word in the name fuzzy matches
-            const words = nameLower.split(/\s+/);
-            for (const word of words) {
-                const wordDist = this._levenshteinDistance(word, queryLower);
+            for (const word of nameWords) {
+                const wordDist = this._levenshteinDistance(word, queryFolded);
                 if (wordDist <= maxDistance) {
                     return 80 - wordDist * 15;
                 }
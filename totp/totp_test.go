@@ -0,0 +1,144 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of RFC 6238 appendix B, "12345678901234567890", in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestCodeRFC6238 checks the SHA-1 test vectors of RFC 6238 appendix B. The RFC lists
+// 8 digit codes; the 6 digit codes are their last 6 digits.
+func TestCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string // RFC value in brackets
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+
+		step, ok := Validate(rfcSecret, tt.want, time.Unix(tt.unix, 0))
+		if !ok || step != tt.unix/Period {
+			t.Errorf("Validate(%s, %d) = %d, %v, want %d, true", tt.want, tt.unix, step, ok, tt.unix/Period)
+		}
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	issued := time.Unix(1234567890, 0)
+	code, err := Code(rfcSecret, issued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := Step(issued)
+
+	tests := []struct {
+		name   string
+		offset time.Duration // from when the code was issued
+		ok     bool
+	}{
+		{"same period", 0, true},
+		{"one period late", Period * time.Second, true},
+		{"one period early", -Period * time.Second, true},
+		{"two periods late", 2 * Period * time.Second, false},
+		{"two periods early", -2 * Period * time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Validate(rfcSecret, code, issued.Add(tt.offset))
+			if ok != tt.ok {
+				t.Fatalf("Validate() ok = %v, want %v", ok, tt.ok)
+			}
+			// The step is the one the code was issued in, not the current one
+			if ok && got != step {
+				t.Errorf("Validate() step = %d, want %d", got, step)
+			}
+		})
+	}
+}
+
+func TestValidateInput(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		ok     bool
+	}{
+		{"spaces", rfcSecret, "005 924", true},
+		{"lowercase secret with spaces", "gezd gnbv gy3t qojq gezd gnbv gy3t qojq", "005924", true},
+		{"wrong code", rfcSecret, "005925", false},
+		{"eight digits", rfcSecret, "89005924", false},
+		{"too short", rfcSecret, "5924", false},
+		{"empty", rfcSecret, "", false},
+		{"bad secret", "not base32!", "005924", false},
+	}
+	for _, tt := range tests {
+		if _, ok := Validate(tt.secret, tt.code, now); ok != tt.ok {
+			t.Errorf("%s: Validate(%q) ok = %v, want %v", tt.name, tt.code, ok, tt.ok)
+		}
+	}
+}
+
+// TestValidateReplay checks a caller that refuses steps at or before the last accepted
+// one, as the login does, accepts each code once, even while it stays in the window
+func TestValidateReplay(t *testing.T) {
+	start := time.Unix(1234567890, 0)
+	var lastStep int64
+	use := func(code string, at time.Time) bool {
+		step, ok := Validate(rfcSecret, code, at)
+		if !ok || step <= lastStep {
+			return false
+		}
+		lastStep = step
+		return true
+	}
+
+	code, _ := Code(rfcSecret, start)
+	if !use(code, start) {
+		t.Fatal("first use of a code was refused")
+	}
+	if use(code, start.Add(time.Second)) {
+		t.Error("code accepted twice in its period")
+	}
+	if use(code, start.Add(Period*time.Second)) {
+		t.Error("code accepted again in the next period")
+	}
+
+	// An older code still in the window is refused once a newer one was used
+	next, _ := Code(rfcSecret, start.Add(Period*time.Second))
+	if !use(next, start.Add(Period*time.Second)) {
+		t.Fatal("code of the next period was refused")
+	}
+	previous, _ := Code(rfcSecret, start.Add(-Period*time.Second))
+	if use(previous, start) {
+		t.Error("code from an earlier period accepted after a later one")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("secret %q has %d characters, want 32", secret, len(secret))
+	}
+	if _, err := Code(secret, time.Now()); err != nil {
+		t.Errorf("Code() with a generated secret: %v", err)
+	}
+}